@@ -0,0 +1,71 @@
+// auditVerify checks the audit log's hash chains for gaps, reordering and
+// edits. It reads the Firestore audit collection, or with --file a log
+// written by a file or stdout sink. It is run by hand, not deployed:
+//
+//	go run ./cmd/auditVerify
+//	go run ./cmd/auditVerify --file /var/log/leadexperts-audit.jsonl
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/peterpla/lead-expert/pkg/audit"
+	"github.com/peterpla/lead-expert/pkg/config"
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// use the default service's configuration, it names the audit collection
+var prefix = "TaskDefault"
+var cfg config.Config
+
+func main() {
+	var file string
+	pflag.StringVar(&file, "file", "", "--file <path> to verify a file or stdout sink's records instead of Firestore")
+
+	// GetConfig parses the command line
+	if err := config.GetConfig(&cfg, prefix); err != nil {
+		log.Fatalf("auditVerify, GetConfig error: %v", err)
+	}
+	serviceInfo.RegisterServiceName("audit-verify")
+
+	var records []audit.Record
+	var problems []string
+	if file != "" {
+		var err error
+		if records, err = audit.ReadFile(file); err != nil {
+			log.Fatalf("auditVerify, ReadFile error: %v", err)
+		}
+		log.Printf("auditVerify, verifying %d records in %q\n", len(records), file)
+	} else {
+		var head audit.Chain
+		var err error
+		if records, head, err = database.ReadAuditRecords(cfg.ProjectID, cfg.DatabaseAudit); err != nil {
+			log.Fatalf("auditVerify, ReadAuditRecords error: %v", err)
+		}
+		log.Printf("auditVerify, verifying %d records in collection %q in project %q\n",
+			len(records), cfg.DatabaseAudit, cfg.ProjectID)
+
+		// the head catches records removed from the end of the chain
+		if n := len(records); n > 0 && (head.Seq != records[n-1].Seq || head.LastHash != records[n-1].Hash) {
+			problems = append(problems, fmt.Sprintf("chain %q: head is at seq %d, last record is seq %d",
+				head.ID, head.Seq, records[n-1].Seq))
+		} else if n == 0 && head.Seq != 0 {
+			problems = append(problems, fmt.Sprintf("chain %q: head is at seq %d, no records found", head.ID, head.Seq))
+		}
+	}
+
+	problems = append(problems, audit.Verify(records)...)
+	for _, problem := range problems {
+		log.Printf("auditVerify, %s\n", problem)
+	}
+	if len(problems) > 0 {
+		log.Printf("auditVerify, FAILED, %d problems found\n", len(problems))
+		os.Exit(1)
+	}
+	log.Printf("auditVerify, OK\n")
+}
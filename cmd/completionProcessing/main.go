@@ -22,12 +22,14 @@ import (
 	"github.com/peterpla/lead-expert/pkg/queue"
 	"github.com/peterpla/lead-expert/pkg/request"
 	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+	"github.com/peterpla/lead-expert/pkg/webhook"
 )
 
 var prefix = "TaskCompletionProcessing"
 var initLogPrefix = "completion-processing.main.init(),"
 var cfg config.Config
 var repo request.RequestRepository
+var webhooks *webhook.Dispatcher
 var q queue.Queue
 var qi = queue.QueueInfo{}
 var qs queue.QueueService
@@ -54,7 +56,13 @@ func main() {
 	defer catch() // implements recover so panics reported
 
 	// connect to the Request database
-	repo = database.NewFirestoreRequestRepository(cfg.ProjectID, cfg.DatabaseRequests)
+	repo = database.NewRequestRepository(&cfg, database.NewAuditSink(&cfg))
+
+	// tells customers their requests completed
+	webhooks = &webhook.Dispatcher{
+		Deliveries: database.NewFirestoreDeliveryRepository(cfg.ProjectID, cfg.DatabaseWebhooks),
+		Customers:  database.NewFirestoreCustomerRepository(cfg.ProjectID, cfg.DatabaseCustomers),
+	}
 
 	if cfg.IsGAE {
 		q = queue.NewGCTQueue(&qi) // use Google Cloud Tasks for queueing
@@ -131,7 +139,7 @@ func taskHandler() httprouter.Handle {
 		// replace | with \n in WorkingTranscript
 		incomingRequest.FinalTranscript = strings.Replace(incomingRequest.WorkingTranscript, "|", "\n", -1)
 		incomingRequest.Status = request.Completed
-		incomingRequest.CompletedAt = time.Now().UTC().Format(time.RFC3339Nano)
+		incomingRequest.CompletedAt = time.Now().UTC().Format(request.TimeLayout)
 
 		// add timestamps and get duration
 		_, err := incomingRequest.AddTimestamps("BeginCompletionProcessing", startTime.Format(time.RFC3339Nano), "EndCompletionProcessing")
@@ -147,10 +155,15 @@ func taskHandler() httprouter.Handle {
 			return
 		}
 
+		// a failed first delivery is retried from the delivery log, so it doesn't fail the task
+		if _, err := webhooks.NotifyRequest(r.Context(), webhook.EventCompleted, &incomingRequest, ""); err != nil && err != webhook.ErrNoCallback {
+			log.Printf("%s.taskHandler, NotifyRequest error: %+v\n", sn, err)
+		}
+
 		// Set a non-2xx status code to indicate a failure in task processing that should be retried.
 		// For example, http.Error(w, "Internal Server Error: Task Processing", http.StatusInternalServerError)
-		w.WriteHeader(http.StatusOK)
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
 
 		// populate a CompletionResponse struct for the HTTP response, with
 		// selected fields of Request
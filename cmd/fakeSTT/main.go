@@ -0,0 +1,128 @@
+// fakeSTT stands in for an offline speech-to-text engine, so the local
+// transcription provider can be run and tested with no engine or model
+// installed. It takes whisper.cpp's arguments, and writes a transcript in
+// whisper.cpp's JSON (or with -format vosk, Vosk's) without listening to
+// the media: each line of the file <media>.txt, if there is one, is a
+// speaker's turn, else a canned call is used. It is not deployed:
+//
+//	go build -o /usr/local/bin/fakeSTT ./cmd/fakeSTT
+//	fakeSTT -l en -oj -of /tmp/transcript -f audio-01.mp3
+//
+// and in the config file, LocalEngine: [fakeSTT, -l, "{lang}", -oj, -of, "{output}", -f, "{media}"]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// canned is the call transcribed when the media has no <media>.txt
+var canned = []string{
+	"Thank you for calling Park flooring. This is Michael. How may I help you?",
+	"Hey Michael. How are you today?",
+	"Good. What's up?",
+	"My name is Yuri.",
+}
+
+const wordMillis = 400 // each word takes 0.4s
+
+func main() {
+	media := flag.String("f", "", "media file to transcribe")
+	output := flag.String("of", "", "write the transcript to <of>.json instead of stdout")
+	format := flag.String("format", "whisper", "output format, whisper or vosk")
+	_ = flag.String("l", "en", "language, ignored")
+	_ = flag.String("m", "", "model file, ignored")
+	_ = flag.Bool("oj", false, "output JSON, always")
+	_ = flag.Bool("tdrz", false, "mark speaker turns, always")
+	flag.Parse()
+
+	if *media == "" {
+		fail("no media file, use -f <path>")
+	}
+	if _, err := os.Stat(*media); err != nil {
+		fail(err.Error())
+	}
+
+	turns := canned
+	if text, err := ioutil.ReadFile(*media + ".txt"); err == nil {
+		turns = nil
+		for _, line := range strings.Split(string(text), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				turns = append(turns, line)
+			}
+		}
+	}
+
+	var transcript interface{}
+	switch *format {
+	case "whisper":
+		transcript = whisper(turns)
+	case "vosk":
+		transcript = vosk(turns)
+	default:
+		fail(fmt.Sprintf("unknown format %q", *format))
+	}
+
+	out, err := json.MarshalIndent(transcript, "", "  ")
+	if err != nil {
+		fail(err.Error())
+	}
+	if *output == "" {
+		fmt.Println(string(out))
+		return
+	}
+	if err := ioutil.WriteFile(*output+".json", out, 0600); err != nil {
+		fail(err.Error())
+	}
+}
+
+func fail(msg string) {
+	fmt.Fprintf(os.Stderr, "fakeSTT: %s\n", msg)
+	os.Exit(1)
+}
+
+// whisper returns each turn as a segment, marked as followed by a turn
+func whisper(turns []string) map[string]interface{} {
+	type offsets struct {
+		From int `json:"from"`
+		To   int `json:"to"`
+	}
+	type segment struct {
+		Offsets         offsets `json:"offsets"`
+		Text            string  `json:"text"`
+		SpeakerTurnNext bool    `json:"speaker_turn_next"`
+	}
+
+	segments := []segment{}
+	at := 0
+	for _, turn := range turns {
+		end := at + wordMillis*len(strings.Fields(turn))
+		segments = append(segments, segment{Offsets: offsets{From: at, To: end}, Text: " " + turn, SpeakerTurnNext: true})
+		at = end
+	}
+	return map[string]interface{}{"transcription": segments}
+}
+
+// vosk returns the turns as one result, word by word
+func vosk(turns []string) map[string]interface{} {
+	type word struct {
+		Word  string  `json:"word"`
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Conf  float64 `json:"conf"`
+	}
+
+	words := []word{}
+	at := 0.0
+	for _, turn := range turns {
+		for _, w := range strings.Fields(turn) {
+			words = append(words, word{Word: w, Start: at, End: at + wordMillis/1000.0, Conf: 1})
+			at += wordMillis / 1000.0
+		}
+	}
+	return map[string]interface{}{"text": strings.Join(turns, " "), "result": words}
+}
@@ -51,7 +51,7 @@ func main() {
 	defer catch() // implements recover so panics reported
 
 	// connect to the Request database
-	repo = database.NewFirestoreRequestRepository(cfg.ProjectID, cfg.DatabaseRequests)
+	repo = database.NewRequestRepository(&cfg, database.NewAuditSink(&cfg))
 
 	if cfg.IsGAE {
 		q = queue.NewGCTQueue(&qi) // use Google Cloud Tasks for queueing
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/julienschmidt/httprouter"
+	"github.com/spf13/viper"
+
+	"github.com/peterpla/lead-expert/pkg/appengine"
+	"github.com/peterpla/lead-expert/pkg/audio"
+	"github.com/peterpla/lead-expert/pkg/check"
+	"github.com/peterpla/lead-expert/pkg/config"
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/middleware"
+	"github.com/peterpla/lead-expert/pkg/queue"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+	"github.com/peterpla/lead-expert/pkg/transcription"
+	"github.com/peterpla/lead-expert/pkg/webhook"
+)
+
+var prefix = "TaskMediaConvert"
+var logPrefix = "media-convert.main.init(),"
+var cfg config.Config
+var repo request.RequestRepository
+var webhooks *webhook.Dispatcher
+var q queue.Queue
+var qi = queue.QueueInfo{}
+var qs queue.QueueService
+
+// use a single instance of Validate, it caches struct info
+var validate *validator.Validate
+
+func init() {
+	if err := config.GetConfig(&cfg, prefix); err != nil {
+		msg := fmt.Sprintf(logPrefix+" GetConfig error: %v", err)
+		panic(msg)
+	}
+
+	// make ServiceName and QueueName available to other packages
+	serviceInfo.RegisterServiceName(cfg.ServiceName)
+	serviceInfo.RegisterQueueName(cfg.QueueName)
+	serviceInfo.RegisterNextServiceName(cfg.NextServiceName)
+}
+
+func main() {
+	sn := serviceInfo.GetServiceName()
+	// Creating App Engine task handlers: https://cloud.google.com/tasks/docs/creating-appengine-handlers
+
+	defer catch() // implements recover so panics reported
+
+	// connect to the Request database
+	repo = database.NewRequestRepository(&cfg, database.NewAuditSink(&cfg))
+
+	// tells customers their requests failed
+	webhooks = &webhook.Dispatcher{
+		Deliveries: database.NewFirestoreDeliveryRepository(cfg.ProjectID, cfg.DatabaseWebhooks),
+		Customers:  database.NewFirestoreCustomerRepository(cfg.ProjectID, cfg.DatabaseCustomers),
+	}
+
+	if cfg.IsGAE {
+		q = queue.NewGCTQueue(&qi) // use Google Cloud Tasks for queueing
+	} else {
+		q = queue.NewNullQueue(&qi) // use null queue, requests thrown away on exit
+	}
+
+	qs = queue.NewService(q)
+	_ = qs
+
+	store := media.NewStore(&cfg)
+	converter := audio.NewFFmpeg(cfg.FFmpeg, cfg.FFprobe)
+
+	router := httprouter.New()
+	router.POST("/task_handler", taskHandler(q, store, converter))
+	router.GET("/", indexHandler)
+	router.NotFound = http.HandlerFunc(myNotFound)
+	cfg.Router = router
+
+	port := os.Getenv("PORT") // Google App Engine complains if "PORT" env var isn't checked
+	if !cfg.IsGAE {
+		port = viper.GetString(prefix + "Port")
+	}
+	if port == "" {
+		panic("PORT undefined")
+	}
+
+	validate = validator.New()
+
+	log.Printf("Starting service %s listening on port %s, requests will be added to queue %s\n",
+		sn, port, cfg.QueueName)
+	// run ListenAndServe in a separate go routine so main can listen for signals
+	go startListening(":"+port, middleware.LogReqResp(router))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	s := <-signals
+	log.Printf("\n%s.main, received signal %s, terminating", sn, s.String())
+}
+
+func startListening(addr string, handler http.Handler) {
+	if err := http.ListenAndServe(addr, handler); err != http.ErrServerClosed {
+		log.Fatalf("%s.startListening, ListenAndServe returned err: %+v\n", serviceInfo.GetServiceName(), err)
+	}
+}
+
+// catch recover() and log it
+func catch() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Fatalf("=====> RECOVER in %s.main.catch, recover() returned: %v\n", serviceInfo.GetServiceName(), r)
+		}
+	}()
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// taskHandler processes task requests, converting the working media to an
+// audio format the Request's speech-to-text provider accepts
+func taskHandler(q queue.Queue, store media.Store, converter audio.Converter) httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		// log.Printf("%s.taskHandler, request: %+v, params: %+v\n", sn, r, p)
+		startTime := time.Now().UTC().Format(time.RFC3339Nano)
+
+		// pull task and queue names from App Engine headers
+		taskName, queueName := appengine.GetAppEngineInfo(w, r)
+
+		incomingRequest := request.Request{}
+		if err := incomingRequest.ReadRequest(w, r, p, validate); err != nil {
+			// ReadRequest called http.Error so we just return
+			return
+		}
+
+		if cfg.IsGAE {
+			if err := check.RequestID(incomingRequest); err != nil {
+				log.Printf("%s.main, check.RequestID error: %v", sn, err)
+				http.Error(w, "Internal Error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// a retried task whose conversion is recorded doesn't convert again:
+		// the media it read may be deleted as superseded. It passes the
+		// recorded Request on, unless a later stage has it already.
+		if stored, err := repo.FindByID(incomingRequest.RequestID); err == nil && stored.Timestamps["EndMediaConvert"] != "" {
+			if stored.Stage == "MediaConvert" {
+				if err := q.Add(&qi, stored); err != nil {
+					log.Printf("%s.taskHandler, q.Add error: %+v\n", sn, err)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			deleteSuperseded(r.Context(), store, &incomingRequest, stored)
+			log.Printf("%s.taskHandler, request %s already converted, at stage %s\n", sn, stored.RequestID, stored.Stage)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// a retried task converts the media again, to the same place
+		provider := transcription.Select(&incomingRequest, cfg.DefaultProvider)
+		result, err := convert(r.Context(), store, converter, &incomingRequest, provider)
+		if permanent(err) {
+			// retrying won't help: fail the Request, and end the task
+			log.Printf("%s.taskHandler, convert error: %v, failing request %s", sn, err, incomingRequest.RequestID)
+			if err := webhook.FailRequest(r.Context(), repo, webhooks, &incomingRequest, err); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if err != nil {
+			log.Printf("%s.taskHandler, convert error: %v, will retry request %s", sn, err, incomingRequest.RequestID)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		newRequest := incomingRequest
+		newRequest.Media = describe(result.working)
+		newRequest.SourceMedia = nil
+		if result.uri != "" {
+			log.Printf("%s.taskHandler, converted %s to %s for %s\n", sn, incomingRequest.WorkingMedia(), result.uri, provider)
+			newRequest.WorkingMediaURI = result.uri
+			newRequest.SourceMedia = describe(result.source)
+		}
+		// long media is transcribed chunk by chunk
+		newRequest.Chunks = result.chunks
+		if len(result.chunks) > 0 {
+			log.Printf("%s.taskHandler, %s split into %d chunks\n", sn, newRequest.WorkingMedia(), len(result.chunks))
+		}
+		// billed by, and ETAs scaled by; transcription has only the
+		// transcript's duration otherwise
+		if seconds := result.source.Duration.Seconds(); seconds > 0 {
+			newRequest.MediaSeconds = seconds
+		}
+
+		// add timestamps and get duration
+		var duration time.Duration
+		if duration, err = newRequest.AddTimestamps("BeginMediaConvert", startTime, "EndMediaConvert"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// record the conversion, so retention and erasure find it
+		if err := repo.Update(&newRequest); err != nil {
+			log.Printf("%s.taskHandler, repo.Update error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// create task on the next pipeline stage's queue with updated Request
+		if err := q.Add(&qi, &newRequest); err != nil {
+			log.Printf("%s.taskHandler, q.Add error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		deleteSuperseded(r.Context(), store, &incomingRequest, &newRequest)
+
+		// send response to Cloud Tasks
+		w.WriteHeader(http.StatusOK)
+
+		log.Printf("%s.taskHandler completed in %v: queue %q, task %q, newRequest: %+v",
+			sn, duration, queueName, taskName, newRequest)
+	}
+}
+
+// deleteSuperseded deletes the copy mediaFetch made, once converted's
+// copy of it is recorded. Nothing else records where it is.
+func deleteSuperseded(ctx context.Context, store media.Store, incoming, converted *request.Request) {
+	superseded := incoming.WorkingMediaURI
+	if superseded == "" || superseded == converted.WorkingMediaURI {
+		return
+	}
+	if err := store.Delete(ctx, superseded); err != nil && err != media.ErrNotFound {
+		log.Printf("%s.deleteSuperseded, Delete(%q) error: %v\n", serviceInfo.GetServiceName(), superseded, err)
+	}
+}
+
+// converted is what convert did
+type converted struct {
+	uri     string      // where the converted copy is; "" if the media is used as is
+	source  *audio.Info // the working media mediaConvert received
+	working *audio.Info // the media the provider receives, source if not converted
+	chunks  []request.Chunk
+}
+
+// convert probes the Request's working media, converts it to the
+// provider's preferred format if the provider doesn't accept it as is,
+// and splits it into chunks if it's long
+func convert(ctx context.Context, store media.Store, converter audio.Converter, req *request.Request, provider string) (*converted, error) {
+	dir, err := ioutil.TempDir("", "mediaConvert")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// ffmpeg reads local files; some formats (e.g. MP4) can't be streamed
+	uri := req.WorkingMedia()
+	src := filepath.Join(dir, "media"+filepath.Ext(uri))
+	n, err := download(ctx, store, uri, src)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, audio.ErrEmpty
+	}
+
+	info, err := converter.Probe(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	info.Bytes = n // ffprobe doesn't report it for every container
+	result := &converted{source: info, working: info}
+
+	accepted := transcription.Accepted[provider]
+	if len(accepted) == 0 {
+		// an unknown provider fails in transcription
+		return result, nil
+	}
+
+	// a call recorded with each speaker on a channel keeps its channels
+	separate := transcription.Separate(provider, req.EffectiveConfig(), info.Channels)
+	to := accepted[0]
+	if separate {
+		to.Channels = info.Channels
+	}
+	if transcription.Accepts(provider, info.Format(), separate) {
+		if _, _, err := audio.Output(info.Format()); err == nil {
+			to = info.Format() // chunks, if any, as is
+		}
+	} else {
+		if cfg.MediaStore == "" {
+			return nil, fmt.Errorf("MediaStore not configured, can't convert %s", uri)
+		}
+		ext, contentType, err := audio.Output(to)
+		if err != nil {
+			return nil, err
+		}
+		dst := filepath.Join(dir, "converted"+ext)
+		if err := converter.Convert(ctx, src, dst, to); err != nil {
+			return nil, err
+		}
+		if result.working, err = converter.Probe(ctx, dst); err != nil {
+			return nil, err
+		}
+		result.uri = mediaDestination(req) + "-converted" + ext
+		if result.working.Bytes, err = put(ctx, store, dst, result.uri, contentType); err != nil {
+			return nil, err
+		}
+		src = dst
+	}
+
+	length := audio.DefaultChunkLength
+	if cfg.ChunkLength > 0 {
+		length = cfg.ChunkLength
+	}
+	if result.working.Duration > length+length/4 && cfg.MediaStore != "" {
+		if result.chunks, err = split(ctx, store, converter, req, src, result.working.Duration, length, to); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// split cuts the media file at path into chunks of about length, at pauses,
+// and puts them in the Store in Format to
+func split(ctx context.Context, store media.Store, converter audio.Converter, req *request.Request, path string, duration, length time.Duration, to audio.Format) ([]request.Chunk, error) {
+	ext, contentType, err := audio.Output(to)
+	if err != nil {
+		return nil, err
+	}
+	silences, err := converter.Silences(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []request.Chunk
+	for i, c := range audio.Chunks(duration, silences, length, audio.ChunkOverlap) {
+		dst := filepath.Join(filepath.Dir(path), fmt.Sprintf("chunk-%03d%s", i, ext))
+		if err := converter.Extract(ctx, path, dst, c.Start, c.End, to); err != nil {
+			return nil, err
+		}
+		uri := fmt.Sprintf("%s-chunk-%03d%s", mediaDestination(req), i, ext)
+		if _, err := put(ctx, store, dst, uri, contentType); err != nil {
+			return nil, err
+		}
+		_ = os.Remove(dst)
+		chunks = append(chunks, request.Chunk{
+			MediaURI: uri,
+			Start:    c.Start.Seconds(),
+			End:      c.End.Seconds(),
+			Status:   request.Pending,
+		})
+	}
+	return chunks, nil
+}
+
+// put copies the file at path to uri in the Store, returning its size
+func put(ctx context.Context, store media.Store, path, uri, contentType string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), store.Put(ctx, uri, f, contentType)
+}
+
+// download copies the media file at uri to path, returning its size
+func download(ctx context.Context, store media.Store, uri, path string) (int64, error) {
+	r, err := store.Get(ctx, uri)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	return n, f.Close()
+}
+
+// describe records what Probe found, for the Request
+func describe(info *audio.Info) *request.Media {
+	return &request.Media{
+		Encoding:        info.Format().Encoding,
+		Codec:           info.Codec,
+		SampleRateHertz: info.SampleRateHertz,
+		Channels:        info.Channels,
+		Seconds:         info.Duration.Seconds(),
+		Bytes:           info.Bytes,
+	}
+}
+
+// permanent reports whether err means converting will never succeed
+func permanent(err error) bool {
+	switch err {
+	case audio.ErrEmpty, audio.ErrUnreadable, audio.ErrNoAudio, audio.ErrUnsupportedFormat, media.ErrNotFound, media.ErrUnsupportedURI:
+		return true
+	}
+	return false
+}
+
+// mediaDestination is where in the media Store the Request's converted
+// media is put, less a suffix and extension
+func mediaDestination(req *request.Request) string {
+	return fmt.Sprintf("%s/media/%d/%s", strings.TrimSuffix(cfg.MediaStore, "/"), req.CustomerID, req.RequestID)
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// indexHandler responds to requests with "service running"
+func indexHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sn := serviceInfo.GetServiceName()
+
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// I'm not dead yet
+	fmt.Fprintf(w, "%q service running\n", sn)
+}
+
+// ********** ********** ********** ********** ********** **********
+
+func myNotFound(w http.ResponseWriter, r *http.Request) {
+	var msg404 = []byte("<h2>404 Not Foundw</h2>")
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write(msg404)
+}
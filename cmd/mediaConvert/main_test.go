@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/peterpla/lead-expert/pkg/audio"
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/queue"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/webhook"
+)
+
+// memoryStore holds what's Put, by URI
+type memoryStore map[string][]byte
+
+func (m memoryStore) Delete(ctx context.Context, uri string) error {
+	if _, ok := m[uri]; !ok {
+		return media.ErrNotFound
+	}
+	delete(m, uri)
+	return nil
+}
+
+func (m memoryStore) Exists(ctx context.Context, uri string) (bool, error) {
+	_, ok := m[uri]
+	return ok, nil
+}
+
+func (m memoryStore) Get(ctx context.Context, uri string) (io.ReadCloser, error) {
+	data, ok := m[uri]
+	if !ok {
+		return nil, media.ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m memoryStore) Put(ctx context.Context, uri string, r io.Reader, contentType string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m[uri] = data
+	return nil
+}
+
+func (m memoryStore) SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error) {
+	return "", media.ErrNoSigner
+}
+
+// fakeConverter's media files hold what Probe finds in them, as
+// "<container> <codec> <sample rate> <channels> [<seconds>]", a minute
+// if the seconds are left out
+type fakeConverter struct{}
+
+func (fakeConverter) Probe(ctx context.Context, path string) (*audio.Info, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info := &audio.Info{}
+	seconds := 60.0
+	if n, _ := fmt.Sscan(string(data), &info.Container, &info.Codec, &info.SampleRateHertz, &info.Channels, &seconds); n < 4 {
+		return nil, audio.ErrUnreadable
+	}
+	info.Duration = time.Duration(seconds * float64(time.Second))
+	return info, nil
+}
+
+func (c fakeConverter) Convert(ctx context.Context, src, dst string, to audio.Format) error {
+	info, err := c.Probe(ctx, src)
+	if err != nil {
+		return err
+	}
+	return c.write(dst, to, info.Duration)
+}
+
+func (c fakeConverter) Extract(ctx context.Context, src, dst string, start, end time.Duration, to audio.Format) error {
+	return c.write(dst, to, end-start)
+}
+
+func (fakeConverter) Silences(ctx context.Context, path string) ([]audio.Silence, error) {
+	return nil, nil
+}
+
+func (fakeConverter) write(dst string, to audio.Format, duration time.Duration) error {
+	container, codec := "flac", "flac"
+	if to.Encoding == "LINEAR16" {
+		container, codec = "wav", "pcm_s16le"
+	}
+	content := fmt.Sprintf("%s %s %d %d", container, codec, to.SampleRateHertz, to.Channels)
+	if duration != time.Minute {
+		content += fmt.Sprintf(" %g", duration.Seconds())
+	}
+	return ioutil.WriteFile(dst, []byte(content), 0600)
+}
+
+// recordingQueue is a null queue remembering the Requests added
+type recordingQueue struct {
+	queue.Queue
+	added []*request.Request
+}
+
+func (r *recordingQueue) Add(qi *queue.QueueInfo, req *request.Request) error {
+	r.added = append(r.added, req)
+	return nil
+}
+
+func TestMediaConvert(t *testing.T) {
+
+	validate = validator.New()
+	cfg.MediaStore = "gs://media-bucket"
+	repo = database.NewMemoryRequestRepository()
+	webhooks = &webhook.Dispatcher{
+		Deliveries: database.NewMemoryDeliveryRepository(),
+		Customers:  database.NewMemoryCustomerRepository(),
+	}
+
+	type test struct {
+		name      string
+		provider  string
+		channels  string // the Request's Config.Channels
+		media     string // the working media's content, see fakeConverter
+		fetched   bool   // mediaFetch copied the media
+		converted string // the converted copy's URI, less the RequestID
+		format    request.Media
+		source    request.Media // if converted
+		chunks    []string      // the chunks' URIs, less the RequestID
+		failed    string        // the Request's Error
+	}
+
+	tests := []test{
+		{name: "mp3", media: "mp3 mp3 44100 2",
+			format: request.Media{Encoding: "MP3", Codec: "mp3", SampleRateHertz: 44100, Channels: 2, Seconds: 60, Bytes: 15}},
+		{name: "phone system", media: "amr amr_nb 8000 1", fetched: true,
+			format: request.Media{Encoding: "AMR", Codec: "amr_nb", SampleRateHertz: 8000, Channels: 1, Seconds: 60, Bytes: 17}},
+		{name: "m4a", media: "mov,mp4,m4a,3gp,3g2,mj2 aac 44100 1", fetched: true,
+			converted: "gs://media-bucket/media/1234567/%s-converted.flac",
+			format:    request.Media{Encoding: "FLAC", Codec: "flac", SampleRateHertz: 16000, Channels: 1, Seconds: 60, Bytes: 17},
+			source:    request.Media{Codec: "aac", SampleRateHertz: 44100, Channels: 1, Seconds: 60, Bytes: 35}},
+		{name: "mp3 for an offline engine", provider: "local", media: "mp3 mp3 44100 2",
+			converted: "gs://media-bucket/media/1234567/%s-converted.wav",
+			format:    request.Media{Encoding: "LINEAR16", Codec: "pcm_s16le", SampleRateHertz: 16000, Channels: 1, Seconds: 60, Bytes: 21},
+			source:    request.Media{Encoding: "MP3", Codec: "mp3", SampleRateHertz: 44100, Channels: 2, Seconds: 60, Bytes: 15}},
+		{name: "stereo call", media: "wav pcm_s16le 8000 2",
+			format: request.Media{Encoding: "LINEAR16", Codec: "pcm_s16le", SampleRateHertz: 8000, Channels: 2, Seconds: 60, Bytes: 20}},
+		{name: "stereo m4a", media: "mov,mp4,m4a,3gp,3g2,mj2 aac 44100 2",
+			converted: "gs://media-bucket/media/1234567/%s-converted.flac",
+			format:    request.Media{Encoding: "FLAC", Codec: "flac", SampleRateHertz: 16000, Channels: 2, Seconds: 60, Bytes: 17},
+			source:    request.Media{Codec: "aac", SampleRateHertz: 44100, Channels: 2, Seconds: 60, Bytes: 35}},
+		{name: "stereo call, mixed", channels: request.ChannelsMixed, media: "wav pcm_s16le 8000 2",
+			converted: "gs://media-bucket/media/1234567/%s-converted.flac",
+			format:    request.Media{Encoding: "FLAC", Codec: "flac", SampleRateHertz: 16000, Channels: 1, Seconds: 60, Bytes: 17},
+			source:    request.Media{Encoding: "LINEAR16", Codec: "pcm_s16le", SampleRateHertz: 8000, Channels: 2, Seconds: 60, Bytes: 20}},
+		{name: "long call", media: "wav pcm_s16le 16000 1 720",
+			format: request.Media{Encoding: "LINEAR16", Codec: "pcm_s16le", SampleRateHertz: 16000, Channels: 1, Seconds: 720, Bytes: 25},
+			chunks: []string{"gs://media-bucket/media/1234567/%s-chunk-000.wav", "gs://media-bucket/media/1234567/%s-chunk-001.wav", "gs://media-bucket/media/1234567/%s-chunk-002.wav"}},
+		{name: "long m4a", media: "mov,mp4,m4a,3gp,3g2,mj2 aac 44100 1 400",
+			converted: "gs://media-bucket/media/1234567/%s-converted.flac",
+			format:    request.Media{Encoding: "FLAC", Codec: "flac", SampleRateHertz: 16000, Channels: 1, Seconds: 400, Bytes: 21},
+			source:    request.Media{Codec: "aac", SampleRateHertz: 44100, Channels: 1, Seconds: 400, Bytes: 39},
+			chunks:    []string{"gs://media-bucket/media/1234567/%s-chunk-000.flac", "gs://media-bucket/media/1234567/%s-chunk-001.flac"}},
+		{name: "corrupt", media: "garbage", failed: "Media file unreadable"},
+		{name: "empty", media: "", failed: "Media file empty"},
+	}
+
+	for _, tc := range tests {
+		req := request.Request{
+			RequestID:    uuid.New(),
+			CustomerID:   1234567,
+			MediaFileURI: "gs://bucket/audio-01.mp3",
+			Status:       request.Pending,
+			AcceptedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+			Config:       request.ProcessingConfig{Provider: tc.provider, Channels: tc.channels},
+		}
+		store := memoryStore{}
+		if tc.fetched {
+			req.MediaFileURI = "https://example.com/audio-01"
+			req.WorkingMediaURI = fmt.Sprintf("gs://media-bucket/media/1234567/%s.bin", req.RequestID)
+		}
+		store[req.WorkingMedia()] = []byte(tc.media)
+		_ = repo.Create(&req)
+
+		rq := &recordingQueue{Queue: queue.NewNullQueue(&queue.QueueInfo{})}
+		router := httprouter.New()
+		router.POST("/task_handler", taskHandler(rq, store, fakeConverter{}))
+
+		body, _ := json.Marshal(req)
+		theRequest, err := http.NewRequest("POST", "/task_handler", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		theRequest.Header.Set("X-Appengine-Taskname", "localTask")
+		theRequest.Header.Set("X-Appengine-Queuename", "localQueue")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, theRequest)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected status code %v, got %v", tc.name, http.StatusOK, rr.Code)
+			continue
+		}
+
+		stored, _ := repo.FindByID(req.RequestID)
+		if tc.failed != "" {
+			if stored.Status != request.Error || stored.Error != tc.failed || len(rq.added) != 0 {
+				t.Errorf("%s: expected request failed with %q and not passed on, got %q, %q, %d added", tc.name, tc.failed, stored.Status, stored.Error, len(rq.added))
+			}
+			continue
+		}
+		if len(rq.added) != 1 {
+			t.Errorf("%s: expected request passed on, got %d added", tc.name, len(rq.added))
+			continue
+		}
+		passed := rq.added[0]
+
+		if passed.Media == nil || *passed.Media != tc.format || stored.Media == nil || *stored.Media != tc.format {
+			t.Errorf("%s: expected media %+v passed on and recorded, got %+v and %+v", tc.name, tc.format, passed.Media, stored.Media)
+		}
+		if tc.converted == "" && stored.SourceMedia != nil {
+			t.Errorf("%s: expected no source media recorded, got %+v", tc.name, stored.SourceMedia)
+		}
+		if tc.converted != "" && (stored.SourceMedia == nil || *stored.SourceMedia != tc.source) {
+			t.Errorf("%s: expected source media %+v recorded, got %+v", tc.name, tc.source, stored.SourceMedia)
+		}
+		if passed.MediaSeconds != tc.format.Seconds {
+			t.Errorf("%s: expected media duration recorded, got %v", tc.name, passed.MediaSeconds)
+		}
+
+		expected := req.WorkingMediaURI
+		if tc.converted != "" {
+			expected = fmt.Sprintf(tc.converted, req.RequestID)
+			if _, ok := store[expected]; !ok {
+				t.Errorf("%s: expected converted media stored at %q, got %v", tc.name, expected, store)
+			}
+			if _, ok := store[req.WorkingMediaURI]; ok && tc.fetched {
+				t.Errorf("%s: expected fetched copy %q deleted", tc.name, req.WorkingMediaURI)
+			}
+		} else if len(store) != 1+len(tc.chunks) {
+			t.Errorf("%s: expected media used as is, got %v", tc.name, store)
+		}
+		if passed.WorkingMediaURI != expected || stored.WorkingMediaURI != expected {
+			t.Errorf("%s: expected working media %q passed on and recorded, got %q and %q", tc.name, expected, passed.WorkingMediaURI, stored.WorkingMediaURI)
+		}
+		if len(passed.Chunks) != len(tc.chunks) || len(stored.Chunks) != len(tc.chunks) {
+			t.Errorf("%s: expected %d chunks passed on and recorded, got %+v and %+v", tc.name, len(tc.chunks), passed.Chunks, stored.Chunks)
+		} else {
+			end := 0.0
+			for i, c := range passed.Chunks {
+				uri := fmt.Sprintf(tc.chunks[i], req.RequestID)
+				if _, ok := store[uri]; !ok || c.MediaURI != uri || c.Status != request.Pending {
+					t.Errorf("%s: expected chunk %d pending at %q, got %+v", tc.name, i, uri, c)
+				}
+				if i > 0 && (c.Start >= end || c.Start < end-audio.ChunkOverlap.Seconds()) {
+					t.Errorf("%s: expected chunk %d to overlap the one before it, got %v, ending %v", tc.name, i, c.Start, end)
+				}
+				end = c.End
+			}
+			if len(tc.chunks) > 0 && end != tc.format.Seconds {
+				t.Errorf("%s: expected the chunks to end with the media, got %v", tc.name, end)
+			}
+		}
+		if _, ok := passed.Timestamps["EndMediaConvert"]; !ok || passed.Stage != "MediaConvert" {
+			t.Errorf("%s: expected MediaConvert stage recorded, got %q, %v", tc.name, passed.Stage, passed.Timestamps)
+		}
+
+		// the task redelivered passes the recorded conversion on again,
+		// though the media it converted may be gone
+		objects := len(store)
+		redelivered, err := http.NewRequest("POST", "/task_handler", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		redelivered.Header.Set("X-Appengine-Taskname", "localTask")
+		redelivered.Header.Set("X-Appengine-Queuename", "localQueue")
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, redelivered)
+		if rr.Code != http.StatusOK || len(rq.added) != 2 || rq.added[1].WorkingMediaURI != expected || len(store) != objects {
+			t.Errorf("%s: redelivered, expected %q passed on again, got %d, %d added, %d objects stored", tc.name, expected, rr.Code, len(rq.added), len(store)-objects)
+		}
+	}
+}
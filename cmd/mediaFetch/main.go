@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/julienschmidt/httprouter"
+	"github.com/spf13/viper"
+
+	"github.com/peterpla/lead-expert/pkg/appengine"
+	"github.com/peterpla/lead-expert/pkg/check"
+	"github.com/peterpla/lead-expert/pkg/config"
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/middleware"
+	"github.com/peterpla/lead-expert/pkg/queue"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+	"github.com/peterpla/lead-expert/pkg/webhook"
+)
+
+var prefix = "TaskMediaFetch"
+var logPrefix = "media-fetch.main.init(),"
+var cfg config.Config
+var repo request.RequestRepository
+var webhooks *webhook.Dispatcher
+var q queue.Queue
+var qi = queue.QueueInfo{}
+var qs queue.QueueService
+
+// use a single instance of Validate, it caches struct info
+var validate *validator.Validate
+
+func init() {
+	if err := config.GetConfig(&cfg, prefix); err != nil {
+		msg := fmt.Sprintf(logPrefix+" GetConfig error: %v", err)
+		panic(msg)
+	}
+
+	// make ServiceName and QueueName available to other packages
+	serviceInfo.RegisterServiceName(cfg.ServiceName)
+	serviceInfo.RegisterQueueName(cfg.QueueName)
+	serviceInfo.RegisterNextServiceName(cfg.NextServiceName)
+}
+
+func main() {
+	sn := serviceInfo.GetServiceName()
+	// Creating App Engine task handlers: https://cloud.google.com/tasks/docs/creating-appengine-handlers
+
+	defer catch() // implements recover so panics reported
+
+	// connect to the Request database
+	repo = database.NewRequestRepository(&cfg, database.NewAuditSink(&cfg))
+
+	// tells customers their requests failed
+	webhooks = &webhook.Dispatcher{
+		Deliveries: database.NewFirestoreDeliveryRepository(cfg.ProjectID, cfg.DatabaseWebhooks),
+		Customers:  database.NewFirestoreCustomerRepository(cfg.ProjectID, cfg.DatabaseCustomers),
+	}
+
+	if cfg.IsGAE {
+		q = queue.NewGCTQueue(&qi) // use Google Cloud Tasks for queueing
+	} else {
+		q = queue.NewNullQueue(&qi) // use null queue, requests thrown away on exit
+	}
+
+	qs = queue.NewService(q)
+	_ = qs
+
+	fetcher := media.NewFetcher(media.NewStore(&cfg))
+	if cfg.MediaMaxBytes > 0 {
+		fetcher.MaxBytes = cfg.MediaMaxBytes
+	}
+	if cfg.MediaFetchTimeout > 0 {
+		fetcher.Timeout = cfg.MediaFetchTimeout
+	}
+
+	router := httprouter.New()
+	router.POST("/task_handler", taskHandler(q, fetcher))
+	router.GET("/", indexHandler)
+	router.NotFound = http.HandlerFunc(myNotFound)
+	cfg.Router = router
+
+	port := os.Getenv("PORT") // Google App Engine complains if "PORT" env var isn't checked
+	if !cfg.IsGAE {
+		port = viper.GetString(prefix + "Port")
+	}
+	if port == "" {
+		panic("PORT undefined")
+	}
+
+	validate = validator.New()
+
+	log.Printf("Starting service %s listening on port %s, requests will be added to queue %s\n",
+		sn, port, cfg.QueueName)
+	// run ListenAndServe in a separate go routine so main can listen for signals
+	go startListening(":"+port, middleware.LogReqResp(router))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	s := <-signals
+	log.Printf("\n%s.main, received signal %s, terminating", sn, s.String())
+}
+
+func startListening(addr string, handler http.Handler) {
+	if err := http.ListenAndServe(addr, handler); err != http.ErrServerClosed {
+		log.Fatalf("%s.startListening, ListenAndServe returned err: %+v\n", serviceInfo.GetServiceName(), err)
+	}
+}
+
+// catch recover() and log it
+func catch() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Fatalf("=====> RECOVER in %s.main.catch, recover() returned: %v\n", serviceInfo.GetServiceName(), r)
+		}
+	}()
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// taskHandler processes task requests, copying media submitted by URL, or
+// in storage other than ours, into the media Store, where later stages
+// read it
+func taskHandler(q queue.Queue, fetcher *media.Fetcher) httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		// log.Printf("%s.taskHandler, request: %+v, params: %+v\n", sn, r, p)
+		startTime := time.Now().UTC().Format(time.RFC3339Nano)
+
+		// pull task and queue names from App Engine headers
+		taskName, queueName := appengine.GetAppEngineInfo(w, r)
+
+		incomingRequest := request.Request{}
+		if err := incomingRequest.ReadRequest(w, r, p, validate); err != nil {
+			// ReadRequest called http.Error so we just return
+			return
+		}
+
+		if cfg.IsGAE {
+			if err := check.RequestID(incomingRequest); err != nil {
+				log.Printf("%s.main, check.RequestID error: %v", sn, err)
+				http.Error(w, "Internal Error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		newRequest := incomingRequest
+
+		// media in our Store is read where it is; anything else is copied
+		// in, so later stages read only our Store
+		var copyMedia func(ctx context.Context, src, dst string) (*media.Fetched, error)
+		uri := incomingRequest.MediaFileURI
+		switch _, err := media.FetchURL(uri); {
+		case err == nil:
+			copyMedia = fetcher.Fetch
+		case !inMediaStore(uri):
+			copyMedia = fetcher.Copy
+		default:
+			// a Request may name only its customer's media in our Store
+			var exists bool
+			err := media.ErrUnavailable
+			if customersMedia(&incomingRequest) {
+				exists, err = fetcher.Store.Exists(r.Context(), uri)
+			}
+			if err == nil && !exists {
+				err = media.ErrUnavailable
+			}
+			if media.Permanent(err) {
+				log.Printf("%s.taskHandler, %q: %v, failing request %s", sn, uri, err, incomingRequest.RequestID)
+				if err := webhook.FailRequest(r.Context(), repo, webhooks, &incomingRequest, err); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			if err != nil {
+				log.Printf("%s.taskHandler, Exists error: %v, will retry request %s", sn, err, incomingRequest.RequestID)
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		if copyMedia != nil {
+			if cfg.MediaStore == "" {
+				log.Printf("%s.taskHandler, MediaStore not configured, can't fetch %s\n", sn, incomingRequest.RequestID)
+				http.Error(w, "Internal Error", http.StatusInternalServerError)
+				return
+			}
+
+			// a retried task copies the file again, to the same place
+			fetched, err := copyMedia(r.Context(), uri, mediaDestination(&incomingRequest))
+			if media.Permanent(err) {
+				// retrying won't help: fail the Request, and end the task
+				log.Printf("%s.taskHandler, Fetch error: %v, failing request %s", sn, err, incomingRequest.RequestID)
+				if err := webhook.FailRequest(r.Context(), repo, webhooks, &incomingRequest, err); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			if err != nil {
+				log.Printf("%s.taskHandler, Fetch error: %v, will retry request %s", sn, err, incomingRequest.RequestID)
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			log.Printf("%s.taskHandler, fetched %d bytes of %s to %s\n", sn, fetched.Bytes, fetched.ContentType, fetched.URI)
+			newRequest.WorkingMediaURI = fetched.URI
+		}
+
+		// add timestamps and get duration
+		var duration time.Duration
+		var err error
+		if duration, err = newRequest.AddTimestamps("BeginMediaFetch", startTime, "EndMediaFetch"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// record the copy, so retention and erasure find it
+		if err := repo.Update(&newRequest); err != nil {
+			log.Printf("%s.taskHandler, repo.Update error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// create task on the next pipeline stage's queue with updated Request
+		if err := q.Add(&qi, &newRequest); err != nil {
+			log.Printf("%s.taskHandler, q.Add error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// send response to Cloud Tasks
+		w.WriteHeader(http.StatusOK)
+
+		log.Printf("%s.taskHandler completed in %v: queue %q, task %q, newRequest: %+v",
+			sn, duration, queueName, taskName, newRequest)
+	}
+}
+
+// inMediaStore reports whether uri is in our media Store
+func inMediaStore(uri string) bool {
+	store := strings.TrimSuffix(cfg.MediaStore, "/")
+	return store != "" && strings.HasPrefix(uri, store+"/")
+}
+
+// customersMedia reports whether the Request's media, in our media Store,
+// is among its customer's, where mediaDestination puts them. Any ".."
+// segment is refused, as it could climb out to another customer's.
+func customersMedia(req *request.Request) bool {
+	uri := req.MediaFileURI
+	for _, segment := range strings.Split(uri, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+	return strings.HasPrefix(uri, fmt.Sprintf("%s/media/%d/", strings.TrimSuffix(cfg.MediaStore, "/"), req.CustomerID))
+}
+
+// mediaDestination is where in the media Store the Request's media is
+// copied, less the extension Fetch adds for its type
+func mediaDestination(req *request.Request) string {
+	return fmt.Sprintf("%s/media/%d/%s", strings.TrimSuffix(cfg.MediaStore, "/"), req.CustomerID, req.RequestID)
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// indexHandler responds to requests with "service running"
+func indexHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sn := serviceInfo.GetServiceName()
+
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// I'm not dead yet
+	fmt.Fprintf(w, "%q service running\n", sn)
+}
+
+// ********** ********** ********** ********** ********** **********
+
+func myNotFound(w http.ResponseWriter, r *http.Request) {
+	var msg404 = []byte("<h2>404 Not Foundw</h2>")
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write(msg404)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/queue"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/webhook"
+)
+
+// memoryStore holds what's Put, by URI
+type memoryStore map[string][]byte
+
+func (m memoryStore) Delete(ctx context.Context, uri string) error {
+	delete(m, uri)
+	return nil
+}
+
+func (m memoryStore) Exists(ctx context.Context, uri string) (bool, error) {
+	_, ok := m[uri]
+	return ok, nil
+}
+
+func (m memoryStore) Get(ctx context.Context, uri string) (io.ReadCloser, error) {
+	data, ok := m[uri]
+	if !ok {
+		return nil, media.ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m memoryStore) Put(ctx context.Context, uri string, r io.Reader, contentType string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m[uri] = data
+	return nil
+}
+
+func (m memoryStore) SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error) {
+	return "", media.ErrNoSigner
+}
+
+// recordingQueue is a null queue remembering the Requests added
+type recordingQueue struct {
+	queue.Queue
+	added []*request.Request
+}
+
+func (r *recordingQueue) Add(qi *queue.QueueInfo, req *request.Request) error {
+	r.added = append(r.added, req)
+	return nil
+}
+
+func TestMediaFetch(t *testing.T) {
+
+	validate = validator.New()
+	cfg.MediaStore = "gs://media-bucket/"
+	repo = database.NewMemoryRequestRepository()
+	webhooks = &webhook.Dispatcher{
+		Deliveries: database.NewMemoryDeliveryRepository(),
+		Customers:  database.NewMemoryCustomerRepository(),
+	}
+
+	mp3 := []byte("ID3\x03\x00\x00\x00\x00\x00\x00\xFF\xFB\x90\x64")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/audio-01.mp3":
+			_, _ = w.Write(mp3)
+		case "/page.html":
+			_, _ = w.Write([]byte("<html><body>not audio</body></html>"))
+		default:
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	type test struct {
+		name    string
+		uri     string
+		status  int
+		working string // WorkingMediaURI, less the RequestID
+		failed  bool
+	}
+
+	tests := []test{
+		{name: "customer's cloud storage", uri: "gs://bucket/audio-01.mp3", status: http.StatusOK, working: "gs://media-bucket/media/1234567/"},
+		{name: "not in cloud storage", uri: "gs://bucket/missing.mp3", status: http.StatusOK, failed: true},
+		{name: "not media in cloud storage", uri: "gs://bucket/config.yaml", status: http.StatusOK, failed: true},
+		{name: "no store", uri: "file:///etc/passwd", status: http.StatusOK, failed: true},
+		{name: "own media in our store", uri: "gs://media-bucket/media/1234567/earlier.mp3", status: http.StatusOK},
+		{name: "another customer's media", uri: "gs://media-bucket/media/7654321/theirs.mp3", status: http.StatusOK, failed: true},
+		{name: "outside media in our store", uri: "gs://media-bucket/theirs.mp3", status: http.StatusOK, failed: true},
+		{name: "escaping own media", uri: "gs://media-bucket/media/1234567/../7654321/theirs.mp3", status: http.StatusOK, failed: true},
+		{name: "by URL", uri: server.URL + "/audio-01.mp3", status: http.StatusOK, working: "gs://media-bucket/media/1234567/"},
+		{name: "not media", uri: server.URL + "/page.html", status: http.StatusOK, failed: true},
+		{name: "server busy", uri: server.URL + "/busy.mp3", status: http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range tests {
+		store := memoryStore{
+			"gs://bucket/audio-01.mp3":                              mp3,
+			"gs://bucket/config.yaml":                               []byte("AdminToken: secret\n"),
+			"gs://media-bucket/media/1234567/earlier.mp3":           mp3,
+			"gs://media-bucket/media/7654321/theirs.mp3":            mp3,
+			"gs://media-bucket/theirs.mp3":                          mp3,
+			"gs://media-bucket/media/1234567/../7654321/theirs.mp3": mp3,
+		}
+		fetcher := media.NewFetcher(media.Stores{"gs": store})
+		fetcher.AllowPrivate = true
+		rq := &recordingQueue{Queue: queue.NewNullQueue(&queue.QueueInfo{})}
+
+		router := httprouter.New()
+		router.POST("/task_handler", taskHandler(rq, fetcher))
+
+		req := request.Request{
+			RequestID:    uuid.New(),
+			CustomerID:   1234567,
+			MediaFileURI: tc.uri,
+			Status:       request.Pending,
+			AcceptedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		_ = repo.Create(&req)
+		body, _ := json.Marshal(req)
+
+		theRequest, err := http.NewRequest("POST", "/task_handler", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		theRequest.Header.Set("X-Appengine-Taskname", "localTask")
+		theRequest.Header.Set("X-Appengine-Queuename", "localQueue")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, theRequest)
+
+		if rr.Code != tc.status {
+			t.Errorf("%s: expected status code %v, got %v", tc.name, tc.status, rr.Code)
+			continue
+		}
+
+		stored, _ := repo.FindByID(req.RequestID)
+		if tc.failed {
+			if stored.Status != request.Error || len(rq.added) != 0 {
+				t.Errorf("%s: expected request failed and not passed on, got %q, %d added", tc.name, stored.Status, len(rq.added))
+			}
+			continue
+		}
+		if tc.status != http.StatusOK {
+			if len(rq.added) != 0 || len(store) != 6 {
+				t.Errorf("%s: expected nothing passed on or stored, got %d added, %d stored", tc.name, len(rq.added), len(store)-6)
+			}
+			continue
+		}
+
+		if len(rq.added) != 1 {
+			t.Errorf("%s: expected request passed on, got %d added", tc.name, len(rq.added))
+			continue
+		}
+		passed := rq.added[0]
+		expected := ""
+		if tc.working != "" {
+			expected = tc.working + req.RequestID.String() + ".mp3"
+			if _, ok := store[expected]; !ok {
+				t.Errorf("%s: expected media stored at %q, got %v", tc.name, expected, store)
+			}
+		}
+		if passed.WorkingMediaURI != expected || stored.WorkingMediaURI != expected {
+			t.Errorf("%s: expected working media %q passed on and recorded, got %q and %q", tc.name, expected, passed.WorkingMediaURI, stored.WorkingMediaURI)
+		}
+		if _, ok := passed.Timestamps["EndMediaFetch"]; !ok || passed.Stage != "MediaFetch" {
+			t.Errorf("%s: expected MediaFetch stage recorded, got %q, %v", tc.name, passed.Stage, passed.Timestamps)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// migrateRequests upgrades every stored Request document to the current
+// schema version (request.RequestVersion). It is run by hand, not deployed:
+//
+//	go run ./cmd/migrateRequests --dry-run
+//	go run ./cmd/migrateRequests
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/peterpla/lead-expert/pkg/config"
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// use the default service's configuration, it owns the Requests database
+var prefix = "TaskDefault"
+var cfg config.Config
+
+func main() {
+	var dryRun bool
+	pflag.BoolVar(&dryRun, "dry-run", false, "--dry-run to report what would be migrated without writing")
+
+	// GetConfig parses the command line
+	if err := config.GetConfig(&cfg, prefix); err != nil {
+		log.Fatalf("migrateRequests, GetConfig error: %v", err)
+	}
+	serviceInfo.RegisterServiceName("migrate-requests")
+
+	log.Printf("migrateRequests, upgrading collection %q in project %q to version %d, dry run: %t\n",
+		cfg.DatabaseRequests, cfg.ProjectID, request.RequestVersion, dryRun)
+
+	summary, err := database.MigrateRequests(cfg.ProjectID, cfg.DatabaseRequests, dryRun)
+	log.Printf("migrateRequests, scanned %d, migrated %d, failed %d\n", summary.Scanned, summary.Migrated, summary.Failed)
+	if err != nil {
+		log.Fatalf("migrateRequests, MigrateRequests error: %v", err)
+	}
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/peterpla/lead-expert/pkg/customer"
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/erasure"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/retention"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+	"github.com/peterpla/lead-expert/pkg/webhook"
+)
+
+var adminPrefix = "/admin/v1"
+
+// ********** ********** ********** ********** ********** **********
+
+// createCustomerHandler returns the handler func for POST /admin/v1/customers
+func createCustomerHandler() httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		newCustomer := customer.Customer{}
+		if err := newCustomer.ReadCustomer(w, r, p, validate); err != nil {
+			// ReadCustomer calls http.Error() on error
+			return
+		}
+		newCustomer.APIKeys = nil // API keys are issued separately
+		newCustomer.APIKeyIDs = nil
+		if err := newCustomer.EnsureWebhookSecret(); err != nil {
+			log.Printf("%s.createCustomerHandler, EnsureWebhookSecret error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := customers.Create(&newCustomer); err != nil {
+			log.Printf("%s.createCustomerHandler, customers.Create error: %+v\n", sn, err)
+			if err == database.ErrAlreadyExistsError {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("%s.createCustomerHandler, created customer %d\n", sn, newCustomer.CustomerID)
+		writeCustomer(w, http.StatusCreated, &newCustomer)
+	}
+}
+
+// getCustomerHandler returns the handler func for GET /admin/v1/customers/:id
+func getCustomerHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		found, ok := findCustomer(w, p)
+		if !ok {
+			return
+		}
+		writeCustomer(w, http.StatusOK, found)
+	}
+}
+
+// updateCustomerHandler returns the handler func for PUT /admin/v1/customers/:id
+func updateCustomerHandler() httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		existing, ok := findCustomer(w, p)
+		if !ok {
+			return
+		}
+
+		updated := customer.Customer{}
+		if err := updated.ReadCustomer(w, r, p, validate); err != nil {
+			// ReadCustomer calls http.Error() on error
+			return
+		}
+		if updated.CustomerID != existing.CustomerID {
+			http.Error(w, "customer_id does not match URL", http.StatusBadRequest)
+			return
+		}
+
+		// preserve fields the profile update doesn't own
+		updated.CreatedAt = existing.CreatedAt
+		updated.APIKeys = existing.APIKeys
+		updated.APIKeyIDs = existing.APIKeyIDs
+		if updated.Webhook.Secret == "" {
+			updated.Webhook.Secret = existing.Webhook.Secret
+		}
+
+		if err := customers.Update(&updated); err != nil {
+			log.Printf("%s.updateCustomerHandler, customers.Update error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("%s.updateCustomerHandler, updated customer %d\n", sn, updated.CustomerID)
+		writeCustomer(w, http.StatusOK, &updated)
+	}
+}
+
+// deleteCustomerHandler returns the handler func for DELETE /admin/v1/customers/:id
+func deleteCustomerHandler() httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		existing, ok := findCustomer(w, p)
+		if !ok {
+			return
+		}
+
+		if err := customers.Delete(existing.CustomerID); err != nil {
+			log.Printf("%s.deleteCustomerHandler, customers.Delete error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("%s.deleteCustomerHandler, deleted customer %d\n", sn, existing.CustomerID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// issuedKey is the response to issuing an API key, the only time the key
+// itself is shown
+type issuedKey struct {
+	customer.APIKey
+	Key      string `json:"api_key"`
+	Replaces string `json:"replaces,omitempty"` // KeyID of the key rotated out
+}
+
+// issueKeyHandler returns the handler func for POST /admin/v1/customers/:id/keys
+func issueKeyHandler() httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		cust, ok := findCustomer(w, p)
+		if !ok {
+			return
+		}
+
+		key, issued, err := cust.NewAPIKey(time.Now())
+		if err != nil {
+			log.Printf("%s.issueKeyHandler, NewAPIKey error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := customers.Update(cust); err != nil {
+			log.Printf("%s.issueKeyHandler, customers.Update error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("%s.issueKeyHandler, customer %d, issued key %s\n", sn, cust.CustomerID, issued.KeyID)
+		writeJSON(w, http.StatusCreated, issuedKey{APIKey: *issued, Key: key})
+	}
+}
+
+// rotateKeyHandler returns the handler func for
+// POST /admin/v1/customers/:id/keys/:key_id/rotate. It issues a replacement
+// key; the old key is accepted for "?grace=<duration>" (default 0) longer.
+func rotateKeyHandler() httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		var grace time.Duration
+		if v := r.URL.Query().Get("grace"); v != "" {
+			var err error
+			if grace, err = time.ParseDuration(v); err != nil || grace < 0 {
+				http.Error(w, "invalid grace", http.StatusBadRequest)
+				return
+			}
+		}
+
+		cust, ok := findCustomer(w, p)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		old, err := cust.ExpireAPIKey(p.ByName("key_id"), now.Add(grace))
+		if err == customer.ErrAPIKeyNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		key, issued, err := cust.NewAPIKey(now)
+		if err != nil {
+			log.Printf("%s.rotateKeyHandler, NewAPIKey error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := customers.Update(cust); err != nil {
+			log.Printf("%s.rotateKeyHandler, customers.Update error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("%s.rotateKeyHandler, customer %d, replaced key %s with %s, old key valid until %s\n",
+			sn, cust.CustomerID, old.KeyID, issued.KeyID, old.RevokedAt)
+		writeJSON(w, http.StatusCreated, issuedKey{APIKey: *issued, Key: key, Replaces: old.KeyID})
+	}
+}
+
+// revokeKeyHandler returns the handler func for
+// DELETE /admin/v1/customers/:id/keys/:key_id
+func revokeKeyHandler() httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		cust, ok := findCustomer(w, p)
+		if !ok {
+			return
+		}
+
+		if _, err := cust.ExpireAPIKey(p.ByName("key_id"), time.Now()); err == customer.ErrAPIKeyNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err := customers.Update(cust); err != nil {
+			log.Printf("%s.revokeKeyHandler, customers.Update error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("%s.revokeKeyHandler, customer %d, revoked key %s\n", sn, cust.CustomerID, p.ByName("key_id"))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// purgeHandler returns the handler func for GET /cron/purge and
+// POST /admin/v1/purge. "?dry_run=true" reports what would be purged without
+// deleting anything.
+func purgeHandler(p *retention.Purger) httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		dryRun := false
+		if v := r.URL.Query().Get("dry_run"); v != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, "invalid dry_run", http.StatusBadRequest)
+				return
+			}
+		}
+
+		report, err := p.Run(r.Context(), dryRun)
+		if err != nil {
+			log.Printf("%s.purgeHandler, Run error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+// createErasureHandler returns the handler func for POST /admin/v1/erasures
+func createErasureHandler(e *erasure.Eraser) httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		sel := erasure.Selector{}
+		if err := request.ReadJSON(w, r, &sel, validate); err != nil {
+			// ReadJSON calls http.Error() on error
+			return
+		}
+		if sel.Kind() == "" {
+			http.Error(w, "exactly one of request_id, phone_number, media_uri required", http.StatusBadRequest)
+			return
+		}
+
+		cert, err := e.Erase(r.Context(), sel)
+		if err == erasure.ErrNotFound || err == database.ErrNotFoundError {
+			http.Error(w, erasure.ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("%s.createErasureHandler, Erase error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, cert)
+	}
+}
+
+// getErasureHandler returns the handler func for GET /admin/v1/erasures/:id
+func getErasureHandler(e *erasure.Eraser) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		cert, ok := findCertificate(w, e, p)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, cert)
+	}
+}
+
+// verifyErasureHandler returns the handler func for GET /admin/v1/erasures/:id/verify
+func verifyErasureHandler(e *erasure.Eraser) httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		cert, ok := findCertificate(w, e, p)
+		if !ok {
+			return
+		}
+
+		v, err := e.Verify(r.Context(), cert)
+		if err != nil {
+			log.Printf("%s.verifyErasureHandler, Verify error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// listDeliveriesHandler returns the handler func for
+// GET /admin/v1/webhooks/deliveries, filtered by "?customer_id=" and
+// "?status=" (pending, delivered or failed)
+func listDeliveriesHandler(d *webhook.Dispatcher) httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		opts := webhook.ListOptions{Status: r.URL.Query().Get("status"), Limit: 100}
+		if v := r.URL.Query().Get("customer_id"); v != "" {
+			var err error
+			if opts.CustomerID, err = strconv.Atoi(v); err != nil || opts.CustomerID <= 0 {
+				http.Error(w, "invalid customer_id", http.StatusBadRequest)
+				return
+			}
+		}
+		switch opts.Status {
+		case "", webhook.Pending, webhook.Delivered, webhook.Failed:
+		default:
+			http.Error(w, "invalid status", http.StatusBadRequest)
+			return
+		}
+
+		found, err := d.Deliveries.List(opts)
+		if err != nil {
+			log.Printf("%s.listDeliveriesHandler, Deliveries.List error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, found)
+	}
+}
+
+// getDeliveryHandler returns the handler func for
+// GET /admin/v1/webhooks/deliveries/:id
+func getDeliveryHandler(d *webhook.Dispatcher) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		found, err := d.Deliveries.FindByID(p.ByName("id"))
+		if err == database.ErrNotFoundError {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("%s.getDeliveryHandler, Deliveries.FindByID error: %+v\n", serviceInfo.GetServiceName(), err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, found)
+	}
+}
+
+// replayDeliveryHandler returns the handler func for
+// POST /admin/v1/webhooks/deliveries/:id/replay. It sends the event again
+// now, and responds with the Delivery and the outcome.
+func replayDeliveryHandler(d *webhook.Dispatcher) httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		replayed, err := d.Replay(r.Context(), p.ByName("id"))
+		if err == database.ErrNotFoundError {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err == webhook.ErrErased {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("%s.replayDeliveryHandler, Replay error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("%s.replayDeliveryHandler, replayed delivery %s: %s\n", sn, replayed.DeliveryID, replayed.Status)
+		writeJSON(w, http.StatusOK, replayed)
+	}
+}
+
+// retryWebhooksHandler returns the handler func for GET /cron/webhooks,
+// which retries deliveries whose backoff has passed
+func retryWebhooksHandler(d *webhook.Dispatcher) httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		n, err := d.RetryDue(r.Context())
+		if err != nil {
+			log.Printf("%s.retryWebhooksHandler, RetryDue error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]int{"attempted": n})
+	}
+}
+
+// findCertificate reads the Certificate identified by the :id URL
+// parameter. On failure it calls http.Error and returns false.
+func findCertificate(w http.ResponseWriter, e *erasure.Eraser, p httprouter.Params) (*erasure.Certificate, bool) {
+	cert, err := e.Certificates.FindByID(p.ByName("id"))
+	if err == database.ErrNotFoundError {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("%s.findCertificate, Certificates.FindByID error: %+v\n", serviceInfo.GetServiceName(), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return cert, true
+}
+
+// findCustomer reads the Customer identified by the :id URL parameter. On
+// failure it calls http.Error and returns false.
+func findCustomer(w http.ResponseWriter, p httprouter.Params) (*customer.Customer, bool) {
+	sn := serviceInfo.GetServiceName()
+
+	customerID, err := strconv.Atoi(p.ByName("id"))
+	if err != nil || customerID <= 0 {
+		http.Error(w, "invalid customer id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	found, err := customers.FindByID(customerID)
+	if err == database.ErrNotFoundError {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("%s.findCustomer, customers.FindByID error: %+v\n", sn, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return found, true
+}
+
+func writeCustomer(w http.ResponseWriter, status int, c *customer.Customer) {
+	writeJSON(w, status, c)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("%s.writeJSON, json.NewEncoder.Encode error: %+v\n", serviceInfo.GetServiceName(), err)
+	}
+}
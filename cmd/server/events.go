@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/notify"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// maxEventStream is how long an event stream stays open; clients reconnect
+// with the same request
+const maxEventStream = 10 * time.Minute
+
+// eventKeepAlive is how often an idle event stream sends a comment, so
+// proxies don't close it
+const eventKeepAlive = 15 * time.Second
+
+// eventsHandler returns the handler func for GET /requests/:uuid/events, a
+// Server-Sent Events stream with a "status" event for the request as it is,
+// then one for each change of status or stage. The stream ends after the
+// request is COMPLETED or ERROR.
+func eventsHandler(notifier notify.Notifier) httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		reqID, err := uuid.Parse(p.ByName("uuid"))
+		if err != nil || reqID == uuid.Nil {
+			http.Error(w, "invalid request id", http.StatusBadRequest)
+			return
+		}
+
+		current, err := repo.FindByID(reqID)
+		if err == database.ErrNotFoundError {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("%s.eventsHandler, repo.FindByID error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ownedByCaller(w, r, current) {
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			log.Printf("%s.eventsHandler, %T can't stream\n", sn, w)
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), maxEventStream)
+		defer cancel()
+		changes, err := notifier.Subscribe(ctx, reqID)
+		if err != nil {
+			log.Printf("%s.eventsHandler, Subscribe error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// re-read, in case it changed before the subscription began
+		if current, err = repo.FindByID(reqID); err != nil {
+			log.Printf("%s.eventsHandler, repo.FindByID error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		seq := 0
+		send := func(req *request.Request) bool {
+			seq++
+			data, _ := json.Marshal(request.RequestSummary{
+				RequestID:    req.RequestID.String(),
+				CustomerID:   req.CustomerID,
+				MediaFileURI: req.MediaFileURI,
+				Status:       req.Status,
+				Stage:        req.Stage,
+				AcceptedAt:   req.AcceptedAt,
+				CompletedAt:  req.CompletedAt,
+				Endpoint:     statusEndpoint(req),
+			})
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: status\ndata: %s\n\n", seq, data); err != nil {
+				return false
+			}
+			flusher.Flush()
+			return req.Status == request.Pending
+		}
+
+		if !send(current) {
+			return
+		}
+
+		keepAlive := time.NewTicker(eventKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case _, ok := <-changes:
+				if !ok {
+					return // client gone, or maxEventStream
+				}
+				next, err := repo.FindByID(reqID)
+				if err != nil {
+					log.Printf("%s.eventsHandler, repo.FindByID error: %+v\n", sn, err)
+					return
+				}
+				if next.Status == current.Status && next.Stage == current.Stage {
+					continue
+				}
+				current = next
+				if !send(current) {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// statusEndpoint is where to GET the request's status, or its transcripts
+// once it's COMPLETED
+func statusEndpoint(req *request.Request) string {
+	if req.Status == request.Completed {
+		return getLocationURI(req.RequestID)
+	}
+	return getStatusURI(req.RequestID)
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,12 +22,21 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/spf13/viper"
 
+	"github.com/peterpla/lead-expert/pkg/audit"
 	"github.com/peterpla/lead-expert/pkg/config"
+	"github.com/peterpla/lead-expert/pkg/customer"
 	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/erasure"
+	"github.com/peterpla/lead-expert/pkg/eta"
+	"github.com/peterpla/lead-expert/pkg/media"
 	"github.com/peterpla/lead-expert/pkg/middleware"
+	"github.com/peterpla/lead-expert/pkg/notify"
 	"github.com/peterpla/lead-expert/pkg/queue"
+	"github.com/peterpla/lead-expert/pkg/ratelimit"
 	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/retention"
 	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+	"github.com/peterpla/lead-expert/pkg/webhook"
 )
 
 var prefix = "TaskDefault"
@@ -28,6 +44,7 @@ var initLogPrefix = "default.main.init(),"
 var cfg config.Config
 var apiPrefix = "/api/v1"
 var repo request.RequestRepository
+var customers customer.CustomerRepository
 var q queue.Queue
 var qi = queue.QueueInfo{}
 var qs queue.QueueService
@@ -53,8 +70,25 @@ func main() {
 
 	defer catch() // implements recover so panics reported
 
+	// one sink for everything this service audits, so file sinks keep a single chain
+	auditSink := database.NewAuditSink(&cfg)
+
 	// connect to the Request database
-	repo = database.NewFirestoreRequestRepository(cfg.ProjectID, cfg.DatabaseRequests)
+	repo = database.NewRequestRepository(&cfg, auditSink)
+
+	// connect to the Customer database
+	customers = database.NewFirestoreCustomerRepository(cfg.ProjectID, cfg.DatabaseCustomers)
+
+	mediaStore := media.NewStore(&cfg)
+
+	// enforces customers' retention settings
+	purger := &retention.Purger{
+		Requests:  repo,
+		Customers: customers,
+		Media:     mediaStore,
+		MediaRoot: cfg.MediaStore,
+		Audit:     auditSink,
+	}
 
 	if cfg.IsGAE {
 		q = queue.NewGCTQueue(&qi) // use Google Cloud Tasks for queueing
@@ -65,10 +99,80 @@ func main() {
 	qs = queue.NewService(q)
 	_ = qs
 
+	// the webhook delivery log
+	deliveries := database.NewFirestoreDeliveryRepository(cfg.ProjectID, cfg.DatabaseWebhooks)
+
+	// honors end callers' requests to delete their data
+	eraser := &erasure.Eraser{
+		Requests:     repo,
+		Media:        mediaStore,
+		Queue:        q,
+		Queues:       queue.PipelineQueueInfos(&cfg),
+		Certificates: database.NewFirestoreCertificateRepository(cfg.ProjectID, cfg.DatabaseErasures),
+		Deliveries:   deliveries,
+		Audit:        auditSink,
+	}
+
+	// long-polls and event streams wait on every service's writes to requests
+	notifier := database.NewFirestoreNotifier(cfg.ProjectID, cfg.DatabaseRequests)
+
+	// tells customers their requests completed or failed
+	webhooks := &webhook.Dispatcher{
+		Deliveries: deliveries,
+		Customers:  customers,
+	}
+
+	// public API callers authenticate with an API key, or a partner's JWT
+	auths := []middleware.Authenticator{middleware.NewAPIKeyAuthenticator(customers)}
+	if cfg.JWTIssuer != "" {
+		keys, err := middleware.NewKeySource(cfg.JWKS)
+		if err != nil {
+			log.Fatalf("%s.main, JWKS error: %v", sn, err)
+		}
+		auths = append(auths, &middleware.JWTAuthenticator{
+			Issuer:        cfg.JWTIssuer,
+			Audience:      cfg.JWTAudience,
+			Keys:          keys,
+			CustomerClaim: cfg.JWTCustomerClaim,
+			Leeway:        time.Minute,
+		})
+	}
+	// submissions are limited by each customer's rate limits and quotas
+	limits := &ratelimit.Enforcer{
+		Limiter: ratelimit.NewLimiter(),
+		Usage:   database.NewFirestoreUsageStore(cfg.ProjectID, cfg.DatabaseUsage),
+	}
+
+	// pending requests' ETAs come from recently completed ones, read
+	// without decrypting their transcripts
+	estimator := eta.NewEstimator(database.NewFirestoreRequestRepository(cfg.ProjectID, cfg.DatabaseRequests))
+
+	api := func(scope string, next httprouter.Handle) httprouter.Handle {
+		return middleware.RequireAuth(auths, middleware.RequireScope(scope, next))
+	}
+
 	router := httprouter.New()
-	router.POST(apiPrefix+"/requests", postHandler(q))
-	router.GET(apiPrefix+"/status/:uuid", getStatusHandler())
-	router.GET(apiPrefix+"/transcripts/:uuid", getTranscriptsHandler())
+	router.POST(apiPrefix+"/requests", api(middleware.ScopeRequestsWrite, middleware.RateLimit(customers, limits, postHandler(q))))
+	router.GET(apiPrefix+"/requests", api(middleware.ScopeRequestsRead, listHandler()))
+	router.GET(apiPrefix+"/requests/:uuid/events", api(middleware.ScopeRequestsRead, eventsHandler(notifier)))
+	router.GET(apiPrefix+"/status/:uuid", api(middleware.ScopeRequestsRead, getStatusHandler(notifier, estimator)))
+	router.GET(apiPrefix+"/transcripts/:uuid", api(middleware.ScopeTranscriptsRead, getTranscriptsHandler(auditSink, estimator)))
+	router.POST(adminPrefix+"/customers", middleware.RequireAdmin(cfg.AdminToken, createCustomerHandler()))
+	router.GET(adminPrefix+"/customers/:id", middleware.RequireAdmin(cfg.AdminToken, getCustomerHandler()))
+	router.PUT(adminPrefix+"/customers/:id", middleware.RequireAdmin(cfg.AdminToken, updateCustomerHandler()))
+	router.DELETE(adminPrefix+"/customers/:id", middleware.RequireAdmin(cfg.AdminToken, deleteCustomerHandler()))
+	router.POST(adminPrefix+"/customers/:id/keys", middleware.RequireAdmin(cfg.AdminToken, issueKeyHandler()))
+	router.POST(adminPrefix+"/customers/:id/keys/:key_id/rotate", middleware.RequireAdmin(cfg.AdminToken, rotateKeyHandler()))
+	router.DELETE(adminPrefix+"/customers/:id/keys/:key_id", middleware.RequireAdmin(cfg.AdminToken, revokeKeyHandler()))
+	router.POST(adminPrefix+"/purge", middleware.RequireAdmin(cfg.AdminToken, purgeHandler(purger)))
+	router.POST(adminPrefix+"/erasures", middleware.RequireAdmin(cfg.AdminToken, createErasureHandler(eraser)))
+	router.GET(adminPrefix+"/erasures/:id", middleware.RequireAdmin(cfg.AdminToken, getErasureHandler(eraser)))
+	router.GET(adminPrefix+"/erasures/:id/verify", middleware.RequireAdmin(cfg.AdminToken, verifyErasureHandler(eraser)))
+	router.GET(adminPrefix+"/webhooks/deliveries", middleware.RequireAdmin(cfg.AdminToken, listDeliveriesHandler(webhooks)))
+	router.GET(adminPrefix+"/webhooks/deliveries/:id", middleware.RequireAdmin(cfg.AdminToken, getDeliveryHandler(webhooks)))
+	router.POST(adminPrefix+"/webhooks/deliveries/:id/replay", middleware.RequireAdmin(cfg.AdminToken, replayDeliveryHandler(webhooks)))
+	router.GET("/cron/purge", middleware.RequireCron(purgeHandler(purger)))
+	router.GET("/cron/webhooks", middleware.RequireCron(retryWebhooksHandler(webhooks)))
 	router.GET("/", indexHandler)
 	router.NotFound = http.HandlerFunc(myNotFound)
 	cfg.Router = router
@@ -121,15 +225,51 @@ func postHandler(q queue.Queue) httprouter.Handle {
 		startTime := time.Now().UTC()
 		// log.Printf("%s.main.postHandler, enter, repo: %+v\n", sn, repo)
 
-		newRequest := request.Request{}
-		if err = newRequest.ReadRequest(w, r, p, validate); err != nil {
+		// only the customer's fields are read; a Request's ID, status,
+		// media copies etc. are set here and by the pipeline
+		submission := request.Submission{}
+		if err = request.ReadJSON(w, r, &submission, validate); err != nil {
 			// log.Printf("%s.postHandler, err: %v\n", sn, err)
-			// readRequest calls http.Error() on error
+			// ReadJSON calls http.Error() on error
 			return
 		}
+		newRequest := submission.Request()
+
+		// customers submit only their own requests
+		caller, ok := callerID(w, r)
+		if !ok {
+			return
+		}
+		if newRequest.CustomerID != caller {
+			log.Printf("%s.postHandler, customer %d submitted for customer %d\n", sn, caller, newRequest.CustomerID)
+			http.Error(w, "customer_id does not match API key", http.StatusForbidden)
+			return
+		}
+
+		// only known, enabled customers may submit requests
+		cust, err := customers.FindByID(newRequest.CustomerID)
+		if err == database.ErrNotFoundError {
+			log.Printf("%s.postHandler, unknown customer %d\n", sn, newRequest.CustomerID)
+			http.Error(w, "unknown customer", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Printf("%s.postHandler, customers.FindByID error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err = cust.CanSubmit(); err != nil {
+			log.Printf("%s.postHandler, customer %d: %v\n", sn, newRequest.CustomerID, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		// custom_config overrides the customer's defaults for this Request only
+		newRequest.ResolveConfig(cust.ProcessingDefaults())
+
 		newRequest.RequestID = uuid.New()
 		newRequest.Version = request.RequestVersion
-		newRequest.AcceptedAt = time.Now().UTC().Format(time.RFC3339Nano)
+		newRequest.AcceptedAt = time.Now().UTC().Format(request.TimeLayout)
 		newRequest.Status = request.Pending
 
 		// add timestamps and get duration
@@ -164,8 +304,9 @@ func postHandler(q queue.Queue) httprouter.Handle {
 		}
 
 		// send response to client
-		w.WriteHeader(http.StatusAccepted)
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", getStatusURI(newRequest.RequestID))
+		w.WriteHeader(http.StatusAccepted)
 		if err = json.NewEncoder(w).Encode(response); err != nil {
 			log.Printf("%s.postHandler, json.NewEncoder.Encode error: %+v\n", sn, err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -182,8 +323,81 @@ func getStatusURI(reqID uuid.UUID) string {
 
 // ********** ********** ********** ********** ********** **********
 
-// getStatusHandler returns the handler func for GET /queue
-func getStatusHandler() httprouter.Handle {
+// listHandler returns the handler func for GET /requests
+func listHandler() httprouter.Handle {
+	sn := serviceInfo.GetServiceName()
+
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		startTime := time.Now().UTC()
+
+		opts, err := request.ListOptionsFromQuery(r.URL.Query())
+		if err != nil {
+			log.Printf("%s.listHandler, ListOptionsFromQuery error: %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// only the calling customer's requests are returned
+		caller, ok := callerID(w, r)
+		if !ok {
+			return
+		}
+		if opts.CustomerID == 0 {
+			opts.CustomerID = caller
+		}
+		if opts.CustomerID != caller {
+			log.Printf("%s.listHandler, customer %d listed customer %d\n", sn, caller, opts.CustomerID)
+			http.Error(w, "customer_id does not match API key", http.StatusForbidden)
+			return
+		}
+
+		var result *request.ListResult
+		if result, err = repo.List(opts); err != nil {
+			log.Printf("%s.listHandler, repo.List error: %+v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// provide selected fields of each Request as the HTTP response
+		response := request.ListResponse{
+			Requests:   []request.RequestSummary{},
+			NextCursor: result.NextCursor,
+		}
+		for _, req := range result.Requests {
+			response.Requests = append(response.Requests, request.RequestSummary{
+				RequestID:    req.RequestID.String(),
+				CustomerID:   req.CustomerID,
+				MediaFileURI: req.MediaFileURI,
+				Status:       req.Status,
+				Stage:        req.Stage,
+				AcceptedAt:   req.AcceptedAt,
+				CompletedAt:  req.CompletedAt,
+				Endpoint:     getStatusURI(req.RequestID),
+			})
+		}
+
+		// send response to client
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err = json.NewEncoder(w).Encode(response); err != nil {
+			log.Printf("%s.listHandler, json.NewEncoder.Encode error: %+v\n", sn, err)
+			return
+		}
+
+		log.Printf("%s.listHandler, completed in %v, customer %d, %d requests\n",
+			sn, time.Since(startTime), opts.CustomerID, len(response.Requests))
+	}
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// maxWait caps "?wait=" on GET /status
+const maxWait = 60 * time.Second
+
+// getStatusHandler returns the handler func for GET /status/:uuid. With
+// "?wait=<duration>", a PENDING request's status is returned when its status
+// or stage changes, or when the wait is over, whichever is first.
+func getStatusHandler(notifier notify.Notifier, estimator *eta.Estimator) httprouter.Handle {
 	sn := serviceInfo.GetServiceName()
 	// log.Printf("%s.getStatusHandler, enter/exit\n", sn)
 
@@ -195,9 +409,15 @@ func getStatusHandler() httprouter.Handle {
 
 		var err error
 		reqForStatus := request.Request{}
-		if err = reqForStatus.ReadRequest(w, r, p, validate); err != nil {
+		if reqForStatus.CustomerID, err = queryCustomerID(w, r); err != nil {
 			log.Printf("%s.getStatusHandler, err: %v\n", sn, err)
-			// readRequest calls http.Error() on error, so we're done - return
+			// queryCustomerID calls http.Error() on error, so we're done - return
+			return
+		}
+
+		var wait time.Duration
+		if wait, err = parseWait(r.URL.Query().Get("wait")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -213,7 +433,7 @@ func getStatusHandler() httprouter.Handle {
 		var zeroUUID uuid.UUID
 		if requestedUUID == zeroUUID {
 			log.Printf("%s.getStatusHandler, zero UUID\n", sn)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, "invalid request id", http.StatusBadRequest)
 			return
 		}
 
@@ -254,9 +474,21 @@ func getStatusHandler() httprouter.Handle {
 				return
 			}
 			originalRequest = *returnedReq
-		}
 
-		// TODO: validate the same CustomerID is asking for status of the same MediaFileURI, reqForStatus vs. originalRequest
+			// another customer's request is reported as not found
+			if !ownedByCaller(w, r, &originalRequest) {
+				return
+			}
+
+			if wait > 0 && originalRequest.Status == request.Pending {
+				if returnedReq, err = awaitChange(r.Context(), notifier, &originalRequest, wait); err != nil {
+					log.Printf("%s.getStatusHandler, awaitChange error: %+v\n", sn, err)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				originalRequest = *returnedReq
+			}
+		}
 
 		reqForStatus.CompletedAt = time.Now().UTC().Format(time.RFC3339Nano)
 
@@ -268,22 +500,32 @@ func getStatusHandler() httprouter.Handle {
 			AcceptedAt:        originalRequest.AcceptedAt,
 			OriginalRequestID: originalRequest.RequestID,
 			CompletedAt:       reqForStatus.CompletedAt,
+			Status:            originalRequest.Status,
+			Stage:             originalRequest.Stage,
 		}
 
+		statusCode := http.StatusOK
 		switch originalRequest.Status {
 		case request.Error:
 			response.OriginalStatus = originalRequest.OriginalStatus
+			response.Error = originalRequest.Error
 			response.OriginalCompletedAt = originalRequest.CompletedAt
 		case request.Pending:
-			response.ETA = getETA().Format(time.RFC3339Nano)
+			estimate := estimator.Estimate(&originalRequest, time.Now().UTC())
+			response.ETA = estimate.ETA.Format(time.RFC3339Nano)
+			response.ETAEarliest = estimate.Earliest.Format(time.RFC3339Nano)
+			response.ETALatest = estimate.Latest.Format(time.RFC3339Nano)
 			response.Endpoint = getStatusURI(originalRequest.RequestID)
+			w.Header().Set("Retry-After", retryAfter(estimate.ETA))
 		case request.Completed:
 			response.Endpoint = getLocationURI(originalRequest.RequestID)
 			response.OriginalStatus = originalRequest.OriginalStatus
 			response.OriginalCompletedAt = originalRequest.CompletedAt
+			w.Header().Set("Location", response.Endpoint)
+			statusCode = http.StatusSeeOther
 		default:
 			log.Printf("%s.getStatusHandler, invalid originalRequest.Status: %v\n", sn, originalRequest.Status)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
@@ -295,11 +537,10 @@ func getStatusHandler() httprouter.Handle {
 		}
 
 		// send response to client
-		w.WriteHeader(http.StatusOK)
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
 		if err := json.NewEncoder(w).Encode(response); err != nil {
-			log.Printf("%s.postHandler, json.NewEncoder.Encode error: %+v\n", sn, err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			log.Printf("%s.getStatusHandler, json.NewEncoder.Encode error: %+v\n", sn, err)
 			return
 		}
 
@@ -307,11 +548,52 @@ func getStatusHandler() httprouter.Handle {
 	}
 }
 
-func getETA() time.Time {
-	// TODO: calculate multiplier based on recent processing time
-	etaTime := time.Now().UTC()
-	etaTime = etaTime.Add(time.Second * 45) // blindly guess 45 seconds from now
-	return etaTime
+// parseWait parses "?wait=", a duration ("30s") or seconds ("30"), capped at maxWait
+func parseWait(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(v)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(v)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid wait %q", v)
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait < 0 {
+		return 0, fmt.Errorf("invalid wait %q", v)
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+	return wait, nil
+}
+
+// awaitChange returns the Request once its status or stage differs from
+// req's, or as it is when wait is over
+func awaitChange(ctx context.Context, notifier notify.Notifier, req *request.Request, wait time.Duration) (*request.Request, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	changes, err := notifier.Subscribe(ctx, req.RequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	// re-read first, in case it changed before the subscription began
+	for {
+		current, err := repo.FindByID(req.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		if current.Status != req.Status || current.Stage != req.Stage {
+			return current, nil
+		}
+		if _, ok := <-changes; !ok {
+			return current, nil
+		}
+	}
 }
 
 func getLocationURI(reqID uuid.UUID) string {
@@ -319,10 +601,101 @@ func getLocationURI(reqID uuid.UUID) string {
 
 }
 
+// retryAfter is the Retry-After header value for eta, in whole seconds
+// from now and at least 1
+func retryAfter(eta time.Time) string {
+	seconds := int(math.Ceil(time.Until(eta).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+// transcriptETag is a weak entity tag for the transcript of a completed
+// Request: responses differ in their own request_id and timestamps, but
+// not in what the customer caches
+func transcriptETag(req *request.Request) string {
+	tags, _ := json.Marshal(req.MatchedTags) // sorted keys, so stable
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s", req.RequestID, req.CompletedAt, req.FinalTranscript, tags)
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether the If-None-Match header value matches etag,
+// by weak comparison (RFC 7232 section 3.2)
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// queryCustomerID returns the calling customer's ID, which "?customer_id="
+// may also give. On failure it calls http.Error and returns the error.
+func queryCustomerID(w http.ResponseWriter, r *http.Request) (int, error) {
+	caller, ok := callerID(w, r)
+	if !ok {
+		return 0, errors.New("no authenticated caller")
+	}
+	v := r.URL.Query().Get("customer_id")
+	if v == "" {
+		return caller, nil
+	}
+	customerID, err := strconv.Atoi(v)
+	if err != nil || customerID <= 0 {
+		err = fmt.Errorf("invalid customer_id %q", v)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, err
+	}
+	if customerID != caller {
+		err = fmt.Errorf("customer %d asked as customer %d", caller, customerID)
+		http.Error(w, "customer_id does not match API key", http.StatusForbidden)
+		return 0, err
+	}
+	return caller, nil
+}
+
+// callerID returns the CustomerID of the authenticated caller. On failure
+// it calls http.Error and returns false.
+func callerID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	principal, ok := middleware.PrincipalFrom(r.Context())
+	if !ok {
+		// the route isn't wrapped by RequireAPIKey
+		log.Printf("%s.callerID, no principal for %s %s\n", serviceInfo.GetServiceName(), r.Method, r.URL.Path)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return 0, false
+	}
+	return principal.CustomerID, true
+}
+
+// ownedByCaller returns true if req belongs to the authenticated caller.
+// Otherwise it responds 404, so callers can't probe for other customers'
+// requests, and returns false.
+func ownedByCaller(w http.ResponseWriter, r *http.Request, req *request.Request) bool {
+	caller, ok := callerID(w, r)
+	if !ok {
+		return false
+	}
+	if req.CustomerID != caller {
+		log.Printf("%s.ownedByCaller, customer %d asked for request %s of customer %d\n",
+			serviceInfo.GetServiceName(), caller, req.RequestID, req.CustomerID)
+		http.Error(w, database.ErrNotFoundError.Error(), http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
 // ********** ********** ********** ********** ********** **********
 
-// getTranscriptsHandler returns the handler func for GET /queue
-func getTranscriptsHandler() httprouter.Handle {
+// getTranscriptsHandler returns the handler func for GET /transcripts/:uuid,
+// recording each transcript returned, and who to, to sink. A transcript
+// whose read can't be recorded isn't returned.
+func getTranscriptsHandler(sink audit.Sink, estimator *eta.Estimator) httprouter.Handle {
 	sn := serviceInfo.GetServiceName()
 	// log.Printf("%s.getTranscriptsHandler, enter/exit\n", sn)
 
@@ -334,9 +707,9 @@ func getTranscriptsHandler() httprouter.Handle {
 
 		var err error
 		reqForTranscript := request.Request{}
-		if err = reqForTranscript.ReadRequest(w, r, p, validate); err != nil {
+		if reqForTranscript.CustomerID, err = queryCustomerID(w, r); err != nil {
 			log.Printf("%s.getTranscriptsHandler, err: %v\n", sn, err)
-			// readRequest calls http.Error() on error
+			// queryCustomerID calls http.Error() on error
 			return
 		}
 		reqForTranscript.RequestID = uuid.New()
@@ -362,16 +735,32 @@ func getTranscriptsHandler() httprouter.Handle {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if !ownedByCaller(w, r, requestPointer) {
+			return
+		}
 		if requestPointer.Status != request.Completed {
+			// no transcript yet (or ever, for ERROR); the client polls /status/:uuid until COMPLETED
 			log.Printf("%s.getTranscriptsHandler, Status not COMPLETED: %q\n", sn, requestPointer.Status)
+			w.Header().Set("Location", getStatusURI(requestPointer.RequestID))
+			if requestPointer.Status == request.Pending {
+				w.Header().Set("Retry-After", retryAfter(estimator.Estimate(requestPointer, time.Now().UTC()).ETA))
+			}
 			w.WriteHeader(http.StatusSeeOther)
-			// TODO: implement See Other response with Location: /status/:uuid - client needs to poll until Completed
 			return
 		}
 
 		returnedRequest := *requestPointer
 
-		// TODO: validate the same CustomerID is asking for status of the same MediaFileURI, reqForStatus vs. originalRequest
+		// a completed transcript changes only if redacted or erased, so
+		// clients may revalidate the copy they have
+		etag := transcriptETag(&returnedRequest)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			log.Printf("%s.getTranscriptsHandler, %s not modified\n", sn, returnedRequest.RequestID)
+			return
+		}
 
 		// add timestamps and get duration
 		var duration time.Duration
@@ -380,6 +769,17 @@ func getTranscriptsHandler() httprouter.Handle {
 			return
 		}
 
+		// the read is recorded, by whom, before anything is shown
+		principal, _ := middleware.PrincipalFrom(r.Context()) // ownedByCaller found it
+		rec := audit.NewRecord(returnedRequest.CustomerID, returnedRequest.RequestID.String(),
+			audit.ActionReadTranscript, "final_transcript", "tags")
+		rec.Actor = principal.String()
+		if err = sink.Write(rec); err != nil {
+			log.Printf("%s.getTranscriptsHandler, audit Write error: %v, record: %+v\n", sn, err, rec)
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
 		// provide selected fields of Request as the HTTP response
 		response := request.GetTranscriptResponse{
 			RequestID:           reqForTranscript.RequestID, // this request for transcript
@@ -395,11 +795,10 @@ func getTranscriptsHandler() httprouter.Handle {
 		}
 
 		// send response to client
-		w.WriteHeader(http.StatusOK)
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
 		if err = json.NewEncoder(w).Encode(response); err != nil {
 			log.Printf("%s.getTranscriptsHandler, json.NewEncoder.Encode error: %+v\n", sn, err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
@@ -1,29 +1,52 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-playground/validator"
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/peterpla/lead-expert/pkg/audit"
 	"github.com/peterpla/lead-expert/pkg/config"
+	"github.com/peterpla/lead-expert/pkg/customer"
 	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/erasure"
+	"github.com/peterpla/lead-expert/pkg/eta"
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/middleware"
+	"github.com/peterpla/lead-expert/pkg/notify"
 	"github.com/peterpla/lead-expert/pkg/queue"
 	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/retention"
+	"github.com/peterpla/lead-expert/pkg/webhook"
 )
 
 // var validate *validator.Validate
 var createdUUID = uuid.UUID{}
 
+// asCustomer wraps next as RequireAPIKey would, authenticated as customerID
+func asCustomer(customerID int, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		ctx := middleware.WithPrincipal(r.Context(), middleware.Principal{CustomerID: customerID})
+		next(w, r.WithContext(ctx), p)
+	}
+}
+
 func TestDefaultPost(t *testing.T) {
 
 	cfg := config.GetConfigPointer()
@@ -74,6 +97,22 @@ func TestDefaultPost(t *testing.T) {
 			body:     `{ "customer_id": 1234567, "media_uri": "lollipop" }`,
 			respBody: "Error:Field validation for 'MediaFileURI'",
 			status:   http.StatusBadRequest},
+		// fields the pipeline sets
+		{name: "working_media_uri",
+			endpoint: "/requests",
+			body:     `{ "customer_id": 1234567, "media_uri": "https://example.com/audio-01.mp3", "working_media_uri": "gs://media/media/7654321/other.mp3" }`,
+			respBody: "unknown field \"working_media_uri\"",
+			status:   http.StatusBadRequest},
+		{name: "media_purged_at",
+			endpoint: "/requests",
+			body:     `{ "customer_id": 1234567, "media_uri": "https://example.com/audio-01.mp3", "media_purged_at": "2020-01-01T00:00:00Z" }`,
+			respBody: "unknown field \"media_purged_at\"",
+			status:   http.StatusBadRequest},
+		{name: "operation",
+			endpoint: "/requests",
+			body:     `{ "customer_id": 1234567, "media_uri": "https://example.com/audio-01.mp3", "operation": { "name": "123" } }`,
+			respBody: "unknown field \"operation\"",
+			status:   http.StatusBadRequest},
 	}
 
 	qi = queue.QueueInfo{}
@@ -94,7 +133,7 @@ func TestDefaultPost(t *testing.T) {
 		// log.Printf("Test %s: %s", tc.name, url)
 
 		router := httprouter.New()
-		router.POST("/api/v1/requests", postHandler(q))
+		router.POST("/api/v1/requests", asCustomer(1234567, postHandler(q)))
 
 		// build the POST request with custom header
 		theRequest, err := http.NewRequest("POST", url, strings.NewReader(tc.body))
@@ -162,7 +201,7 @@ func TestDefaultGetStatus(t *testing.T) {
 	prefix := fmt.Sprintf("https://%s%s.appspot.com%s", servicePrefix, cfg.ProjectID, apiPrefix)
 
 	router := httprouter.New()
-	router.GET(apiPrefix+"/status/:uuid", getStatusHandler())
+	router.GET(apiPrefix+"/status/:uuid", asCustomer(1234567, getStatusHandler(notify.NewBroker(), eta.NewEstimator(repo))))
 
 	// build the GET request with custom header
 	url := prefix + "/status/" + testUUID.String()
@@ -230,7 +269,7 @@ func TestDefaultGetStatusSpecial(t *testing.T) {
 			uuid:     request.CompletedUUIDStr,
 			body:     `{ "customer_id": 1234567, "media_uri": "gs://elated-practice-224603.appspot.com/audio_uploads/audio-01.mp3" }`,
 			respBody: "endpoint",
-			status:   http.StatusOK,
+			status:   http.StatusSeeOther,
 		},
 		{name: "GET status PENDING",
 			endpoint: "/status/",
@@ -246,6 +285,35 @@ func TestDefaultGetStatusSpecial(t *testing.T) {
 			respBody: "original_status",
 			status:   http.StatusOK,
 		},
+		{name: "GET status without body",
+			endpoint: "/status/",
+			uuid:     request.PendingUUIDStr,
+			respBody: "eta",
+			status:   http.StatusOK,
+		},
+		{name: "GET status as own customer_id",
+			endpoint: "/status/",
+			uuid:     request.PendingUUIDStr + "?customer_id=1234567",
+			respBody: "eta",
+			status:   http.StatusOK,
+		},
+		// invalid
+		{name: "GET status as another customer_id",
+			endpoint: "/status/",
+			uuid:     request.PendingUUIDStr + "?customer_id=7654321",
+			status:   http.StatusForbidden,
+		},
+		{name: "GET status as invalid customer_id",
+			endpoint: "/status/",
+			uuid:     request.PendingUUIDStr + "?customer_id=abc",
+			status:   http.StatusBadRequest,
+		},
+		{name: "GET status zero UUID",
+			endpoint: "/status/",
+			uuid:     uuid.Nil.String(),
+			respBody: "invalid request id",
+			status:   http.StatusBadRequest,
+		},
 	}
 
 	apiPrefix := "/api/v1"
@@ -256,7 +324,7 @@ func TestDefaultGetStatusSpecial(t *testing.T) {
 	for _, tc := range tests {
 
 		router := httprouter.New()
-		router.GET(apiPrefix+tc.endpoint+":uuid", getStatusHandler())
+		router.GET(apiPrefix+tc.endpoint+":uuid", asCustomer(1234567, getStatusHandler(notify.NewBroker(), eta.NewEstimator(repo))))
 
 		tempUUID := tc.uuid
 		if tc.uuid == "generate" {
@@ -330,7 +398,7 @@ func TestDefaultGetTranscripts(t *testing.T) {
 	for _, tc := range tests {
 
 		router := httprouter.New()
-		router.GET(apiPrefix+tc.endpoint+":uuid", getTranscriptsHandler())
+		router.GET(apiPrefix+tc.endpoint+":uuid", asCustomer(1234567, getTranscriptsHandler(audit.NewStdoutSink(), eta.NewEstimator(repo))))
 
 		// build the GET request with custom header
 		url := prefix + tc.endpoint + "752b8d94-c8d8-4a92-978e-9f397153f7c7" // use a known UUID
@@ -364,3 +432,827 @@ func TestDefaultGetTranscripts(t *testing.T) {
 		}
 	}
 }
+
+func TestDefaultList(t *testing.T) {
+
+	// use an in-memory database so the expected results are known
+	repo = database.NewMemoryRequestRepository()
+
+	validate = validator.New()
+
+	for _, customerID := range []int{1234567, 1234567, 1234567, 7654321} {
+		req := request.Request{
+			RequestID:    uuid.New(),
+			CustomerID:   customerID,
+			MediaFileURI: "gs://elated-practice-224603.appspot.com/audio_uploads/audio-01.mp3",
+			Status:       request.Pending,
+			AcceptedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		if err := repo.Create(&req); err != nil {
+			t.Fatalf("Create error: %v", err)
+		}
+	}
+
+	type test struct {
+		name     string
+		query    string
+		count    int
+		more     bool
+		respBody string
+		status   int
+	}
+
+	tests := []test{
+		// valid
+		{name: "all for customer",
+			query:  "?customer_id=1234567",
+			count:  3,
+			status: http.StatusOK},
+		{name: "paged",
+			query:  "?customer_id=1234567&limit=2",
+			count:  2,
+			more:   true,
+			status: http.StatusOK},
+		{name: "no matches",
+			query:  "?customer_id=1234567&status=COMPLETED",
+			count:  0,
+			status: http.StatusOK},
+		{name: "customer_id implied by API key",
+			query:  "",
+			count:  3,
+			status: http.StatusOK},
+		// invalid
+		{name: "another customer",
+			query:    "?customer_id=7654321",
+			respBody: "customer_id does not match",
+			status:   http.StatusForbidden},
+		{name: "bad status",
+			query:    "?customer_id=1234567&status=LOST",
+			respBody: "unknown status",
+			status:   http.StatusBadRequest},
+	}
+
+	apiPrefix := "/api/v1"
+
+	for _, tc := range tests {
+
+		router := httprouter.New()
+		router.GET(apiPrefix+"/requests", asCustomer(1234567, listHandler()))
+
+		theRequest, err := http.NewRequest("GET", apiPrefix+"/requests"+tc.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// response recorder
+		rr := httptest.NewRecorder()
+
+		// send the request
+		router.ServeHTTP(rr, theRequest)
+
+		if tc.status != rr.Code {
+			t.Errorf("%s: expected status code %v, got %v", tc.name, tc.status, rr.Code)
+		}
+
+		var b []byte
+		if b, err = ioutil.ReadAll(rr.Body); err != nil {
+			t.Fatalf("%s: ReadAll error: %v", tc.name, err)
+		}
+
+		if tc.respBody != "" {
+			if !strings.Contains(string(b), tc.respBody) {
+				t.Errorf("%s: expected %q, not found (in %q)", tc.name, tc.respBody, string(b))
+			}
+			continue
+		}
+
+		var response request.ListResponse
+		if err := json.Unmarshal(b, &response); err != nil {
+			t.Fatalf("%s: json.Unmarshal error: %+v", tc.name, err)
+		}
+		if len(response.Requests) != tc.count {
+			t.Errorf("%s: expected %d requests, got %d", tc.name, tc.count, len(response.Requests))
+		}
+		if (response.NextCursor != "") != tc.more {
+			t.Errorf("%s: expected more %v, got next_cursor %q", tc.name, tc.more, response.NextCursor)
+		}
+	}
+}
+
+func TestDefaultAdminCustomers(t *testing.T) {
+
+	customers = database.NewMemoryCustomerRepository()
+
+	validate = validator.New()
+
+	token := "test-admin-token"
+
+	type test struct {
+		name     string
+		method   string
+		endpoint string
+		token    string
+		body     string
+		respBody string
+		status   int
+	}
+
+	tests := []test{
+		{name: "no token",
+			method:   "POST",
+			endpoint: "/customers",
+			body:     `{ "customer_id": 1234567, "name": "Park Flooring" }`,
+			status:   http.StatusUnauthorized},
+		{name: "create",
+			method:   "POST",
+			endpoint: "/customers",
+			token:    token,
+			body:     `{ "customer_id": 1234567, "name": "Park Flooring", "contact": { "email": "michael@example.com" } }`,
+			respBody: "Park Flooring",
+			status:   http.StatusCreated},
+		{name: "create duplicate",
+			method:   "POST",
+			endpoint: "/customers",
+			token:    token,
+			body:     `{ "customer_id": 1234567, "name": "Park Flooring" }`,
+			status:   http.StatusConflict},
+		{name: "create invalid email",
+			method:   "POST",
+			endpoint: "/customers",
+			token:    token,
+			body:     `{ "customer_id": 7654321, "name": "Oak Floors", "contact": { "email": "nope" } }`,
+			respBody: "Error:Field validation for 'Email'",
+			status:   http.StatusBadRequest},
+		{name: "get",
+			method:   "GET",
+			endpoint: "/customers/1234567",
+			token:    token,
+			respBody: "michael@example.com",
+			status:   http.StatusOK},
+		{name: "update mismatched id",
+			method:   "PUT",
+			endpoint: "/customers/1234567",
+			token:    token,
+			body:     `{ "customer_id": 7654321, "name": "Park Flooring" }`,
+			status:   http.StatusBadRequest},
+		{name: "update",
+			method:   "PUT",
+			endpoint: "/customers/1234567",
+			token:    token,
+			body:     `{ "customer_id": 1234567, "name": "Park Flooring", "disabled": true }`,
+			respBody: `"disabled":true`,
+			status:   http.StatusOK},
+		{name: "delete",
+			method:   "DELETE",
+			endpoint: "/customers/1234567",
+			token:    token,
+			status:   http.StatusNoContent},
+		{name: "get deleted",
+			method:   "GET",
+			endpoint: "/customers/1234567",
+			token:    token,
+			status:   http.StatusNotFound},
+	}
+
+	router := httprouter.New()
+	router.POST(adminPrefix+"/customers", middleware.RequireAdmin(token, createCustomerHandler()))
+	router.GET(adminPrefix+"/customers/:id", middleware.RequireAdmin(token, getCustomerHandler()))
+	router.PUT(adminPrefix+"/customers/:id", middleware.RequireAdmin(token, updateCustomerHandler()))
+	router.DELETE(adminPrefix+"/customers/:id", middleware.RequireAdmin(token, deleteCustomerHandler()))
+
+	for _, tc := range tests {
+
+		theRequest, err := http.NewRequest(tc.method, adminPrefix+tc.endpoint, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.token != "" {
+			theRequest.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+
+		// response recorder
+		rr := httptest.NewRecorder()
+
+		// send the request
+		router.ServeHTTP(rr, theRequest)
+
+		if tc.status != rr.Code {
+			t.Errorf("%s: %s %q expected status code %v, got %v", tc.name, tc.method, tc.endpoint, tc.status, rr.Code)
+		}
+
+		if tc.respBody != "" {
+			var b []byte
+			if b, err = ioutil.ReadAll(rr.Body); err != nil {
+				t.Fatalf("%s: ReadAll error: %v", tc.name, err)
+			}
+			if !strings.Contains(string(b), tc.respBody) {
+				t.Errorf("%s: expected %q, not found (in %q)", tc.name, tc.respBody, string(b))
+			}
+		}
+	}
+}
+
+func TestDefaultAPIKeys(t *testing.T) {
+
+	repo = database.NewMemoryRequestRepository()
+	customers = database.NewMemoryCustomerRepository()
+	validate = validator.New()
+	token := "admin-secret"
+
+	for _, customerID := range []int{1234567, 7654321} {
+		_ = customers.Create(&customer.Customer{CustomerID: customerID, Name: "Park Flooring"})
+	}
+	other := request.Request{
+		RequestID:    uuid.New(),
+		CustomerID:   7654321,
+		MediaFileURI: "gs://elated-practice-224603.appspot.com/audio_uploads/audio-01.mp3",
+		Status:       request.Completed,
+		AcceptedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	_ = repo.Create(&other)
+
+	router := httprouter.New()
+	router.GET(apiPrefix+"/requests", middleware.RequireAPIKey(customers, listHandler()))
+	router.GET(apiPrefix+"/status/:uuid", middleware.RequireAPIKey(customers, getStatusHandler(notify.NewBroker(), eta.NewEstimator(repo))))
+	router.GET(apiPrefix+"/transcripts/:uuid", middleware.RequireAPIKey(customers, getTranscriptsHandler(audit.NewStdoutSink(), eta.NewEstimator(repo))))
+	router.POST(adminPrefix+"/customers/:id/keys", middleware.RequireAdmin(token, issueKeyHandler()))
+	router.POST(adminPrefix+"/customers/:id/keys/:key_id/rotate", middleware.RequireAdmin(token, rotateKeyHandler()))
+	router.DELETE(adminPrefix+"/customers/:id/keys/:key_id", middleware.RequireAdmin(token, revokeKeyHandler()))
+
+	send := func(method string, path string, bearer string) *httptest.ResponseRecorder {
+		theRequest, err := http.NewRequest(method, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bearer != "" {
+			theRequest.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, theRequest)
+		return rr
+	}
+	issue := func(path string) issuedKey {
+		rr := send("POST", adminPrefix+path, token)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("POST %s: expected status code %v, got %v", path, http.StatusCreated, rr.Code)
+		}
+		var issued issuedKey
+		if err := json.Unmarshal(rr.Body.Bytes(), &issued); err != nil {
+			t.Fatalf("POST %s: json.Unmarshal error: %v", path, err)
+		}
+		return issued
+	}
+
+	first := issue("/customers/1234567/keys")
+	if !strings.HasPrefix(first.Key, customer.APIKeyPrefix+first.KeyID+"_") {
+		t.Errorf("expected key %q to carry key_id %q", first.Key, first.KeyID)
+	}
+	stored, _ := customers.FindByID(1234567)
+	if strings.Contains(fmt.Sprintf("%+v", stored), strings.TrimPrefix(first.Key, customer.APIKeyPrefix+first.KeyID+"_")) {
+		t.Errorf("expected only the key's hash stored")
+	}
+
+	// keys rotated with a grace period overlap their replacement
+	second := issue("/customers/1234567/keys/" + first.KeyID + "/rotate?grace=1h")
+	if second.Replaces != first.KeyID {
+		t.Errorf("expected rotated key to replace %q, got %q", first.KeyID, second.Replaces)
+	}
+	third := issue("/customers/1234567/keys/" + second.KeyID + "/rotate")
+
+	type test struct {
+		name   string
+		method string
+		path   string
+		bearer string
+		status int
+	}
+
+	tests := []test{
+		{name: "no key", method: "GET", path: apiPrefix + "/requests", status: http.StatusUnauthorized},
+		{name: "not a key", method: "GET", path: apiPrefix + "/requests", bearer: token, status: http.StatusUnauthorized},
+		{name: "wrong secret", method: "GET", path: apiPrefix + "/requests", bearer: first.Key[:len(first.Key)-1] + "x", status: http.StatusUnauthorized},
+		{name: "key in grace period", method: "GET", path: apiPrefix + "/requests", bearer: first.Key, status: http.StatusOK},
+		{name: "rotated without grace", method: "GET", path: apiPrefix + "/requests", bearer: second.Key, status: http.StatusUnauthorized},
+		{name: "current key", method: "GET", path: apiPrefix + "/requests", bearer: third.Key, status: http.StatusOK},
+		{name: "another customer's status", method: "GET", path: apiPrefix + "/status/" + other.RequestID.String(), bearer: third.Key, status: http.StatusNotFound},
+		{name: "another customer's transcript", method: "GET", path: apiPrefix + "/transcripts/" + other.RequestID.String(), bearer: third.Key, status: http.StatusNotFound},
+		{name: "revoke", method: "DELETE", path: adminPrefix + "/customers/1234567/keys/" + third.KeyID, bearer: token, status: http.StatusNoContent},
+		{name: "revoked key", method: "GET", path: apiPrefix + "/requests", bearer: third.Key, status: http.StatusUnauthorized},
+		{name: "revoke unknown key", method: "DELETE", path: adminPrefix + "/customers/1234567/keys/nope", bearer: token, status: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		if rr := send(tc.method, tc.path, tc.bearer); rr.Code != tc.status {
+			t.Errorf("%s: %s %q expected status code %v, got %v", tc.name, tc.method, tc.path, tc.status, rr.Code)
+		}
+	}
+}
+
+func TestDefaultPurge(t *testing.T) {
+
+	repo = database.NewMemoryRequestRepository()
+	customers = database.NewMemoryCustomerRepository()
+	_ = customers.Create(&customer.Customer{CustomerID: 1234567, Name: "Park Flooring",
+		Retention: customer.Retention{TranscriptDays: 30}})
+
+	old := request.Request{
+		RequestID:       uuid.New(),
+		CustomerID:      1234567,
+		MediaFileURI:    "gs://bucket/file.mp3",
+		Status:          request.Completed,
+		AcceptedAt:      time.Now().UTC().AddDate(0, 0, -31).Format(time.RFC3339Nano),
+		FinalTranscript: "[Speaker 1] Hello",
+	}
+	_ = repo.Create(&old)
+
+	purger := &retention.Purger{Requests: repo, Customers: customers, Media: media.NewGCSStore(""), Audit: audit.NewStdoutSink()}
+
+	type test struct {
+		name     string
+		endpoint string
+		cron     bool
+		respBody string
+		status   int
+	}
+
+	tests := []test{
+		{name: "not from cron",
+			endpoint: "/cron/purge?dry_run=true",
+			status:   http.StatusForbidden},
+		{name: "bad dry_run",
+			endpoint: "/cron/purge?dry_run=maybe",
+			cron:     true,
+			status:   http.StatusBadRequest},
+		{name: "dry run",
+			endpoint: "/cron/purge?dry_run=true",
+			cron:     true,
+			respBody: `"what":"transcript"`,
+			status:   http.StatusOK},
+	}
+
+	router := httprouter.New()
+	router.GET("/cron/purge", middleware.RequireCron(purgeHandler(purger)))
+
+	for _, tc := range tests {
+
+		theRequest, err := http.NewRequest("GET", tc.endpoint, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.cron {
+			theRequest.Header.Set("X-Appengine-Cron", "true")
+		}
+
+		// response recorder
+		rr := httptest.NewRecorder()
+
+		// send the request
+		router.ServeHTTP(rr, theRequest)
+
+		if tc.status != rr.Code {
+			t.Errorf("%s: %q expected status code %v, got %v", tc.name, tc.endpoint, tc.status, rr.Code)
+		}
+
+		if tc.respBody != "" && !strings.Contains(rr.Body.String(), tc.respBody) {
+			t.Errorf("%s: expected %q, not found (in %q)", tc.name, tc.respBody, rr.Body.String())
+		}
+	}
+
+	// the dry run left the transcript in place
+	found, _ := repo.FindByID(old.RequestID)
+	if found.FinalTranscript == "" {
+		t.Errorf("dry run: expected transcript kept, got %+v", found)
+	}
+}
+
+// noMedia is a media.Store holding nothing
+type noMedia struct{}
+
+func (noMedia) Delete(ctx context.Context, uri string) error         { return media.ErrNotFound }
+func (noMedia) Exists(ctx context.Context, uri string) (bool, error) { return false, nil }
+func (noMedia) Get(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return nil, media.ErrNotFound
+}
+func (noMedia) Put(ctx context.Context, uri string, r io.Reader, contentType string) error {
+	return nil
+}
+func (noMedia) SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error) {
+	return "", media.ErrNoSigner
+}
+
+func TestDefaultErasures(t *testing.T) {
+
+	repo = database.NewMemoryRequestRepository()
+	validate = validator.New()
+
+	req := request.Request{
+		RequestID:       uuid.New(),
+		CustomerID:      1234567,
+		MediaFileURI:    "gs://bucket/file.mp3",
+		Status:          request.Completed,
+		AcceptedAt:      time.Now().UTC().Format(time.RFC3339Nano),
+		FinalTranscript: "[Speaker 1] call me at 555-123-4567",
+	}
+	_ = repo.Create(&req)
+
+	eraser := &erasure.Eraser{
+		Requests:     repo,
+		Media:        noMedia{},
+		Queue:        queue.NewNullQueue(&queue.QueueInfo{}),
+		Certificates: database.NewMemoryCertificateRepository(),
+		Deliveries:   database.NewMemoryDeliveryRepository(),
+		Audit:        audit.NewStdoutSink(),
+	}
+
+	token := "test-admin-token"
+	router := httprouter.New()
+	router.POST(adminPrefix+"/erasures", middleware.RequireAdmin(token, createErasureHandler(eraser)))
+	router.GET(adminPrefix+"/erasures/:id", middleware.RequireAdmin(token, getErasureHandler(eraser)))
+	router.GET(adminPrefix+"/erasures/:id/verify", middleware.RequireAdmin(token, verifyErasureHandler(eraser)))
+
+	send := func(method, endpoint, body string) *httptest.ResponseRecorder {
+		theRequest, err := http.NewRequest(method, adminPrefix+endpoint, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		theRequest.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, theRequest)
+		return rr
+	}
+
+	type test struct {
+		name   string
+		body   string
+		status int
+	}
+
+	tests := []test{
+		{name: "no selector",
+			body:   `{ "customer_id": 1234567 }`,
+			status: http.StatusBadRequest},
+		{name: "another customer",
+			body:   fmt.Sprintf(`{ "customer_id": 7654321, "request_id": %q }`, req.RequestID),
+			status: http.StatusNotFound},
+		{name: "unmatched phone number",
+			body:   `{ "customer_id": 1234567, "phone_number": "555-987-6543" }`,
+			status: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		if rr := send("POST", "/erasures", tc.body); rr.Code != tc.status {
+			t.Errorf("%s: expected status code %v, got %v (%q)", tc.name, tc.status, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := send("POST", "/erasures", `{ "customer_id": 1234567, "phone_number": "(555) 123-4567" }`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("erase: expected status code %v, got %v (%q)", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var cert erasure.Certificate
+	if err := json.NewDecoder(rr.Body).Decode(&cert); err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Requests) != 1 || cert.Requests[0].RequestID != req.RequestID.String() {
+		t.Errorf("erase: expected request %s erased, got %+v", req.RequestID, cert.Requests)
+	}
+
+	if rr = send("GET", "/erasures/"+cert.CertificateID, ""); rr.Code != http.StatusOK {
+		t.Errorf("get: expected status code %v, got %v", http.StatusOK, rr.Code)
+	}
+	rr = send("GET", "/erasures/"+cert.CertificateID+"/verify", "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"verified":true`) {
+		t.Errorf("verify: expected verified, got %v %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDefaultWebhooks(t *testing.T) {
+
+	customers = database.NewMemoryCustomerRepository()
+	validate = validator.New()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	webhooks := &webhook.Dispatcher{
+		Deliveries:   database.NewMemoryDeliveryRepository(),
+		Customers:    customers,
+		AllowPrivate: true,
+	}
+
+	token := "test-admin-token"
+	router := httprouter.New()
+	router.POST(adminPrefix+"/customers", middleware.RequireAdmin(token, createCustomerHandler()))
+	router.GET(adminPrefix+"/webhooks/deliveries", middleware.RequireAdmin(token, listDeliveriesHandler(webhooks)))
+	router.GET(adminPrefix+"/webhooks/deliveries/:id", middleware.RequireAdmin(token, getDeliveryHandler(webhooks)))
+	router.POST(adminPrefix+"/webhooks/deliveries/:id/replay", middleware.RequireAdmin(token, replayDeliveryHandler(webhooks)))
+
+	send := func(method, endpoint, body string) *httptest.ResponseRecorder {
+		theRequest, err := http.NewRequest(method, adminPrefix+endpoint, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		theRequest.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, theRequest)
+		return rr
+	}
+
+	// new customers get a signing secret
+	rr := send("POST", "/customers", `{ "customer_id": 1234567, "name": "Hooked", "webhook": { "url": "https://example.com/hook" } }`)
+	if rr.Code != http.StatusCreated || !strings.Contains(rr.Body.String(), `"secret":"whsec_`) {
+		t.Fatalf("create: expected a webhook secret, got %v %q", rr.Code, rr.Body.String())
+	}
+	rr = send("POST", "/customers", `{ "customer_id": 7654321, "name": "Insecure", "webhook": { "url": "http://example.com/hook" } }`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("create: expected an http:// webhook rejected, got %v", rr.Code)
+	}
+
+	req := &request.Request{RequestID: uuid.New(), CustomerID: 1234567, Status: request.Completed, CallbackURL: receiver.URL}
+	delivery, err := webhooks.NotifyRequest(context.Background(), webhook.EventCompleted, req, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		name     string
+		method   string
+		endpoint string
+		status   int
+		contains string
+	}
+
+	tests := []test{
+		{name: "list", method: "GET", endpoint: "/webhooks/deliveries?customer_id=1234567&status=delivered", status: http.StatusOK, contains: delivery.DeliveryID},
+		{name: "list, bad status", method: "GET", endpoint: "/webhooks/deliveries?status=lost", status: http.StatusBadRequest},
+		{name: "get", method: "GET", endpoint: "/webhooks/deliveries/" + delivery.DeliveryID, status: http.StatusOK, contains: `"status":"delivered"`},
+		{name: "get, unknown", method: "GET", endpoint: "/webhooks/deliveries/nope", status: http.StatusNotFound},
+		{name: "replay", method: "POST", endpoint: "/webhooks/deliveries/" + delivery.DeliveryID + "/replay", status: http.StatusOK, contains: `"replayed_at"`},
+		{name: "replay, unknown", method: "POST", endpoint: "/webhooks/deliveries/nope/replay", status: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		rr := send(tc.method, tc.endpoint, "")
+		if rr.Code != tc.status || !strings.Contains(rr.Body.String(), tc.contains) {
+			t.Errorf("%s: expected status code %v containing %q, got %v %q", tc.name, tc.status, tc.contains, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestDefaultStatusWait(t *testing.T) {
+
+	broker := notify.NewBroker()
+	repo = notify.NewNotifyingRequestRepository(database.NewMemoryRequestRepository(), broker)
+	validate = validator.New()
+
+	req := request.Request{
+		RequestID:    uuid.New(),
+		CustomerID:   1234567,
+		MediaFileURI: "gs://elated-practice-224603.appspot.com/audio_uploads/audio-01.mp3",
+		Status:       request.Pending,
+		AcceptedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	_ = repo.Create(&req)
+
+	router := httprouter.New()
+	router.GET(apiPrefix+"/status/:uuid", asCustomer(1234567, getStatusHandler(broker, eta.NewEstimator(repo))))
+	router.GET(apiPrefix+"/requests/:uuid/events", asCustomer(1234567, eventsHandler(broker)))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	getStatus := func(query string) (int, request.GetStatusResponse) {
+		theRequest, err := http.NewRequest("GET", apiPrefix+"/status/"+req.RequestID.String()+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, theRequest)
+		var resp request.GetStatusResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+	update := func(after time.Duration, status string, stage string) {
+		time.Sleep(after)
+		updated := req
+		updated.Status = status
+		updated.Stage = stage
+		_ = repo.Update(&updated)
+	}
+
+	type test struct {
+		name   string
+		query  string
+		status int
+	}
+
+	tests := []test{
+		{name: "invalid wait", query: "?wait=soon", status: http.StatusBadRequest},
+		{name: "negative wait", query: "?wait=-5s", status: http.StatusBadRequest},
+		{name: "no change within wait", query: "?wait=50ms", status: http.StatusOK},
+		{name: "no wait", query: "?wait=0", status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		if code, _ := getStatus(tc.query); code != tc.status {
+			t.Errorf("%s: expected status code %v, got %v", tc.name, tc.status, code)
+		}
+	}
+
+	// a long-poll returns as soon as the stage changes
+	go update(50*time.Millisecond, request.Pending, "TranscriptionGCP")
+	start := time.Now()
+	code, resp := getStatus("?wait=30s")
+	if code != http.StatusOK || resp.Stage != "TranscriptionGCP" {
+		t.Errorf("long-poll: expected stage %q, got %v %+v", "TranscriptionGCP", code, resp)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("long-poll: expected early return, took %v", elapsed)
+	}
+
+	// the event stream sends the current status, then each change, ending
+	// once the request is COMPLETED
+	resp2, err := http.Get(server.URL + apiPrefix + "/requests/" + req.RequestID.String() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	if ct := resp2.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("events: expected Content-Type %q, got %q", "text/event-stream", ct)
+	}
+	go func() {
+		update(50*time.Millisecond, request.Pending, "TranscriptionGCP") // unchanged, not sent
+		update(50*time.Millisecond, request.Pending, "TranscriptionComplete")
+		update(50*time.Millisecond, request.Completed, "TranscriptionComplete")
+	}()
+
+	var summaries []request.RequestSummary
+	scanner := bufio.NewScanner(resp2.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+			var summary request.RequestSummary
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &summary); err != nil {
+				t.Fatalf("events: json.Unmarshal error: %v", err)
+			}
+			summaries = append(summaries, summary)
+		}
+	}
+
+	expected := []string{"PENDING TranscriptionGCP", "PENDING TranscriptionComplete", "COMPLETED TranscriptionComplete"}
+	var got []string
+	for _, s := range summaries {
+		got = append(got, s.Status+" "+s.Stage)
+	}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("events: expected %v, got %v", expected, got)
+	}
+	if len(summaries) > 0 && summaries[len(summaries)-1].Endpoint != getLocationURI(req.RequestID) {
+		t.Errorf("events: expected endpoint %q, got %q", getLocationURI(req.RequestID), summaries[len(summaries)-1].Endpoint)
+	}
+}
+
+func TestDefaultTranscriptsHTTP(t *testing.T) {
+
+	repo = database.NewMemoryRequestRepository()
+	validate = validator.New()
+
+	pending := request.Request{
+		RequestID:    uuid.New(),
+		CustomerID:   1234567,
+		MediaFileURI: "gs://elated-practice-224603.appspot.com/audio_uploads/audio-01.mp3",
+		Status:       request.Pending,
+		AcceptedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	completed := pending
+	completed.RequestID = uuid.New()
+	completed.Status = request.Completed
+	completed.CompletedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	completed.FinalTranscript = "[Speaker 1] thanks for calling"
+	failed := pending
+	failed.RequestID = uuid.New()
+	failed.Status = request.Error
+	failed.OriginalStatus = http.StatusBadRequest
+	failed.Error = "Media file empty"
+	for _, req := range []*request.Request{&pending, &completed, &failed} {
+		_ = repo.Create(req)
+	}
+
+	router := httprouter.New()
+	router.GET(apiPrefix+"/status/:uuid", asCustomer(1234567, getStatusHandler(notify.NewBroker(), eta.NewEstimator(repo))))
+	sink := &recordingSink{}
+	router.GET(apiPrefix+"/transcripts/:uuid", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		ctx := middleware.WithPrincipal(r.Context(), middleware.Principal{CustomerID: 1234567, KeyID: "key-1"})
+		getTranscriptsHandler(sink, eta.NewEstimator(repo))(w, r.WithContext(ctx), p)
+	})
+
+	// no body: GET requests carry their identity in auth and the query
+	get := func(path string, ifNoneMatch string) *httptest.ResponseRecorder {
+		theRequest, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ifNoneMatch != "" {
+			theRequest.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, theRequest)
+		return rr
+	}
+
+	rr := get(getLocationURI(completed.RequestID), "")
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" {
+		t.Fatalf("completed transcript: expected status code %v with ETag, got %v %q", http.StatusOK, rr.Code, etag)
+	}
+
+	type test struct {
+		name        string
+		path        string
+		ifNoneMatch string
+		status      int
+		headers     map[string]string
+	}
+
+	tests := []test{
+		{name: "pending transcript", path: getLocationURI(pending.RequestID), status: http.StatusSeeOther,
+			headers: map[string]string{"Location": getStatusURI(pending.RequestID), "Retry-After": "45"}},
+		{name: "failed transcript", path: getLocationURI(failed.RequestID), status: http.StatusSeeOther,
+			headers: map[string]string{"Location": getStatusURI(failed.RequestID), "Retry-After": ""}},
+		{name: "pending status", path: getStatusURI(pending.RequestID), status: http.StatusOK,
+			headers: map[string]string{"Content-Type": "application/json", "Retry-After": "45", "Location": ""}},
+		{name: "completed status", path: getStatusURI(completed.RequestID), status: http.StatusSeeOther,
+			headers: map[string]string{"Content-Type": "application/json", "Location": getLocationURI(completed.RequestID)}},
+		{name: "completed transcript", path: getLocationURI(completed.RequestID), status: http.StatusOK,
+			headers: map[string]string{"Content-Type": "application/json", "ETag": etag}},
+		{name: "unchanged transcript", path: getLocationURI(completed.RequestID), ifNoneMatch: etag, status: http.StatusNotModified,
+			headers: map[string]string{"ETag": etag}},
+		{name: "unchanged transcript, strong tag", path: getLocationURI(completed.RequestID), ifNoneMatch: `"x", ` + strings.TrimPrefix(etag, "W/"), status: http.StatusNotModified},
+		{name: "any transcript", path: getLocationURI(completed.RequestID), ifNoneMatch: "*", status: http.StatusNotModified},
+		{name: "stale transcript", path: getLocationURI(completed.RequestID), ifNoneMatch: `W/"stale"`, status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		rr := get(tc.path, tc.ifNoneMatch)
+		if rr.Code != tc.status {
+			t.Errorf("%s: expected status code %v, got %v", tc.name, tc.status, rr.Code)
+		}
+		for key, value := range tc.headers {
+			got := rr.Header().Get(key)
+			if key == "Retry-After" && value != "" && got != "" {
+				// seconds until the ETA, which moves with the clock
+				if seconds, err := strconv.Atoi(got); err == nil && seconds > 0 {
+					continue
+				}
+			}
+			if got != value {
+				t.Errorf("%s: expected %s %q, got %q", tc.name, key, value, got)
+			}
+		}
+		if tc.status == http.StatusNotModified && rr.Body.Len() != 0 {
+			t.Errorf("%s: expected no body, got %q", tc.name, rr.Body.String())
+		}
+	}
+
+	// a failed request says why
+	rr = get(getStatusURI(failed.RequestID), "")
+	var status request.GetStatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil || status.OriginalStatus != http.StatusBadRequest || status.Error != failed.Error {
+		t.Errorf("failed status: expected %d, %q, got %+v, %v", http.StatusBadRequest, failed.Error, status, err)
+	}
+
+	// the transcript changes if redacted
+	completed.FinalTranscript = "[Speaker 1] [REDACTED]"
+	_ = repo.Update(&completed)
+	if rr := get(getLocationURI(completed.RequestID), etag); rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Errorf("redacted transcript: expected status code %v with a new ETag, got %v %q", http.StatusOK, rr.Code, rr.Header().Get("ETag"))
+	}
+
+	// each transcript returned was recorded, with the key that read it
+	if len(sink.records) != 4 {
+		t.Errorf("expected 4 reads recorded, got %d", len(sink.records))
+	}
+	for _, rec := range sink.records {
+		if rec.Action != audit.ActionReadTranscript || rec.RequestID != completed.RequestID.String() || rec.Actor != "customer 1234567, API key key-1" {
+			t.Errorf("unexpected record %+v", rec)
+		}
+	}
+
+	// a read that can't be recorded isn't returned
+	sink.err = errors.New("unavailable")
+	if rr := get(getLocationURI(completed.RequestID), ""); rr.Code != http.StatusServiceUnavailable || strings.Contains(rr.Body.String(), "REDACTED") {
+		t.Errorf("unrecorded transcript: expected status code %v without the transcript, got %v %q", http.StatusServiceUnavailable, rr.Code, rr.Body.String())
+	}
+}
+
+// recordingSink is an audit.Sink remembering the records written
+type recordingSink struct {
+	records []audit.Record
+	err     error // returned by every Write, if set
+}
+
+func (s *recordingSink) Write(rec audit.Record) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, rec)
+	return nil
+}
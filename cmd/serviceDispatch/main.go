@@ -20,6 +20,7 @@ import (
 	"github.com/peterpla/lead-expert/pkg/queue"
 	"github.com/peterpla/lead-expert/pkg/request"
 	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+	"github.com/peterpla/lead-expert/pkg/transcription"
 )
 
 var prefix = "TaskServiceDispatch"
@@ -52,7 +53,7 @@ func main() {
 	defer catch() // implements recover so panics reported
 
 	// connect to the Request database
-	repo = database.NewFirestoreRequestRepository(cfg.ProjectID, cfg.DatabaseRequests)
+	repo = database.NewRequestRepository(&cfg, database.NewAuditSink(&cfg))
 
 	if cfg.IsGAE {
 		q = queue.NewGCTQueue(&qi) // use Google Cloud Tasks for queueing
@@ -126,16 +127,19 @@ func taskHandler(q queue.Queue) httprouter.Handle {
 
 		newRequest := incomingRequest
 
-		// TODO: implement serviceDispatch processing
-		// E.g., select which ML transcription service to use and submit that request.
-		//
-		// The current default selection is Google Cloud Speech-to-Text
-		// so TaskServiceDispatchWriteToQ and TaskServiceDispatchNextSvcToHandleReq
-		// reflect "transcriptionGCP" as the next stage in the pipeline.
+		// select the speech-to-text provider, chosen by the Request or the
+		// customer's profile, and later stages see which it was
+		provider := transcription.Select(&newRequest, cfg.DefaultProvider)
+		newRequest.Config.Provider = provider
+		target, err := providerQueue(provider)
+		if err != nil {
+			log.Printf("%s.taskHandler, providerQueue(%q) error: %v, request %s\n", sn, provider, err, newRequest.RequestID)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		// add timestamps and get duration
 		var duration time.Duration
-		var err error
 		if duration, err = newRequest.AddTimestamps("BeginServiceDispatch", startTime, "EndServiceDispatch"); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -144,8 +148,8 @@ func taskHandler(q queue.Queue) httprouter.Handle {
 		// TODO: write updated Request to the Requests database
 		_ = repo
 
-		// create task on the next pipeline stage's queue with updated request
-		if err := q.Add(&qi, &newRequest); err != nil {
+		// create task on the provider's transcription queue with updated request
+		if err := q.Add(&target, &newRequest); err != nil {
 			log.Printf("%s.taskHandler, q.Add error: %+v\n", sn, err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -161,6 +165,21 @@ func taskHandler(q queue.Queue) httprouter.Handle {
 	}
 }
 
+// providerQueue returns the queue of the provider's transcription
+// service. Without a route in config, a known provider's is this service's
+// own next queue.
+func providerQueue(provider string) (queue.QueueInfo, error) {
+	if route, ok := cfg.TranscriptionProviders[provider]; ok {
+		return queue.NamedQueueInfo(&cfg, route.Queue, route.Service), nil
+	}
+	for _, name := range transcription.Names {
+		if provider == name {
+			return qi, nil
+		}
+	}
+	return queue.QueueInfo{}, transcription.ErrUnknownProvider
+}
+
 // ********** ********** ********** ********** ********** **********
 
 // indexHandler responds to requests with "service running"
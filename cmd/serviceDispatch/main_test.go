@@ -16,6 +16,8 @@ import (
 	"github.com/peterpla/lead-expert/pkg/config"
 	"github.com/peterpla/lead-expert/pkg/database"
 	"github.com/peterpla/lead-expert/pkg/queue"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/transcription"
 )
 
 func TestServiceDispatch(t *testing.T) {
@@ -90,3 +92,97 @@ func TestServiceDispatch(t *testing.T) {
 		}
 	}
 }
+
+// recordingQueue is a null queue remembering where each Request was added
+type recordingQueue struct {
+	queue.Queue
+	added map[string]*request.Request // by queue name
+}
+
+func (r *recordingQueue) Add(qi *queue.QueueInfo, req *request.Request) error {
+	r.added[qi.Name] = req
+	return nil
+}
+
+func TestProviderRouting(t *testing.T) {
+
+	validate = validator.New()
+	cfg.ProjectID = "project"
+	cfg.StorageLocation = "us-west2"
+	qi = queue.QueueInfo{Name: "projects/project/locations/us-west2/queues/TranscriptionGCP"}
+
+	type test struct {
+		name     string
+		routes   map[string]config.ProviderRoute
+		fallback string // cfg.DefaultProvider
+		custom   string // the Request's custom_config
+		status   int
+		queue    string // where the Request was added
+		provider string
+	}
+
+	tests := []test{
+		{name: "default, no routes",
+			status:   http.StatusOK,
+			queue:    "projects/project/locations/us-west2/queues/TranscriptionGCP",
+			provider: transcription.Google},
+		{name: "routed default",
+			routes:   map[string]config.ProviderRoute{"google": {Queue: "TranscriptionGCPBatch", Service: "transcription-gcp-batch"}},
+			fallback: "google",
+			status:   http.StatusOK,
+			queue:    "projects/project/locations/us-west2/queues/TranscriptionGCPBatch",
+			provider: transcription.Google},
+		{name: "chosen by the request",
+			routes:   map[string]config.ProviderRoute{"google": {Queue: "TranscriptionGCPBatch", Service: "transcription-gcp-batch"}},
+			fallback: "other",
+			custom:   `, "config": { "provider": "google" }`,
+			status:   http.StatusOK,
+			queue:    "projects/project/locations/us-west2/queues/TranscriptionGCPBatch",
+			provider: transcription.Google},
+		{name: "local, no routes",
+			routes:   map[string]config.ProviderRoute{"google": {Queue: "TranscriptionGCPBatch", Service: "transcription-gcp-batch"}},
+			custom:   `, "config": { "provider": "local" }`,
+			status:   http.StatusOK,
+			queue:    "projects/project/locations/us-west2/queues/TranscriptionGCP",
+			provider: transcription.Local},
+		{name: "unrouted provider",
+			fallback: "other",
+			status:   http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		cfg.TranscriptionProviders = tc.routes
+		cfg.DefaultProvider = tc.fallback
+		rq := &recordingQueue{Queue: queue.NewNullQueue(&queue.QueueInfo{}), added: make(map[string]*request.Request)}
+
+		router := httprouter.New()
+		router.POST("/task_handler", taskHandler(rq))
+
+		body := fmt.Sprintf(`{ "customer_id": 1234567, "media_uri": "gs://bucket/audio-01.mp3", "accepted_at": %q%s }`,
+			time.Now().UTC().Format(time.RFC3339Nano), tc.custom)
+		theRequest, err := http.NewRequest("POST", "/task_handler", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		theRequest.Header.Set("X-Appengine-Taskname", "localTask")
+		theRequest.Header.Set("X-Appengine-Queuename", "localQueue")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, theRequest)
+
+		if rr.Code != tc.status {
+			t.Errorf("%s: expected status code %v, got %v", tc.name, tc.status, rr.Code)
+			continue
+		}
+		if tc.queue == "" {
+			continue
+		}
+		req, ok := rq.added[tc.queue]
+		if !ok {
+			t.Errorf("%s: expected request added to %q, got %v", tc.name, tc.queue, rq.added)
+			continue
+		}
+		if req.Config.Provider != tc.provider {
+			t.Errorf("%s: expected provider %q recorded, got %q", tc.name, tc.provider, req.Config.Provider)
+		}
+	}
+}
@@ -55,7 +55,7 @@ func main() {
 	defer catch() // implements recover so panics reported
 
 	// connect to the Request database
-	repo = database.NewFirestoreRequestRepository(cfg.ProjectID, cfg.DatabaseRequests)
+	repo = database.NewRequestRepository(&cfg, database.NewAuditSink(&cfg))
 
 	if cfg.IsGAE {
 		q = queue.NewGCTQueue(&qi) // use Google Cloud Tasks for queueing
@@ -243,12 +243,10 @@ func gDLPPrepareRequest(req *request.Request) *dlppb.InspectContentRequest {
 
 	minLikelihood := dlppb.Likelihood_POSSIBLE
 	includeQuote := true
-	// TODO: add/tune list of InfoTypes we want to match
-	infoTypes := []*dlppb.InfoType{
-		{Name: "PHONE_NUMBER"},
-		{Name: "PERSON_NAME"},
-		{Name: "STREET_ADDRESS"},
-		{Name: "US_STATE"},
+	// InfoTypes come from the Request's config: custom_config, else the customer's defaults, else the system defaults
+	var infoTypes []*dlppb.InfoType
+	for _, name := range req.EffectiveConfig().InfoTypes {
+		infoTypes = append(infoTypes, &dlppb.InfoType{Name: name})
 	}
 	item := &dlppb.ContentItem{
 		DataItem: &dlppb.ContentItem_Value{
@@ -1,39 +1,43 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
-	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	speech "cloud.google.com/go/speech/apiv1"
 	"github.com/go-playground/validator"
-	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/spf13/viper"
-	speechpb "google.golang.org/genproto/googleapis/cloud/speech/v1"
 
 	"github.com/peterpla/lead-expert/pkg/appengine"
 	"github.com/peterpla/lead-expert/pkg/check"
 	"github.com/peterpla/lead-expert/pkg/config"
 	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/media"
 	"github.com/peterpla/lead-expert/pkg/middleware"
 	"github.com/peterpla/lead-expert/pkg/queue"
+	"github.com/peterpla/lead-expert/pkg/ratelimit"
 	"github.com/peterpla/lead-expert/pkg/request"
 	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+	"github.com/peterpla/lead-expert/pkg/transcription"
+	"github.com/peterpla/lead-expert/pkg/webhook"
 )
 
 var prefix = "TaskTranscriptionGCP"
 var logPrefix = "transcription-gcp.main.init(),"
 var cfg config.Config
 var repo request.RequestRepository
+var usage ratelimit.UsageStore
+var webhooks *webhook.Dispatcher
 var q queue.Queue
 var qi = queue.QueueInfo{}
 var qs queue.QueueService
@@ -41,7 +45,16 @@ var qs queue.QueueService
 // use a single instance of Validate, it caches struct info
 var validate *validator.Validate
 
-const MAX_ALTERNATIVES = 2 // max alternatives from Google Speech-to-Text
+// chunkParallelism is how many of a Request's chunks are transcribed at once
+const chunkParallelism = 8
+
+// operationTimeout is how long a provider's operation may run before the
+// Request fails
+const operationTimeout = 6 * time.Hour
+
+// errOperationTimeout - the provider's operation ran longer than
+// operationTimeout
+var errOperationTimeout = errors.New("Transcription timed out")
 
 func init() {
 	if err := config.GetConfig(&cfg, prefix); err != nil {
@@ -62,7 +75,16 @@ func main() {
 	defer catch() // implements recover so panics reported
 
 	// connect to the Request database
-	repo = database.NewFirestoreRequestRepository(cfg.ProjectID, cfg.DatabaseRequests)
+	repo = database.NewRequestRepository(&cfg, database.NewAuditSink(&cfg))
+
+	// transcribed audio counts toward customers' quotas
+	usage = database.NewFirestoreUsageStore(cfg.ProjectID, cfg.DatabaseUsage)
+
+	// tells customers their requests failed
+	webhooks = &webhook.Dispatcher{
+		Deliveries: database.NewFirestoreDeliveryRepository(cfg.ProjectID, cfg.DatabaseWebhooks),
+		Customers:  database.NewFirestoreCustomerRepository(cfg.ProjectID, cfg.DatabaseCustomers),
+	}
 
 	if cfg.IsGAE {
 		q = queue.NewGCTQueue(&qi) // use Google Cloud Tasks for queueing
@@ -73,8 +95,18 @@ func main() {
 	qs = queue.NewService(q)
 	_ = qs
 
+	// the Request's Config chooses among the providers, which read media
+	// files from the Store
+	store := media.NewStore(&cfg)
+	providers := map[string]transcription.Provider{
+		transcription.Google: transcription.NewGoogleProvider(store),
+	}
+	if len(cfg.LocalEngine) > 0 {
+		providers[transcription.Local] = transcription.NewLocalProvider(cfg.LocalEngine, store)
+	}
+
 	router := httprouter.New()
-	router.POST("/task_handler", taskHandler(q))
+	router.POST("/task_handler", taskHandler(q, store, providers))
 	router.GET("/", indexHandler)
 	router.NotFound = http.HandlerFunc(myNotFound)
 	cfg.Router = router
@@ -118,8 +150,13 @@ func catch() {
 
 // ********** ********** ********** ********** ********** **********
 
-// taskHandler processes task requests.
-func taskHandler(q queue.Queue) httprouter.Handle {
+// taskHandler processes task requests, transcribing with the provider
+// serviceDispatch chose. A provider transcribing as an operation is checked
+// on by later tasks, queued to this service, rather than waited for. Long
+// media's chunks, see cmd/mediaConvert, are transcribed in parallel, their
+// transcripts kept in the store, so a retried task stitches them again;
+// retention and erasure delete them with the rest of the Request's media.
+func taskHandler(q queue.Queue, store media.Store, providers map[string]transcription.Provider) httprouter.Handle {
 	sn := serviceInfo.GetServiceName()
 
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -152,17 +189,71 @@ func taskHandler(q queue.Queue) httprouter.Handle {
 
 		// log.Printf("%s.taskHandler - decoded request: %+v\n", sn, incomingRequest)
 
-		var newRequest request.Request
-		var err error
-
 		// submit transcription request
-		if newRequest, err = googleSpeechToText(incomingRequest); err != nil {
-			log.Printf("%s.taskHandler, googleSpeechToText error: %v", sn, err)
+		name := transcription.Select(&incomingRequest, cfg.DefaultProvider)
+		provider, ok := providers[name]
+		var transcript *transcription.Transcript
+		var err error
+		if ok {
+			opts := transcription.OptionsFrom(incomingRequest.EffectiveConfig())
+			if m := incomingRequest.Media; m != nil {
+				// as mediaConvert found or made it
+				opts.Encoding = m.Encoding
+				opts.SampleRateHertz = m.SampleRateHertz
+				opts.Channels = m.Channels
+				opts.SeparateChannels = transcription.Separate(name, incomingRequest.EffectiveConfig(), m.Channels)
+			}
+			switch async, isAsync := provider.(transcription.AsyncProvider); {
+			case len(incomingRequest.Chunks) > 0:
+				// chunks are short, each waited for
+				transcript, err = transcribeChunks(r.Context(), store, provider, &incomingRequest, opts)
+			case isAsync:
+				transcript, err = pollOperation(r.Context(), q, async, &incomingRequest, opts)
+			default:
+				transcript, err = provider.Transcribe(r.Context(), incomingRequest.WorkingMedia(), opts)
+			}
+		} else {
+			err = transcription.ErrUnknownProvider // not configured on this service
+		}
+		if err == transcription.ErrUnsupportedMedia || err == transcription.ErrUnknownProvider ||
+			err == transcription.ErrOperationFailed || err == errOperationTimeout {
+			// retrying won't help: fail the Request, and end the task
+			log.Printf("%s.taskHandler, %s Transcribe error: %v, failing request %s", sn, name, err, incomingRequest.RequestID)
+			if err := webhook.FailRequest(r.Context(), repo, webhooks, &incomingRequest, err); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if err != nil {
+			log.Printf("%s.taskHandler, %s Transcribe error: %v", sn, name, err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if transcript == nil {
+			// the operation is running, a later task checks on it
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 
-		// add timestamps and get duration
+		// capture the working transcript for later pipeline stages
+		newRequest := incomingRequest
+		newRequest.WorkingTranscript = transcript.Attributed()
+		newRequest.Structured = transcript.Structured()
+		audio := transcript.Duration()
+		if incomingRequest.MediaSeconds > 0 {
+			// as mediaConvert probed it; the transcript ends with the last word
+			audio = time.Duration(incomingRequest.MediaSeconds * float64(time.Second))
+		}
+
+		// later requests' ETAs scale with their media's duration
+		newRequest.MediaSeconds = audio.Seconds()
+
+		// add timestamps and get duration, from submitting the operation if any
+		if newRequest.Operation != nil {
+			startTime = newRequest.Operation.SubmittedAt
+		}
 		var duration time.Duration
 		if duration, err = newRequest.AddTimestamps("BeginTranscriptionGCP", startTime, "EndTranscriptionGCP"); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -176,6 +267,11 @@ func taskHandler(q queue.Queue) httprouter.Handle {
 			return
 		}
 
+		// a retried task counts its audio again; quotas err toward the customer's limit
+		if err := ratelimit.RecordAudio(usage, newRequest.CustomerID, audio, time.Now()); err != nil {
+			log.Printf("%s.taskHandler, RecordAudio error: %+v\n", sn, err)
+		}
+
 		// create task on the next pipeline stage's queue with updated Request
 		if err := q.Add(&qi, &newRequest); err != nil {
 			log.Printf("%s.taskHandler, q.Add error: %+v\n", sn, err)
@@ -193,326 +289,230 @@ func taskHandler(q queue.Queue) httprouter.Handle {
 	}
 }
 
-// ********** ********** ********** ********** ********** **********
-
-// indexHandler responds to requests with "service running"
-func indexHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+// pollOperation submits req's working media to the provider, or checks on
+// the operation submitted, returning the transcript once it's done. Until
+// then the Request is recorded, and queued to this service again to check
+// after operationBackoff; nil is returned, as for a task duplicating
+// another's check.
+func pollOperation(ctx context.Context, q queue.Queue, provider transcription.AsyncProvider, req *request.Request, opts transcription.Options) (*transcription.Transcript, error) {
 	sn := serviceInfo.GetServiceName()
 
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
+	// only an operation this stage submitted and recorded is checked, never
+	// one named by the task; a retried task's may be submitted already, or
+	// a duplicate task's checked by another
+	stored, err := repo.FindByID(req.RequestID)
+	if err != nil {
+		log.Printf("%s.pollOperation, repo.FindByID error: %+v\n", sn, err)
+		return nil, err
 	}
+	var recorded *request.Operation
+	if stored.Operation != nil && stored.Operation.Provider == provider.Name() {
+		if req.Operation != nil && req.Operation.Name == stored.Operation.Name && req.Operation.Checks < stored.Operation.Checks {
+			log.Printf("%s.pollOperation, %s already checked %d times, dropping check %d\n", sn, req.RequestID, stored.Operation.Checks, req.Operation.Checks+1)
+			return nil, nil
+		}
+		op := *stored.Operation
+		recorded = &op
+	}
+	req.Operation = recorded
 
-	// I'm not dead yet
-	fmt.Fprintf(w, "%q service running\n", sn)
-}
-
-// ********** ********** ********** ********** ********** **********
-
-func myNotFound(w http.ResponseWriter, r *http.Request) {
-	var msg404 = []byte("<h2>404 Not Foundw</h2>")
+	now := time.Now().UTC()
+	if req.Operation == nil {
+		name, err := provider.Submit(ctx, req.WorkingMedia(), opts)
+		if err != nil {
+			return nil, err
+		}
+		req.Operation = &request.Operation{Name: name, Provider: provider.Name(), SubmittedAt: now.Format(time.RFC3339Nano)}
+		log.Printf("%s.pollOperation, %s submitted as %s operation %q\n", sn, req.RequestID, provider.Name(), name)
+	} else {
+		op, err := provider.Check(ctx, req.Operation.Name, opts)
+		if err != nil {
+			return nil, err
+		}
+		req.Operation.Checks++
+		req.Operation.Progress = op.Progress
+		if op.Done {
+			return op.Transcript, nil
+		}
+		if submitted, err := time.Parse(time.RFC3339Nano, req.Operation.SubmittedAt); err == nil && now.Sub(submitted) > operationTimeout {
+			return nil, errOperationTimeout
+		}
+	}
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.WriteHeader(http.StatusNotFound)
-	_, _ = w.Write(msg404)
+	if err := repo.Update(req); err != nil {
+		log.Printf("%s.pollOperation, repo.Update error: %+v\n", sn, err)
+		return nil, err
+	}
+	self := pollQueue(provider.Name())
+	if err := q.AddAfter(&self, req, operationBackoff(req.Operation.Checks)); err != nil {
+		log.Printf("%s.pollOperation, q.AddAfter error: %+v\n", sn, err)
+		return nil, err
+	}
+	return nil, nil
 }
 
-// ********** ********** ********** ********** ********** **********
-
-// ErrBadMediaFileURI
-var ErrBadMediaFileURI = errors.New("Bad media_uri")
-
-func googleSpeechToText(req request.Request) (request.Request, error) {
-	// sn := serviceInfo.GetServiceName()
-	// log.Printf("%s.googleSpeechToText, request: %+v\n", sn, req)
-
-	var emptyRequest = request.Request{}
-	var badRequest request.Request
-	var err error
-
-	// Overall flow:
-	//   1. copy the media file to Google Cloud Storage (only files already in GCS buckets supported at this point)
-	//   2. if needed, convert file to a supported format (only .MP3 files supported at this point)
-	// 	 3. submit file to Speech-to-Text service
-	//   4. capture the transcription for use by later pipeline stages
+// operationBackoff is how long to wait before an operation's next check,
+// after checks so far: 15 seconds, doubling to at most 5 minutes
+func operationBackoff(checks int) time.Duration {
+	const first, max = 15 * time.Second, 5 * time.Minute
 
-	if err := copyAndConvertMediaFile(req); err != nil {
-		return badRequest, ErrBadMediaFileURI
+	delay := first
+	for i := 0; i < checks && delay < max; i++ {
+		delay *= 2
 	}
-
-	// prepare the request
-	ctx, client, gSTTreq, err := prepareGoogleSTTRequest(req.MediaFileURI)
-	if err != nil {
-		return emptyRequest, err
+	if delay > max {
+		delay = max
 	}
+	return delay
+}
 
-	var resp *speechpb.LongRunningRecognizeResponse
-	// submit the request, get the response
-	if resp, err = getGoogleSTTResponse(ctx, client, gSTTreq); err != nil {
-		return emptyRequest, err
+// pollQueue returns the queue of this service's tasks for the provider: its
+// route in config, as serviceDispatch sends them, else TranscriptionGCP
+func pollQueue(provider string) queue.QueueInfo {
+	if route, ok := cfg.TranscriptionProviders[provider]; ok {
+		return queue.NamedQueueInfo(&cfg, route.Queue, route.Service)
 	}
-
-	// process the response, capture the working transcript for later pipeline stages
-	newRequest := processTranscriptionResponse(req, resp)
-
-	// log.Printf("%s.googleSpeechToText exiting, WorkingTranscript: %q, request %s\n", sn, newRequest.WorkingTranscript, newRequest.RequestID)
-
-	return newRequest, nil
+	return queue.NamedQueueInfo(&cfg, "TranscriptionGCP", cfg.ServiceName)
 }
 
-func prepareGoogleSTTRequest(gcsURI string) (context.Context, *speech.Client, *speechpb.LongRunningRecognizeRequest, error) {
+// transcribeChunks transcribes the chunks of req's media not yet
+// transcribed, in parallel, then stitches all their transcripts. Each
+// chunk's progress is recorded on the Request, so a retried task
+// transcribes only the chunks that failed.
+func transcribeChunks(ctx context.Context, store media.Store, provider transcription.Provider, req *request.Request, opts transcription.Options) (*transcription.Transcript, error) {
 	sn := serviceInfo.GetServiceName()
-	// log.Printf("%s.prepareGoogleSTTRequest, URI: %s\n", sn, gcsURI)
 
-	ctx := context.Background()
-	client, err := speech.NewClient(ctx)
-	if err != nil {
-		log.Printf("%s.taskHandler, speech.NewClient() error: %v", sn, err)
-		return nil, nil, nil, err
+	// the task holds the chunks as mediaConvert queued them; a retried
+	// task's progress is in the Requests database
+	if stored, err := repo.FindByID(req.RequestID); err == nil && len(stored.Chunks) == len(req.Chunks) {
+		req.Chunks = stored.Chunks
 	}
 
-	// "By using the [classes] in your recognition config, Cloud
-	// Speech-to-Text is more likely to correctly transcribe audio
-	// that includes [those classes]""
-	phrases := []string{"$MONEY", "$MONTH", "$POSTALCODE", "$FULLPHONENUM"}
-	speechContext := speechpb.SpeechContext{Phrases: phrases}
-
-	// Send the contents of the audio file for transcription.
-	req := &speechpb.LongRunningRecognizeRequest{
-		Config: &speechpb.RecognitionConfig{
-			// for MP3, DO NOT include Encoding or SampleRateHertz
-			// Encoding:        speechpb.RecognitionConfig_LINEAR16,
-			// SampleRateHertz: 48000,
-			LanguageCode:    "en-US",
-			UseEnhanced:     true, // phone model requires enhanced service
-			Model:           "phone_call",
-			MaxAlternatives: MAX_ALTERNATIVES,
-			// adds punctuation to recognition result
-			EnableAutomaticPunctuation: true,
-			// recognize different speakers and what they say
-			DiarizationConfig: &speechpb.SpeakerDiarizationConfig{
-				EnableSpeakerDiarization: true,
-			},
-			SpeechContexts: []*speechpb.SpeechContext{
-				&speechContext,
-			},
-		},
-		Audio: &speechpb.RecognitionAudio{
-			// where to find the audio file
-			AudioSource: &speechpb.RecognitionAudio_Uri{Uri: gcsURI},
-		},
+	var mu sync.Mutex // guards req and errs
+	var wg sync.WaitGroup
+	errs := make([]error, len(req.Chunks))
+	sem := make(chan struct{}, chunkParallelism)
+	for i, chunk := range req.Chunks {
+		if chunk.Status == request.Completed {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, chunk request.Chunk) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			uri, err := transcribeChunk(ctx, store, provider, chunk, opts)
+
+			mu.Lock()
+			defer mu.Unlock()
+			c := &req.Chunks[i]
+			c.Attempts++
+			if err != nil {
+				log.Printf("%s.transcribeChunks, chunk %d of %s error: %v\n", sn, i, req.RequestID, err)
+				c.Status = request.Error
+				c.Error = err.Error()
+				errs[i] = err
+			} else {
+				c.Status = request.Completed
+				c.Error = ""
+				c.TranscriptURI = uri
+			}
+			if err := repo.Update(req); err != nil {
+				log.Printf("%s.transcribeChunks, repo.Update error: %+v\n", sn, err)
+			}
+		}(i, chunk)
 	}
+	wg.Wait()
 
-	return ctx, client, req, nil
-}
+	var failed error
+	for _, err := range errs {
+		if err == transcription.ErrUnsupportedMedia {
+			return nil, err
+		}
+		if failed == nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		return nil, failed
+	}
 
-func getGoogleSTTResponse(ctx context.Context, client *speech.Client, req *speechpb.LongRunningRecognizeRequest) (*speechpb.LongRunningRecognizeResponse, error) {
-	// "Transcribing long audio files", https://cloud.google.com/speech-to-text/docs/async-recognize
-	sn := serviceInfo.GetServiceName()
+	chunks := make([]transcription.ChunkTranscript, 0, len(req.Chunks))
+	for i, chunk := range req.Chunks {
+		t, err := readTranscript(ctx, store, chunk.TranscriptURI)
+		if err != nil {
+			log.Printf("%s.transcribeChunks, readTranscript(%q) error: %v\n", sn, chunk.TranscriptURI, err)
+			if err == media.ErrNotFound {
+				// gone, e.g. deleted by a task that then failed: transcribe it again
+				req.Chunks[i].Status = request.Pending
+				_ = repo.Update(req)
+			}
+			return nil, err
+		}
+		chunks = append(chunks, transcription.ChunkTranscript{
+			Transcript: t,
+			Start:      time.Duration(chunk.Start * float64(time.Second)),
+			End:        time.Duration(chunk.End * float64(time.Second)),
+		})
+	}
+	return transcription.Stitch(chunks, opts.SeparateChannels), nil
+}
 
-	op, err := client.LongRunningRecognize(ctx, req)
+// transcribeChunk transcribes the chunk, returning where its transcript is
+// stored, beside the chunk's media
+func transcribeChunk(ctx context.Context, store media.Store, provider transcription.Provider, chunk request.Chunk, opts transcription.Options) (string, error) {
+	t, err := provider.Transcribe(ctx, chunk.MediaURI, opts)
 	if err != nil {
-		log.Printf("%s.submitgoogleSpeechToText, error from LongRunningRecognize(req: %+v), error: %v", sn, req, err)
-		return nil, err
+		return "", err
 	}
-	resp, err := op.Wait(ctx)
+	data, err := json.Marshal(t)
 	if err != nil {
-		log.Printf("%s.submitgoogleSpeechToText, Wait() error: %v", sn, err)
-		return nil, err
+		return "", err
 	}
-	// log.Printf("%s.submitgoogleSpeechToText, resp.Results: %+v", sn, resp.Results)
-
-	return resp, nil
+	uri := chunk.MediaURI + ".json"
+	if err := store.Put(ctx, uri, bytes.NewReader(data), "application/json"); err != nil {
+		return "", err
+	}
+	return uri, nil
 }
 
-// copyAndConvertMediaFile ensures the media file is available on Google Cloud Storage
-func copyAndConvertMediaFile(req request.Request) error {
-	sn := serviceInfo.GetServiceName()
-
-	uri := req.MediaFileURI
-
-	// TODO: copy file into GCS bucket
-
-	// !!! HACK !!! confirm media file is already in GCS bucket
-	if len(uri) < 5 || uri[0:5] != "gs://" {
-		log.Printf("%s.copyAndConvertMediaFile, only \"gs://\" files supported (temporary): %q, RequestID: %s\n",
-			sn, uri, req.RequestID.String())
-		return ErrBadMediaFileURI
+// readTranscript reads a chunk's transcript from the store
+func readTranscript(ctx context.Context, store media.Store, uri string) (*transcription.Transcript, error) {
+	rc, err := store.Get(ctx, uri)
+	if err != nil {
+		return nil, err
 	}
+	defer rc.Close()
 
-	// TODO: convert the media file if needed
-
-	// Libraries to investigate re: MP3 -> WAV
-	//  https://github.com/giorgisio/goav - Golang bindings for FFmpeg
-	//  https://www.ffmpeg.org/ffmpeg.html - ffmpeg is a very fast video and audio converter
-	//
-	//  https://github.com/nareix/joy4/cgo/ffmpeg - Golang audio/video library and streaming server
-	//  https://github.com/xfrr/goffmpeg - FFMPEG wrapper written in GO
-	//
-	//  https://github.com/go-audio/examples/blob/master/format-converter/main.go - Generic Go package designed to
-	//	define a common interface to analyze and/or process audio data
-	//
-	//	https://github.com/faiface/beep - A little package ... Suitable for playback and audio-processing.
-	//
-	// Potentially relevent write-ups:
-	//  "Scalable Video Transcoding With App Engine Flexible",
-	//	https://medium.com/google-cloud/scalable-video-transcoding-with-app-engine-flexible-621f6e7fdf56
-	//
-	//  "ffmpeg won't execute properly in google app engine standard nodejs",
-	//  https://stackoverflow.com/questions/57350148/ffmpeg-wont-execute-properly-in-google-app-engine-standard-nodejs
-
-	// !!! HACK !!! - only work with MP3 files
-
-	// confirm filename ends in ".MP3" (case insensitive)
-	if strings.ToLower(filepath.Ext(uri)) != ".mp3" {
-		log.Printf("%s.copyAndConvertMediaFile, only \".MP3\" files supported (temporary): %q", sn, uri)
-		return ErrBadMediaFileURI
+	t := &transcription.Transcript{}
+	if err := json.NewDecoder(rc).Decode(t); err != nil {
+		return nil, err
 	}
-
-	return nil
+	return t, nil
 }
 
 // ********** ********** ********** ********** ********** **********
 
-// rawWordInfo holds a copy of the WordInfo slice from STT
-type rawWordInfo struct {
-	wordInfo []*speechpb.WordInfo
-}
-
-// Transcript holds data during transcription processing
-type Transcript struct {
-	requestID         uuid.UUID
-	mediaFileURI      string
-	rawTranscript     []string
-	rawConfidence     []float32
-	rawWords          []rawWordInfo
-	attributedStrings [][]string
-	workingTranscript string
-}
-
-func processTranscriptionResponse(req request.Request, resp *speechpb.LongRunningRecognizeResponse) request.Request {
+// indexHandler responds to requests with "service running"
+func indexHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	sn := serviceInfo.GetServiceName()
-	// log.Printf("%s.processTranscriptionResponse, request: %+v, LongRunningRecognizeResponse: %+v\n",
-	// 	sn, req, resp)
-
-	// modify a copy of the incoming request
-	newRequest := req
-
-	transcript := newTranscript(newRequest)
-
-	// var transcript = Transcript{
-	// 	requestID:    newRequest.RequestID,
-	// 	mediaFileURI: newRequest.MediaFileURI,
-	// }
-	// // log.Printf("%s.processTranscriptionResponse, transcript: %+v\n", sn, transcript)
-	// transcript.rawTranscript = make([]string, MAX_ALTERNATIVES+1)
-	// transcript.rawConfidence = make([]float32, MAX_ALTERNATIVES+1)
-	// transcript.rawWords = make([]rawWordInfo, MAX_ALTERNATIVES+1)
-	// transcript.attributedStrings = make([][]string, 16*(MAX_ALTERNATIVES+1))
-	// // log.Printf("%s.processTranscriptionResponse, transcript: %+v\n", sn, transcript)
-
-	var a int
-	var ac int // alternative count
-	// var r int
-	// var result *speechpb.SpeechRecognitionResult
-
-	for _, result := range resp.Results {
-
-		var alt *speechpb.SpeechRecognitionAlternative
-		for a, alt = range result.Alternatives {
-			if len(alt.GetWords()) == 0 {
-				// alternatives with no WordInfo records are ignored
-				continue
-			}
-			transcript.rawTranscript[a] = alt.GetTranscript()
-			transcript.rawConfidence[a] = alt.GetConfidence()
-			transcript.rawWords[a].wordInfo = alt.GetWords()
-			transcript.attributedStrings[a] = wordsToAttributedStrings(transcript.rawWords[a].wordInfo)
-			ac++
-
-			// log.Printf("%s.processTranscriptionResponse, processed Results [%d] Alternative[%d], attributedStrings begins: %16q, Confidence: %6.4f, RequestID: %s\n",
-			// 	sn, r, a, transcript.attributedStrings[a][0], transcript.rawConfidence[a], req.RequestID.String())
-		}
-	}
-
-	transcript.workingTranscript = strings.Join(transcript.attributedStrings[0], "")
-	newRequest.WorkingTranscript = transcript.workingTranscript
-
-	// log.Printf("%s.processTranscriptionResponse, processed %d results, %d alternatives, WorkingTranscript begins: %16q, RequestID: %s\n",
-	// 	sn, r, ac, newRequest.WorkingTranscript, req.RequestID.String())
-	_ = sn
-
-	return newRequest
-}
 
-func newTranscript(req request.Request) Transcript {
-	var transcript = Transcript{
-		requestID:    req.RequestID,
-		mediaFileURI: req.MediaFileURI,
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
 	}
 
-	transcript.rawTranscript = make([]string, MAX_ALTERNATIVES+1)
-	transcript.rawConfidence = make([]float32, MAX_ALTERNATIVES+1)
-	transcript.rawWords = make([]rawWordInfo, MAX_ALTERNATIVES+1)
-	transcript.attributedStrings = make([][]string, 16*(MAX_ALTERNATIVES+1))
-	// log.Printf("%s.processTranscriptionResponse, transcript: %+v\n", sn, transcript)
-
-	return transcript
+	// I'm not dead yet
+	fmt.Fprintf(w, "%q service running\n", sn)
 }
 
-func wordsToAttributedStrings(rawWords []*speechpb.WordInfo) []string {
-	// sn := serviceInfo.GetServiceName()
-	// log.Printf("%s.wordsToAttributedStrings, rawWords: %+v\n", sn, rawWords)
-
-	// use | instead of \n to keep log entries cleaner
-	// completionProcessing replaces | with \n
-	const separator = "|"
-	var initString = "[Speaker 1]"
-
-	strings := []string{}
-	emptyStrings := strings
-
-	var speaker = 1
-	var wordCount int
-
-	var tmpString = initString
-	for _, word := range rawWords {
-		tmpWord := word.GetWord()
-		tmpSpeaker := int(word.GetSpeakerTag())
-		// tmpConfidence = word.GetConfidence()
-		// tmpStart = word.GetStartTime()
-		// tmpEnd = word.GetEndTime()
-
-		if tmpSpeaker != speaker {
-			// changed speakers - end the current string
-			tmpString = tmpString + separator
-			strings = append(strings, tmpString)
-			// log.Printf("%s.transcriptionGCP.wordsToAttributedStrings, appended tmpString: %+v\n",
-			// 	sn, tmpString)
-
-			// reset tmpString, capture new speaker
-			tmpString = fmt.Sprintf("[Speaker %d]", tmpSpeaker)
-			speaker = tmpSpeaker
-		}
-
-		// POLICY: add space in front of the word we're adding (avoids
-		// trailing spaces)
-		tmpString = tmpString + " " + tmpWord
-		wordCount++
-	}
-
-	if tmpString != initString {
-		// end the final string, append it
-		tmpString = tmpString + "\n"
-		strings = append(strings, tmpString)
-	} else {
-		// didn't get any words from GetWord(), return empty []string
-		strings = emptyStrings
-	}
+// ********** ********** ********** ********** ********** **********
 
-	// log.Printf("%s.transcriptionGCP.wordsToAttributedStrings, returning %d words: %q\n",
-	// 	sn, wordCount, strings)
+func myNotFound(w http.ResponseWriter, r *http.Request) {
+	var msg404 = []byte("<h2>404 Not Foundw</h2>")
 
-	return strings
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write(msg404)
 }
@@ -1,21 +1,32 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-playground/validator"
+	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 
 	"github.com/peterpla/lead-expert/pkg/config"
 	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/media"
 	"github.com/peterpla/lead-expert/pkg/queue"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/transcription"
+	"github.com/peterpla/lead-expert/pkg/webhook"
 )
 
 func TestTranscriptionGCP(t *testing.T) {
@@ -68,7 +79,7 @@ func TestTranscriptionGCP(t *testing.T) {
 		// log.Printf("Test %s: %s", tc.name, url)
 
 		router := httprouter.New()
-		router.POST("/task_handler", taskHandler(q))
+		router.POST("/task_handler", taskHandler(q, nil, map[string]transcription.Provider{transcription.Google: transcription.NewGoogleProvider(nil)}))
 
 		// build the POST request with custom header
 		theRequest, err := http.NewRequest("POST", url, strings.NewReader(tc.body))
@@ -97,3 +108,391 @@ func TestTranscriptionGCP(t *testing.T) {
 		}
 	}
 }
+
+// memoryStore holds what's Put, by URI
+type memoryStore struct {
+	sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *memoryStore) Delete(ctx context.Context, uri string) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.objects[uri]; !ok {
+		return media.ErrNotFound
+	}
+	delete(m.objects, uri)
+	return nil
+}
+
+func (m *memoryStore) Exists(ctx context.Context, uri string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	_, ok := m.objects[uri]
+	return ok, nil
+}
+
+func (m *memoryStore) Get(ctx context.Context, uri string) (io.ReadCloser, error) {
+	m.Lock()
+	defer m.Unlock()
+	data, ok := m.objects[uri]
+	if !ok {
+		return nil, media.ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memoryStore) Put(ctx context.Context, uri string, r io.Reader, contentType string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.objects[uri] = data
+	return nil
+}
+
+func (m *memoryStore) SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error) {
+	return "", media.ErrNoSigner
+}
+
+// fakeProvider returns the transcripts given, by media URI, after failing
+// as many times as given
+type fakeProvider struct {
+	sync.Mutex
+	transcripts map[string]*transcription.Transcript
+	failures    map[string]int
+	transcribed []string
+}
+
+func (p *fakeProvider) Name() string {
+	return transcription.Google
+}
+
+func (p *fakeProvider) Transcribe(ctx context.Context, mediaURI string, opts transcription.Options) (*transcription.Transcript, error) {
+	p.Lock()
+	defer p.Unlock()
+	p.transcribed = append(p.transcribed, mediaURI)
+	if p.failures[mediaURI] > 0 {
+		p.failures[mediaURI]--
+		return nil, errors.New("deadline exceeded")
+	}
+	return p.transcripts[mediaURI], nil
+}
+
+func TestTranscribeChunks(t *testing.T) {
+
+	validate = validator.New()
+	repo = database.NewMemoryRequestRepository()
+	usage = database.NewMemoryUsageStore()
+	webhooks = &webhook.Dispatcher{
+		Deliveries: database.NewMemoryDeliveryRepository(),
+		Customers:  database.NewMemoryCustomerRepository(),
+	}
+
+	s := time.Second
+	first, second := "gs://media-bucket/media/1234567/x-chunk-000.flac", "gs://media-bucket/media/1234567/x-chunk-001.flac"
+	store := &memoryStore{objects: map[string][]byte{first: []byte("audio"), second: []byte("audio")}}
+	// the second chunk starts 2s before the first ends, its speakers numbered the other way round
+	provider := &fakeProvider{
+		transcripts: map[string]*transcription.Transcript{
+			first: {Provider: transcription.Google, Words: []transcription.Word{
+				{Text: "Hello", Speaker: 1, Start: s, End: 2 * s}, {Text: "there", Speaker: 2, Start: 9 * s, End: 10 * s}}},
+			second: {Provider: transcription.Google, Words: []transcription.Word{
+				{Text: "there", Speaker: 1, Start: s, End: 2 * s}, {Text: "hi", Speaker: 1, Start: 4 * s, End: 5 * s}}},
+		},
+		failures: map[string]int{second: 1},
+	}
+
+	req := request.Request{
+		RequestID:    uuid.New(),
+		CustomerID:   1234567,
+		MediaFileURI: "gs://bucket/audio-01.mp3",
+		Status:       request.Pending,
+		AcceptedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+		Chunks: []request.Chunk{
+			{MediaURI: first, Start: 0, End: 10, Status: request.Pending},
+			{MediaURI: second, Start: 8, End: 20, Status: request.Pending},
+		},
+	}
+	_ = repo.Create(&req)
+	body, _ := json.Marshal(req)
+
+	rq := &recordingQueue{Queue: queue.NewNullQueue(&queue.QueueInfo{})}
+	router := httprouter.New()
+	router.POST("/task_handler", taskHandler(rq, store, map[string]transcription.Provider{transcription.Google: provider}))
+	post := func() int {
+		theRequest, err := http.NewRequest("POST", "/task_handler", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		theRequest.Header.Set("X-Appengine-Taskname", "localTask")
+		theRequest.Header.Set("X-Appengine-Queuename", "localQueue")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, theRequest)
+		return rr.Code
+	}
+
+	// a chunk failing fails the task, to retry
+	if code := post(); code == http.StatusOK || len(rq.added) != 0 {
+		t.Fatalf("chunk failed: expected the task retried, got %d, %d added", code, len(rq.added))
+	}
+	stored, _ := repo.FindByID(req.RequestID)
+	if c := stored.Chunks[0]; c.Status != request.Completed || c.TranscriptURI != first+".json" {
+		t.Errorf("chunk failed: expected the first chunk completed, got %+v", c)
+	}
+	if c := stored.Chunks[1]; c.Status != request.Error || c.Attempts != 1 || c.Error == "" {
+		t.Errorf("chunk failed: expected the second chunk's error recorded, got %+v", c)
+	}
+
+	// the retried task, queued with the chunks pending, transcribes only the one that failed
+	provider.transcribed = nil
+	if code := post(); code != http.StatusOK || len(rq.added) != 1 {
+		t.Fatalf("retried: expected the request passed on, got %d, %d added", code, len(rq.added))
+	}
+	if len(provider.transcribed) != 1 || provider.transcribed[0] != second {
+		t.Errorf("retried: expected only %q transcribed, got %v", second, provider.transcribed)
+	}
+	if expected := "[Speaker 1] Hello|[Speaker 2] there hi\n"; rq.added[0].WorkingTranscript != expected {
+		t.Errorf("retried: expected %q stitched, got %q", expected, rq.added[0].WorkingTranscript)
+	}
+	if c := rq.added[0].Chunks[1]; c.Status != request.Completed || c.Attempts != 2 {
+		t.Errorf("retried: expected the second chunk completed on its second attempt, got %+v", c)
+	}
+	if len(store.objects) != 4 {
+		t.Errorf("retried: expected the chunks' media and transcripts kept, got %v", store.objects)
+	}
+
+	// the task redelivered stitches the transcripts again, transcribing nothing
+	provider.transcribed = nil
+	if code := post(); code != http.StatusOK || len(rq.added) != 2 || len(provider.transcribed) != 0 {
+		t.Fatalf("redelivered: expected the request passed on, got %d, %d added, %v transcribed", code, len(rq.added), provider.transcribed)
+	}
+	if rq.added[1].WorkingTranscript != rq.added[0].WorkingTranscript {
+		t.Errorf("redelivered: expected %q stitched, got %q", rq.added[0].WorkingTranscript, rq.added[1].WorkingTranscript)
+	}
+}
+
+func TestLargeTranscript(t *testing.T) {
+
+	validate = validator.New()
+	repo = database.NewMemoryRequestRepository()
+	usage = database.NewMemoryUsageStore()
+
+	// a long recording's words, timed and scored, outgrow a task
+	mediaURI := "gs://bucket/audio-01.flac"
+	transcript := &transcription.Transcript{Provider: transcription.Google}
+	for i := 0; i < 20000; i++ {
+		start := time.Duration(i) * time.Second
+		transcript.Words = append(transcript.Words, transcription.Word{
+			Text: fmt.Sprintf("word%d", i), Speaker: 1 + i%2, Start: start, End: start + time.Second/2, Confidence: 0.9})
+	}
+	provider := &fakeProvider{transcripts: map[string]*transcription.Transcript{mediaURI: transcript}}
+
+	req := request.Request{
+		RequestID:    uuid.New(),
+		CustomerID:   1234567,
+		MediaFileURI: mediaURI,
+		Status:       request.Pending,
+		AcceptedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	_ = repo.Create(&req)
+	body, _ := json.Marshal(req)
+
+	rq := &recordingQueue{Queue: queue.NewNullQueue(&queue.QueueInfo{})}
+	router := httprouter.New()
+	router.POST("/task_handler", taskHandler(rq, &memoryStore{objects: map[string][]byte{}}, map[string]transcription.Provider{transcription.Google: provider}))
+	theRequest, err := http.NewRequest("POST", "/task_handler", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	theRequest.Header.Set("X-Appengine-Taskname", "localTask")
+	theRequest.Header.Set("X-Appengine-Queuename", "localQueue")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, theRequest)
+
+	if rr.Code != http.StatusOK || len(rq.added) != 1 {
+		t.Fatalf("expected the request passed on, got %d, %d added: %s", rr.Code, len(rq.added), rr.Body.String())
+	}
+	stored, _ := repo.FindByID(req.RequestID)
+	if stored.Structured == nil || len(stored.Structured.Segments) == 0 {
+		t.Errorf("expected the structured transcript stored, got %+v", stored.Structured)
+	}
+}
+
+// fakeOperations transcribes as operations, done on the check given
+type fakeOperations struct {
+	doneOn     int  // check
+	fail       bool // the operation fails
+	transcript *transcription.Transcript
+	submitted  int
+	checked    int
+}
+
+func (p *fakeOperations) Name() string {
+	return transcription.Google
+}
+
+func (p *fakeOperations) Transcribe(ctx context.Context, mediaURI string, opts transcription.Options) (*transcription.Transcript, error) {
+	return nil, errors.New("waited for the transcript")
+}
+
+func (p *fakeOperations) Submit(ctx context.Context, mediaURI string, opts transcription.Options) (string, error) {
+	p.submitted++
+	return "operations/1", nil
+}
+
+func (p *fakeOperations) Check(ctx context.Context, operation string, opts transcription.Options) (*transcription.Operation, error) {
+	p.checked++
+	if p.fail {
+		return nil, transcription.ErrOperationFailed
+	}
+	if p.checked < p.doneOn {
+		return &transcription.Operation{Name: operation, Progress: 40}, nil
+	}
+	return &transcription.Operation{Name: operation, Done: true, Progress: 100, Transcript: p.transcript}, nil
+}
+
+func TestPollOperation(t *testing.T) {
+
+	validate = validator.New()
+	repo = database.NewMemoryRequestRepository()
+	usage = database.NewMemoryUsageStore()
+	webhooks = &webhook.Dispatcher{
+		Deliveries: database.NewMemoryDeliveryRepository(),
+		Customers:  database.NewMemoryCustomerRepository(),
+	}
+
+	newRequest := func() (*request.Request, []byte) {
+		req := request.Request{
+			RequestID:    uuid.New(),
+			CustomerID:   1234567,
+			MediaFileURI: "gs://bucket/audio-01.mp3",
+			Status:       request.Pending,
+			AcceptedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		_ = repo.Create(&req)
+		body, _ := json.Marshal(req)
+		return &req, body
+	}
+	post := func(router *httprouter.Router, body []byte) int {
+		theRequest, err := http.NewRequest("POST", "/task_handler", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		theRequest.Header.Set("X-Appengine-Taskname", "localTask")
+		theRequest.Header.Set("X-Appengine-Queuename", "localQueue")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, theRequest)
+		return rr.Code
+	}
+
+	provider := &fakeOperations{doneOn: 2, transcript: &transcription.Transcript{
+		Words: []transcription.Word{{Text: "Hello", Speaker: 1}, {Text: "there", Speaker: 1}}}}
+	rq := &recordingQueue{Queue: queue.NewNullQueue(&queue.QueueInfo{})}
+	router := httprouter.New()
+	router.POST("/task_handler", taskHandler(rq, nil, map[string]transcription.Provider{transcription.Google: provider}))
+
+	// submitted, then checked by a later task
+	req, body := newRequest()
+	if code := post(router, body); code != http.StatusOK || provider.submitted != 1 || len(rq.scheduled) != 1 || len(rq.added) != 0 {
+		t.Fatalf("submitted: expected a check scheduled, got %d, %d submitted, %d scheduled, %d added", code, provider.submitted, len(rq.scheduled), len(rq.added))
+	}
+	if op := rq.scheduled[0].Operation; op == nil || op.Name != "operations/1" || rq.delays[0] != 15*time.Second {
+		t.Errorf("submitted: expected the operation checked in 15s, got %+v in %v", op, rq.delays[0])
+	}
+	if stored, _ := repo.FindByID(req.RequestID); stored.Operation == nil || stored.Operation.Name != "operations/1" {
+		t.Errorf("submitted: expected the operation recorded, got %+v", stored.Operation)
+	}
+
+	// the submitting task, retried, checks the operation it submitted
+	if code := post(router, body); code != http.StatusOK || provider.submitted != 1 || provider.checked != 1 || len(rq.scheduled) != 2 {
+		t.Fatalf("retried: expected the operation checked, got %d, %d submitted, %d checked, %d scheduled", code, provider.submitted, provider.checked, len(rq.scheduled))
+	}
+	if stored, _ := repo.FindByID(req.RequestID); stored.Operation.Checks != 1 || stored.Operation.Progress != 40 || rq.delays[1] != 30*time.Second {
+		t.Errorf("retried: expected the check recorded, and the next backed off, got %+v, %v", stored.Operation, rq.delays[1])
+	}
+
+	// so the first check scheduled is dropped
+	first, _ := json.Marshal(rq.scheduled[0])
+	if code := post(router, first); code != http.StatusOK || provider.checked != 1 || len(rq.scheduled) != 2 {
+		t.Errorf("duplicate: expected the check dropped, got %d, %d checked, %d scheduled", code, provider.checked, len(rq.scheduled))
+	}
+
+	// and the second finds it done
+	second, _ := json.Marshal(rq.scheduled[1])
+	if code := post(router, second); code != http.StatusOK || len(rq.added) != 1 {
+		t.Fatalf("done: expected the request passed on, got %d, %d added", code, len(rq.added))
+	}
+	passed := rq.added[0]
+	if expected := "[Speaker 1] Hello there\n"; passed.WorkingTranscript != expected {
+		t.Errorf("done: expected %q, got %q", expected, passed.WorkingTranscript)
+	}
+	if passed.Structured == nil || passed.Structured.Text() != "Hello there" {
+		t.Errorf("done: expected the structured transcript passed on, got %+v", passed.Structured)
+	}
+	if stored, _ := repo.FindByID(passed.RequestID); stored.Structured == nil || len(stored.Structured.Segments) != 1 {
+		t.Errorf("done: expected the structured transcript recorded, got %+v", stored.Structured)
+	}
+	if passed.Timestamps["BeginTranscriptionGCP"] != passed.Operation.SubmittedAt {
+		t.Errorf("done: expected the stage to begin when submitted, got %v, %+v", passed.Timestamps, passed.Operation)
+	}
+
+	// an operation named by the task, not recorded, isn't checked
+	req, _ = newRequest()
+	req.Operation = &request.Operation{Name: "operations/other", Provider: transcription.Google, SubmittedAt: req.AcceptedAt}
+	injected, _ := json.Marshal(req)
+	submitted, checked := provider.submitted, provider.checked
+	if code := post(router, injected); code != http.StatusOK || provider.submitted != submitted+1 || provider.checked != checked {
+		t.Errorf("injected: expected the media submitted, got %d, %d submitted, %d checked", code, provider.submitted-submitted, provider.checked-checked)
+	}
+	if stored, _ := repo.FindByID(req.RequestID); stored.Operation == nil || stored.Operation.Name != "operations/1" {
+		t.Errorf("injected: expected the submitted operation recorded, got %+v", stored.Operation)
+	}
+
+	// an operation failing fails the request
+	provider.fail = true
+	req, body = newRequest()
+	post(router, body)
+	check, _ := json.Marshal(rq.scheduled[len(rq.scheduled)-1])
+	if code := post(router, check); code != http.StatusOK {
+		t.Errorf("failed: expected status code %v, got %v", http.StatusOK, code)
+	}
+	if stored, _ := repo.FindByID(req.RequestID); stored.Status != request.Error || stored.Error != "Transcription failed" {
+		t.Errorf("failed: expected the request failed, got %q, %q", stored.Status, stored.Error)
+	}
+}
+
+func TestOperationBackoff(t *testing.T) {
+	expected := []time.Duration{15 * time.Second, 30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for checks, delay := range expected {
+		if got := operationBackoff(checks); got != delay {
+			t.Errorf("after %d checks: expected %v, got %v", checks, delay, got)
+		}
+	}
+}
+
+// recordingQueue is a null queue remembering the Requests added, and those
+// scheduled with their delays
+type recordingQueue struct {
+	queue.Queue
+	added     []*request.Request
+	scheduled []*request.Request
+	delays    []time.Duration
+}
+
+func (r *recordingQueue) Add(qi *queue.QueueInfo, req *request.Request) error {
+	// as Cloud Tasks refuses
+	if payload, err := queue.TaskPayload(req); err != nil || len(payload) > queue.MaxTaskSize {
+		return fmt.Errorf("task too large: %d bytes, error %v", len(payload), err)
+	}
+	r.added = append(r.added, req)
+	return nil
+}
+
+func (r *recordingQueue) AddAfter(qi *queue.QueueInfo, req *request.Request, delay time.Duration) error {
+	r.scheduled = append(r.scheduled, req)
+	r.delays = append(r.delays, delay)
+	return nil
+}
@@ -23,6 +23,8 @@ gcloud app deploy --verbosity=warning --quiet ./cmd/server/app.yaml
 
 # deploy other services in parallel
 gcloud app deploy --verbosity=warning --quiet ./cmd/initialRequest/app.yaml &
+gcloud app deploy --verbosity=warning --quiet ./cmd/mediaFetch/app.yaml &
+gcloud app deploy --verbosity=warning --quiet ./cmd/mediaConvert/app.yaml &
 gcloud app deploy --verbosity=warning --quiet ./cmd/serviceDispatch/app.yaml &
 gcloud app deploy --verbosity=warning --quiet ./cmd/transcriptionGCP/app.yaml &
 gcloud app deploy --verbosity=warning --quiet ./cmd/transcriptionComplete/app.yaml &
@@ -37,5 +39,8 @@ gcloud app deploy --verbosity=warning --quiet ./cmd/taggingQAComplete/app.yaml &
 gcloud app deploy --verbosity=warning --quiet ./cmd/completionProcessing/app.yaml &
 wait
 
+# scheduled jobs, e.g. retention purge
+gcloud app deploy --verbosity=warning --quiet ./cron.yaml
+
 # list all services in the current project
 gcloud app services list
@@ -0,0 +1,33 @@
+#!/usr/bin/bash
+
+# un-comment commands you need to perform, comment those already done
+# see "Managing indexes in Cloud Firestore"
+# <https://cloud.google.com/firestore/docs/query-data/indexing>
+
+# GET /api/v1/requests (RequestRepository.List) filters on customer_id and
+# optionally status and/or stage, sorts by accepted_at (or created_at,
+# updated_at, completed_at) and then by document ID. Each combination needs a
+# composite index; descending sorts are shown, ascending ones use the same
+# indexes in reverse.
+#gcloud firestore indexes composite create --collection-group=leadexperts-requests --field-config field-path=customer_id,order=ascending --field-config field-path=accepted_at,order=descending
+#gcloud firestore indexes composite create --collection-group=leadexperts-requests --field-config field-path=customer_id,order=ascending --field-config field-path=status,order=ascending --field-config field-path=accepted_at,order=descending
+#gcloud firestore indexes composite create --collection-group=leadexperts-requests --field-config field-path=customer_id,order=ascending --field-config field-path=stage,order=ascending --field-config field-path=accepted_at,order=descending
+#gcloud firestore indexes composite create --collection-group=leadexperts-requests --field-config field-path=customer_id,order=ascending --field-config field-path=status,order=ascending --field-config field-path=stage,order=ascending --field-config field-path=accepted_at,order=descending
+#gcloud firestore indexes composite create --collection-group=leadexperts-requests --field-config field-path=customer_id,order=ascending --field-config field-path=created_at,order=descending
+#gcloud firestore indexes composite create --collection-group=leadexperts-requests --field-config field-path=customer_id,order=ascending --field-config field-path=updated_at,order=descending
+#gcloud firestore indexes composite create --collection-group=leadexperts-requests --field-config field-path=customer_id,order=ascending --field-config field-path=completed_at,order=descending
+
+# eta.Estimator models recently completed requests, newest first, and counts
+# the pending ones
+#gcloud firestore indexes composite create --collection-group=leadexperts-requests --field-config field-path=status,order=ascending --field-config field-path=completed_at,order=descending
+#gcloud firestore indexes composite create --collection-group=leadexperts-requests --field-config field-path=status,order=ascending --field-config field-path=accepted_at,order=ascending
+
+# webhook.DeliveryRepository.List: the delivery log by customer and status,
+# newest first, and pending deliveries whose retry is due
+#gcloud firestore indexes composite create --collection-group=leadexperts-webhooks --field-config field-path=customer_id,order=ascending --field-config field-path=created_at,order=descending
+#gcloud firestore indexes composite create --collection-group=leadexperts-webhooks --field-config field-path=customer_id,order=ascending --field-config field-path=status,order=ascending --field-config field-path=created_at,order=descending
+#gcloud firestore indexes composite create --collection-group=leadexperts-webhooks --field-config field-path=status,order=ascending --field-config field-path=created_at,order=descending
+#gcloud firestore indexes composite create --collection-group=leadexperts-webhooks --field-config field-path=status,order=ascending --field-config field-path=next_attempt_at,order=ascending
+
+# "use list to verify that your indexes were created successfully"
+gcloud firestore indexes composite list
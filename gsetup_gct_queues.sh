@@ -10,6 +10,8 @@
 # 2. "Create a queue"
 # <https://cloud.google.com/tasks/docs/creating-queues>
 #gcloud tasks queues create InitialRequest
+#gcloud tasks queues create MediaFetch
+#gcloud tasks queues create MediaConvert
 #gcloud tasks queues create ServiceDispatch
 #gcloud tasks queues create TranscriptionGCP
 #gcloud tasks queues create TranscriptionComplete
@@ -0,0 +1,437 @@
+// Audio package probes media files, and converts them to the audio formats
+// speech-to-text providers accept, with ffprobe and ffmpeg
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// ErrUnreadable - ffprobe or ffmpeg can't read the file: it's corrupt, or
+// not media
+var ErrUnreadable = errors.New("Media file unreadable")
+
+// ErrEmpty - the media file is empty, or its audio lasts no time
+var ErrEmpty = errors.New("Media file empty")
+
+// ErrNoAudio - the media file has no audio stream, e.g. a silent video
+var ErrNoAudio = errors.New("Media file has no audio")
+
+// ErrUnsupportedFormat - Convert can't produce the Format
+var ErrUnsupportedFormat = errors.New("Unsupported audio format")
+
+// Format is an audio format: an encoding, as Speech-to-Text names them
+// (e.g. "FLAC", "LINEAR16", "MP3"), a sample rate and a channel count.
+// Zero values match any.
+type Format struct {
+	Encoding        string
+	SampleRateHertz int
+	Channels        int
+}
+
+// Matches reports whether audio in format actual is in format f
+func (f Format) Matches(actual Format) bool {
+	return f.Encoding == actual.Encoding && f.Encoding != "" &&
+		(f.SampleRateHertz == 0 || f.SampleRateHertz == actual.SampleRateHertz) &&
+		(f.Channels == 0 || f.Channels == actual.Channels)
+}
+
+// Info is what ffprobe finds in a media file, and its first audio stream
+type Info struct {
+	Container       string // as ffprobe names it, e.g. "wav", "mov,mp4,m4a,3gp,3g2,mj2"
+	Codec           string // e.g. "pcm_s16le", "aac"
+	SampleRateHertz int
+	Channels        int
+	Duration        time.Duration
+	Bytes           int64
+	Video           bool // has a video stream too
+}
+
+// Format returns the format of the audio. Its Encoding is "" if the audio
+// must be converted for any provider, e.g. AAC, or a video's soundtrack.
+func (i *Info) Format() Format {
+	f := Format{SampleRateHertz: i.SampleRateHertz, Channels: i.Channels}
+	if i.Video {
+		return f
+	}
+
+	containers := strings.Split(i.Container, ",")
+	in := func(container string) bool {
+		for _, c := range containers {
+			if c == container {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case i.Codec == "mp3" && in("mp3"):
+		f.Encoding = "MP3"
+	case i.Codec == "flac" && in("flac"):
+		f.Encoding = "FLAC"
+	case i.Codec == "pcm_s16le" && in("wav"):
+		f.Encoding = "LINEAR16"
+	case i.Codec == "pcm_mulaw" && in("wav"):
+		f.Encoding = "MULAW"
+	case i.Codec == "amr_nb" && in("amr"):
+		f.Encoding = "AMR"
+	case i.Codec == "amr_wb" && in("amr"):
+		f.Encoding = "AMR_WB"
+	case i.Codec == "opus" && in("ogg"):
+		f.Encoding = "OGG_OPUS"
+	}
+	return f
+}
+
+// output is how Convert writes an encoding
+type output struct {
+	codec       string // ffmpeg's encoder
+	ext         string
+	contentType string
+}
+
+var outputs = map[string]output{
+	"FLAC":     {codec: "flac", ext: ".flac", contentType: "audio/flac"},
+	"LINEAR16": {codec: "pcm_s16le", ext: ".wav", contentType: "audio/wave"},
+}
+
+// Output returns the extension and content type of files Convert writes
+// in Format f, ErrUnsupportedFormat if it can't
+func Output(f Format) (ext string, contentType string, err error) {
+	o, ok := outputs[f.Encoding]
+	if !ok {
+		return "", "", ErrUnsupportedFormat
+	}
+	return o.ext, o.contentType, nil
+}
+
+// Converter is implemented by ffmpeg, and fakes of it
+type Converter interface {
+	// Probe describes the media file at path
+	Probe(ctx context.Context, path string) (*Info, error)
+	// Convert writes the audio of the media file src to dst, in Format to
+	Convert(ctx context.Context, src, dst string, to Format) error
+	// Extract writes the audio of the media file src from start to end to
+	// dst, in Format to
+	Extract(ctx context.Context, src, dst string, start, end time.Duration, to Format) error
+	// Silences finds the silences in the media file at path
+	Silences(ctx context.Context, path string) ([]Silence, error)
+}
+
+// Silence is a pause in the audio, e.g. between sentences
+type Silence struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ffmpeg implements Converter by running ffprobe and ffmpeg
+type ffmpeg struct {
+	ffmpeg  string
+	ffprobe string
+}
+
+// NewFFmpeg returns the Converter running the ffmpeg and ffprobe commands
+// given, by default those on the PATH
+func NewFFmpeg(ffmpegPath, ffprobePath string) Converter {
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	if ffprobePath == "" {
+		ffprobePath = "ffprobe"
+	}
+	return ffmpeg{ffmpeg: ffmpegPath, ffprobe: ffprobePath}
+}
+
+// Probe runs ffprobe on the file
+func (f ffmpeg) Probe(ctx context.Context, path string) (*Info, error) {
+	if fi, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if fi.Size() == 0 {
+		return nil, ErrEmpty
+	}
+	args := append([]string{"-v", "error", "-print_format", "json", "-show_format", "-show_streams"}, inputArgs(path)...)
+	out, err := f.run(ctx, f.ffprobe, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseProbe(out)
+}
+
+// Convert runs ffmpeg, dropping any video
+func (f ffmpeg) Convert(ctx context.Context, src, dst string, to Format) error {
+	args, err := convertArgs(src, dst, to)
+	if err != nil {
+		return err
+	}
+	_, err = f.run(ctx, f.ffmpeg, args...)
+	return err
+}
+
+// Extract runs ffmpeg, seeking to start and stopping at end
+func (f ffmpeg) Extract(ctx context.Context, src, dst string, start, end time.Duration, to Format) error {
+	args, err := extractArgs(src, dst, start, end, to)
+	if err != nil {
+		return err
+	}
+	_, err = f.run(ctx, f.ffmpeg, args...)
+	return err
+}
+
+// Silences runs ffmpeg's silencedetect filter, which reports on stderr
+func (f ffmpeg) Silences(ctx context.Context, path string) ([]Silence, error) {
+	sn := serviceInfo.GetServiceName()
+
+	args := append([]string{"-nostdin", "-hide_banner"}, inputArgs(path)...)
+	args = append(args, "-vn", "-af", fmt.Sprintf("silencedetect=noise=%s:d=%s", silenceNoise, seconds(silenceMin)), "-f", "null", "-")
+	cmd := exec.CommandContext(ctx, f.ffmpeg, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && ctx.Err() == nil {
+			log.Printf("%s.audio.Silences, %s error: %v\n", sn, f.ffmpeg, err)
+			return nil, ErrUnreadable
+		}
+		return nil, err
+	}
+	return parseSilences(stderr.Bytes()), nil
+}
+
+// what silencedetect counts as silence: quieter than silenceNoise for at
+// least silenceMin, e.g. a pause between sentences
+const (
+	silenceNoise = "-30dB"
+	silenceMin   = 500 * time.Millisecond
+)
+
+// parseSilences reads silencedetect's log lines, "silence_start: 12.3"
+// then "silence_end: 13.1 | silence_duration: 0.8"; a silence still open
+// at the end of the file is dropped.
+func parseSilences(out []byte) []Silence {
+	var silences []Silence
+	var start time.Duration
+	open := false
+	for _, line := range strings.Split(string(out), "\n") {
+		if i := strings.Index(line, "silence_start: "); i >= 0 {
+			if s, err := strconv.ParseFloat(strings.TrimSpace(line[i+len("silence_start: "):]), 64); err == nil {
+				start, open = fromSeconds(s), true
+			}
+			continue
+		}
+		if i := strings.Index(line, "silence_end: "); i >= 0 && open {
+			field := strings.Fields(line[i+len("silence_end: "):])
+			if len(field) == 0 {
+				continue
+			}
+			if s, err := strconv.ParseFloat(field[0], 64); err == nil {
+				silences = append(silences, Silence{Start: start, End: fromSeconds(s)})
+				open = false
+			}
+		}
+	}
+	return silences
+}
+
+// DefaultChunkLength is how long Chunks are by default: Speech-to-Text
+// transcribes one in a minute or two, well within a task's deadline
+const DefaultChunkLength = 5 * time.Minute
+
+// ChunkOverlap is how much of the previous chunk each chunk repeats: time
+// for both speakers to say something
+const ChunkOverlap = 15 * time.Second
+
+// Chunk is a part of a media file, transcribed on its own. Each chunk but
+// the first overlaps the end of the previous one, so the speakers of the
+// two can be matched up.
+type Chunk struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Chunks splits audio lasting duration into chunks of about length, each
+// ending in the middle of the silence nearest length from its start, or
+// at length if there's none within half of it. The last chunk may be up
+// to a quarter longer, rather than leave a sliver. Each chunk but the
+// first starts overlap before the previous one ends.
+func Chunks(duration time.Duration, silences []Silence, length, overlap time.Duration) []Chunk {
+	var chunks []Chunk
+	var cut time.Duration // where the previous chunk ended
+	for duration-cut > length+length/4 {
+		target := cut + length
+		next := target
+		best := length / 2 // no further from target than this
+		for _, s := range silences {
+			middle := s.Start + (s.End-s.Start)/2
+			distance := middle - target
+			if distance < 0 {
+				distance = -distance
+			}
+			if middle > cut+overlap && distance <= best {
+				next, best = middle, distance
+			}
+		}
+		chunks = append(chunks, Chunk{Start: chunkStart(cut, overlap), End: next})
+		cut = next
+	}
+	return append(chunks, Chunk{Start: chunkStart(cut, overlap), End: duration})
+}
+
+func chunkStart(cut, overlap time.Duration) time.Duration {
+	if cut < overlap {
+		return 0
+	}
+	return cut - overlap
+}
+
+// seconds formats d as ffmpeg reads times
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+func fromSeconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// run runs the command, returning its stdout. The command failing is
+// ErrUnreadable, as ffmpeg and ffprobe fail on files they can't read;
+// failing to start it is returned as is.
+func (f ffmpeg) run(ctx context.Context, command string, args ...string) ([]byte, error) {
+	sn := serviceInfo.GetServiceName()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && ctx.Err() == nil {
+			log.Printf("%s.audio.run, %s error: %v, stderr: %s\n", sn, command, err, strings.TrimSpace(stderr.String()))
+			return nil, ErrUnreadable
+		}
+		log.Printf("%s.audio.run, %s error: %v\n", sn, command, err)
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// the media is the customer's: ffmpeg and ffprobe read only the local file,
+// as one of the containers we accept, so e.g. an HLS or concat playlist
+// can't make them read other files or URLs
+const (
+	protocols = "file"
+	demuxers  = "wav,flac,mp3,mov,ogg,amr,aac" // "mov" is also MP4 and M4A
+)
+
+// inputArgs returns ffmpeg's and ffprobe's arguments to read src
+func inputArgs(src string) []string {
+	return []string{"-protocol_whitelist", protocols, "-format_whitelist", demuxers, "-i", src}
+}
+
+// convertArgs returns ffmpeg's arguments to convert src to dst
+func convertArgs(src, dst string, to Format) ([]string, error) {
+	o, ok := outputs[to.Encoding]
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+
+	args := append([]string{"-nostdin", "-v", "error", "-y"}, inputArgs(src)...)
+	args = append(args, "-vn")
+	if to.Channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(to.Channels))
+	}
+	if to.SampleRateHertz > 0 {
+		args = append(args, "-ar", strconv.Itoa(to.SampleRateHertz))
+	}
+	return append(args, "-c:a", o.codec, dst), nil
+}
+
+// extractArgs returns ffmpeg's arguments to convert src from start to end
+// to dst. It seeks before the input's options, which re-encoding makes
+// accurate.
+func extractArgs(src, dst string, start, end time.Duration, to Format) ([]string, error) {
+	args, err := convertArgs(src, dst, to)
+	if err != nil {
+		return nil, err
+	}
+	seek := []string{"-ss", seconds(start), "-t", seconds(end - start)}
+	for i, arg := range args {
+		if arg == "-protocol_whitelist" {
+			return append(append(append([]string{}, args[:i]...), seek...), args[i:]...), nil
+		}
+	}
+	return nil, fmt.Errorf("no input in %q", args)
+}
+
+// probeOutput is the part of ffprobe's JSON output we use
+type probeOutput struct {
+	Streams []struct {
+		CodecType  string `json:"codec_type"`
+		CodecName  string `json:"codec_name"`
+		SampleRate string `json:"sample_rate"`
+		Channels   int    `json:"channels"`
+		Duration   string `json:"duration"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		Size       string `json:"size"`
+	} `json:"format"`
+}
+
+// parseProbe reads ffprobe's output
+func parseProbe(out []byte) (*Info, error) {
+	var probe probeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("ffprobe output: %v", err)
+	}
+
+	info := &Info{Container: probe.Format.FormatName}
+	info.Bytes, _ = strconv.ParseInt(probe.Format.Size, 10, 64)
+	duration := probe.Format.Duration
+
+	audio := false
+	for _, s := range probe.Streams {
+		switch s.CodecType {
+		case "video":
+			// cover art, e.g. in MP3s, is a one-frame "video"
+			if s.CodecName != "mjpeg" && s.CodecName != "png" {
+				info.Video = true
+			}
+		case "audio":
+			if audio {
+				continue
+			}
+			audio = true
+			info.Codec = s.CodecName
+			info.SampleRateHertz, _ = strconv.Atoi(s.SampleRate)
+			info.Channels = s.Channels
+			if duration == "" {
+				duration = s.Duration
+			}
+		}
+	}
+	if !audio {
+		return nil, ErrNoAudio
+	}
+
+	// some files, e.g. streamed ones, don't say how long they are
+	if seconds, err := strconv.ParseFloat(duration, 64); err == nil {
+		if seconds <= 0 {
+			return nil, ErrEmpty
+		}
+		info.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	return info, nil
+}
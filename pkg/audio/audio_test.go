@@ -0,0 +1,261 @@
+package audio
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseProbe(t *testing.T) {
+
+	type test struct {
+		name     string
+		out      string
+		err      error
+		expected *Info
+	}
+
+	tests := []test{
+		{name: "wav",
+			out: `{"streams": [{"codec_type": "audio", "codec_name": "pcm_s16le", "sample_rate": "8000", "channels": 2}],
+				"format": {"format_name": "wav", "duration": "62.500000", "size": "2000044"}}`,
+			expected: &Info{Container: "wav", Codec: "pcm_s16le", SampleRateHertz: 8000, Channels: 2, Duration: 62500 * time.Millisecond, Bytes: 2000044}},
+		{name: "video",
+			out: `{"streams": [{"codec_type": "video", "codec_name": "h264"}, {"codec_type": "audio", "codec_name": "aac", "sample_rate": "48000", "channels": 2}],
+				"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "10.0", "size": "1000"}}`,
+			expected: &Info{Container: "mov,mp4,m4a,3gp,3g2,mj2", Codec: "aac", SampleRateHertz: 48000, Channels: 2, Duration: 10 * time.Second, Bytes: 1000, Video: true}},
+		{name: "mp3 with cover art",
+			out: `{"streams": [{"codec_type": "audio", "codec_name": "mp3", "sample_rate": "44100", "channels": 1, "duration": "3.0"}, {"codec_type": "video", "codec_name": "mjpeg"}],
+				"format": {"format_name": "mp3", "size": "48000"}}`,
+			expected: &Info{Container: "mp3", Codec: "mp3", SampleRateHertz: 44100, Channels: 1, Duration: 3 * time.Second, Bytes: 48000}},
+		{name: "no audio",
+			out: `{"streams": [{"codec_type": "video", "codec_name": "h264"}], "format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2"}}`,
+			err: ErrNoAudio},
+		{name: "header only",
+			out: `{"streams": [{"codec_type": "audio", "codec_name": "pcm_s16le", "sample_rate": "16000", "channels": 1}],
+				"format": {"format_name": "wav", "duration": "0.000000", "size": "44"}}`,
+			err: ErrEmpty},
+	}
+
+	for _, tc := range tests {
+		got, err := parseProbe([]byte(tc.out))
+		if err != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, err)
+			continue
+		}
+		if !cmp.Equal(got, tc.expected) {
+			t.Errorf("%s: %s", tc.name, cmp.Diff(tc.expected, got))
+		}
+	}
+
+	if _, err := parseProbe([]byte("not JSON")); err == nil {
+		t.Errorf("not JSON: expected an error, got none")
+	}
+}
+
+func TestFormat(t *testing.T) {
+
+	type test struct {
+		name     string
+		info     Info
+		expected Format
+	}
+
+	tests := []test{
+		{name: "mp3", info: Info{Container: "mp3", Codec: "mp3", SampleRateHertz: 44100, Channels: 2}, expected: Format{"MP3", 44100, 2}},
+		{name: "wav", info: Info{Container: "wav", Codec: "pcm_s16le", SampleRateHertz: 8000, Channels: 1}, expected: Format{"LINEAR16", 8000, 1}},
+		{name: "24-bit wav", info: Info{Container: "wav", Codec: "pcm_s24le", SampleRateHertz: 48000, Channels: 1}, expected: Format{"", 48000, 1}},
+		{name: "phone system", info: Info{Container: "amr", Codec: "amr_nb", SampleRateHertz: 8000, Channels: 1}, expected: Format{"AMR", 8000, 1}},
+		{name: "opus", info: Info{Container: "ogg", Codec: "opus", SampleRateHertz: 48000, Channels: 1}, expected: Format{"OGG_OPUS", 48000, 1}},
+		{name: "m4a", info: Info{Container: "mov,mp4,m4a,3gp,3g2,mj2", Codec: "aac", SampleRateHertz: 44100, Channels: 2}, expected: Format{"", 44100, 2}},
+		{name: "flac in a video", info: Info{Container: "matroska,webm", Codec: "flac", SampleRateHertz: 16000, Channels: 1, Video: true}, expected: Format{"", 16000, 1}},
+	}
+
+	for _, tc := range tests {
+		if got := tc.info.Format(); got != tc.expected {
+			t.Errorf("%s: expected %+v, got %+v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	flac16k := Format{Encoding: "FLAC", SampleRateHertz: 16000, Channels: 1}
+
+	if !flac16k.Matches(Format{"FLAC", 16000, 1}) {
+		t.Errorf("expected same format to match")
+	}
+	if flac16k.Matches(Format{"FLAC", 44100, 1}) || flac16k.Matches(Format{"FLAC", 16000, 2}) || flac16k.Matches(Format{"LINEAR16", 16000, 1}) {
+		t.Errorf("expected other rates, channels or encodings not to match")
+	}
+	if !(Format{Encoding: "MP3"}).Matches(Format{"MP3", 44100, 2}) {
+		t.Errorf("expected any rate and channels to match")
+	}
+	if (Format{}).Matches(Format{"", 44100, 2}) {
+		t.Errorf("expected audio needing conversion never to match")
+	}
+}
+
+func TestConvertArgs(t *testing.T) {
+	args, err := convertArgs("in.m4a", "out.flac", Format{Encoding: "FLAC", SampleRateHertz: 16000, Channels: 1})
+	expected := []string{"-nostdin", "-v", "error", "-y", "-protocol_whitelist", "file", "-format_whitelist", demuxers, "-i", "in.m4a", "-vn", "-ac", "1", "-ar", "16000", "-c:a", "flac", "out.flac"}
+	if err != nil || !cmp.Equal(args, expected) {
+		t.Errorf("expected %q, got %q, %v", expected, args, err)
+	}
+
+	if _, err := convertArgs("in.wav", "out.mp3", Format{Encoding: "MP3"}); err != ErrUnsupportedFormat {
+		t.Errorf("MP3: expected %v, got %v", ErrUnsupportedFormat, err)
+	}
+
+	args, err = extractArgs("in.flac", "out.wav", 90*time.Second, 390500*time.Millisecond, Format{Encoding: "LINEAR16", SampleRateHertz: 16000})
+	expected = []string{"-nostdin", "-v", "error", "-y", "-ss", "90.000", "-t", "300.500", "-protocol_whitelist", "file", "-format_whitelist", demuxers, "-i", "in.flac", "-vn", "-ar", "16000", "-c:a", "pcm_s16le", "out.wav"}
+	if err != nil || !cmp.Equal(args, expected) {
+		t.Errorf("extract: expected %q, got %q, %v", expected, args, err)
+	}
+}
+
+func TestParseSilences(t *testing.T) {
+	out := `Input #0, wav, from 'call.wav':
+[silencedetect @ 0x5581] silence_start: 4.52
+[silencedetect @ 0x5581] silence_end: 5.3 | silence_duration: 0.78
+size=N/A time=00:00:10.00 bitrate=N/A speed= 900x
+[silencedetect @ 0x5581] silence_start: 8
+[silencedetect @ 0x5581] silence_end: 9.75 | silence_duration: 1.75
+[silencedetect @ 0x5581] silence_start: 9.9
+`
+	expected := []Silence{
+		{Start: 4520 * time.Millisecond, End: 5300 * time.Millisecond},
+		{Start: 8 * time.Second, End: 9750 * time.Millisecond},
+	}
+	if got := parseSilences([]byte(out)); !cmp.Equal(got, expected) {
+		t.Errorf("%s", cmp.Diff(expected, got))
+	}
+}
+
+func TestChunks(t *testing.T) {
+	const m = time.Minute
+
+	type test struct {
+		name     string
+		duration time.Duration
+		silences []Silence
+		expected []Chunk
+	}
+
+	tests := []test{
+		{name: "short", duration: 6 * m, expected: []Chunk{{Start: 0, End: 6 * m}}},
+		{name: "cut in silences",
+			duration: 14 * m,
+			silences: []Silence{{Start: 4 * m, End: 4*m + 2*time.Second}, {Start: 5*m + 30*time.Second, End: 5*m + 32*time.Second}, {Start: 10 * m, End: 10*m + 4*time.Second}},
+			expected: []Chunk{
+				{Start: 0, End: 5*m + 31*time.Second},
+				{Start: 5*m + 21*time.Second, End: 10*m + 2*time.Second},
+				{Start: 9*m + 52*time.Second, End: 14 * m},
+			}},
+		{name: "no silences", duration: 16 * m,
+			expected: []Chunk{{Start: 0, End: 5 * m}, {Start: 5*m - 10*time.Second, End: 10 * m}, {Start: 10*m - 10*time.Second, End: 16 * m}}},
+		{name: "silence too far", duration: 8 * m, silences: []Silence{{Start: time.Minute, End: time.Minute + time.Second}},
+			expected: []Chunk{{Start: 0, End: 5 * m}, {Start: 5*m - 10*time.Second, End: 8 * m}}},
+	}
+
+	for _, tc := range tests {
+		if got := Chunks(tc.duration, tc.silences, 5*m, 10*time.Second); !cmp.Equal(got, tc.expected) {
+			t.Errorf("%s: %s", tc.name, cmp.Diff(tc.expected, got))
+		}
+	}
+}
+
+// TestFFmpeg runs ffmpeg and ffprobe, if they're installed
+func TestFFmpeg(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not found")
+	}
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		t.Skip("ffprobe not found")
+	}
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "audio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// two seconds of a stereo tone, as AAC
+	src := filepath.Join(dir, "tone.m4a")
+	if out, err := exec.Command("ffmpeg", "-nostdin", "-v", "error", "-f", "lavfi", "-i", "sine=frequency=440:duration=2",
+		"-ac", "2", "-c:a", "aac", src).CombinedOutput(); err != nil {
+		t.Fatalf("making %s: %v, %s", src, err, out)
+	}
+
+	c := NewFFmpeg("", "")
+	info, err := c.Probe(ctx, src)
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if info.Codec != "aac" || info.Channels != 2 || info.Format().Encoding != "" {
+		t.Errorf("Probe: expected stereo AAC, got %+v", info)
+	}
+
+	to := Format{Encoding: "FLAC", SampleRateHertz: 16000, Channels: 1}
+	dst := filepath.Join(dir, "tone.flac")
+	if err := c.Convert(ctx, src, dst, to); err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	if info, err = c.Probe(ctx, dst); err != nil || !to.Matches(info.Format()) {
+		t.Errorf("Convert: expected %+v, got %+v, %v", to, info, err)
+	}
+
+	// the first second of the tone, and the silence after it
+	part := filepath.Join(dir, "part.wav")
+	if err := c.Extract(ctx, dst, part, 500*time.Millisecond, 1500*time.Millisecond, Format{Encoding: "LINEAR16", SampleRateHertz: 16000}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if info, err = c.Probe(ctx, part); err != nil || info.Duration < 990*time.Millisecond || info.Duration > 1010*time.Millisecond {
+		t.Errorf("Extract: expected a second of audio, got %+v, %v", info, err)
+	}
+	// a second of tone, two of silence, and another of tone
+	gap := filepath.Join(dir, "gap.wav")
+	if out, err := exec.Command("ffmpeg", "-nostdin", "-v", "error",
+		"-f", "lavfi", "-i", "sine=frequency=440:sample_rate=16000:duration=1",
+		"-f", "lavfi", "-i", "anullsrc=r=16000:cl=mono",
+		"-f", "lavfi", "-i", "sine=frequency=440:sample_rate=16000:duration=1",
+		"-filter_complex", "[1]atrim=duration=2[s];[0][s][2]concat=n=3:v=0:a=1", gap).CombinedOutput(); err != nil {
+		t.Fatalf("making %s: %v, %s", gap, err, out)
+	}
+	silences, err := c.Silences(ctx, gap)
+	if err != nil || len(silences) != 1 || silences[0].Start < 900*time.Millisecond || silences[0].End > 3100*time.Millisecond {
+		t.Errorf("Silences: expected one from 1s to 3s, got %+v, %v", silences, err)
+	}
+
+	empty := filepath.Join(dir, "empty.mp3")
+	_ = ioutil.WriteFile(empty, nil, 0600)
+	if _, err := c.Probe(ctx, empty); err != ErrEmpty {
+		t.Errorf("empty file: expected %v, got %v", ErrEmpty, err)
+	}
+	corrupt := filepath.Join(dir, "corrupt.mp3")
+	_ = ioutil.WriteFile(corrupt, []byte("not audio"), 0600)
+	if _, err := c.Probe(ctx, corrupt); err != ErrUnreadable {
+		t.Errorf("corrupt file: expected %v, got %v", ErrUnreadable, err)
+	}
+
+	// playlists naming other files aren't followed
+	for name, playlist := range map[string]string{
+		"concat.mp3":   "ffconcat version 1.0\nfile '" + gap + "'\n",
+		"playlist.mp3": "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4,\nfile://" + gap + "\n#EXT-X-ENDLIST\n",
+	} {
+		path := filepath.Join(dir, name)
+		_ = ioutil.WriteFile(path, []byte(playlist), 0600)
+		if _, err := c.Probe(ctx, path); err != ErrUnreadable {
+			t.Errorf("%s: expected %v, got %v", name, ErrUnreadable, err)
+		}
+		if _, err := c.Silences(ctx, path); err != ErrUnreadable {
+			t.Errorf("%s: Silences expected %v, got %v", name, ErrUnreadable, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Audit package records who did what to which Request, for compliance
+// reviews. Records are hash-chained: each carries the hash of the record
+// before it, so a gap or an edit anywhere in the chain is detectable.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// actions recorded
+const (
+	ActionCreate          = "create"
+	ActionUpdate          = "update"
+	ActionDeleteFields    = "delete_fields"
+	ActionReadTranscript  = "read_transcript"
+	ActionPurgeMedia      = "purge_media"
+	ActionPurgeTranscript = "purge_transcript"
+	ActionErase           = "erase"
+)
+
+// Record describes one auditable event
+type Record struct {
+	Chain      string   `json:"chain" firestore:"chain"` // records are linked within a chain
+	Seq        int64    `json:"seq" firestore:"seq"`     // 1 for the first record in the chain
+	Actor      string   `json:"actor" firestore:"actor"` // service or principal responsible
+	CustomerID int      `json:"customer_id" firestore:"customer_id"`
+	RequestID  string   `json:"request_id,omitempty" firestore:"request_id,omitempty"`
+	Action     string   `json:"action" firestore:"action"`
+	Fields     []string `json:"fields,omitempty" firestore:"fields,omitempty"` // fields or objects touched
+	Timestamp  string   `json:"timestamp" firestore:"timestamp"`
+	PrevHash   string   `json:"prev_hash" firestore:"prev_hash"` // "" for the first record in the chain
+	Hash       string   `json:"hash" firestore:"hash"`           // hex SHA-256 of the record, Hash excluded
+}
+
+// Sink is implemented by each place audit records can be written. Sinks
+// link each record into their chain before storing it.
+type Sink interface {
+	Write(rec Record) error
+}
+
+// NewRecord returns a Record attributed to this service, timestamped now
+func NewRecord(customerID int, requestID string, action string, fields ...string) Record {
+	return Record{
+		Actor:      serviceInfo.GetServiceName(),
+		CustomerID: customerID,
+		RequestID:  requestID,
+		Action:     action,
+		Fields:     fields,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// ComputeHash returns the hex SHA-256 of the record's JSON encoding, with
+// Hash itself excluded
+func (rec Record) ComputeHash() string {
+	rec.Hash = ""
+	recJSON, _ := json.Marshal(rec) // Record always marshals
+	sum := sha256.Sum256(recJSON)
+	return hex.EncodeToString(sum[:])
+}
+
+// Chain is the state needed to link the next record: the last record's
+// sequence number and hash
+type Chain struct {
+	ID       string `json:"chain" firestore:"chain"`
+	Seq      int64  `json:"seq" firestore:"seq"`
+	LastHash string `json:"hash" firestore:"hash"`
+}
+
+// Link assigns rec the next position in the chain, and hashes it
+func (c *Chain) Link(rec *Record) {
+	c.Seq++
+	rec.Chain = c.ID
+	rec.Seq = c.Seq
+	rec.PrevHash = c.LastHash
+	rec.Hash = rec.ComputeHash()
+	c.LastHash = rec.Hash
+}
+
+// Verify checks every chain in records, returning a description of each
+// gap, reordering or edit found; none means the chains are intact
+func Verify(records []Record) []string {
+	chains := make(map[string][]Record)
+	ids := []string{}
+	for _, rec := range records {
+		if _, ok := chains[rec.Chain]; !ok {
+			ids = append(ids, rec.Chain)
+		}
+		chains[rec.Chain] = append(chains[rec.Chain], rec)
+	}
+	sort.Strings(ids)
+
+	problems := []string{}
+	for _, id := range ids {
+		chain := chains[id]
+		sort.SliceStable(chain, func(i, j int) bool { return chain[i].Seq < chain[j].Seq })
+
+		prev := Chain{ID: id}
+		for _, rec := range chain {
+			if rec.Seq != prev.Seq+1 {
+				problems = append(problems, fmt.Sprintf("chain %q: expected seq %d, got %d", id, prev.Seq+1, rec.Seq))
+			}
+			if rec.PrevHash != prev.LastHash {
+				problems = append(problems, fmt.Sprintf("chain %q seq %d: prev_hash doesn't match the preceding record", id, rec.Seq))
+			}
+			if rec.ComputeHash() != rec.Hash {
+				problems = append(problems, fmt.Sprintf("chain %q seq %d: hash doesn't match contents", id, rec.Seq))
+			}
+			prev.Seq = rec.Seq
+			prev.LastHash = rec.Hash
+		}
+	}
+
+	return problems
+}
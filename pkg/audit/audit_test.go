@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/request"
+)
+
+// chainOf returns n records linked into one chain
+func chainOf(n int) []Record {
+	chain := Chain{ID: "test"}
+	records := []Record{}
+	for i := 0; i < n; i++ {
+		rec := NewRecord(1234567, uuid.New().String(), ActionUpdate, "status")
+		chain.Link(&rec)
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestVerify(t *testing.T) {
+	type test struct {
+		name     string
+		tamper   func([]Record) []Record
+		problems int
+	}
+
+	tests := []test{
+		{name: "intact", tamper: func(r []Record) []Record { return r }},
+		{name: "edited", tamper: func(r []Record) []Record {
+			r[2].Action = ActionCreate
+			return r
+		}, problems: 1},
+		{name: "edited and rehashed", tamper: func(r []Record) []Record {
+			r[2].CustomerID = 7654321
+			r[2].Hash = r[2].ComputeHash()
+			return r
+		}, problems: 1},
+		{name: "gap", tamper: func(r []Record) []Record {
+			return append(r[:2], r[3:]...)
+		}, problems: 2},
+		{name: "first removed", tamper: func(r []Record) []Record {
+			return r[1:]
+		}, problems: 2},
+		{name: "reordered but intact", tamper: func(r []Record) []Record {
+			r[1], r[3] = r[3], r[1]
+			return r
+		}},
+		{name: "renumbered", tamper: func(r []Record) []Record {
+			r[1].Seq, r[3].Seq = r[3].Seq, r[1].Seq
+			return r
+		}, problems: 6},
+	}
+
+	for _, tc := range tests {
+		problems := Verify(tc.tamper(chainOf(5)))
+		if len(problems) != tc.problems {
+			t.Errorf("%s: expected %d problems, got %d: %v", tc.name, tc.problems, len(problems), problems)
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	_ = sink.Write(NewRecord(1234567, "", ActionCreate))
+	_ = sink.Write(NewRecord(1234567, "", ActionUpdate))
+
+	// a second sink on the same file continues the chain
+	sink, err = NewFileSink(path)
+	if err != nil {
+		t.Fatalf("NewFileSink (resume): %v", err)
+	}
+	_ = sink.Write(NewRecord(1234567, "", ActionReadTranscript))
+
+	records, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(records) != 3 || records[2].Seq != 3 || records[2].PrevHash != records[1].Hash {
+		t.Errorf("expected one chain of 3 records, got %+v", records)
+	}
+	if problems := Verify(records); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+}
+
+func TestReadRecords(t *testing.T) {
+	var buf bytes.Buffer
+	sink := &writerSink{w: &buf, chain: Chain{ID: "stdout"}}
+	_ = sink.Write(NewRecord(1234567, "", ActionCreate))
+	buf.WriteString("2020/03/01 12:00:00 default.main, not a record\n")
+	_ = sink.Write(NewRecord(1234567, "", ActionUpdate))
+
+	records, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecords: %v", err)
+	}
+	if len(records) != 2 {
+		t.Errorf("expected 2 records, got %+v", records)
+	}
+}
+
+// memRequests notes whether Update reached it
+type memRequests struct {
+	request.RequestRepository
+	updated bool
+}
+
+func (m *memRequests) Update(req *request.Request) error {
+	m.updated = true
+	return nil
+}
+
+type memSink struct {
+	records []Record
+	err     error // returned by every Write, if set
+}
+
+func (m *memSink) Write(rec Record) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.records = append(m.records, rec)
+	return nil
+}
+
+func TestAuditedRequestRepository(t *testing.T) {
+	inner := &memRequests{}
+	sink := &memSink{}
+	repo := NewAuditedRequestRepository(inner, sink)
+
+	req := request.Request{
+		RequestID:  uuid.New(),
+		CustomerID: 1234567,
+		Status:     request.Completed,
+	}
+	if err := repo.Update(&req); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	if !inner.updated {
+		t.Errorf("expected Update passed to the wrapped repository")
+	}
+	if len(sink.records) != 1 {
+		t.Fatalf("expected 1 record, got %+v", sink.records)
+	}
+	rec := sink.records[0]
+	if rec.Action != ActionUpdate || rec.CustomerID != 1234567 || rec.RequestID != req.RequestID.String() {
+		t.Errorf("unexpected record %+v", rec)
+	}
+	found := false
+	for _, field := range rec.Fields {
+		found = found || field == "status"
+	}
+	if !found {
+		t.Errorf("expected status among fields written, got %v", rec.Fields)
+	}
+
+	// a change that can't be recorded is an error, to retry
+	sink.err = errors.New("too much contention")
+	if err := repo.Update(&req); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("expected ErrNotRecorded, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// auditedRequestRepository implements request.RequestRepository by passing
+// each call to the wrapped repository, and recording every change
+type auditedRequestRepository struct {
+	request.RequestRepository
+	sink Sink
+}
+
+// ErrNotRecorded - the change was made, but not recorded to the audit log
+var ErrNotRecorded = errors.New("Change not recorded to the audit log")
+
+// NewAuditedRequestRepository wraps repo, recording each Create, Update and
+// DeleteFields to sink. A change that can't be recorded returns
+// ErrNotRecorded, though made, so the caller retries it and it's recorded
+// then. Reads aren't recorded here; handlers that show Request content to
+// a caller record that themselves.
+func NewAuditedRequestRepository(repo request.RequestRepository, sink Sink) request.RequestRepository {
+	return auditedRequestRepository{repo, sink}
+}
+
+func (r auditedRequestRepository) Create(req *request.Request) error {
+	if err := r.RequestRepository.Create(req); err != nil {
+		return err
+	}
+	return r.write(NewRecord(req.CustomerID, req.RequestID.String(), ActionCreate, fieldsWritten(req)...))
+}
+
+func (r auditedRequestRepository) Update(req *request.Request) error {
+	if err := r.RequestRepository.Update(req); err != nil {
+		return err
+	}
+	return r.write(NewRecord(req.CustomerID, req.RequestID.String(), ActionUpdate, fieldsWritten(req)...))
+}
+
+func (r auditedRequestRepository) DeleteFields(reqID uuid.UUID, fields ...string) error {
+	if err := r.RequestRepository.DeleteFields(reqID, fields...); err != nil {
+		return err
+	}
+	// CustomerID isn't known without a read; the RequestID identifies it
+	return r.write(NewRecord(0, reqID.String(), ActionDeleteFields, fields...))
+}
+
+// write records rec, returning ErrNotRecorded if it can't
+func (r auditedRequestRepository) write(rec Record) error {
+	if err := r.sink.Write(rec); err != nil {
+		log.Printf("%s.audit, Sink.Write error: %v, record: %+v\n", serviceInfo.GetServiceName(), err, rec)
+		return fmt.Errorf("%w: %v", ErrNotRecorded, err)
+	}
+	return nil
+}
+
+// fieldsWritten returns the names of the fields an Update writes, i.e.
+// those present in the Request's map representation
+func fieldsWritten(req *request.Request) []string {
+	reqMap, err := req.ToMap()
+	if err != nil {
+		return nil
+	}
+	fields := make([]string, 0, len(reqMap))
+	for name := range reqMap {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// ********** ********** ********** ********** ********** **********
+
+// writerSink implements Sink by writing each record as a line of JSON. The
+// chain lives in memory, so the writer must have a single Sink.
+type writerSink struct {
+	mu    sync.Mutex
+	w     io.Writer
+	chain Chain
+}
+
+// NewStdoutSink returns a Sink writing JSON lines to stdout. Each process
+// starts its own chain, named for the service and start time.
+func NewStdoutSink() Sink {
+	return &writerSink{
+		w:     os.Stdout,
+		chain: Chain{ID: fmt.Sprintf("%s-%d", serviceInfo.GetServiceName(), time.Now().UnixNano())},
+	}
+}
+
+func (s *writerSink) Write(rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chain.Link(&rec)
+	recJSON, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "%s\n", recJSON)
+	return err
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// NewFileSink returns a Sink appending JSON lines to the file at path,
+// continuing the chain already in the file. Only one process may write
+// the file.
+func NewFileSink(path string) (Sink, error) {
+	records, err := ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	chain := Chain{ID: filepath.Base(path)}
+	if len(records) > 0 {
+		last := records[len(records)-1]
+		chain = Chain{ID: last.Chain, Seq: last.Seq, LastHash: last.Hash}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writerSink{w: f, chain: chain}, nil
+}
+
+// ReadFile reads the records written by a file or stdout Sink, skipping
+// lines that aren't records
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadRecords(f)
+}
+
+// ReadRecords reads JSON-lines records, skipping lines that aren't records
+func ReadRecords(r io.Reader) ([]Record, error) {
+	records := []Record{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Hash == "" {
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	return records, scanner.Err()
+}
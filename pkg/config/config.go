@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	cloudkms "cloud.google.com/go/kms/apiv1"
 	"cloud.google.com/go/storage"
@@ -75,6 +76,16 @@ func GetConfig(cfg *Config, svc string) error {
 		{structField: "TaskInitialRequestNextSvcToHandleReq", envVar: "TASK_INITIAL_REQUEST_SVC_TO_HANDLE_REQ"},
 		{structField: "TaskInitialRequestPort", envVar: "TASK_INITIAL_REQUEST_PORT"},
 		//
+		{structField: "TaskMediaFetchSvcName", envVar: "TASK_MEDIA_FETCH_SERVICENAME"},
+		{structField: "TaskMediaFetchWriteToQ", envVar: "TASK_MEDIA_FETCH_WRITE_TO_Q"},
+		{structField: "TaskMediaFetchNextSvcToHandleReq", envVar: "TASK_MEDIA_FETCH_SVC_TO_HANDLE_REQ"},
+		{structField: "TaskMediaFetchPort", envVar: "TASK_MEDIA_FETCH_PORT"},
+		//
+		{structField: "TaskMediaConvertSvcName", envVar: "TASK_MEDIA_CONVERT_SERVICENAME"},
+		{structField: "TaskMediaConvertWriteToQ", envVar: "TASK_MEDIA_CONVERT_WRITE_TO_Q"},
+		{structField: "TaskMediaConvertNextSvcToHandleReq", envVar: "TASK_MEDIA_CONVERT_SVC_TO_HANDLE_REQ"},
+		{structField: "TaskMediaConvertPort", envVar: "TASK_MEDIA_CONVERT_PORT"},
+		//
 		{structField: "TaskServiceDispatchSvcName", envVar: "TASK_SERVICE_DISPATCH_SERVICENAME"},
 		{structField: "TaskServiceDispatchWriteToQ", envVar: "TASK_SERVICE_DISPATCH_WRITE_TO_Q"},
 		{structField: "TaskServiceDispatchNextSvcToHandleReq", envVar: "TASK_SERVICE_DISPATCH_SVC_TO_HANDLE_REQ"},
@@ -200,6 +211,37 @@ func GetConfig(cfg *Config, svc string) error {
 	cfg.Description = viper.GetString("Description")
 	cfg.DatabaseRequests = viper.GetString("DatabaseRequests")
 	cfg.DatabaseCustomers = viper.GetString("DatabaseCustomers")
+	cfg.DatabaseAudit = viper.GetString("DatabaseAudit")
+	cfg.DatabaseErasures = viper.GetString("DatabaseErasures")
+	cfg.AuditSink = viper.GetString("AuditSink")
+	cfg.DatabaseKeys = viper.GetString("DatabaseKeys")
+	cfg.DatabaseUsage = viper.GetString("DatabaseUsage")
+	cfg.DatabaseWebhooks = viper.GetString("DatabaseWebhooks")
+	cfg.KeyWrapper = viper.GetString("KeyWrapper")
+	cfg.KmsDataKey = viper.GetString("KmsDataKey")
+	cfg.JWTIssuer = viper.GetString("JWTIssuer")
+	cfg.JWTAudience = viper.GetString("JWTAudience")
+	cfg.JWKS = viper.GetString("JWKS")
+	cfg.JWTCustomerClaim = viper.GetString("JWTCustomerClaim")
+	cfg.PipelineQueues = viper.GetStringSlice("PipelineQueues")
+	cfg.DefaultProvider = viper.GetString("DefaultProvider")
+	cfg.LocalEngine = viper.GetStringSlice("LocalEngine")
+	cfg.MediaStore = viper.GetString("MediaStore")
+	cfg.MediaSigner = viper.GetString("MediaSigner")
+	cfg.S3Endpoint = viper.GetString("S3Endpoint")
+	cfg.S3Region = viper.GetString("S3Region")
+	cfg.S3AccessKeyID = viper.GetString("S3AccessKeyID")
+	cfg.S3SecretAccessKey = viper.GetString("S3SecretAccessKey")
+	cfg.MediaMaxBytes = viper.GetInt64("MediaMaxBytes")
+	cfg.MediaFetchTimeout = viper.GetDuration("MediaFetchTimeout")
+	cfg.FFmpeg = viper.GetString("FFmpeg")
+	cfg.ChunkLength = viper.GetDuration("ChunkLength")
+	cfg.FFprobe = viper.GetString("FFprobe")
+	if err := viper.UnmarshalKey("TranscriptionProviders", &cfg.TranscriptionProviders); err != nil {
+		log.Printf("GetConfig, TranscriptionProviders error: %v\n", err)
+		return err
+	}
+	cfg.AdminToken = viper.GetString("AdminToken")
 	cfg.Version = viper.GetString("Version")
 
 	// set Config struct fields based on execution environment
@@ -229,18 +271,54 @@ const (
 	GCTQueue
 )
 
+// ProviderRoute is where serviceDispatch sends Requests for a
+// speech-to-text provider
+type ProviderRoute struct {
+	Queue   string // Cloud Tasks queue
+	Service string // App Engine service handling the queue
+}
+
 type Config struct {
+	AdminToken        string // bearer token for /admin endpoints
 	AppName           string
+	AuditSink         string        // "firestore", "stdout" or "file:<path>"
+	ChunkLength       time.Duration // long media is split into chunks of about this length; 0 for audio.DefaultChunkLength
 	ConfigFile        string
+	DatabaseAudit     string
 	DatabaseCustomers string
+	DatabaseErasures  string
+	DatabaseKeys      string // customers' wrapped data keys
 	DatabaseRequests  string
+	DatabaseUsage     string // customers' usage toward their quotas
+	DatabaseWebhooks  string // webhook delivery log
+	DefaultProvider   string // speech-to-text provider when neither customer nor Request chooses
 	Description       string
+	FFmpeg            string // ffmpeg command mediaConvert runs; "" for the one on the PATH
+	FFprobe           string // likewise ffprobe
 	IsGAE             bool
+	JWKS              string // partners' JWT signing keys: a URL or "file:<path>"
+	JWTAudience       string
+	JWTCustomerClaim  string        // claim holding the CustomerID
+	JWTIssuer         string        // partner JWTs accepted only when set
+	KeyWrapper        string        // wraps data keys: "kms" or "file:<path>"
+	KmsDataKey        string        // KMS key in KmsKeyRing wrapping data keys
+	LocalEngine       []string      // offline speech-to-text engine and its arguments, see pkg/transcription
+	MediaFetchTimeout time.Duration // for each download; 0 for media.DefaultFetchTimeout
+	MediaMaxBytes     int64         // largest media file fetched; 0 for media.DefaultMaxBytes
+	MediaSigner       string        // service account signing Cloud Storage URLs
+	MediaStore        string        // where mediaFetch copies media files: "gs://bucket", "s3://bucket" or "file:///dir"
+	PipelineQueues    []string      // every Cloud Tasks queue in the pipeline
 	QueueName         string
 	Router            http.Handler
 	ServiceName       string
 	NextServiceName   string
 	StorageType       Type
+	S3AccessKeyID     string
+	S3Endpoint        string // S3-compatible service, e.g. MinIO; "" for Amazon S3
+	S3Region          string
+	S3SecretAccessKey string
+	// where serviceDispatch sends Requests for each speech-to-text provider
+	TranscriptionProviders map[string]ProviderRoute
 	// Key Management Service for encrypted config
 	EncryptedBucket string
 	KmsKey          string
@@ -253,6 +331,8 @@ type Config struct {
 	// port number used by each service
 	TaskDefaultPort               string
 	TaskInitialRequestPort        string
+	TaskMediaFetchPort            string
+	TaskMediaConvertPort          string
 	TaskServiceDispatchPort       string
 	TaskTranscriptionGCPPort      string
 	TaskTranscriptionCompletePort string
@@ -266,6 +346,8 @@ type Config struct {
 	// queue name used by each services
 	TaskDefaultWriteToQ               string
 	TaskInitialRequestWriteToQ        string
+	TaskMediaFetchWriteToQ            string
+	TaskMediaConvertWriteToQ          string
 	TaskServiceDispatchWriteToQ       string
 	TaskTranscriptionGCPWriteToQ      string
 	TaskTranscriptionCompleteWriteToQ string
@@ -279,6 +361,8 @@ type Config struct {
 	// service name of each service
 	TaskDefaultSvcName               string
 	TaskInitialRequestSvcName        string
+	TaskMediaFetchSvcName            string
+	TaskMediaConvertSvcName          string
 	TaskServiceDispatchSvcName       string
 	TaskTranscriptionGCPSvcName      string
 	TaskTranscriptionCompleteSvcName string
@@ -292,6 +376,8 @@ type Config struct {
 	// next service in the chain to handle requests
 	TaskDefaultNextSvcToHandleReq               string
 	TaskInitialRequestNextSvcToHandleReq        string
+	TaskMediaFetchNextSvcToHandleReq            string
+	TaskMediaConvertNextSvcToHandleReq          string
 	TaskServiceDispatchNextSvcToHandleReq       string
 	TaskTranscriptionGCPNextSvcToHandleReq      string
 	TaskTranscriptionCompleteNextSvcToHandleReq string
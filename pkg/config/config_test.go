@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -15,14 +16,41 @@ func TestGetConfig(t *testing.T) {
 		AppName:           "MyLeadExpert",
 		ConfigFile:        "config.yaml",
 		Description:       "More leads for local retailers. Generate more sales by routing your existing traffic through a proven conversion process.",
+		AuditSink:         "firestore",
+		ChunkLength:       0,
+		DatabaseAudit:     "leadexperts-audit",
 		DatabaseCustomers: "leadexperts-customers",
+		DatabaseErasures:  "leadexperts-erasures",
+		DatabaseKeys:      "leadexperts-keys",
 		DatabaseRequests:  "leadexperts-requests",
+		DatabaseUsage:     "leadexperts-usage",
+		DatabaseWebhooks:  "leadexperts-webhooks",
+		DefaultProvider:   "google",
+		FFmpeg:            "",
+		FFprobe:           "",
 		IsGAE:             false,
-		QueueName:         "InitialRequest",
-		Router:            nil,
-		ServiceName:       "default",
-		NextServiceName:   "initial-request",
-		StorageType:       Memory,
+		JWKS:              "",
+		JWTAudience:       "",
+		JWTCustomerClaim:  "",
+		JWTIssuer:         "",
+		KeyWrapper:        "kms",
+		KmsDataKey:        "transcripts",
+		LocalEngine:       []string{},
+		MediaFetchTimeout: 10 * time.Minute,
+		MediaMaxBytes:     524288000,
+		MediaSigner:       "elated-practice-224603@appspot.gserviceaccount.com",
+		MediaStore:        "gs://elated-practice-224603-media",
+		PipelineQueues: []string{"InitialRequest", "MediaFetch", "MediaConvert", "ServiceDispatch", "TranscriptionGCP", "TranscriptionComplete",
+			"TranscriptQA", "TranscriptQAComplete", "Tagging", "TaggingComplete", "TaggingQA", "TaggingQAComplete",
+			"CompletionProcessing"},
+		QueueName:   "InitialRequest",
+		Router:      nil,
+		ServiceName: "default",
+		TranscriptionProviders: map[string]ProviderRoute{
+			"google": {Queue: "TranscriptionGCP", Service: "transcription-gcp"},
+		},
+		NextServiceName: "initial-request",
+		StorageType:     Memory,
 		// Key Management Service for encrypted config
 		EncryptedBucket: "elated-practice-224603-lead-expert-secret",
 		KmsKey:          "config",
@@ -35,6 +63,8 @@ func TestGetConfig(t *testing.T) {
 		// port number used by each service
 		TaskDefaultPort:               "8080",
 		TaskInitialRequestPort:        "8081",
+		TaskMediaFetchPort:            "8092",
+		TaskMediaConvertPort:          "8093",
 		TaskServiceDispatchPort:       "8082",
 		TaskTranscriptionGCPPort:      "8083",
 		TaskTranscriptionCompletePort: "8084",
@@ -47,7 +77,9 @@ func TestGetConfig(t *testing.T) {
 		TaskCompletionProcessingPort:  "8091",
 		// queue name used by each services
 		TaskDefaultWriteToQ:               "InitialRequest",
-		TaskInitialRequestWriteToQ:        "ServiceDispatch",
+		TaskInitialRequestWriteToQ:        "MediaFetch",
+		TaskMediaFetchWriteToQ:            "MediaConvert",
+		TaskMediaConvertWriteToQ:          "ServiceDispatch",
 		TaskServiceDispatchWriteToQ:       "TranscriptionGCP",
 		TaskTranscriptionGCPWriteToQ:      "TranscriptionComplete",
 		TaskTranscriptionCompleteWriteToQ: "TranscriptQA",
@@ -61,6 +93,8 @@ func TestGetConfig(t *testing.T) {
 		// service name of each service
 		TaskDefaultSvcName:               "default",
 		TaskInitialRequestSvcName:        "initial-request",
+		TaskMediaFetchSvcName:            "media-fetch",
+		TaskMediaConvertSvcName:          "media-convert",
 		TaskServiceDispatchSvcName:       "service-dispatch",
 		TaskTranscriptionGCPSvcName:      "transcription-gcp",
 		TaskTranscriptionCompleteSvcName: "transcription-complete",
@@ -73,7 +107,9 @@ func TestGetConfig(t *testing.T) {
 		TaskCompletionProcessingSvcName:  "completion-processing",
 		// next service in the chain to handle requests
 		TaskDefaultNextSvcToHandleReq:               "initial-request",
-		TaskInitialRequestNextSvcToHandleReq:        "service-dispatch",
+		TaskInitialRequestNextSvcToHandleReq:        "media-fetch",
+		TaskMediaFetchNextSvcToHandleReq:            "media-convert",
+		TaskMediaConvertNextSvcToHandleReq:          "service-dispatch",
 		TaskServiceDispatchNextSvcToHandleReq:       "transcription-gcp",
 		TaskTranscriptionGCPNextSvcToHandleReq:      "transcription-complete",
 		TaskTranscriptionCompleteNextSvcToHandleReq: "transcript-qa",
@@ -100,6 +136,12 @@ func TestGetConfig(t *testing.T) {
 		// CHEAT: nil-out the actual QueueService, hard to compare addresses
 		// cfg.QueueService = nil
 
+		// secrets aren't kept in the test: confirm present, then exclude from comparison
+		if cfg.AdminToken == "" {
+			t.Errorf("AdminToken: expected non-empty")
+		}
+		defaultResult.AdminToken = cfg.AdminToken
+
 		if !cmp.Equal(defaultResult, cfg) {
 			findMismatch(t, defaultResult, cfg)
 		}
@@ -124,6 +166,102 @@ func findMismatch(t *testing.T, expected Config, got Config) {
 		foundMismatch = true
 		t.Errorf("DatabaseCustomers: expected %q, got %q", expected.DatabaseCustomers, got.DatabaseCustomers)
 	}
+	if expected.AuditSink != got.AuditSink {
+		foundMismatch = true
+		t.Errorf("AuditSink: expected %q, got %q", expected.AuditSink, got.AuditSink)
+	}
+	if expected.DatabaseAudit != got.DatabaseAudit {
+		foundMismatch = true
+		t.Errorf("DatabaseAudit: expected %q, got %q", expected.DatabaseAudit, got.DatabaseAudit)
+	}
+	if expected.DatabaseKeys != got.DatabaseKeys {
+		foundMismatch = true
+		t.Errorf("DatabaseKeys: expected %q, got %q", expected.DatabaseKeys, got.DatabaseKeys)
+	}
+	if expected.DatabaseUsage != got.DatabaseUsage {
+		foundMismatch = true
+		t.Errorf("DatabaseUsage: expected %q, got %q", expected.DatabaseUsage, got.DatabaseUsage)
+	}
+	if expected.DatabaseWebhooks != got.DatabaseWebhooks {
+		foundMismatch = true
+		t.Errorf("DatabaseWebhooks: expected %q, got %q", expected.DatabaseWebhooks, got.DatabaseWebhooks)
+	}
+	if expected.DefaultProvider != got.DefaultProvider {
+		foundMismatch = true
+		t.Errorf("DefaultProvider: expected %q, got %q", expected.DefaultProvider, got.DefaultProvider)
+	}
+	if expected.ChunkLength != got.ChunkLength {
+		foundMismatch = true
+		t.Errorf("ChunkLength: expected %v, got %v", expected.ChunkLength, got.ChunkLength)
+	}
+	if expected.FFmpeg != got.FFmpeg {
+		foundMismatch = true
+		t.Errorf("FFmpeg: expected %q, got %q", expected.FFmpeg, got.FFmpeg)
+	}
+	if expected.FFprobe != got.FFprobe {
+		foundMismatch = true
+		t.Errorf("FFprobe: expected %q, got %q", expected.FFprobe, got.FFprobe)
+	}
+	if expected.MediaStore != got.MediaStore {
+		foundMismatch = true
+		t.Errorf("MediaStore: expected %q, got %q", expected.MediaStore, got.MediaStore)
+	}
+	if expected.MediaSigner != got.MediaSigner {
+		foundMismatch = true
+		t.Errorf("MediaSigner: expected %q, got %q", expected.MediaSigner, got.MediaSigner)
+	}
+	if expected.S3Endpoint != got.S3Endpoint {
+		foundMismatch = true
+		t.Errorf("S3Endpoint: expected %q, got %q", expected.S3Endpoint, got.S3Endpoint)
+	}
+	if expected.S3Region != got.S3Region {
+		foundMismatch = true
+		t.Errorf("S3Region: expected %q, got %q", expected.S3Region, got.S3Region)
+	}
+	if expected.MediaFetchTimeout != got.MediaFetchTimeout {
+		foundMismatch = true
+		t.Errorf("MediaFetchTimeout: expected %v, got %v", expected.MediaFetchTimeout, got.MediaFetchTimeout)
+	}
+	if expected.MediaMaxBytes != got.MediaMaxBytes {
+		foundMismatch = true
+		t.Errorf("MediaMaxBytes: expected %d, got %d", expected.MediaMaxBytes, got.MediaMaxBytes)
+	}
+	if !cmp.Equal(expected.LocalEngine, got.LocalEngine) {
+		foundMismatch = true
+		t.Errorf("LocalEngine: expected %v, got %v", expected.LocalEngine, got.LocalEngine)
+	}
+	if !cmp.Equal(expected.TranscriptionProviders, got.TranscriptionProviders) {
+		foundMismatch = true
+		t.Errorf("TranscriptionProviders: expected %v, got %v", expected.TranscriptionProviders, got.TranscriptionProviders)
+	}
+	if expected.JWKS != got.JWKS {
+		foundMismatch = true
+		t.Errorf("JWKS: expected %q, got %q", expected.JWKS, got.JWKS)
+	}
+	if expected.JWTAudience != got.JWTAudience {
+		foundMismatch = true
+		t.Errorf("JWTAudience: expected %q, got %q", expected.JWTAudience, got.JWTAudience)
+	}
+	if expected.JWTCustomerClaim != got.JWTCustomerClaim {
+		foundMismatch = true
+		t.Errorf("JWTCustomerClaim: expected %q, got %q", expected.JWTCustomerClaim, got.JWTCustomerClaim)
+	}
+	if expected.JWTIssuer != got.JWTIssuer {
+		foundMismatch = true
+		t.Errorf("JWTIssuer: expected %q, got %q", expected.JWTIssuer, got.JWTIssuer)
+	}
+	if expected.KeyWrapper != got.KeyWrapper {
+		foundMismatch = true
+		t.Errorf("KeyWrapper: expected %q, got %q", expected.KeyWrapper, got.KeyWrapper)
+	}
+	if expected.KmsDataKey != got.KmsDataKey {
+		foundMismatch = true
+		t.Errorf("KmsDataKey: expected %q, got %q", expected.KmsDataKey, got.KmsDataKey)
+	}
+	if expected.DatabaseErasures != got.DatabaseErasures {
+		foundMismatch = true
+		t.Errorf("DatabaseErasures: expected %q, got %q", expected.DatabaseErasures, got.DatabaseErasures)
+	}
 	if expected.DatabaseRequests != got.DatabaseRequests {
 		foundMismatch = true
 		t.Errorf("DatabaseRequests: expected %q, got %q", expected.DatabaseRequests, got.DatabaseRequests)
@@ -137,6 +275,10 @@ func findMismatch(t *testing.T, expected Config, got Config) {
 		foundMismatch = true
 		t.Errorf("IsGAE: expected %t, got %v", expected.IsGAE, got.IsGAE)
 	}
+	if !cmp.Equal(expected.PipelineQueues, got.PipelineQueues) {
+		foundMismatch = true
+		t.Errorf("PipelineQueues: expected %v, got %v", expected.PipelineQueues, got.PipelineQueues)
+	}
 	if expected.QueueName != got.QueueName {
 		foundMismatch = true
 		t.Errorf("QueueName: expected %q, got %q", expected.QueueName, got.QueueName)
@@ -199,6 +341,14 @@ func findMismatch(t *testing.T, expected Config, got Config) {
 		foundMismatch = true
 		t.Errorf("TaskInitialRequestPort: expected %q, got %q", expected.TaskInitialRequestPort, got.TaskInitialRequestPort)
 	}
+	if expected.TaskMediaFetchPort != got.TaskMediaFetchPort {
+		foundMismatch = true
+		t.Errorf("TaskMediaFetchPort: expected %q, got %q", expected.TaskMediaFetchPort, got.TaskMediaFetchPort)
+	}
+	if expected.TaskMediaConvertPort != got.TaskMediaConvertPort {
+		foundMismatch = true
+		t.Errorf("TaskMediaConvertPort: expected %q, got %q", expected.TaskMediaConvertPort, got.TaskMediaConvertPort)
+	}
 	if expected.TaskServiceDispatchPort != got.TaskServiceDispatchPort {
 		foundMismatch = true
 		t.Errorf("TaskServiceDispatchPort: expected %q, got %q", expected.TaskServiceDispatchPort, got.TaskServiceDispatchPort)
@@ -249,6 +399,14 @@ func findMismatch(t *testing.T, expected Config, got Config) {
 		foundMismatch = true
 		t.Errorf("TaskInitialRequestWriteToQ: expected %q, got %q", expected.TaskInitialRequestWriteToQ, got.TaskInitialRequestWriteToQ)
 	}
+	if expected.TaskMediaFetchWriteToQ != got.TaskMediaFetchWriteToQ {
+		foundMismatch = true
+		t.Errorf("TaskMediaFetchWriteToQ: expected %q, got %q", expected.TaskMediaFetchWriteToQ, got.TaskMediaFetchWriteToQ)
+	}
+	if expected.TaskMediaConvertWriteToQ != got.TaskMediaConvertWriteToQ {
+		foundMismatch = true
+		t.Errorf("TaskMediaConvertWriteToQ: expected %q, got %q", expected.TaskMediaConvertWriteToQ, got.TaskMediaConvertWriteToQ)
+	}
 	if expected.TaskServiceDispatchWriteToQ != got.TaskServiceDispatchWriteToQ {
 		foundMismatch = true
 		t.Errorf("TaskServiceDispatchWriteToQ: expected %q, got %q", expected.TaskServiceDispatchWriteToQ, got.TaskServiceDispatchWriteToQ)
@@ -299,6 +457,14 @@ func findMismatch(t *testing.T, expected Config, got Config) {
 		foundMismatch = true
 		t.Errorf("TaskInitialRequestSvcName: expected %q, got %q", expected.TaskInitialRequestSvcName, got.TaskInitialRequestSvcName)
 	}
+	if expected.TaskMediaFetchSvcName != got.TaskMediaFetchSvcName {
+		foundMismatch = true
+		t.Errorf("TaskMediaFetchSvcName: expected %q, got %q", expected.TaskMediaFetchSvcName, got.TaskMediaFetchSvcName)
+	}
+	if expected.TaskMediaConvertSvcName != got.TaskMediaConvertSvcName {
+		foundMismatch = true
+		t.Errorf("TaskMediaConvertSvcName: expected %q, got %q", expected.TaskMediaConvertSvcName, got.TaskMediaConvertSvcName)
+	}
 	if expected.TaskServiceDispatchSvcName != got.TaskServiceDispatchSvcName {
 		foundMismatch = true
 		t.Errorf("TaskServiceDispatchSvcName: expected %q, got %q", expected.TaskServiceDispatchSvcName, got.TaskServiceDispatchSvcName)
@@ -349,6 +515,14 @@ func findMismatch(t *testing.T, expected Config, got Config) {
 		foundMismatch = true
 		t.Errorf("TaskInitialRequestNextSvcToHandleReq: expected %q, got %q", expected.TaskInitialRequestNextSvcToHandleReq, got.TaskInitialRequestNextSvcToHandleReq)
 	}
+	if expected.TaskMediaFetchNextSvcToHandleReq != got.TaskMediaFetchNextSvcToHandleReq {
+		foundMismatch = true
+		t.Errorf("TaskMediaFetchNextSvcToHandleReq: expected %q, got %q", expected.TaskMediaFetchNextSvcToHandleReq, got.TaskMediaFetchNextSvcToHandleReq)
+	}
+	if expected.TaskMediaConvertNextSvcToHandleReq != got.TaskMediaConvertNextSvcToHandleReq {
+		foundMismatch = true
+		t.Errorf("TaskMediaConvertNextSvcToHandleReq: expected %q, got %q", expected.TaskMediaConvertNextSvcToHandleReq, got.TaskMediaConvertNextSvcToHandleReq)
+	}
 	if expected.TaskServiceDispatchNextSvcToHandleReq != got.TaskServiceDispatchNextSvcToHandleReq {
 		foundMismatch = true
 		t.Errorf("TaskServiceDispatchNextSvcToHandleReq: expected %q, got %q", expected.TaskServiceDispatchNextSvcToHandleReq, got.TaskServiceDispatchNextSvcToHandleReq)
@@ -0,0 +1,167 @@
+// Customer package defines the customer profile: who the customer is, how
+// they reach us, and their default processing preferences
+package customer
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-playground/validator"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/peterpla/lead-expert/pkg/request"
+)
+
+// WebhookSecretPrefix starts every webhook signing secret
+const WebhookSecretPrefix = "whsec_"
+
+// ErrCustomerDisabled - customer exists but may not submit requests
+var ErrCustomerDisabled = errors.New("Customer disabled")
+
+// Customer defines properties of a customer's profile
+type Customer struct {
+	CustomerID    int                   `json:"customer_id" firestore:"-" validate:"required,gte=1,lt=10000000"` // redundant when Firestore docID = CustomerID
+	Name          string                `json:"name" firestore:"name" validate:"required"`
+	Disabled      bool                  `json:"disabled" firestore:"disabled"`
+	Contact       Contact               `json:"contact" firestore:"contact"`
+	APIKeys       []APIKey              `json:"api_keys,omitempty" firestore:"api_keys,omitempty"`
+	APIKeyIDs     []string              `json:"-" firestore:"api_key_ids,omitempty"` // KeyID of each of APIKeys, for FindByAPIKeyID
+	Transcription TranscriptionSettings `json:"transcription" firestore:"transcription"`
+	Tagging       TaggingSettings       `json:"tagging" firestore:"tagging"`
+	Delivery      []Destination         `json:"delivery,omitempty" firestore:"delivery,omitempty" validate:"dive"`
+	Budget        Budget                `json:"budget" firestore:"budget"`
+	Retention     Retention             `json:"retention" firestore:"retention"`
+	Limits        Limits                `json:"limits" firestore:"limits"`
+	Webhook       Webhook               `json:"webhook" firestore:"webhook"`
+	CreatedAt     string                `json:"created_at,omitempty" firestore:"created_at,omitempty"`
+	UpdatedAt     string                `json:"updated_at,omitempty" firestore:"updated_at,omitempty"`
+}
+
+// Contact is how we reach the customer
+type Contact struct {
+	Name  string `json:"name,omitempty" firestore:"name,omitempty"`
+	Email string `json:"email,omitempty" firestore:"email,omitempty" validate:"omitempty,email"`
+	Phone string `json:"phone,omitempty" firestore:"phone,omitempty"`
+}
+
+// APIKey is a credential the customer uses to call the public API. Only a
+// hash of the secret is stored.
+type APIKey struct {
+	KeyID     string `json:"key_id" firestore:"key_id"`
+	Hash      string `json:"-" firestore:"hash"`
+	CreatedAt string `json:"created_at" firestore:"created_at"`
+	RevokedAt string `json:"revoked_at,omitempty" firestore:"revoked_at,omitempty"` // not accepted from this time, which a rotation may set in the future
+}
+
+// TranscriptionSettings are the customer's default speech-to-text settings
+type TranscriptionSettings struct {
+	Provider                string `json:"provider,omitempty" firestore:"provider,omitempty" validate:"omitempty,oneof=google local"` // e.g. "google", see pkg/transcription
+	LanguageCode            string `json:"language_code,omitempty" firestore:"language_code,omitempty"`                               // e.g. "en-US"
+	Model                   string `json:"model,omitempty" firestore:"model,omitempty" validate:"omitempty,oneof=default phone_call video command_and_search"`
+	MaxAlternatives         int    `json:"max_alternatives,omitempty" firestore:"max_alternatives,omitempty" validate:"gte=0,lte=30"`
+	DiarizationSpeakerCount int    `json:"diarization_speaker_count,omitempty" firestore:"diarization_speaker_count,omitempty" validate:"gte=0,lte=10"`
+	Channels                string `json:"channels,omitempty" firestore:"channels,omitempty" validate:"omitempty,oneof=separate mixed"` // how multi-channel calls are transcribed, see request.ChannelsSeparate
+}
+
+// TaggingSettings are the customer's default tagging settings
+type TaggingSettings struct {
+	InfoTypes []string `json:"info_types,omitempty" firestore:"info_types,omitempty"` // DLP InfoType names, e.g. "PHONE_NUMBER"
+}
+
+// Destination is where finished transcripts are delivered
+type Destination struct {
+	Type string `json:"type" firestore:"type" validate:"required,oneof=gcs https email"`
+	URI  string `json:"uri" firestore:"uri" validate:"required"`
+}
+
+// Budget limits what the customer spends, in US cents; zero means no limit
+type Budget struct {
+	PerRequestMaxCents int `json:"per_request_max_cents,omitempty" firestore:"per_request_max_cents,omitempty" validate:"gte=0"`
+	MonthlyMaxCents    int `json:"monthly_max_cents,omitempty" firestore:"monthly_max_cents,omitempty" validate:"gte=0"`
+}
+
+// Retention is how long the customer's data is kept after a Request is
+// accepted, in days; zero means keep forever
+type Retention struct {
+	MediaDays      int `json:"media_days,omitempty" firestore:"media_days,omitempty" validate:"gte=0"`
+	TranscriptDays int `json:"transcript_days,omitempty" firestore:"transcript_days,omitempty" validate:"gte=0"`
+}
+
+// Limits caps how fast and how much the customer submits. A zero rate
+// means the system default; a zero quota means no limit. Quotas reset at
+// midnight UTC, and on the first of the month.
+type Limits struct {
+	RequestsPerMinute    int `json:"requests_per_minute,omitempty" firestore:"requests_per_minute,omitempty" validate:"gte=0"`
+	Burst                int `json:"burst,omitempty" firestore:"burst,omitempty" validate:"gte=0"`
+	KeyRequestsPerMinute int `json:"key_requests_per_minute,omitempty" firestore:"key_requests_per_minute,omitempty" validate:"gte=0"` // per API key, or partner user
+	KeyBurst             int `json:"key_burst,omitempty" firestore:"key_burst,omitempty" validate:"gte=0"`
+	DailyRequests        int `json:"daily_requests,omitempty" firestore:"daily_requests,omitempty" validate:"gte=0"`
+	MonthlyRequests      int `json:"monthly_requests,omitempty" firestore:"monthly_requests,omitempty" validate:"gte=0"`
+	DailyAudioMinutes    int `json:"daily_audio_minutes,omitempty" firestore:"daily_audio_minutes,omitempty" validate:"gte=0"`
+	MonthlyAudioMinutes  int `json:"monthly_audio_minutes,omitempty" firestore:"monthly_audio_minutes,omitempty" validate:"gte=0"`
+}
+
+// Webhook is where the customer is told their requests completed or
+// failed, unless a request has its own callback_url. Every event is signed
+// with Secret, which is generated when the customer is created.
+type Webhook struct {
+	URL    string `json:"url,omitempty" firestore:"url,omitempty" validate:"omitempty,url,startswith=https://"`
+	Secret string `json:"secret,omitempty" firestore:"secret,omitempty"`
+}
+
+// CustomerRepository is implemented by each supported customer database
+type CustomerRepository interface {
+	Create(customer *Customer) error
+	FindByID(customerID int) (*Customer, error)
+	Update(customer *Customer) error
+	Delete(customerID int) error
+	List() ([]*Customer, error)
+	KeyRepository
+}
+
+// KeyRepository finds customers by their API keys, returning
+// ErrAPIKeyNotFound if no customer holds the key
+type KeyRepository interface {
+	FindByAPIKeyID(keyID string) (*Customer, error)
+}
+
+// ReadCustomer decodes and validates a Customer from the HTTP request body
+func (c *Customer) ReadCustomer(w http.ResponseWriter, r *http.Request, p httprouter.Params, validate *validator.Validate) error {
+	return request.ReadJSON(w, r, c, validate)
+}
+
+// CanSubmit returns nil if the customer may submit requests
+func (c *Customer) CanSubmit() error {
+	if c.Disabled {
+		return ErrCustomerDisabled
+	}
+	return nil
+}
+
+// EnsureWebhookSecret generates the customer's webhook signing secret, if
+// they don't have one
+func (c *Customer) EnsureWebhookSecret() error {
+	if c.Webhook.Secret != "" {
+		return nil
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return err
+	}
+	c.Webhook.Secret = WebhookSecretPrefix + secret
+	return nil
+}
+
+// ProcessingDefaults returns the customer's default processing settings, to
+// be merged over the system defaults and under any per-request custom_config
+func (c *Customer) ProcessingDefaults() request.ProcessingConfig {
+	return request.ProcessingConfig{
+		Provider:                c.Transcription.Provider,
+		LanguageCode:            c.Transcription.LanguageCode,
+		Model:                   c.Transcription.Model,
+		MaxAlternatives:         c.Transcription.MaxAlternatives,
+		DiarizationSpeakerCount: c.Transcription.DiarizationSpeakerCount,
+		Channels:                c.Transcription.Channels,
+		InfoTypes:               append([]string(nil), c.Tagging.InfoTypes...),
+	}
+}
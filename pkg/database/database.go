@@ -0,0 +1,72 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/peterpla/lead-expert/pkg/audit"
+	"github.com/peterpla/lead-expert/pkg/config"
+	"github.com/peterpla/lead-expert/pkg/encryption"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// NewRequestRepository returns the Firestore Request database as every
+// service uses it: transcripts and tags encrypted at rest, and every change
+// recorded to sink
+func NewRequestRepository(cfg *config.Config, sink audit.Sink) request.RequestRepository {
+	repo := NewFirestoreRequestRepository(cfg.ProjectID, cfg.DatabaseRequests)
+	repo = encryption.NewEncryptedRequestRepository(repo, NewKeyring(cfg))
+	return audit.NewAuditedRequestRepository(repo, sink)
+}
+
+// NewKeyring returns the Keyring holding customers' data keys, wrapped as
+// selected by the KeyWrapper config key: "kms" (the default) uses the Cloud
+// KMS key KmsDataKey, and "file:<path>" a local key file. Without a usable
+// wrapper the service exits, rather than store plaintext.
+func NewKeyring(cfg *config.Config) *encryption.Keyring {
+	sn := serviceInfo.GetServiceName()
+
+	var wrapper encryption.KeyWrapper
+	switch {
+	case cfg.KeyWrapper == "" || cfg.KeyWrapper == "kms":
+		keyName := fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s",
+			cfg.ProjectID, cfg.KmsLocation, cfg.KmsKeyRing, cfg.KmsDataKey)
+		wrapper = encryption.NewKMSKeyWrapper(keyName)
+	case strings.HasPrefix(cfg.KeyWrapper, "file:"):
+		var err error
+		if wrapper, err = encryption.NewLocalKeyWrapper(strings.TrimPrefix(cfg.KeyWrapper, "file:")); err != nil {
+			log.Fatalf("%s.NewKeyring, NewLocalKeyWrapper error: %v", sn, err)
+		}
+	default:
+		log.Fatalf("%s.NewKeyring, unknown KeyWrapper %q", sn, cfg.KeyWrapper)
+	}
+
+	return encryption.NewKeyring(wrapper, NewFirestoreKeyRepository(cfg.ProjectID, cfg.DatabaseKeys))
+}
+
+// NewAuditSink returns the audit.Sink selected by the AuditSink config key:
+// "firestore" (the default) writes to the DatabaseAudit collection,
+// "stdout" to the service's log, and "file:<path>" to a local file
+func NewAuditSink(cfg *config.Config) audit.Sink {
+	sn := serviceInfo.GetServiceName()
+
+	switch {
+	case cfg.AuditSink == "stdout":
+		return audit.NewStdoutSink()
+	case strings.HasPrefix(cfg.AuditSink, "file:"):
+		sink, err := audit.NewFileSink(strings.TrimPrefix(cfg.AuditSink, "file:"))
+		if err != nil {
+			// keep serving, and keep auditing, rather than crash-loop
+			log.Printf("%s.NewAuditSink, NewFileSink error: %v, auditing to stdout\n", sn, err)
+			return audit.NewStdoutSink()
+		}
+		return sink
+	case cfg.AuditSink == "" || cfg.AuditSink == "firestore":
+		return NewFirestoreAuditSink(cfg.ProjectID, cfg.DatabaseAudit)
+	default:
+		log.Printf("%s.NewAuditSink, unknown AuditSink %q, auditing to stdout\n", sn, cfg.AuditSink)
+		return audit.NewStdoutSink()
+	}
+}
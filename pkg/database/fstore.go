@@ -8,6 +8,7 @@ import (
 
 	"cloud.google.com/go/firestore"
 	"github.com/google/uuid"
+	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
@@ -77,7 +78,7 @@ func (r requestRepository) Create(req *request.Request) error {
 	// 	sn, client, col, colRef, docID, docRef, reqMap)
 	// log.Printf("%s.fstore.Create, calling Set() with client: %+v,\n... col: %+v, colRef: %+v,\n... docID: %+v, docRef: %+v,\n... req: %+v\n",
 	// 	sn, client, col, colRef, docID, docRef, *req)
-	req.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	req.CreatedAt = time.Now().UTC().Format(request.TimeLayout)
 
 	// _, err = docRef.Set(ctx, reqMap)
 	_, err = docRef.Set(ctx, *req)
@@ -152,9 +153,9 @@ func (r requestRepository) FindByID(reqID uuid.UUID) (*request.Request, error) {
 	}
 
 	// extract data into the Request we'll return
-	var foundRequest request.Request
-	if err := docsnap.DataTo(&foundRequest); err != nil {
-		log.Printf("%s.fstore.FindByID, DataTo returned err: %+v", sn, err)
+	foundRequest, err := decodeRequest(docsnap)
+	if err != nil {
+		log.Printf("%s.fstore.FindByID, decodeRequest returned err: %+v", sn, err)
 		return &emptyRequest, ErrFindError
 	}
 
@@ -163,7 +164,7 @@ func (r requestRepository) FindByID(reqID uuid.UUID) (*request.Request, error) {
 
 	// log.Printf("%s.fstore.FindByID, foundRequest: %+v\n", sn, foundRequest)
 
-	return &foundRequest, nil
+	return foundRequest, nil
 }
 
 // Update writes an updated Request to the database
@@ -187,7 +188,7 @@ func (r requestRepository) Update(req *request.Request) error {
 	}
 
 	// req.UpdatedAt = time.Now().UTC().Format(time.RFC3339Nano)
-	reqMap["updated_at"] = time.Now().UTC().Format(time.RFC3339Nano)
+	reqMap["updated_at"] = time.Now().UTC().Format(request.TimeLayout)
 	// log.Printf("reqMap: %+v\n", reqMap)
 
 	// prepare to talk to Firestore
@@ -239,8 +240,145 @@ func (r requestRepository) Update(req *request.Request) error {
 	return nil
 }
 
+// DeleteFields removes the named fields from the stored Request. Update
+// uses "set with merge", which leaves fields omitted from the Request intact.
+func (r requestRepository) DeleteFields(reqID uuid.UUID, fields ...string) error {
+	sn := serviceInfo.GetServiceName()
+
+	if reqID == uuid.Nil {
+		return ErrZeroUUIDError
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.DeleteFields, NewClient returned err: %v\n", sn, err)
+		return ErrUpdateError
+	}
+	defer client.Close()
+
+	updates := []firestore.Update{
+		{Path: "updated_at", Value: time.Now().UTC().Format(request.TimeLayout)},
+	}
+	for _, field := range fields {
+		updates = append(updates, firestore.Update{Path: field, Value: firestore.Delete})
+	}
+
+	if _, err = client.Collection(r.Collection).Doc(reqID.String()).Update(ctx, updates); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return ErrNotFoundError
+		}
+		log.Printf("%s.fstore.DeleteFields, Update returned err: %v\n", sn, err)
+		return ErrUpdateError
+	}
+
+	return nil
+}
+
+// List returns the page of Requests selected by opts
+func (r requestRepository) List(opts request.ListOptions) (*request.ListResult, error) {
+	sn := serviceInfo.GetServiceName()
+
+	var emptyResult = request.ListResult{}
+
+	if err := opts.Normalize(); err != nil {
+		log.Printf("%s.fstore.List, Normalize returned err: %v\n", sn, err)
+		return &emptyResult, err
+	}
+
+	// prepare to talk to Firestore
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.List, NewClient returned err: %v\n", sn, err)
+		return &emptyResult, ErrListError
+	}
+	defer client.Close()
+
+	// equality filters first, then the (optional) range filter on accepted_at
+	q := client.Collection(r.Collection).Query
+	if opts.CustomerID != 0 {
+		q = q.Where("customer_id", "==", opts.CustomerID)
+	}
+	if opts.Status != "" {
+		q = q.Where("status", "==", opts.Status)
+	}
+	if opts.Stage != "" {
+		q = q.Where("stage", "==", opts.Stage)
+	}
+	if !opts.AcceptedAfter.IsZero() {
+		q = q.Where("accepted_at", ">=", request.FormatListTime(opts.AcceptedAfter))
+	}
+	if !opts.AcceptedBefore.IsZero() {
+		q = q.Where("accepted_at", "<", request.FormatListTime(opts.AcceptedBefore))
+	}
+
+	// sort by the requested field, breaking ties by document ID (= RequestID)
+	// so the cursor identifies a unique position
+	dir := firestore.Asc
+	if opts.Descending {
+		dir = firestore.Desc
+	}
+	q = q.OrderBy(opts.OrderBy, dir).OrderBy(firestore.DocumentID, dir)
+
+	if opts.Cursor != "" {
+		value, id, _ := request.DecodeCursor(opts.Cursor) // validated by Normalize
+		q = q.StartAfter(value, id)
+	}
+
+	// ask for one extra document to learn whether there's another page
+	q = q.Limit(opts.Limit + 1)
+
+	result := request.ListResult{}
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("%s.fstore.List, iterator returned err: %+v\n", sn, err)
+			return &emptyResult, ErrListError
+		}
+
+		foundRequest, err := decodeRequest(docsnap)
+		if err != nil {
+			log.Printf("%s.fstore.List, decodeRequest(%q) returned err: %+v\n", sn, docsnap.Ref.ID, err)
+			return &emptyResult, ErrListError
+		}
+		if foundRequest.RequestID, err = uuid.Parse(docsnap.Ref.ID); err != nil {
+			log.Printf("%s.fstore.List, docID %q is not a UUID, skipping\n", sn, docsnap.Ref.ID)
+			continue
+		}
+		result.Requests = append(result.Requests, foundRequest)
+	}
+
+	if len(result.Requests) > opts.Limit {
+		result.Requests = result.Requests[:opts.Limit]
+		last := result.Requests[opts.Limit-1]
+		result.NextCursor = request.EncodeCursor(opts.OrderValue(last), last.RequestID.String())
+	}
+
+	return &result, nil
+}
+
+// decodeRequest upgrades a stored Request document to the current schema
+// version, then decodes it
+func decodeRequest(docsnap *firestore.DocumentSnapshot) (*request.Request, error) {
+	doc := docsnap.Data()
+	if _, err := request.MigrateDocument(doc); err != nil {
+		return &request.Request{}, err
+	}
+	return request.FromDocument(doc)
+}
+
 var ErrCreateError = fmt.Errorf("fstore Create error")
 var ErrZeroUUIDError = fmt.Errorf("fstore zero UUID error")
 var ErrUpdateError = fmt.Errorf("fstore Update error")
 var ErrNotFoundError = fmt.Errorf("fstore Not Found error")
 var ErrFindError = fmt.Errorf("fstore Find error")
+var ErrListError = fmt.Errorf("fstore List error")
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peterpla/lead-expert/pkg/audit"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// auditHeadDocID is the document holding the chain's last sequence number
+// and hash; record document IDs are their zero-padded sequence numbers
+const auditHeadDocID = "head"
+
+// auditMaxAttempts is how many times a Write's transaction is tried: every
+// service's writes contend for the head document, so more than Firestore's
+// default
+const auditMaxAttempts = 20
+
+// auditSink implements the audit.Sink interface, one chain per collection
+// shared by every service
+type auditSink struct {
+	ProjectID  string
+	Collection string
+}
+
+func NewFirestoreAuditSink(projID string, coll string) audit.Sink {
+	return auditSink{
+		projID,
+		coll,
+	}
+}
+
+// Write links rec to the chain and stores it, in one transaction so
+// concurrent writers can't fork the chain. An error means rec wasn't
+// stored; callers return it, rather than lose the record.
+func (s auditSink) Write(rec audit.Record) error {
+	sn := serviceInfo.GetServiceName()
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, s.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.audit.Write, NewClient returned err: %v\n", sn, err)
+		return ErrCreateError
+	}
+	defer client.Close()
+
+	coll := client.Collection(s.Collection)
+	headRef := coll.Doc(auditHeadDocID)
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		chain := audit.Chain{ID: s.Collection}
+		headSnap, err := tx.Get(headRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := headSnap.DataTo(&chain); err != nil {
+				return err
+			}
+		}
+
+		linked := rec
+		chain.Link(&linked)
+		if err := tx.Create(coll.Doc(auditRecordDocID(linked.Seq)), linked); err != nil {
+			return err
+		}
+		return tx.Set(headRef, chain)
+	}, firestore.MaxAttempts(auditMaxAttempts))
+	if err != nil {
+		log.Printf("%s.fstore.audit.Write, transaction returned err: %v\n", sn, err)
+		return ErrCreateError
+	}
+
+	return nil
+}
+
+// ReadAuditRecords returns every record in the collection in sequence
+// order, and the chain's head, for verification
+func ReadAuditRecords(projID string, coll string) ([]audit.Record, audit.Chain, error) {
+	sn := serviceInfo.GetServiceName()
+
+	head := audit.Chain{ID: coll}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, projID)
+	if err != nil {
+		log.Printf("%s.fstore.ReadAuditRecords, NewClient returned err: %v\n", sn, err)
+		return nil, head, ErrFindError
+	}
+	defer client.Close()
+
+	records := []audit.Record{}
+	iter := client.Collection(coll).OrderBy(firestore.DocumentID, firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("%s.fstore.ReadAuditRecords, iterator returned err: %+v\n", sn, err)
+			return nil, head, ErrFindError
+		}
+
+		if docsnap.Ref.ID == auditHeadDocID {
+			if err := docsnap.DataTo(&head); err != nil {
+				return nil, head, ErrFindError
+			}
+			continue
+		}
+		var rec audit.Record
+		if err := docsnap.DataTo(&rec); err != nil {
+			log.Printf("%s.fstore.ReadAuditRecords, DataTo(%q) returned err: %+v\n", sn, docsnap.Ref.ID, err)
+			return nil, head, ErrFindError
+		}
+		records = append(records, rec)
+	}
+
+	return records, head, nil
+}
+
+func auditRecordDocID(seq int64) string {
+	return fmt.Sprintf("%020d", seq)
+}
@@ -0,0 +1,255 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peterpla/lead-expert/pkg/customer"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// customerRepository implements the customer.CustomerRepository interface
+type customerRepository struct {
+	ProjectID  string
+	Collection string
+}
+
+func NewFirestoreCustomerRepository(projID string, coll string) customer.CustomerRepository {
+	return customerRepository{
+		projID,
+		coll,
+	}
+}
+
+// Create writes a new Customer to the database, failing if the CustomerID is already in use
+func (r customerRepository) Create(cust *customer.Customer) error {
+	sn := serviceInfo.GetServiceName()
+
+	if cust.CustomerID <= 0 {
+		log.Printf("%s.fstore.Customer.Create, invalid CustomerID %d\n", sn, cust.CustomerID)
+		return ErrZeroCustomerIDError
+	}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Customer.Create, NewClient returned err: %v\n", sn, err)
+		return ErrCreateError
+	}
+	defer client.Close()
+
+	cust.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+
+	// CustomerID = document ID
+	docRef := client.Collection(r.Collection).Doc(customerDocID(cust.CustomerID))
+	if _, err = docRef.Create(ctx, *cust); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			log.Printf("%s.fstore.Customer.Create, CustomerID %d already exists\n", sn, cust.CustomerID)
+			return ErrAlreadyExistsError
+		}
+		log.Printf("%s.fstore.Customer.Create, Create returned err %+v\n", sn, err)
+		return ErrCreateError
+	}
+
+	return nil
+}
+
+// FindByID reads the Customer with the given CustomerID
+func (r customerRepository) FindByID(customerID int) (*customer.Customer, error) {
+	sn := serviceInfo.GetServiceName()
+
+	var emptyCustomer = customer.Customer{}
+
+	if customerID <= 0 {
+		return &emptyCustomer, ErrZeroCustomerIDError
+	}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Customer.FindByID, NewClient returned err: %v\n", sn, err)
+		return &emptyCustomer, ErrFindError
+	}
+	defer client.Close()
+
+	docsnap, err := client.Collection(r.Collection).Doc(customerDocID(customerID)).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			log.Printf("%s.fstore.Customer.FindByID, CustomerID %d not found\n", sn, customerID)
+			return &emptyCustomer, ErrNotFoundError
+		}
+		log.Printf("%s.fstore.Customer.FindByID, Get returned err: %+v\n", sn, err)
+		return &emptyCustomer, ErrFindError
+	}
+
+	var foundCustomer customer.Customer
+	if err := docsnap.DataTo(&foundCustomer); err != nil {
+		log.Printf("%s.fstore.Customer.FindByID, DataTo returned err: %+v", sn, err)
+		return &emptyCustomer, ErrFindError
+	}
+	foundCustomer.CustomerID = customerID
+
+	return &foundCustomer, nil
+}
+
+// Update replaces an existing Customer's profile
+func (r customerRepository) Update(cust *customer.Customer) error {
+	sn := serviceInfo.GetServiceName()
+
+	if cust.CustomerID <= 0 {
+		return ErrZeroCustomerIDError
+	}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Customer.Update, NewClient returned err: %v\n", sn, err)
+		return ErrUpdateError
+	}
+	defer client.Close()
+
+	cust.UpdatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+
+	// only update a Customer that exists; Set alone would create it
+	docRef := client.Collection(r.Collection).Doc(customerDocID(cust.CustomerID))
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(docRef); err != nil {
+			return err
+		}
+		return tx.Set(docRef, *cust)
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			log.Printf("%s.fstore.Customer.Update, CustomerID %d not found\n", sn, cust.CustomerID)
+			return ErrNotFoundError
+		}
+		log.Printf("%s.fstore.Customer.Update, transaction returned err: %v\n", sn, err)
+		return ErrUpdateError
+	}
+
+	return nil
+}
+
+// Delete removes the Customer with the given CustomerID
+func (r customerRepository) Delete(customerID int) error {
+	sn := serviceInfo.GetServiceName()
+
+	if customerID <= 0 {
+		return ErrZeroCustomerIDError
+	}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Customer.Delete, NewClient returned err: %v\n", sn, err)
+		return ErrDeleteError
+	}
+	defer client.Close()
+
+	docRef := client.Collection(r.Collection).Doc(customerDocID(customerID))
+	if _, err = docRef.Delete(ctx, firestore.Exists); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return ErrNotFoundError
+		}
+		log.Printf("%s.fstore.Customer.Delete, Delete returned err: %v\n", sn, err)
+		return ErrDeleteError
+	}
+
+	return nil
+}
+
+// List returns all Customers, ordered by CustomerID
+func (r customerRepository) List() ([]*customer.Customer, error) {
+	sn := serviceInfo.GetServiceName()
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Customer.List, NewClient returned err: %v\n", sn, err)
+		return nil, ErrListError
+	}
+	defer client.Close()
+
+	found := []*customer.Customer{}
+	iter := client.Collection(r.Collection).Documents(ctx)
+	defer iter.Stop()
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("%s.fstore.Customer.List, iterator returned err: %+v\n", sn, err)
+			return nil, ErrListError
+		}
+
+		var cust customer.Customer
+		if err := docsnap.DataTo(&cust); err != nil {
+			log.Printf("%s.fstore.Customer.List, DataTo(%q) returned err: %+v\n", sn, docsnap.Ref.ID, err)
+			return nil, ErrListError
+		}
+		if cust.CustomerID, err = strconv.Atoi(docsnap.Ref.ID); err != nil {
+			log.Printf("%s.fstore.Customer.List, docID %q is not a CustomerID, skipping\n", sn, docsnap.Ref.ID)
+			continue
+		}
+		found = append(found, &cust)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].CustomerID < found[j].CustomerID })
+
+	return found, nil
+}
+
+// FindByAPIKeyID reads the Customer holding the API key keyID
+func (r customerRepository) FindByAPIKeyID(keyID string) (*customer.Customer, error) {
+	sn := serviceInfo.GetServiceName()
+
+	var emptyCustomer = customer.Customer{}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Customer.FindByAPIKeyID, NewClient returned err: %v\n", sn, err)
+		return &emptyCustomer, ErrFindError
+	}
+	defer client.Close()
+
+	iter := client.Collection(r.Collection).Where("api_key_ids", "array-contains", keyID).Limit(1).Documents(ctx)
+	defer iter.Stop()
+	docsnap, err := iter.Next()
+	if err == iterator.Done {
+		return &emptyCustomer, customer.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		log.Printf("%s.fstore.Customer.FindByAPIKeyID, iterator returned err: %+v\n", sn, err)
+		return &emptyCustomer, ErrFindError
+	}
+
+	var foundCustomer customer.Customer
+	if err := docsnap.DataTo(&foundCustomer); err != nil {
+		log.Printf("%s.fstore.Customer.FindByAPIKeyID, DataTo returned err: %+v", sn, err)
+		return &emptyCustomer, ErrFindError
+	}
+	if foundCustomer.CustomerID, err = strconv.Atoi(docsnap.Ref.ID); err != nil {
+		log.Printf("%s.fstore.Customer.FindByAPIKeyID, docID %q is not a CustomerID\n", sn, docsnap.Ref.ID)
+		return &emptyCustomer, ErrFindError
+	}
+
+	return &foundCustomer, nil
+}
+
+func customerDocID(customerID int) string {
+	return strconv.Itoa(customerID)
+}
+
+var ErrAlreadyExistsError = fmt.Errorf("fstore Already Exists error")
+var ErrDeleteError = fmt.Errorf("fstore Delete error")
+var ErrZeroCustomerIDError = fmt.Errorf("fstore zero CustomerID error")
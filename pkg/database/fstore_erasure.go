@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peterpla/lead-expert/pkg/erasure"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// certificateRepository implements the erasure.CertificateRepository interface
+type certificateRepository struct {
+	ProjectID  string
+	Collection string
+}
+
+func NewFirestoreCertificateRepository(projID string, coll string) erasure.CertificateRepository {
+	return certificateRepository{
+		projID,
+		coll,
+	}
+}
+
+// Create writes a new Certificate; certificates are never updated
+func (r certificateRepository) Create(cert *erasure.Certificate) error {
+	sn := serviceInfo.GetServiceName()
+
+	if cert.CertificateID == "" {
+		return ErrZeroUUIDError
+	}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Certificate.Create, NewClient returned err: %v\n", sn, err)
+		return ErrCreateError
+	}
+	defer client.Close()
+
+	// CertificateID = document ID
+	if _, err = client.Collection(r.Collection).Doc(cert.CertificateID).Create(ctx, *cert); err != nil {
+		log.Printf("%s.fstore.Certificate.Create, Create returned err %+v\n", sn, err)
+		return ErrCreateError
+	}
+
+	return nil
+}
+
+// FindByID reads the Certificate with the given CertificateID
+func (r certificateRepository) FindByID(certificateID string) (*erasure.Certificate, error) {
+	sn := serviceInfo.GetServiceName()
+
+	var emptyCertificate = erasure.Certificate{}
+
+	if certificateID == "" {
+		return &emptyCertificate, ErrZeroUUIDError
+	}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Certificate.FindByID, NewClient returned err: %v\n", sn, err)
+		return &emptyCertificate, ErrFindError
+	}
+	defer client.Close()
+
+	docsnap, err := client.Collection(r.Collection).Doc(certificateID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return &emptyCertificate, ErrNotFoundError
+		}
+		log.Printf("%s.fstore.Certificate.FindByID, Get returned err: %+v\n", sn, err)
+		return &emptyCertificate, ErrFindError
+	}
+
+	var found erasure.Certificate
+	if err := docsnap.DataTo(&found); err != nil {
+		log.Printf("%s.fstore.Certificate.FindByID, DataTo returned err: %+v", sn, err)
+		return &emptyCertificate, ErrFindError
+	}
+	found.CertificateID = certificateID
+
+	return &found, nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peterpla/lead-expert/pkg/encryption"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// keyRepository implements the encryption.KeyRepository interface
+type keyRepository struct {
+	ProjectID  string
+	Collection string
+}
+
+func NewFirestoreKeyRepository(projID string, coll string) encryption.KeyRepository {
+	return keyRepository{
+		projID,
+		coll,
+	}
+}
+
+// Create writes a customer's data key; keys are never updated
+func (r keyRepository) Create(key *encryption.DataKey) error {
+	sn := serviceInfo.GetServiceName()
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Key.Create, NewClient returned err: %v\n", sn, err)
+		return ErrCreateError
+	}
+	defer client.Close()
+
+	// CustomerID = document ID
+	docID := strconv.Itoa(key.CustomerID)
+	if _, err = client.Collection(r.Collection).Doc(docID).Create(ctx, *key); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return encryption.ErrKeyExists
+		}
+		log.Printf("%s.fstore.Key.Create, Create returned err %+v\n", sn, err)
+		return ErrCreateError
+	}
+
+	return nil
+}
+
+// FindByCustomerID reads the customer's data key
+func (r keyRepository) FindByCustomerID(customerID int) (*encryption.DataKey, error) {
+	sn := serviceInfo.GetServiceName()
+
+	var emptyKey = encryption.DataKey{}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Key.FindByCustomerID, NewClient returned err: %v\n", sn, err)
+		return &emptyKey, ErrFindError
+	}
+	defer client.Close()
+
+	docsnap, err := client.Collection(r.Collection).Doc(strconv.Itoa(customerID)).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return &emptyKey, encryption.ErrKeyNotFound
+		}
+		log.Printf("%s.fstore.Key.FindByCustomerID, Get returned err: %+v\n", sn, err)
+		return &emptyKey, ErrFindError
+	}
+
+	var found encryption.DataKey
+	if err := docsnap.DataTo(&found); err != nil {
+		log.Printf("%s.fstore.Key.FindByCustomerID, DataTo returned err: %+v", sn, err)
+		return &emptyKey, ErrFindError
+	}
+
+	return &found, nil
+}
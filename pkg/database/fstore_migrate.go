@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// MigrationSummary reports what MigrateRequests did
+type MigrationSummary struct {
+	Scanned  int // documents read
+	Migrated int // documents upgraded (or that would be, if dry run)
+	Failed   int // documents that could not be upgraded
+}
+
+// MigrateRequests rewrites every Request document in the collection that is
+// older than request.RequestVersion. With dryRun, nothing is written.
+func MigrateRequests(projID string, coll string, dryRun bool) (MigrationSummary, error) {
+	sn := serviceInfo.GetServiceName()
+
+	summary := MigrationSummary{}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, projID)
+	if err != nil {
+		log.Printf("%s.fstore.MigrateRequests, NewClient returned err: %v\n", sn, err)
+		return summary, ErrMigrateError
+	}
+	defer client.Close()
+
+	iter := client.Collection(coll).Documents(ctx)
+	defer iter.Stop()
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("%s.fstore.MigrateRequests, iterator returned err: %+v\n", sn, err)
+			return summary, ErrMigrateError
+		}
+		summary.Scanned++
+
+		if request.DocumentVersion(docsnap.Data()) >= request.RequestVersion {
+			continue
+		}
+
+		if dryRun {
+			doc := docsnap.Data()
+			if _, err := request.MigrateDocument(doc); err != nil {
+				log.Printf("%s.fstore.MigrateRequests, docID %q, MigrateDocument returned err: %v\n", sn, docsnap.Ref.ID, err)
+				summary.Failed++
+				continue
+			}
+			log.Printf("%s.fstore.MigrateRequests, dry run, would migrate docID %q\n", sn, docsnap.Ref.ID)
+			summary.Migrated++
+			continue
+		}
+
+		// re-read inside a transaction so a concurrent pipeline update isn't overwritten
+		err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			current, err := tx.Get(docsnap.Ref)
+			if err != nil {
+				return err
+			}
+			doc := current.Data()
+			migrated, err := request.MigrateDocument(doc)
+			if err != nil || !migrated {
+				return err
+			}
+			return tx.Set(docsnap.Ref, doc)
+		})
+		if err != nil {
+			log.Printf("%s.fstore.MigrateRequests, docID %q, migration returned err: %v\n", sn, docsnap.Ref.ID, err)
+			summary.Failed++
+			continue
+		}
+		summary.Migrated++
+	}
+
+	return summary, nil
+}
+
+var ErrMigrateError = fmt.Errorf("fstore Migrate error")
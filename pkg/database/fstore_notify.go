@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peterpla/lead-expert/pkg/notify"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// requestNotifier implements the notify.Notifier interface with Firestore
+// snapshot listeners, so it sees every service's writes to a Request
+type requestNotifier struct {
+	ProjectID  string
+	Collection string
+}
+
+func NewFirestoreNotifier(projID string, coll string) notify.Notifier {
+	return requestNotifier{
+		projID,
+		coll,
+	}
+}
+
+// Subscribe listens to the Request's document until ctx is done. The
+// first change is the document as it is now.
+func (n requestNotifier) Subscribe(ctx context.Context, reqID uuid.UUID) (<-chan notify.Change, error) {
+	sn := serviceInfo.GetServiceName()
+
+	client, err := firestore.NewClient(ctx, n.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Notifier.Subscribe, NewClient returned err: %v\n", sn, err)
+		return nil, ErrFindError
+	}
+
+	ch := make(chan notify.Change, 1)
+	go func() {
+		defer client.Close()
+		defer close(ch)
+
+		iter := client.Collection(n.Collection).Doc(reqID.String()).Snapshots(ctx)
+		defer iter.Stop()
+		for {
+			docsnap, err := iter.Next()
+			if err != nil {
+				if ctx.Err() == nil && status.Code(err) != codes.Canceled {
+					log.Printf("%s.fstore.Notifier.Subscribe, Next returned err: %+v\n", sn, err)
+				}
+				return
+			}
+			if !docsnap.Exists() {
+				continue
+			}
+
+			change := notify.Change{RequestID: reqID}
+			change.Status, _ = dataString(docsnap, "status")
+			change.Stage, _ = dataString(docsnap, "stage")
+			change.UpdatedAt, _ = dataString(docsnap, "updated_at")
+			notify.Latest(ch, change)
+		}
+	}()
+
+	return ch, nil
+}
+
+func dataString(docsnap *firestore.DocumentSnapshot, field string) (string, error) {
+	v, err := docsnap.DataAt(field)
+	if err != nil {
+		return "", err
+	}
+	s, _ := v.(string)
+	return s, nil
+}
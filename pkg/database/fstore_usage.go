@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peterpla/lead-expert/pkg/ratelimit"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// usageStore implements the ratelimit.UsageStore interface, one document
+// per customer and period
+type usageStore struct {
+	ProjectID  string
+	Collection string
+}
+
+func NewFirestoreUsageStore(projID string, coll string) ratelimit.UsageStore {
+	return usageStore{
+		projID,
+		coll,
+	}
+}
+
+// Add increments the customer's usage for period; concurrent Adds from
+// every service instance are safe
+func (s usageStore) Add(customerID int, period string, requests int, audioSeconds int) error {
+	sn := serviceInfo.GetServiceName()
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, s.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Usage.Add, NewClient returned err: %v\n", sn, err)
+		return ErrUpdateError
+	}
+	defer client.Close()
+
+	doc := client.Collection(s.Collection).Doc(usageDocID(customerID, period))
+	if _, err = doc.Set(ctx, map[string]interface{}{
+		"customer_id":   customerID,
+		"period":        period,
+		"requests":      firestore.Increment(requests),
+		"audio_seconds": firestore.Increment(audioSeconds),
+	}, firestore.MergeAll); err != nil {
+		log.Printf("%s.fstore.Usage.Add, Set returned err: %+v\n", sn, err)
+		return ErrUpdateError
+	}
+
+	return nil
+}
+
+// Get reads the customer's usage for period
+func (s usageStore) Get(customerID int, period string) (*ratelimit.Usage, error) {
+	sn := serviceInfo.GetServiceName()
+
+	var emptyUsage = ratelimit.Usage{CustomerID: customerID, Period: period}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, s.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Usage.Get, NewClient returned err: %v\n", sn, err)
+		return &emptyUsage, ErrFindError
+	}
+	defer client.Close()
+
+	docsnap, err := client.Collection(s.Collection).Doc(usageDocID(customerID, period)).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return &emptyUsage, nil
+		}
+		log.Printf("%s.fstore.Usage.Get, Get returned err: %+v\n", sn, err)
+		return &emptyUsage, ErrFindError
+	}
+
+	var found ratelimit.Usage
+	if err := docsnap.DataTo(&found); err != nil {
+		log.Printf("%s.fstore.Usage.Get, DataTo returned err: %+v", sn, err)
+		return &emptyUsage, ErrFindError
+	}
+
+	return &found, nil
+}
+
+// usageDocID is e.g. "1234567-day:2020-03-01"
+func usageDocID(customerID int, period string) string {
+	return fmt.Sprintf("%d-%s", customerID, period)
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+	"github.com/peterpla/lead-expert/pkg/webhook"
+)
+
+// deliveryRepository implements the webhook.DeliveryRepository interface
+type deliveryRepository struct {
+	ProjectID  string
+	Collection string
+}
+
+func NewFirestoreDeliveryRepository(projID string, coll string) webhook.DeliveryRepository {
+	return deliveryRepository{
+		projID,
+		coll,
+	}
+}
+
+// Create writes a new Delivery
+func (r deliveryRepository) Create(d *webhook.Delivery) error {
+	sn := serviceInfo.GetServiceName()
+
+	if d.DeliveryID == "" {
+		return ErrZeroUUIDError
+	}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Delivery.Create, NewClient returned err: %v\n", sn, err)
+		return ErrCreateError
+	}
+	defer client.Close()
+
+	// DeliveryID = document ID
+	if _, err = client.Collection(r.Collection).Doc(d.DeliveryID).Create(ctx, *d); err != nil {
+		log.Printf("%s.fstore.Delivery.Create, Create returned err %+v\n", sn, err)
+		return ErrCreateError
+	}
+
+	return nil
+}
+
+// Update overwrites the Delivery with its latest attempts and status
+func (r deliveryRepository) Update(d *webhook.Delivery) error {
+	sn := serviceInfo.GetServiceName()
+
+	if d.DeliveryID == "" {
+		return ErrZeroUUIDError
+	}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Delivery.Update, NewClient returned err: %v\n", sn, err)
+		return ErrUpdateError
+	}
+	defer client.Close()
+
+	if _, err = client.Collection(r.Collection).Doc(d.DeliveryID).Set(ctx, *d); err != nil {
+		log.Printf("%s.fstore.Delivery.Update, Set returned err %+v\n", sn, err)
+		return ErrUpdateError
+	}
+
+	return nil
+}
+
+// FindByID reads the Delivery with the given DeliveryID
+func (r deliveryRepository) FindByID(deliveryID string) (*webhook.Delivery, error) {
+	sn := serviceInfo.GetServiceName()
+
+	var emptyDelivery = webhook.Delivery{}
+
+	if deliveryID == "" {
+		return &emptyDelivery, ErrZeroUUIDError
+	}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Delivery.FindByID, NewClient returned err: %v\n", sn, err)
+		return &emptyDelivery, ErrFindError
+	}
+	defer client.Close()
+
+	docsnap, err := client.Collection(r.Collection).Doc(deliveryID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return &emptyDelivery, ErrNotFoundError
+		}
+		log.Printf("%s.fstore.Delivery.FindByID, Get returned err: %+v\n", sn, err)
+		return &emptyDelivery, ErrFindError
+	}
+
+	var found webhook.Delivery
+	if err := docsnap.DataTo(&found); err != nil {
+		log.Printf("%s.fstore.Delivery.FindByID, DataTo returned err: %+v", sn, err)
+		return &emptyDelivery, ErrFindError
+	}
+	found.DeliveryID = deliveryID
+
+	return &found, nil
+}
+
+// List returns the Deliveries opts selects: newest first, or, with DueBy,
+// the longest overdue first
+func (r deliveryRepository) List(opts webhook.ListOptions) ([]*webhook.Delivery, error) {
+	sn := serviceInfo.GetServiceName()
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, r.ProjectID)
+	if err != nil {
+		log.Printf("%s.fstore.Delivery.List, NewClient returned err: %v\n", sn, err)
+		return nil, ErrListError
+	}
+	defer client.Close()
+
+	q := client.Collection(r.Collection).Query
+	if opts.CustomerID != 0 {
+		q = q.Where("customer_id", "==", opts.CustomerID)
+	}
+	if opts.RequestID != "" {
+		q = q.Where("request_id", "==", opts.RequestID)
+	}
+	if opts.DueBy != "" {
+		q = q.Where("status", "==", webhook.Pending).
+			Where("next_attempt_at", "<=", opts.DueBy).
+			OrderBy("next_attempt_at", firestore.Asc)
+	} else {
+		if opts.Status != "" {
+			q = q.Where("status", "==", opts.Status)
+		}
+		q = q.OrderBy("created_at", firestore.Desc)
+	}
+	if opts.Limit > 0 {
+		q = q.Limit(opts.Limit)
+	}
+
+	found := []*webhook.Delivery{}
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+	for {
+		docsnap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("%s.fstore.Delivery.List, iterator returned err: %+v\n", sn, err)
+			return nil, ErrListError
+		}
+
+		var d webhook.Delivery
+		if err := docsnap.DataTo(&d); err != nil {
+			log.Printf("%s.fstore.Delivery.List, DataTo(%q) returned err: %+v\n", sn, docsnap.Ref.ID, err)
+			return nil, ErrListError
+		}
+		d.DeliveryID = docsnap.Ref.ID
+		found = append(found, &d)
+	}
+
+	return found, nil
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// memoryRequestRepository implements the request.RequestRepository interface
+// in memory, for local execution and tests. Contents are lost on exit.
+type memoryRequestRepository struct {
+	mu       sync.RWMutex
+	requests map[uuid.UUID]request.Request
+}
+
+func NewMemoryRequestRepository() request.RequestRepository {
+	return &memoryRequestRepository{
+		requests: make(map[uuid.UUID]request.Request),
+	}
+}
+
+// Create stores a copy of the Request
+func (r *memoryRequestRepository) Create(req *request.Request) error {
+	sn := serviceInfo.GetServiceName()
+
+	if req.RequestID == uuid.Nil {
+		log.Printf("%s.memory.Create, zero UUID not allowed\n", sn)
+		return ErrZeroUUIDError
+	}
+
+	req.CreatedAt = time.Now().UTC().Format(request.TimeLayout)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests[req.RequestID] = copyRequest(req)
+
+	return nil
+}
+
+// FindByID returns a copy of the stored Request
+func (r *memoryRequestRepository) FindByID(reqID uuid.UUID) (*request.Request, error) {
+	var emptyRequest = request.Request{}
+
+	if reqID == uuid.Nil {
+		return &emptyRequest, ErrZeroUUIDError
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	found, ok := r.requests[reqID]
+	if !ok {
+		return &emptyRequest, ErrNotFoundError
+	}
+
+	foundRequest := copyRequest(&found)
+	return &foundRequest, nil
+}
+
+// Update replaces the stored Request with a copy of req
+func (r *memoryRequestRepository) Update(req *request.Request) error {
+	if req.RequestID == uuid.Nil {
+		return ErrZeroUUIDError
+	}
+
+	updated := copyRequest(req)
+	updated.UpdatedAt = time.Now().UTC().Format(request.TimeLayout)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests[req.RequestID] = updated
+
+	return nil
+}
+
+// DeleteFields removes the named fields from the stored Request
+func (r *memoryRequestRepository) DeleteFields(reqID uuid.UUID, fields ...string) error {
+	if reqID == uuid.Nil {
+		return ErrZeroUUIDError
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	found, ok := r.requests[reqID]
+	if !ok {
+		return ErrNotFoundError
+	}
+	if err := found.ClearFields(fields...); err != nil {
+		return err
+	}
+	found.UpdatedAt = time.Now().UTC().Format(request.TimeLayout)
+	r.requests[reqID] = found
+
+	return nil
+}
+
+// List returns the page of Requests selected by opts, with the same
+// ordering and cursor semantics as the Firestore implementation
+func (r *memoryRequestRepository) List(opts request.ListOptions) (*request.ListResult, error) {
+	var emptyResult = request.ListResult{}
+
+	if err := opts.Normalize(); err != nil {
+		return &emptyResult, err
+	}
+
+	r.mu.RLock()
+	matched := []*request.Request{}
+	for _, stored := range r.requests {
+		if opts.Matches(&stored) {
+			found := copyRequest(&stored)
+			matched = append(matched, &found)
+		}
+	}
+	r.mu.RUnlock()
+
+	less := func(a, b *request.Request) bool {
+		av, bv := opts.OrderValue(a), opts.OrderValue(b)
+		if av != bv {
+			return av < bv
+		}
+		return a.RequestID.String() < b.RequestID.String()
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if opts.Descending {
+			return less(matched[j], matched[i])
+		}
+		return less(matched[i], matched[j])
+	})
+
+	// skip everything up to and including the cursor position
+	if opts.Cursor != "" {
+		value, id, _ := request.DecodeCursor(opts.Cursor) // validated by Normalize
+		start := len(matched)
+		for i, req := range matched {
+			v, reqID := opts.OrderValue(req), req.RequestID.String()
+			after := v > value || (v == value && reqID > id)
+			if opts.Descending {
+				after = v < value || (v == value && reqID < id)
+			}
+			if after {
+				start = i
+				break
+			}
+		}
+		matched = matched[start:]
+	}
+
+	result := request.ListResult{Requests: matched}
+	if len(matched) > opts.Limit {
+		result.Requests = matched[:opts.Limit]
+		last := result.Requests[opts.Limit-1]
+		result.NextCursor = request.EncodeCursor(opts.OrderValue(last), last.RequestID.String())
+	}
+
+	return &result, nil
+}
+
+// copyRequest returns a copy of req that shares no maps with it
+func copyRequest(req *request.Request) request.Request {
+	c := *req
+
+	if req.Timestamps != nil {
+		c.Timestamps = make(map[string]string, len(req.Timestamps))
+		for k, v := range req.Timestamps {
+			c.Timestamps[k] = v
+		}
+	}
+	if req.Structured != nil {
+		structured := *req.Structured
+		c.Structured = &structured
+	}
+	if req.MatchedTags != nil {
+		c.MatchedTags = make(map[string]request.Tags, len(req.MatchedTags))
+		for k, v := range req.MatchedTags {
+			c.MatchedTags[k] = v
+		}
+	}
+
+	return c
+}
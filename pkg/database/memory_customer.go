@@ -0,0 +1,132 @@
+package database
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/customer"
+)
+
+// memoryCustomerRepository implements the customer.CustomerRepository
+// interface in memory, for local execution and tests
+type memoryCustomerRepository struct {
+	mu        sync.RWMutex
+	customers map[int]customer.Customer
+}
+
+func NewMemoryCustomerRepository() customer.CustomerRepository {
+	return &memoryCustomerRepository{
+		customers: make(map[int]customer.Customer),
+	}
+}
+
+// Create stores a copy of a new Customer, failing if the CustomerID is already in use
+func (r *memoryCustomerRepository) Create(cust *customer.Customer) error {
+	if cust.CustomerID <= 0 {
+		return ErrZeroCustomerIDError
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.customers[cust.CustomerID]; ok {
+		return ErrAlreadyExistsError
+	}
+	cust.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	r.customers[cust.CustomerID] = copyCustomer(cust)
+
+	return nil
+}
+
+// FindByID returns a copy of the stored Customer
+func (r *memoryCustomerRepository) FindByID(customerID int) (*customer.Customer, error) {
+	var emptyCustomer = customer.Customer{}
+
+	if customerID <= 0 {
+		return &emptyCustomer, ErrZeroCustomerIDError
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	found, ok := r.customers[customerID]
+	if !ok {
+		return &emptyCustomer, ErrNotFoundError
+	}
+
+	foundCustomer := copyCustomer(&found)
+	return &foundCustomer, nil
+}
+
+// Update replaces an existing Customer's profile
+func (r *memoryCustomerRepository) Update(cust *customer.Customer) error {
+	if cust.CustomerID <= 0 {
+		return ErrZeroCustomerIDError
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.customers[cust.CustomerID]; !ok {
+		return ErrNotFoundError
+	}
+	cust.UpdatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	r.customers[cust.CustomerID] = copyCustomer(cust)
+
+	return nil
+}
+
+// Delete removes the Customer with the given CustomerID
+func (r *memoryCustomerRepository) Delete(customerID int) error {
+	if customerID <= 0 {
+		return ErrZeroCustomerIDError
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.customers[customerID]; !ok {
+		return ErrNotFoundError
+	}
+	delete(r.customers, customerID)
+
+	return nil
+}
+
+// List returns copies of all Customers, ordered by CustomerID
+func (r *memoryCustomerRepository) List() ([]*customer.Customer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	found := []*customer.Customer{}
+	for _, cust := range r.customers {
+		c := copyCustomer(&cust)
+		found = append(found, &c)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].CustomerID < found[j].CustomerID })
+
+	return found, nil
+}
+
+// FindByAPIKeyID returns a copy of the Customer holding the API key keyID
+func (r *memoryCustomerRepository) FindByAPIKeyID(keyID string) (*customer.Customer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, cust := range r.customers {
+		for _, id := range cust.APIKeyIDs {
+			if id == keyID {
+				c := copyCustomer(&cust)
+				return &c, nil
+			}
+		}
+	}
+
+	return &customer.Customer{}, customer.ErrAPIKeyNotFound
+}
+
+// copyCustomer returns a copy of cust that shares no slices with it
+func copyCustomer(cust *customer.Customer) customer.Customer {
+	c := *cust
+	c.APIKeys = append([]customer.APIKey(nil), cust.APIKeys...)
+	c.APIKeyIDs = append([]string(nil), cust.APIKeyIDs...)
+	c.Tagging.InfoTypes = append([]string(nil), cust.Tagging.InfoTypes...)
+	c.Delivery = append([]customer.Destination(nil), cust.Delivery...)
+	return c
+}
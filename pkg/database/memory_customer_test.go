@@ -0,0 +1,60 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/peterpla/lead-expert/pkg/customer"
+)
+
+func TestMemoryCustomer(t *testing.T) {
+
+	custRepo := NewMemoryCustomerRepository()
+
+	cust := customer.Customer{
+		CustomerID: 1234567,
+		Name:       "Park Flooring",
+		Contact:    customer.Contact{Email: "michael@example.com"},
+		Tagging:    customer.TaggingSettings{InfoTypes: []string{"PHONE_NUMBER"}},
+	}
+
+	if err := custRepo.Create(&cust); err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	if err := custRepo.Create(&cust); err != ErrAlreadyExistsError {
+		t.Errorf("Create duplicate, expected %v, got %v", ErrAlreadyExistsError, err)
+	}
+
+	// changes to the caller's copy must not leak into the repository
+	cust.Tagging.InfoTypes[0] = "EMAIL_ADDRESS"
+	got, err := custRepo.FindByID(1234567)
+	if err != nil {
+		t.Fatalf("FindByID error: %v", err)
+	}
+	if got.Tagging.InfoTypes[0] != "PHONE_NUMBER" {
+		t.Errorf("FindByID, expected InfoTypes [PHONE_NUMBER], got %v", got.Tagging.InfoTypes)
+	}
+
+	got.Disabled = true
+	if err := custRepo.Update(got); err != nil {
+		t.Fatalf("Update error: %v", err)
+	}
+	if got, _ = custRepo.FindByID(1234567); got.CanSubmit() != customer.ErrCustomerDisabled {
+		t.Errorf("Update, expected disabled customer, got %+v", got)
+	}
+
+	if err := custRepo.Update(&customer.Customer{CustomerID: 7654321}); err != ErrNotFoundError {
+		t.Errorf("Update unknown, expected %v, got %v", ErrNotFoundError, err)
+	}
+	if _, err := custRepo.FindByAPIKeyID("unknown"); err != customer.ErrAPIKeyNotFound {
+		t.Errorf("FindByAPIKeyID unknown, expected %v, got %v", customer.ErrAPIKeyNotFound, err)
+	}
+	if err := custRepo.Delete(1234567); err != nil {
+		t.Errorf("Delete error: %v", err)
+	}
+	if _, err := custRepo.FindByID(1234567); err != ErrNotFoundError {
+		t.Errorf("FindByID after Delete, expected %v, got %v", ErrNotFoundError, err)
+	}
+	if _, err := custRepo.FindByID(0); err != ErrZeroCustomerIDError {
+		t.Errorf("FindByID zero, expected %v, got %v", ErrZeroCustomerIDError, err)
+	}
+}
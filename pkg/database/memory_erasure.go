@@ -0,0 +1,51 @@
+package database
+
+import (
+	"sync"
+
+	"github.com/peterpla/lead-expert/pkg/erasure"
+)
+
+// memoryCertificateRepository implements the erasure.CertificateRepository
+// interface in memory, for local execution and tests
+type memoryCertificateRepository struct {
+	mu           sync.RWMutex
+	certificates map[string]erasure.Certificate
+}
+
+func NewMemoryCertificateRepository() erasure.CertificateRepository {
+	return &memoryCertificateRepository{
+		certificates: make(map[string]erasure.Certificate),
+	}
+}
+
+// Create stores a copy of a new Certificate
+func (r *memoryCertificateRepository) Create(cert *erasure.Certificate) error {
+	if cert.CertificateID == "" {
+		return ErrZeroUUIDError
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.certificates[cert.CertificateID]; ok {
+		return ErrAlreadyExistsError
+	}
+	c := *cert
+	c.Requests = append([]erasure.ErasedRequest(nil), cert.Requests...)
+	r.certificates[cert.CertificateID] = c
+
+	return nil
+}
+
+// FindByID returns a copy of the stored Certificate
+func (r *memoryCertificateRepository) FindByID(certificateID string) (*erasure.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	found, ok := r.certificates[certificateID]
+	if !ok {
+		return &erasure.Certificate{}, ErrNotFoundError
+	}
+	found.Requests = append([]erasure.ErasedRequest(nil), found.Requests...)
+
+	return &found, nil
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"sync"
+
+	"github.com/peterpla/lead-expert/pkg/encryption"
+)
+
+// memoryKeyRepository implements the encryption.KeyRepository interface in
+// memory, for local execution and tests
+type memoryKeyRepository struct {
+	mu   sync.RWMutex
+	keys map[int]encryption.DataKey
+}
+
+func NewMemoryKeyRepository() encryption.KeyRepository {
+	return &memoryKeyRepository{
+		keys: make(map[int]encryption.DataKey),
+	}
+}
+
+// Create stores a copy of the customer's data key
+func (r *memoryKeyRepository) Create(key *encryption.DataKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.keys[key.CustomerID]; ok {
+		return encryption.ErrKeyExists
+	}
+	k := *key
+	k.WrappedKey = append([]byte(nil), key.WrappedKey...)
+	r.keys[key.CustomerID] = k
+
+	return nil
+}
+
+// FindByCustomerID returns a copy of the customer's data key
+func (r *memoryKeyRepository) FindByCustomerID(customerID int) (*encryption.DataKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	found, ok := r.keys[customerID]
+	if !ok {
+		return &encryption.DataKey{}, encryption.ErrKeyNotFound
+	}
+	found.WrappedKey = append([]byte(nil), found.WrappedKey...)
+
+	return &found, nil
+}
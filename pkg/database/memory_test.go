@@ -0,0 +1,112 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/request"
+)
+
+func TestMemoryList(t *testing.T) {
+
+	memRepo := NewMemoryRequestRepository()
+
+	// ten requests for one customer, one minute apart, alternating status,
+	// plus one request for another customer
+	base := time.Date(2020, 1, 6, 12, 0, 0, 0, time.UTC)
+	var ids []uuid.UUID
+	for i := 0; i < 10; i++ {
+		req := request.Request{
+			RequestID:    uuid.New(),
+			CustomerID:   1234567,
+			MediaFileURI: "gs://elated-practice-224603.appspot.com/audio_uploads/audio-01.mp3",
+			Status:       request.Pending,
+			AcceptedAt:   base.Add(time.Minute * time.Duration(i)).Format(time.RFC3339Nano),
+		}
+		if i%2 == 1 {
+			req.Status = request.Completed
+		}
+		if err := memRepo.Create(&req); err != nil {
+			t.Fatalf("Create error: %v", err)
+		}
+		ids = append(ids, req.RequestID)
+	}
+	other := request.Request{RequestID: uuid.New(), CustomerID: 7654321, Status: request.Pending,
+		AcceptedAt: base.Format(time.RFC3339Nano)}
+	if err := memRepo.Create(&other); err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+
+	type test struct {
+		name     string
+		opts     request.ListOptions
+		expected []uuid.UUID
+	}
+
+	tests := []test{
+		{name: "ascending, all",
+			opts:     request.ListOptions{CustomerID: 1234567},
+			expected: ids},
+		{name: "descending, status",
+			opts:     request.ListOptions{CustomerID: 1234567, Status: request.Completed, Descending: true},
+			expected: []uuid.UUID{ids[9], ids[7], ids[5], ids[3], ids[1]}},
+		{name: "time range",
+			opts: request.ListOptions{CustomerID: 1234567,
+				AcceptedAfter: base.Add(time.Minute * 2), AcceptedBefore: base.Add(time.Minute * 5)},
+			expected: ids[2:5]},
+		{name: "other customer",
+			opts:     request.ListOptions{CustomerID: 7654321},
+			expected: []uuid.UUID{other.RequestID}},
+	}
+
+	for _, tc := range tests {
+		result, err := memRepo.List(tc.opts)
+		if err != nil {
+			t.Errorf("%s: List error: %v", tc.name, err)
+			continue
+		}
+		if len(result.Requests) != len(tc.expected) {
+			t.Errorf("%s: expected %d requests, got %d", tc.name, len(tc.expected), len(result.Requests))
+			continue
+		}
+		for i, req := range result.Requests {
+			if req.RequestID != tc.expected[i] {
+				t.Errorf("%s: [%d] expected %v, got %v", tc.name, i, tc.expected[i], req.RequestID)
+			}
+		}
+	}
+
+	// page through in both directions, three at a time
+	for _, descending := range []bool{false, true} {
+		opts := request.ListOptions{CustomerID: 1234567, Limit: 3, Descending: descending}
+		var got []uuid.UUID
+		for pages := 0; pages < 10; pages++ {
+			result, err := memRepo.List(opts)
+			if err != nil {
+				t.Fatalf("paging, List error: %v", err)
+			}
+			for _, req := range result.Requests {
+				got = append(got, req.RequestID)
+			}
+			if result.NextCursor == "" {
+				break
+			}
+			opts.Cursor = result.NextCursor
+		}
+
+		if len(got) != len(ids) {
+			t.Fatalf("paging (descending %v), expected %d requests, got %d", descending, len(ids), len(got))
+		}
+		for i := range got {
+			want := ids[i]
+			if descending {
+				want = ids[len(ids)-1-i]
+			}
+			if got[i] != want {
+				t.Errorf("paging (descending %v), [%d] expected %v, got %v", descending, i, want, got[i])
+			}
+		}
+	}
+}
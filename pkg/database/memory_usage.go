@@ -0,0 +1,47 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/peterpla/lead-expert/pkg/ratelimit"
+)
+
+// memoryUsageStore implements the ratelimit.UsageStore interface in memory,
+// for local execution and tests
+type memoryUsageStore struct {
+	mu    sync.RWMutex
+	usage map[string]ratelimit.Usage
+}
+
+func NewMemoryUsageStore() ratelimit.UsageStore {
+	return &memoryUsageStore{
+		usage: make(map[string]ratelimit.Usage),
+	}
+}
+
+// Add increments the customer's usage for period
+func (s *memoryUsageStore) Add(customerID int, period string, requests int, audioSeconds int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := fmt.Sprintf("%d-%s", customerID, period)
+	u := s.usage[key]
+	u.CustomerID, u.Period = customerID, period
+	u.Requests += requests
+	u.AudioSeconds += audioSeconds
+	s.usage[key] = u
+
+	return nil
+}
+
+// Get returns a copy of the customer's usage for period
+func (s *memoryUsageStore) Get(customerID int, period string) (*ratelimit.Usage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.usage[fmt.Sprintf("%d-%s", customerID, period)]
+	if !ok {
+		u = ratelimit.Usage{CustomerID: customerID, Period: period}
+	}
+
+	return &u, nil
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/peterpla/lead-expert/pkg/webhook"
+)
+
+// memoryDeliveryRepository implements the webhook.DeliveryRepository
+// interface in memory, for local execution and tests
+type memoryDeliveryRepository struct {
+	mu         sync.RWMutex
+	deliveries map[string]webhook.Delivery
+}
+
+func NewMemoryDeliveryRepository() webhook.DeliveryRepository {
+	return &memoryDeliveryRepository{
+		deliveries: make(map[string]webhook.Delivery),
+	}
+}
+
+// Create stores a copy of a new Delivery
+func (r *memoryDeliveryRepository) Create(d *webhook.Delivery) error {
+	if d.DeliveryID == "" {
+		return ErrZeroUUIDError
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.deliveries[d.DeliveryID]; ok {
+		return ErrAlreadyExistsError
+	}
+	r.deliveries[d.DeliveryID] = copyDelivery(d)
+
+	return nil
+}
+
+// Update replaces the stored Delivery with a copy of d
+func (r *memoryDeliveryRepository) Update(d *webhook.Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.deliveries[d.DeliveryID]; !ok {
+		return ErrNotFoundError
+	}
+	r.deliveries[d.DeliveryID] = copyDelivery(d)
+
+	return nil
+}
+
+// FindByID returns a copy of the stored Delivery
+func (r *memoryDeliveryRepository) FindByID(deliveryID string) (*webhook.Delivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	found, ok := r.deliveries[deliveryID]
+	if !ok {
+		return &webhook.Delivery{}, ErrNotFoundError
+	}
+	d := copyDelivery(&found)
+
+	return &d, nil
+}
+
+// List returns copies of the Deliveries opts selects: newest first, or,
+// with DueBy, the longest overdue first
+func (r *memoryDeliveryRepository) List(opts webhook.ListOptions) ([]*webhook.Delivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// times are compared as strings, as Firestore compares them
+	found := []*webhook.Delivery{}
+	for _, d := range r.deliveries {
+		if opts.CustomerID != 0 && d.CustomerID != opts.CustomerID {
+			continue
+		}
+		if opts.RequestID != "" && d.RequestID != opts.RequestID {
+			continue
+		}
+		if opts.Status != "" && d.Status != opts.Status {
+			continue
+		}
+		if opts.DueBy != "" {
+			if d.Status != webhook.Pending || d.NextAttemptAt == "" || d.NextAttemptAt > opts.DueBy {
+				continue
+			}
+		}
+		c := copyDelivery(&d)
+		found = append(found, &c)
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if opts.DueBy != "" {
+			return found[i].NextAttemptAt < found[j].NextAttemptAt
+		}
+		return found[i].CreatedAt > found[j].CreatedAt
+	})
+	if opts.Limit > 0 && len(found) > opts.Limit {
+		found = found[:opts.Limit]
+	}
+
+	return found, nil
+}
+
+func copyDelivery(d *webhook.Delivery) webhook.Delivery {
+	c := *d
+	c.Attempts = append([]webhook.Attempt(nil), d.Attempts...)
+	return c
+}
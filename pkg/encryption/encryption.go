@@ -0,0 +1,193 @@
+// Encryption package protects transcript content at rest using envelope
+// encryption: each customer's fields are sealed with that customer's data
+// key (AES-256-GCM), and data keys are stored wrapped by a KeyWrapper, e.g.
+// Cloud KMS.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// DataKeySize is the length in bytes of data keys and local wrapping keys (AES-256)
+const DataKeySize = 32
+
+// sealedPrefix marks a sealed value; values without it are plaintext
+// written before encryption was enabled
+const sealedPrefix = "enc:v1:"
+
+// ErrKeyExists - a data key already exists for the customer
+var ErrKeyExists = errors.New("Data key already exists")
+
+// ErrKeyNotFound - no data key exists for the customer
+var ErrKeyNotFound = errors.New("Data key not found")
+
+// ErrDecrypt - a sealed value didn't decrypt, it's corrupt or under another key
+var ErrDecrypt = errors.New("Decryption failed")
+
+// KeyWrapper is implemented by each key-encryption-key backend
+type KeyWrapper interface {
+	Wrap(ctx context.Context, key []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// DataKey is a customer's data key, wrapped
+type DataKey struct {
+	CustomerID int    `json:"customer_id" firestore:"customer_id"`
+	WrappedKey []byte `json:"wrapped_key" firestore:"wrapped_key"`
+	CreatedAt  string `json:"created_at" firestore:"created_at"`
+}
+
+// KeyRepository stores wrapped data keys, one per customer
+type KeyRepository interface {
+	Create(key *DataKey) error // ErrKeyExists if the customer already has one
+	FindByCustomerID(customerID int) (*DataKey, error)
+}
+
+// Keyring hands out customers' data keys, creating each on first use and
+// caching them unwrapped
+type Keyring struct {
+	Wrapper KeyWrapper
+	Keys    KeyRepository
+
+	mu    sync.Mutex
+	cache map[int][]byte
+}
+
+func NewKeyring(wrapper KeyWrapper, keys KeyRepository) *Keyring {
+	return &Keyring{
+		Wrapper: wrapper,
+		Keys:    keys,
+		cache:   make(map[int][]byte),
+	}
+}
+
+// DataKey returns the customer's data key, creating it if needed
+func (k *Keyring) DataKey(ctx context.Context, customerID int) ([]byte, error) {
+	sn := serviceInfo.GetServiceName()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.cache[customerID]; ok {
+		return key, nil
+	}
+
+	stored, err := k.Keys.FindByCustomerID(customerID)
+	if err == ErrKeyNotFound {
+		stored, err = k.create(ctx, customerID)
+	}
+	if err != nil {
+		log.Printf("%s.encryption.DataKey, customer %d, error: %v\n", sn, customerID, err)
+		return nil, err
+	}
+
+	key, err := k.Wrapper.Unwrap(ctx, stored.WrappedKey)
+	if err != nil {
+		log.Printf("%s.encryption.DataKey, customer %d, Unwrap error: %v\n", sn, customerID, err)
+		return nil, err
+	}
+	if len(key) != DataKeySize {
+		return nil, fmt.Errorf("%w: customer %d data key is %d bytes", ErrDecrypt, customerID, len(key))
+	}
+
+	k.cache[customerID] = key
+	return key, nil
+}
+
+// create generates and stores a new data key; if another process stored
+// one first, that one is used
+func (k *Keyring) create(ctx context.Context, customerID int) (*DataKey, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	wrapped, err := k.Wrapper.Wrap(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &DataKey{
+		CustomerID: customerID,
+		WrappedKey: wrapped,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if err := k.Keys.Create(stored); err == ErrKeyExists {
+		return k.Keys.FindByCustomerID(customerID)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// Seal encrypts plaintext under key, binding it to context (e.g. the
+// Request and field it's stored in) so it can't be moved elsewhere
+func Seal(key []byte, plaintext string, context string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal with the same key and context.
+// Values that aren't sealed are returned unchanged.
+func Open(key []byte, value string, context string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(context))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether value was produced by Seal
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
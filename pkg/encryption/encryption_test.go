@@ -0,0 +1,150 @@
+package encryption_test
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/encryption"
+	"github.com/peterpla/lead-expert/pkg/request"
+)
+
+// newWrapper returns a local KeyWrapper using a fresh key file
+func newWrapper(t *testing.T) encryption.KeyWrapper {
+	path := filepath.Join(t.TempDir(), "wrapping.key")
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", encryption.DataKeySize)))
+	if err := ioutil.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	wrapper, err := encryption.NewLocalKeyWrapper(path)
+	if err != nil {
+		t.Fatalf("NewLocalKeyWrapper: %v", err)
+	}
+	return wrapper
+}
+
+func TestSealOpen(t *testing.T) {
+	key := []byte(strings.Repeat("a", encryption.DataKeySize))
+	otherKey := []byte(strings.Repeat("b", encryption.DataKeySize))
+
+	sealed, err := encryption.Seal(key, "[Speaker 1] call me at 555-123-4567", "id/final_transcript")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if strings.Contains(sealed, "555") || !encryption.IsSealed(sealed) {
+		t.Errorf("expected sealed value, got %q", sealed)
+	}
+
+	type test struct {
+		name     string
+		key      []byte
+		value    string
+		context  string
+		expected string
+		err      bool
+	}
+
+	tests := []test{
+		{name: "round trip", key: key, value: sealed, context: "id/final_transcript", expected: "[Speaker 1] call me at 555-123-4567"},
+		{name: "wrong key", key: otherKey, value: sealed, context: "id/final_transcript", err: true},
+		{name: "moved to another field", key: key, value: sealed, context: "id/working_transcript", err: true},
+		{name: "plaintext passes through", key: key, value: "[Speaker 1] hello", context: "id/final_transcript", expected: "[Speaker 1] hello"},
+	}
+
+	for _, tc := range tests {
+		got, err := encryption.Open(tc.key, tc.value, tc.context)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.err, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	keys := database.NewMemoryKeyRepository()
+	wrapper := newWrapper(t)
+	ctx := context.Background()
+
+	key1, err := encryption.NewKeyring(wrapper, keys).DataKey(ctx, 1234567)
+	if err != nil {
+		t.Fatalf("DataKey: %v", err)
+	}
+	// another process finds the same key
+	again, err := encryption.NewKeyring(wrapper, keys).DataKey(ctx, 1234567)
+	if err != nil || string(again) != string(key1) {
+		t.Errorf("expected the stored key, got %x, err %v", again, err)
+	}
+	key2, _ := encryption.NewKeyring(wrapper, keys).DataKey(ctx, 7654321)
+	if string(key2) == string(key1) {
+		t.Errorf("expected each customer to have their own key")
+	}
+
+	stored, _ := keys.FindByCustomerID(1234567)
+	if strings.Contains(string(stored.WrappedKey), string(key1)) {
+		t.Errorf("expected data key stored wrapped")
+	}
+}
+
+func TestEncryptedRequestRepository(t *testing.T) {
+	inner := database.NewMemoryRequestRepository()
+	repo := encryption.NewEncryptedRequestRepository(inner,
+		encryption.NewKeyring(newWrapper(t), database.NewMemoryKeyRepository()))
+
+	tags := map[string]request.Tags{"PHONE_NUMBER": {Quote: "555-123-4567", InfoType: "PHONE_NUMBER"}}
+	req := request.Request{
+		RequestID:         uuid.New(),
+		CustomerID:        1234567,
+		WorkingTranscript: "[Speaker 1] call me at 555-123-4567",
+		FinalTranscript:   "[Speaker 1] call me at 555-123-4567",
+		Structured: &request.Transcript{Segments: []request.Segment{
+			{Speaker: 1, Text: "call me at 555-123-4567", Words: []request.Word{{Text: "call"}, {Text: "me"}, {Text: "at"}, {Text: "555-123-4567"}}}}},
+		MatchedTags: tags,
+	}
+	if err := repo.Create(&req); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if req.FinalTranscript == "" || req.MatchedTags == nil {
+		t.Errorf("expected caller's Request unchanged, got %+v", req)
+	}
+
+	// stored sealed
+	stored, _ := inner.FindByID(req.RequestID)
+	if !encryption.IsSealed(stored.WorkingTranscript) || !encryption.IsSealed(stored.FinalTranscript) ||
+		stored.Structured != nil || !encryption.IsSealed(stored.EncryptedStructure) ||
+		stored.MatchedTags != nil || !encryption.IsSealed(stored.EncryptedTags) {
+		t.Errorf("expected transcripts and tags sealed, got %+v", stored)
+	}
+
+	// read back plaintext
+	found, err := repo.FindByID(req.RequestID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if found.FinalTranscript != req.FinalTranscript || found.MatchedTags["PHONE_NUMBER"].Quote != "555-123-4567" ||
+		found.EncryptedTags != "" || found.Structured == nil || found.Structured.Text() != "call me at 555-123-4567" ||
+		found.EncryptedStructure != "" {
+		t.Errorf("expected plaintext, got %+v", found)
+	}
+
+	// Requests stored before encryption was enabled still read
+	legacy := request.Request{RequestID: uuid.New(), CustomerID: 1234567, FinalTranscript: "[Speaker 1] hello"}
+	_ = inner.Create(&legacy)
+	result, err := repo.List(request.ListOptions{CustomerID: 1234567})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	for _, got := range result.Requests {
+		if encryption.IsSealed(got.FinalTranscript) {
+			t.Errorf("expected plaintext from List, got %q", got.FinalTranscript)
+		}
+	}
+}
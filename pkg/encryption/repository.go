@@ -0,0 +1,208 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// encryptedRequestRepository implements request.RequestRepository by
+// sealing transcripts and tags before passing Requests to the wrapped
+// repository, and opening them in Requests it returns
+type encryptedRequestRepository struct {
+	request.RequestRepository
+	keys *Keyring
+}
+
+// NewEncryptedRequestRepository wraps repo so WorkingTranscript,
+// FinalTranscript, Structured and MatchedTags are stored sealed under the
+// customer's data key. Structured is stored as EncryptedStructure, and
+// MatchedTags as EncryptedTags. Plaintext values written before encryption
+// was enabled are read as-is.
+func NewEncryptedRequestRepository(repo request.RequestRepository, keys *Keyring) request.RequestRepository {
+	return encryptedRequestRepository{repo, keys}
+}
+
+func (r encryptedRequestRepository) Create(req *request.Request) error {
+	sealed, err := r.seal(req)
+	if err != nil {
+		return err
+	}
+	if err := r.RequestRepository.Create(sealed); err != nil {
+		return err
+	}
+	req.CreatedAt = sealed.CreatedAt
+	return nil
+}
+
+func (r encryptedRequestRepository) Update(req *request.Request) error {
+	sealed, err := r.seal(req)
+	if err != nil {
+		return err
+	}
+	if err := r.RequestRepository.Update(sealed); err != nil {
+		return err
+	}
+	req.UpdatedAt = sealed.UpdatedAt
+
+	// Update merges, so remove any plaintext stored before encryption
+	var plaintext []string
+	if sealed.EncryptedStructure != "" {
+		plaintext = append(plaintext, "structured_transcript")
+	}
+	if sealed.EncryptedTags != "" {
+		plaintext = append(plaintext, "tags")
+	}
+	if len(plaintext) > 0 {
+		return r.RequestRepository.DeleteFields(req.RequestID, plaintext...)
+	}
+	return nil
+}
+
+func (r encryptedRequestRepository) FindByID(reqID uuid.UUID) (*request.Request, error) {
+	req, err := r.RequestRepository.FindByID(reqID)
+	if err != nil {
+		return req, err
+	}
+	if err := r.open(req); err != nil {
+		return &request.Request{}, err
+	}
+	return req, nil
+}
+
+func (r encryptedRequestRepository) List(opts request.ListOptions) (*request.ListResult, error) {
+	result, err := r.RequestRepository.List(opts)
+	if err != nil {
+		return result, err
+	}
+	for _, req := range result.Requests {
+		if err := r.open(req); err != nil {
+			return &request.ListResult{}, err
+		}
+	}
+	return result, nil
+}
+
+// seal returns a copy of req with its transcripts and tags sealed
+func (r encryptedRequestRepository) seal(req *request.Request) (*request.Request, error) {
+	sn := serviceInfo.GetServiceName()
+
+	sealed := *req
+	if req.WorkingTranscript == "" && req.FinalTranscript == "" && req.Structured == nil && len(req.MatchedTags) == 0 {
+		return &sealed, nil
+	}
+
+	key, err := r.keys.DataKey(context.Background(), req.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"working_transcript", &sealed.WorkingTranscript},
+		{"final_transcript", &sealed.FinalTranscript},
+	} {
+		if *f.value == "" {
+			continue
+		}
+		if *f.value, err = Seal(key, *f.value, fieldContext(req.RequestID, f.name)); err != nil {
+			log.Printf("%s.encryption.seal, %s: %v\n", sn, f.name, err)
+			return nil, err
+		}
+	}
+
+	if req.Structured != nil {
+		structuredJSON, err := json.Marshal(req.Structured)
+		if err != nil {
+			return nil, err
+		}
+		if sealed.EncryptedStructure, err = Seal(key, string(structuredJSON), fieldContext(req.RequestID, "structured_transcript")); err != nil {
+			log.Printf("%s.encryption.seal, structured_transcript: %v\n", sn, err)
+			return nil, err
+		}
+		sealed.Structured = nil
+	}
+
+	if len(req.MatchedTags) > 0 {
+		tagsJSON, err := json.Marshal(req.MatchedTags)
+		if err != nil {
+			return nil, err
+		}
+		if sealed.EncryptedTags, err = Seal(key, string(tagsJSON), fieldContext(req.RequestID, "tags")); err != nil {
+			log.Printf("%s.encryption.seal, tags: %v\n", sn, err)
+			return nil, err
+		}
+		sealed.MatchedTags = nil
+	}
+
+	return &sealed, nil
+}
+
+// open decrypts req's transcripts and tags in place
+func (r encryptedRequestRepository) open(req *request.Request) error {
+	sn := serviceInfo.GetServiceName()
+
+	if !IsSealed(req.WorkingTranscript) && !IsSealed(req.FinalTranscript) && req.EncryptedStructure == "" && req.EncryptedTags == "" {
+		return nil
+	}
+
+	key, err := r.keys.DataKey(context.Background(), req.CustomerID)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"working_transcript", &req.WorkingTranscript},
+		{"final_transcript", &req.FinalTranscript},
+	} {
+		if *f.value, err = Open(key, *f.value, fieldContext(req.RequestID, f.name)); err != nil {
+			log.Printf("%s.encryption.open, request %s %s: %v\n", sn, req.RequestID, f.name, err)
+			return err
+		}
+	}
+
+	if req.EncryptedStructure != "" {
+		structuredJSON, err := Open(key, req.EncryptedStructure, fieldContext(req.RequestID, "structured_transcript"))
+		if err != nil {
+			log.Printf("%s.encryption.open, request %s structured_transcript: %v\n", sn, req.RequestID, err)
+			return err
+		}
+		structured := &request.Transcript{}
+		if err := json.Unmarshal([]byte(structuredJSON), structured); err != nil {
+			return err
+		}
+		req.Structured = structured
+		req.EncryptedStructure = ""
+	}
+
+	if req.EncryptedTags != "" {
+		tagsJSON, err := Open(key, req.EncryptedTags, fieldContext(req.RequestID, "tags"))
+		if err != nil {
+			log.Printf("%s.encryption.open, request %s tags: %v\n", sn, req.RequestID, err)
+			return err
+		}
+		var tags map[string]request.Tags
+		if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
+			return err
+		}
+		req.MatchedTags = tags
+		req.EncryptedTags = ""
+	}
+
+	return nil
+}
+
+// fieldContext binds a sealed value to the Request and field holding it
+func fieldContext(reqID uuid.UUID, field string) string {
+	return reqID.String() + "/" + field
+}
@@ -0,0 +1,106 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	cloudkms "cloud.google.com/go/kms/apiv1"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+// kmsWrapper implements KeyWrapper using a Cloud KMS symmetric key
+type kmsWrapper struct {
+	KeyName string // projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>
+}
+
+func NewKMSKeyWrapper(keyName string) KeyWrapper {
+	return kmsWrapper{keyName}
+}
+
+func (w kmsWrapper) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	client, err := cloudkms.NewKeyManagementClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	resp, err := client.Encrypt(ctx, &kmspb.EncryptRequest{Name: w.KeyName, Plaintext: key})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+func (w kmsWrapper) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	client, err := cloudkms.NewKeyManagementClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	resp, err := client.Decrypt(ctx, &kmspb.DecryptRequest{Name: w.KeyName, Ciphertext: wrapped})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// localWrapper implements KeyWrapper with a key held locally, for tests
+// and self-hosting
+type localWrapper struct {
+	key []byte
+}
+
+// NewLocalKeyWrapper returns a KeyWrapper using the base64-encoded 32-byte
+// key in the file at path, e.g. one created by
+//
+//	head -c 32 /dev/urandom | base64 > wrapping.key
+func NewLocalKeyWrapper(path string) (KeyWrapper, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %q: %v", path, err)
+	}
+	if len(key) != DataKeySize {
+		return nil, fmt.Errorf("key file %q: expected %d bytes, got %d", path, DataKeySize, len(key))
+	}
+
+	return localWrapper{key}, nil
+}
+
+func (w localWrapper) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	aead, err := newAEAD(w.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, nil), nil
+}
+
+func (w localWrapper) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(w.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	key, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return key, nil
+}
@@ -0,0 +1,422 @@
+// Erasure package honors end callers' requests to delete their personal
+// data (GDPR/CCPA "right to erasure"), and certifies what was deleted
+package erasure
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/audit"
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/queue"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+	"github.com/peterpla/lead-expert/pkg/webhook"
+)
+
+// ErrInvalidSelector - Selector must identify the customer and exactly one of request_id, phone_number, media_uri
+var ErrInvalidSelector = errors.New("Invalid erasure selector")
+
+// ErrNotFound - no Request matches the Selector
+var ErrNotFound = errors.New("No matching Request")
+
+// selector kinds
+const (
+	ByRequestID   = "request_id"
+	ByPhoneNumber = "phone_number"
+	ByMediaURI    = "media_uri"
+)
+
+// ErasedFields are the firestore names of the Request fields that may hold
+// personal data
+var ErasedFields = append([]string{"media_uri", "working_media_uri"}, request.TranscriptFields...)
+
+// Selector identifies whose data to erase
+type Selector struct {
+	CustomerID  int    `json:"customer_id" validate:"required,gte=1,lt=10000000"`
+	RequestID   string `json:"request_id,omitempty" validate:"omitempty,uuid4"`
+	PhoneNumber string `json:"phone_number,omitempty" validate:"omitempty,min=7,max=32"`
+	MediaURI    string `json:"media_uri,omitempty" validate:"omitempty,uri"`
+}
+
+// Kind returns which selector field is set, or "" unless exactly one is
+func (s Selector) Kind() string {
+	kinds := []string{}
+	if s.RequestID != "" {
+		kinds = append(kinds, ByRequestID)
+	}
+	if s.PhoneNumber != "" {
+		kinds = append(kinds, ByPhoneNumber)
+	}
+	if s.MediaURI != "" {
+		kinds = append(kinds, ByMediaURI)
+	}
+	if len(kinds) != 1 {
+		return ""
+	}
+	return kinds[0]
+}
+
+// value returns the selector field that is set
+func (s Selector) value() string {
+	return s.RequestID + s.PhoneNumber + s.MediaURI
+}
+
+// Certificate records what an erasure deleted. It holds a hash of the
+// selector value rather than the value itself.
+type Certificate struct {
+	CertificateID string          `json:"certificate_id" firestore:"-"`
+	CustomerID    int             `json:"customer_id" firestore:"customer_id"`
+	SelectorKind  string          `json:"selector_kind" firestore:"selector_kind"`
+	SelectorHash  string          `json:"selector_hash" firestore:"selector_hash"` // hex SHA-256 of the selector value
+	Requests      []ErasedRequest `json:"requests" firestore:"requests"`
+	Actor         string          `json:"actor" firestore:"actor"`
+	IssuedAt      string          `json:"issued_at" firestore:"issued_at"`
+}
+
+// ErasedRequest records what was deleted for one Request
+type ErasedRequest struct {
+	RequestID       string   `json:"request_id" firestore:"request_id"`
+	Fields          []string `json:"fields" firestore:"fields"`
+	MediaURI        string   `json:"media_uri,omitempty" firestore:"media_uri,omitempty"`                 // as submitted
+	WorkingMediaURI string   `json:"working_media_uri,omitempty" firestore:"working_media_uri,omitempty"` // mediaFetch's copy
+	Media           []string `json:"media,omitempty" firestore:"media,omitempty"`                         // every object deleted, kept to verify it stays deleted
+	MediaNotHeld    []string `json:"media_not_held,omitempty" firestore:"media_not_held,omitempty"`       // not in a store of ours (e.g. "https://"), so not deleted
+	MediaDeleted    bool     `json:"media_deleted" firestore:"media_deleted"`
+	QueueTasks      []string `json:"queue_tasks,omitempty" firestore:"queue_tasks,omitempty"` // Cloud Tasks task names deleted
+	Deliveries      []string `json:"deliveries,omitempty" firestore:"deliveries,omitempty"`   // webhook deliveries whose payload was deleted
+}
+
+// Verification is the result of re-checking every store named in a Certificate
+type Verification struct {
+	CertificateID string   `json:"certificate_id"`
+	Verified      bool     `json:"verified"`
+	Problems      []string `json:"problems,omitempty"`
+	CheckedAt     string   `json:"checked_at"`
+}
+
+// CertificateRepository is implemented by each supported certificate database
+type CertificateRepository interface {
+	Create(cert *Certificate) error
+	FindByID(certificateID string) (*Certificate, error)
+}
+
+// Eraser finds and deletes an end caller's personal data
+type Eraser struct {
+	Requests     request.RequestRepository
+	Media        media.Store
+	Queue        queue.Queue
+	Queues       []queue.QueueInfo // every queue in the pipeline
+	Certificates CertificateRepository
+	Deliveries   webhook.DeliveryRepository // webhook events carry the media_uri
+	Audit        audit.Sink
+}
+
+// Find returns the customer's Requests matched by sel
+func (e *Eraser) Find(sel Selector) ([]*request.Request, error) {
+	kind := sel.Kind()
+	if kind == "" || sel.CustomerID <= 0 {
+		return nil, ErrInvalidSelector
+	}
+
+	if kind == ByRequestID {
+		reqID, err := uuid.Parse(sel.RequestID)
+		if err != nil {
+			return nil, ErrInvalidSelector
+		}
+		found, err := e.Requests.FindByID(reqID)
+		if err != nil {
+			return nil, err
+		}
+		// another customer's Request is indistinguishable from none at all
+		if found.CustomerID != sel.CustomerID {
+			return nil, ErrNotFound
+		}
+		return []*request.Request{found}, nil
+	}
+
+	// no index on transcript content, so scan the customer's Requests
+	matched := []*request.Request{}
+	opts := request.ListOptions{CustomerID: sel.CustomerID, Limit: request.MaxListLimit}
+	for {
+		page, err := e.Requests.List(opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, req := range page.Requests {
+			if (kind == ByMediaURI && req.MediaFileURI == sel.MediaURI) ||
+				(kind == ByPhoneNumber && MentionsPhoneNumber(req, sel.PhoneNumber)) {
+				matched = append(matched, req)
+			}
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+
+	if len(matched) == 0 {
+		return nil, ErrNotFound
+	}
+	return matched, nil
+}
+
+// Erase deletes the personal data held by every Request matched by sel,
+// from the Requests database, the media store, the pipeline's queues and
+// the webhook delivery log, and returns the stored Certificate
+func (e *Eraser) Erase(ctx context.Context, sel Selector) (*Certificate, error) {
+	sn := serviceInfo.GetServiceName()
+
+	matched, err := e.Find(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &Certificate{
+		CertificateID: uuid.New().String(),
+		CustomerID:    sel.CustomerID,
+		SelectorKind:  sel.Kind(),
+		SelectorHash:  hashValue(sel.value()),
+		Requests:      []ErasedRequest{},
+		Actor:         sn,
+	}
+
+	for _, req := range matched {
+		erased, err := e.eraseRequest(ctx, req)
+		if err != nil {
+			log.Printf("%s.erasure.Erase, RequestID %s: %v\n", sn, req.RequestID, err)
+			return nil, err
+		}
+		cert.Requests = append(cert.Requests, erased)
+	}
+
+	cert.IssuedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	if err := e.Certificates.Create(cert); err != nil {
+		log.Printf("%s.erasure.Erase, Certificates.Create error: %v\n", sn, err)
+		return nil, err
+	}
+
+	log.Printf("%s.erasure.Erase, customer %d, %s, erased %d requests, certificate %s\n",
+		sn, cert.CustomerID, cert.SelectorKind, len(cert.Requests), cert.CertificateID)
+	return cert, nil
+}
+
+// eraseRequest deletes one Request's queued tasks, media, webhook payloads
+// and personal fields
+func (e *Eraser) eraseRequest(ctx context.Context, req *request.Request) (ErasedRequest, error) {
+	erased := ErasedRequest{
+		RequestID:       req.RequestID.String(),
+		Fields:          ErasedFields,
+		MediaURI:        req.MediaFileURI,
+		WorkingMediaURI: req.WorkingMediaURI,
+	}
+
+	// stop the pipeline first, so no stage writes the data back
+	for i := range e.Queues {
+		names, err := e.Queue.FindTasks(&e.Queues[i], req.RequestID)
+		if err != nil {
+			return erased, err
+		}
+		for _, name := range names {
+			if err := e.Queue.DeleteTask(&e.Queues[i], name); err != nil {
+				return erased, err
+			}
+			erased.QueueTasks = append(erased.QueueTasks, name)
+		}
+	}
+
+	// a media_uri outside our store (e.g. "https://") isn't ours to delete
+	for _, uri := range req.StoredMedia() {
+		err := e.Media.Delete(ctx, uri)
+		if err == media.ErrUnsupportedURI {
+			erased.MediaNotHeld = append(erased.MediaNotHeld, uri)
+			continue
+		}
+		if err != nil && err != media.ErrNotFound {
+			return erased, err
+		}
+		erased.Media = append(erased.Media, uri)
+		erased.MediaDeleted = true
+	}
+
+	erasedAt := time.Now().UTC().Format(request.TimeLayout)
+	deliveries, err := e.eraseDeliveries(req, erasedAt)
+	if err != nil {
+		return erased, err
+	}
+	erased.Deliveries = deliveries
+
+	if err := e.Requests.DeleteFields(req.RequestID, ErasedFields...); err != nil {
+		return erased, err
+	}
+	if err := req.ClearFields(ErasedFields...); err != nil {
+		return erased, err
+	}
+	req.ErasedAt = erasedAt
+	if req.Status == request.Pending {
+		req.Status = request.Error // its tasks are gone, it will never complete
+	}
+	if err := e.Requests.Update(req); err != nil {
+		return erased, err
+	}
+
+	return erased, e.Audit.Write(audit.NewRecord(req.CustomerID, erased.RequestID, audit.ActionErase, ErasedFields...))
+}
+
+// eraseDeliveries deletes the payload of every webhook Delivery about the
+// Request, returning their IDs. Those not yet delivered are never sent.
+func (e *Eraser) eraseDeliveries(req *request.Request, erasedAt string) ([]string, error) {
+	deliveries, err := e.Deliveries.List(webhook.ListOptions{CustomerID: req.CustomerID, RequestID: req.RequestID.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, d := range deliveries {
+		if d.ErasedAt == "" {
+			d.Payload = ""
+			d.ErasedAt = erasedAt
+			if d.Status == webhook.Pending {
+				d.Status = webhook.Failed
+				d.NextAttemptAt = ""
+			}
+			if err := e.Deliveries.Update(d); err != nil {
+				return nil, err
+			}
+		}
+		ids = append(ids, d.DeliveryID)
+	}
+	return ids, nil
+}
+
+// Verify re-checks every store named in the certificate, confirming the
+// erased data is still gone
+func (e *Eraser) Verify(ctx context.Context, cert *Certificate) (*Verification, error) {
+	v := &Verification{CertificateID: cert.CertificateID}
+
+	for _, erased := range cert.Requests {
+		reqID, err := uuid.Parse(erased.RequestID)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := e.Requests.FindByID(reqID)
+		if err != nil {
+			return nil, err
+		}
+		if req.ErasedAt == "" {
+			v.Problems = append(v.Problems, fmt.Sprintf("request %s: not marked erased", erased.RequestID))
+		}
+		if req.MediaFileURI != "" || req.WorkingMediaURI != "" || req.WorkingTranscript != "" || req.FinalTranscript != "" || req.Structured != nil || len(req.MatchedTags) != 0 {
+			v.Problems = append(v.Problems, fmt.Sprintf("request %s: personal data present", erased.RequestID))
+		}
+
+		// certificates issued before Media was recorded name only these two
+		uris := append([]string{erased.MediaURI, erased.WorkingMediaURI}, erased.Media...)
+		checked := map[string]bool{"": true}
+		for _, uri := range uris {
+			if checked[uri] {
+				continue
+			}
+			checked[uri] = true
+			exists, err := e.Media.Exists(ctx, uri)
+			if err == media.ErrUnsupportedURI {
+				continue // not in our store
+			}
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				v.Problems = append(v.Problems, fmt.Sprintf("request %s: media file present", erased.RequestID))
+			}
+		}
+
+		deliveries, err := e.Deliveries.List(webhook.ListOptions{CustomerID: cert.CustomerID, RequestID: erased.RequestID})
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range deliveries {
+			if d.Payload != "" {
+				v.Problems = append(v.Problems, fmt.Sprintf("request %s: webhook delivery %s payload present", erased.RequestID, d.DeliveryID))
+			}
+		}
+
+		for i := range e.Queues {
+			names, err := e.Queue.FindTasks(&e.Queues[i], reqID)
+			if err != nil {
+				return nil, err
+			}
+			if len(names) != 0 {
+				v.Problems = append(v.Problems, fmt.Sprintf("request %s: %d task(s) in queue %s", erased.RequestID, len(names), e.Queues[i].Name))
+			}
+		}
+	}
+
+	v.Verified = len(v.Problems) == 0
+	v.CheckedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	return v, nil
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// phoneLike matches digit sequences as phone numbers are usually written,
+// e.g. "555-123-4567", "(555) 123 4567", "+1 555.123.4567"
+var phoneLike = regexp.MustCompile(`\+?\d[\d\s().-]{5,}\d`)
+
+// MentionsPhoneNumber reports whether the Request's tags or transcripts
+// mention the phone number, however it's formatted
+func MentionsPhoneNumber(req *request.Request, phone string) bool {
+	want := NormalizePhoneNumber(phone)
+	if want == "" {
+		return false
+	}
+
+	for _, tag := range req.MatchedTags {
+		if NormalizePhoneNumber(tag.Quote) == want {
+			return true
+		}
+	}
+	transcripts := []string{req.WorkingTranscript, req.FinalTranscript}
+	if req.Structured != nil {
+		transcripts = append(transcripts, req.Structured.Text())
+	}
+	for _, transcript := range transcripts {
+		for _, candidate := range phoneLike.FindAllString(transcript, -1) {
+			if NormalizePhoneNumber(candidate) == want {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// NormalizePhoneNumber reduces a phone number to its digits, dropping the
+// North American country code "1" from 11-digit numbers
+func NormalizePhoneNumber(phone string) string {
+	var b strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	digits := b.String()
+	if len(digits) == 11 && digits[0] == '1' {
+		digits = digits[1:]
+	}
+	return digits
+}
+
+func hashValue(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,300 @@
+package erasure_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/audit"
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/erasure"
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/queue"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/webhook"
+)
+
+// fakeMedia holds the set of "gs://" media URIs that exist
+type fakeMedia map[string]bool
+
+func (f fakeMedia) Delete(ctx context.Context, uri string) error {
+	if !strings.HasPrefix(uri, "gs://") {
+		return media.ErrUnsupportedURI
+	}
+	if !f[uri] {
+		return media.ErrNotFound
+	}
+	delete(f, uri)
+	return nil
+}
+
+func (f fakeMedia) Exists(ctx context.Context, uri string) (bool, error) {
+	if !strings.HasPrefix(uri, "gs://") {
+		return false, media.ErrUnsupportedURI
+	}
+	return f[uri], nil
+}
+
+func (f fakeMedia) Get(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return nil, media.ErrNotFound
+}
+
+func (f fakeMedia) Put(ctx context.Context, uri string, r io.Reader, contentType string) error {
+	f[uri] = true
+	return nil
+}
+
+func (f fakeMedia) SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error) {
+	return "", media.ErrNoSigner
+}
+
+// fakeQueue holds pending task names by RequestID
+type fakeQueue map[uuid.UUID][]string
+
+func (f fakeQueue) Create(qi *queue.QueueInfo) error                              { return nil }
+func (f fakeQueue) Connect(qi *queue.QueueInfo) error                             { return nil }
+func (f fakeQueue) Add(qi *queue.QueueInfo, req *request.Request) error           { return nil }
+func (f fakeQueue) InfoFromConfig(qi *queue.QueueInfo) error                      { return nil }
+func (f fakeQueue) FindTasks(qi *queue.QueueInfo, id uuid.UUID) ([]string, error) { return f[id], nil }
+func (f fakeQueue) DeleteTask(qi *queue.QueueInfo, taskName string) error {
+	for id, names := range f {
+		for i, name := range names {
+			if name == taskName {
+				f[id] = append(names[:i], names[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (f fakeQueue) AddAfter(qi *queue.QueueInfo, req *request.Request, delay time.Duration) error {
+	return nil
+}
+
+type fakeAudit struct {
+	records []audit.Record
+}
+
+func (f *fakeAudit) Write(rec audit.Record) error {
+	f.records = append(f.records, rec)
+	return nil
+}
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	type test struct {
+		phone    string
+		expected string
+	}
+
+	tests := []test{
+		{"555-123-4567", "5551234567"},
+		{"(555) 123 4567", "5551234567"},
+		{"+1 555.123.4567", "5551234567"},
+		{"+44 20 7946 0958", "442079460958"},
+		{"call me", ""},
+	}
+
+	for _, tc := range tests {
+		if got := erasure.NormalizePhoneNumber(tc.phone); got != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.phone, tc.expected, got)
+		}
+	}
+}
+
+func TestErase(t *testing.T) {
+	requests := database.NewMemoryRequestRepository()
+	store := fakeMedia{}
+	tasks := fakeQueue{}
+
+	seed := func(customerID int, mediaURI string, status string, transcript string, tags map[string]request.Tags) uuid.UUID {
+		req := request.Request{
+			RequestID:       uuid.New(),
+			CustomerID:      customerID,
+			MediaFileURI:    mediaURI,
+			Status:          status,
+			AcceptedAt:      "2020-03-01T12:00:00Z",
+			FinalTranscript: transcript,
+			Structured:      &request.Transcript{Segments: []request.Segment{{Speaker: 1, Text: transcript}}},
+			MatchedTags:     tags,
+		}
+		_ = requests.Create(&req)
+		store[mediaURI] = true
+		return req.RequestID
+	}
+
+	tagged := seed(1234567, "gs://bucket/a.mp3", request.Completed, "[Speaker 1] my number is 555-123-4567",
+		map[string]request.Tags{"PHONE_NUMBER": {Quote: "555-123-4567", InfoType: "PHONE_NUMBER"}})
+	untagged := seed(1234567, "gs://bucket/b.mp3", request.Completed, "[Speaker 2] call (555) 123 4567 tomorrow", nil)
+	pending := seed(1234567, "gs://bucket/c.mp3", request.Pending, "", nil)
+	other := seed(1234567, "gs://bucket/d.mp3", request.Completed, "[Speaker 1] 555-987-6543", nil)
+	otherCustomer := seed(7654321, "gs://bucket/e.mp3", request.Completed, "[Speaker 1] 555-123-4567", nil)
+	tasks[pending] = []string{"queues/Tagging/tasks/1"}
+
+	// webhook events name the media
+	deliveries := database.NewMemoryDeliveryRepository()
+	hooked := func(id uuid.UUID, status string) *webhook.Delivery {
+		d := &webhook.Delivery{DeliveryID: uuid.New().String(), CustomerID: 1234567, RequestID: id.String(),
+			Payload: `{"data":{"media_uri":"gs://bucket/a.mp3"}}`, Status: status, NextAttemptAt: "2020-03-01T12:05:00Z", CreatedAt: "2020-03-01T12:00:00Z"}
+		_ = deliveries.Create(d)
+		return d
+	}
+	delivered := hooked(tagged, webhook.Delivered)
+	retrying := hooked(tagged, webhook.Pending)
+	untouched := hooked(other, webhook.Delivered)
+
+	fa := &fakeAudit{}
+	e := erasure.Eraser{
+		Requests:     requests,
+		Media:        store,
+		Queue:        tasks,
+		Queues:       []queue.QueueInfo{{Name: "queues/Tagging"}},
+		Certificates: database.NewMemoryCertificateRepository(),
+		Deliveries:   deliveries,
+		Audit:        fa,
+	}
+	ctx := context.Background()
+
+	type test struct {
+		name     string
+		sel      erasure.Selector
+		expected []uuid.UUID
+		err      error
+	}
+
+	tests := []test{
+		{name: "no selector", sel: erasure.Selector{CustomerID: 1234567}, err: erasure.ErrInvalidSelector},
+		{name: "two selectors", sel: erasure.Selector{CustomerID: 1234567, PhoneNumber: "5551234567", MediaURI: "gs://bucket/a.mp3"}, err: erasure.ErrInvalidSelector},
+		{name: "another customer's request", sel: erasure.Selector{CustomerID: 1234567, RequestID: otherCustomer.String()}, err: erasure.ErrNotFound},
+		{name: "unknown media", sel: erasure.Selector{CustomerID: 1234567, MediaURI: "gs://bucket/z.mp3"}, err: erasure.ErrNotFound},
+		{name: "by phone number", sel: erasure.Selector{CustomerID: 1234567, PhoneNumber: "+1 (555) 123-4567"}, expected: []uuid.UUID{tagged, untagged}},
+		{name: "by media", sel: erasure.Selector{CustomerID: 1234567, MediaURI: "gs://bucket/c.mp3"}, expected: []uuid.UUID{pending}},
+	}
+
+	for _, tc := range tests {
+		cert, err := e.Erase(ctx, tc.sel)
+		if err != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		got := map[string]bool{}
+		for _, erased := range cert.Requests {
+			got[erased.RequestID] = true
+		}
+		if len(got) != len(tc.expected) {
+			t.Errorf("%s: expected %d requests erased, got %+v", tc.name, len(tc.expected), cert.Requests)
+		}
+		for _, id := range tc.expected {
+			if !got[id.String()] {
+				t.Errorf("%s: expected %s erased, got %+v", tc.name, id, cert.Requests)
+			}
+		}
+		if len(cert.SelectorHash) != 64 {
+			t.Errorf("%s: expected hashed selector, got %q", tc.name, cert.SelectorHash)
+		}
+
+		// the follow-up query confirms it
+		stored, err := e.Certificates.FindByID(cert.CertificateID)
+		if err != nil {
+			t.Fatalf("%s: FindByID: %v", tc.name, err)
+		}
+		v, err := e.Verify(ctx, stored)
+		if err != nil || !v.Verified {
+			t.Errorf("%s: expected verified, got %+v, err %v", tc.name, v, err)
+		}
+	}
+
+	// erased requests keep no personal data, and pending ones won't complete
+	for _, id := range []uuid.UUID{tagged, untagged, pending} {
+		req, _ := requests.FindByID(id)
+		if req.ErasedAt == "" || req.MediaFileURI != "" || req.FinalTranscript != "" || req.Structured != nil || req.MatchedTags != nil {
+			t.Errorf("%s: expected erased, got %+v", id, req)
+		}
+		if req.Status == request.Pending {
+			t.Errorf("%s: expected no longer pending", id)
+		}
+	}
+	if len(tasks[pending]) != 0 {
+		t.Errorf("expected queued task deleted, got %v", tasks[pending])
+	}
+	for _, d := range []*webhook.Delivery{delivered, retrying} {
+		found, _ := deliveries.FindByID(d.DeliveryID)
+		if found.Payload != "" || found.ErasedAt == "" || found.Status == webhook.Pending {
+			t.Errorf("delivery %s: expected payload erased and never sent again, got %+v", d.DeliveryID, found)
+		}
+	}
+	if found, _ := deliveries.FindByID(untouched.DeliveryID); found.Payload == "" {
+		t.Errorf("delivery %s: expected untouched, got %+v", untouched.DeliveryID, found)
+	}
+	for _, id := range []uuid.UUID{other, otherCustomer} {
+		req, _ := requests.FindByID(id)
+		if req.ErasedAt != "" || req.FinalTranscript == "" {
+			t.Errorf("%s: expected untouched, got %+v", id, req)
+		}
+	}
+	if len(fa.records) != 3 {
+		t.Errorf("expected 3 audit records, got %d", len(fa.records))
+	}
+
+	// mediaFetch's copy of submitted media goes too
+	fetched := seed(1234567, "https://example.com/f.mp3", request.Completed, "", nil)
+	delete(store, "https://example.com/f.mp3")
+	req, _ := requests.FindByID(fetched)
+	req.WorkingMediaURI = "gs://bucket/media/1234567/f.mp3"
+	_ = requests.Update(req)
+	store[req.WorkingMediaURI] = true
+	cert, err := e.Erase(ctx, erasure.Selector{CustomerID: 1234567, MediaURI: "https://example.com/f.mp3"})
+	if err != nil || len(cert.Requests) != 1 || cert.Requests[0].WorkingMediaURI != "gs://bucket/media/1234567/f.mp3" {
+		t.Fatalf("fetched media: expected erased, got %+v, err %v", cert, err)
+	}
+	if erased := cert.Requests[0]; len(erased.MediaNotHeld) != 1 || erased.MediaNotHeld[0] != "https://example.com/f.mp3" || !erased.MediaDeleted {
+		t.Errorf("fetched media: expected the URL recorded as not held, and the copy deleted, got %+v", erased)
+	}
+	if store["gs://bucket/media/1234567/f.mp3"] {
+		t.Errorf("fetched media: expected copy deleted")
+	}
+	if req, _ = requests.FindByID(fetched); req.WorkingMediaURI != "" {
+		t.Errorf("fetched media: expected working_media_uri erased, got %q", req.WorkingMediaURI)
+	}
+	store["gs://bucket/media/1234567/f.mp3"] = true
+	if v, _ := e.Verify(ctx, cert); v.Verified {
+		t.Errorf("fetched media: expected verification to fail when the copy reappears, got %+v", v)
+	}
+
+	// and the chunks it was transcribed in
+	chunked := seed(1234567, "gs://bucket/g.mp3", request.Completed, "", nil)
+	req, _ = requests.FindByID(chunked)
+	req.Chunks = []request.Chunk{{MediaURI: "gs://bucket/media/1234567/g-chunk-0.flac", TranscriptURI: "gs://bucket/media/1234567/g-chunk-0.flac.json"}}
+	_ = requests.Update(req)
+	store[req.Chunks[0].MediaURI], store[req.Chunks[0].TranscriptURI] = true, true
+	cert, err = e.Erase(ctx, erasure.Selector{CustomerID: 1234567, MediaURI: "gs://bucket/g.mp3"})
+	if err != nil || store[req.Chunks[0].MediaURI] || store[req.Chunks[0].TranscriptURI] {
+		t.Fatalf("chunks: expected deleted, got %v, err %v", store, err)
+	}
+	store[req.Chunks[0].TranscriptURI] = true
+	if v, _ := e.Verify(ctx, cert); v.Verified {
+		t.Errorf("chunks: expected verification to fail when a chunk's transcript reappears, got %+v", v)
+	}
+
+	// verification catches data that reappears
+	cert, _ = e.Erase(ctx, erasure.Selector{CustomerID: 1234567, MediaURI: "gs://bucket/d.mp3"})
+	store["gs://bucket/d.mp3"] = true
+	if v, _ := e.Verify(ctx, cert); v.Verified {
+		t.Errorf("expected verification to fail when media reappears, got %+v", v)
+	}
+	cert, _ = e.Erase(ctx, erasure.Selector{CustomerID: 1234567, RequestID: tagged.String()})
+	d, _ := deliveries.FindByID(delivered.DeliveryID)
+	d.Payload = `{"data":{"media_uri":"gs://bucket/a.mp3"}}`
+	_ = deliveries.Update(d)
+	if v, _ := e.Verify(ctx, cert); v.Verified {
+		t.Errorf("expected verification to fail when a webhook payload reappears, got %+v", v)
+	}
+}
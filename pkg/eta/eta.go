@@ -0,0 +1,330 @@
+// Package eta predicts when a pending Request will complete, from the
+// per-stage durations recorded in the Timestamps of recently completed
+// Requests, the media's duration, and how many Requests are waiting ahead
+package eta
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// Stages is the pipeline, in order, each by the name its service records
+// in Timestamps as "Begin<stage>" and "End<stage>"
+var Stages = []string{
+	"InitialRequest",
+	"MediaFetch",
+	"MediaConvert",
+	"ServiceDispatch",
+	"TranscriptionGCP",
+	"TranscriptionComplete",
+	"TranscriptionQA",
+	"TranscriptionQAComplete",
+	"Tagging",
+	"TaggingComplete",
+	"TaggingQA",
+	"TaggingQAComplete",
+	"CompletionProcessing",
+}
+
+// mediaStages take time in proportion to the media's duration
+var mediaStages = map[string]bool{
+	"MediaFetch":       true,
+	"MediaConvert":     true,
+	"TranscriptionGCP": true,
+}
+
+const (
+	DefaultSamples     = 100              // recently completed Requests modelled
+	DefaultRefresh     = time.Minute      // how long a model is used before it's rebuilt
+	DefaultConcurrency = 10               // Requests each stage processes at once
+	maxPendingPages    = 10               // of request.MaxListLimit, counting queue depth
+	fallbackETA        = 45 * time.Second // with nothing to go on
+	fallbackEarliest   = 15 * time.Second
+	fallbackLatest     = 2 * time.Minute
+)
+
+// the quantiles of stage durations taken as the earliest, expected and
+// latest completion: an 80% band around the median
+const (
+	lowQuantile  = 0.1
+	midQuantile  = 0.5
+	highQuantile = 0.9
+)
+
+// Estimate is when a Request is expected to complete, and the band it will
+// most likely complete within
+type Estimate struct {
+	ETA      time.Time
+	Earliest time.Time
+	Latest   time.Time
+	Samples  int // completed Requests the estimate is based on, 0 for a guess
+}
+
+// Estimator predicts Estimates from the Requests in Requests. It reads
+// only their stages, timestamps and media durations, so Requests needn't
+// open transcripts.
+type Estimator struct {
+	Requests    request.RequestRepository
+	Samples     int
+	Refresh     time.Duration
+	Concurrency int
+
+	mu          sync.Mutex
+	model       *Model
+	refreshedAt time.Time
+}
+
+// NewEstimator returns an Estimator with the default settings
+func NewEstimator(repo request.RequestRepository) *Estimator {
+	return &Estimator{
+		Requests:    repo,
+		Samples:     DefaultSamples,
+		Refresh:     DefaultRefresh,
+		Concurrency: DefaultConcurrency,
+	}
+}
+
+// Estimate predicts when req will complete. Errors reading Requests are
+// logged, and the previous model (or a guess) used instead.
+func (e *Estimator) Estimate(req *request.Request, now time.Time) Estimate {
+	return e.current(now).Estimate(req, now)
+}
+
+// current returns the model, rebuilding it if older than Refresh
+func (e *Estimator) current(now time.Time) *Model {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.model != nil && now.Sub(e.refreshedAt) < e.Refresh {
+		return e.model
+	}
+	model, err := e.build()
+	if err != nil {
+		log.Printf("%s.eta.Estimator, build error: %v\n", serviceInfo.GetServiceName(), err)
+		if e.model == nil {
+			e.model = &Model{}
+		}
+		e.refreshedAt = now // try again after Refresh, not on every call
+		return e.model
+	}
+	e.model = model
+	e.refreshedAt = now
+	return model
+}
+
+// build reads recently completed Requests, and all pending ones
+func (e *Estimator) build() (*Model, error) {
+	completed, err := e.Requests.List(request.ListOptions{
+		Status:     request.Completed,
+		OrderBy:    "completed_at",
+		Descending: true,
+		Limit:      e.Samples,
+	})
+	if err != nil {
+		return nil, err
+	}
+	model := NewModel(completed.Requests, e.Concurrency)
+
+	opts := request.ListOptions{Status: request.Pending, Limit: request.MaxListLimit}
+	for page := 0; page < maxPendingPages; page++ {
+		pending, err := e.Requests.List(opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, req := range pending.Requests {
+			model.waiting[req.Stage]++
+		}
+		if pending.NextCursor == "" {
+			break
+		}
+		opts.Cursor = pending.NextCursor
+	}
+
+	return model, nil
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// Model holds the stage durations of completed Requests, and how many
+// pending Requests last completed each stage
+type Model struct {
+	stages      map[string]*stageTimes
+	waiting     map[string]int
+	samples     int
+	concurrency int
+}
+
+// stageTimes holds, sorted, how long a stage took after the one before
+// it, including time queued, and for mediaStages per second of media
+type stageTimes struct {
+	elapsed  []time.Duration
+	perMedia []float64 // seconds per second of media
+}
+
+// NewModel returns a Model of the stage durations in completed, for stages
+// processing concurrency Requests at once
+func NewModel(completed []*request.Request, concurrency int) *Model {
+	m := &Model{
+		stages:      make(map[string]*stageTimes),
+		waiting:     make(map[string]int),
+		concurrency: concurrency,
+	}
+	if m.concurrency < 1 {
+		m.concurrency = 1
+	}
+
+	for _, req := range completed {
+		prev, err := time.Parse(time.RFC3339Nano, req.AcceptedAt)
+		if err != nil {
+			continue
+		}
+		sampled := false
+		for _, stage := range Stages {
+			end, err := time.Parse(time.RFC3339Nano, req.Timestamps["End"+stage])
+			if err != nil {
+				continue // stage skipped, or not recorded
+			}
+			elapsed := end.Sub(prev)
+			prev = end
+			if elapsed < 0 {
+				continue
+			}
+			st := m.stages[stage]
+			if st == nil {
+				st = &stageTimes{}
+				m.stages[stage] = st
+			}
+			st.elapsed = append(st.elapsed, elapsed)
+			if mediaStages[stage] && req.MediaSeconds > 0 {
+				st.perMedia = append(st.perMedia, elapsed.Seconds()/req.MediaSeconds)
+			}
+			sampled = true
+		}
+		if sampled {
+			m.samples++
+		}
+	}
+
+	for _, st := range m.stages {
+		sort.Slice(st.elapsed, func(i, j int) bool { return st.elapsed[i] < st.elapsed[j] })
+		sort.Float64s(st.perMedia)
+	}
+	return m
+}
+
+// Estimate predicts when req will complete: the stage durations still
+// ahead of it, from when it completed its last stage, plus the wait for
+// those queued ahead of it beyond what its next stage processes at once
+func (m *Model) Estimate(req *request.Request, now time.Time) Estimate {
+	since := lastStageEnd(req, now)
+	remaining := remainingStages(req.Stage)
+
+	if m.samples == 0 {
+		return clamp(Estimate{
+			ETA:      since.Add(fallbackETA),
+			Earliest: since.Add(fallbackEarliest),
+			Latest:   since.Add(fallbackLatest),
+		}, now)
+	}
+
+	var low, mid, high time.Duration
+	for _, stage := range remaining {
+		st := m.stages[stage]
+		if st == nil {
+			continue // never seen to take time
+		}
+		if mediaStages[stage] && req.MediaSeconds > 0 && len(st.perMedia) > 0 {
+			low += scale(st.perMedia, lowQuantile, req.MediaSeconds)
+			mid += scale(st.perMedia, midQuantile, req.MediaSeconds)
+			high += scale(st.perMedia, highQuantile, req.MediaSeconds)
+			continue
+		}
+		low += quantile(st.elapsed, lowQuantile)
+		mid += quantile(st.elapsed, midQuantile)
+		high += quantile(st.elapsed, highQuantile)
+	}
+
+	// those ahead in the next stage's queue beyond its concurrency each
+	// hold up a slot for that stage's usual time
+	if len(remaining) > 0 {
+		if st := m.stages[remaining[0]]; st != nil {
+			if ahead := m.waiting[req.Stage] - m.concurrency; ahead > 0 {
+				rounds := time.Duration((ahead + m.concurrency - 1) / m.concurrency)
+				mid += rounds * quantile(st.elapsed, midQuantile)
+				high += rounds * quantile(st.elapsed, highQuantile)
+			}
+		}
+	}
+
+	return clamp(Estimate{
+		ETA:      since.Add(mid),
+		Earliest: since.Add(low),
+		Latest:   since.Add(high),
+		Samples:  m.samples,
+	}, now)
+}
+
+// remainingStages returns the Stages after stage, all of them if stage
+// isn't one (e.g. "" or "Default" when just accepted)
+func remainingStages(stage string) []string {
+	for i, s := range Stages {
+		if s == stage {
+			return Stages[i+1:]
+		}
+	}
+	return Stages
+}
+
+// lastStageEnd is when req completed its last stage, or was accepted
+func lastStageEnd(req *request.Request, now time.Time) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, req.Timestamps["End"+req.Stage]); err == nil && req.Stage != "" {
+		return t
+	}
+	if t, err := time.Parse(time.RFC3339Nano, req.AcceptedAt); err == nil {
+		return t
+	}
+	return now
+}
+
+// clamp moves an overdue Estimate to now: it's still expected any moment
+func clamp(e Estimate, now time.Time) Estimate {
+	if e.Earliest.Before(now) {
+		e.Earliest = now
+	}
+	if e.ETA.Before(now) {
+		e.ETA = now
+	}
+	if e.Latest.Before(now) {
+		e.Latest = now
+	}
+	return e
+}
+
+// quantile returns the q quantile of sorted, by nearest rank
+func quantile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[rank(len(sorted), q)]
+}
+
+// scale returns the q quantile of sorted rates, times seconds of media
+func scale(sorted []float64, q float64, seconds float64) time.Duration {
+	return time.Duration(sorted[rank(len(sorted), q)] * seconds * float64(time.Second))
+}
+
+func rank(n int, q float64) int {
+	i := int(q*float64(n)+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= n {
+		i = n - 1
+	}
+	return i
+}
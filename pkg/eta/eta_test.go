@@ -0,0 +1,174 @@
+package eta_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/eta"
+	"github.com/peterpla/lead-expert/pkg/request"
+)
+
+var base = time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// completedRequest took 2s for each stage, but TranscriptionGCP took half
+// the media's duration
+func completedRequest(accepted time.Time, mediaSeconds float64) *request.Request {
+	req := &request.Request{
+		RequestID:    uuid.New(),
+		CustomerID:   1234567,
+		MediaFileURI: "gs://bucket/audio.mp3",
+		MediaSeconds: mediaSeconds,
+		Status:       request.Completed,
+		AcceptedAt:   accepted.Format(time.RFC3339Nano),
+		Timestamps:   make(map[string]string),
+	}
+	t := accepted
+	for _, stage := range eta.Stages {
+		if stage == "TranscriptionGCP" {
+			t = t.Add(time.Duration(mediaSeconds/2) * time.Second)
+		} else {
+			t = t.Add(2 * time.Second)
+		}
+		req.Timestamps["End"+stage] = t.Format(time.RFC3339Nano)
+	}
+	req.CompletedAt = t.Format(time.RFC3339Nano)
+	return req
+}
+
+// pendingRequest completed stage at done
+func pendingRequest(stage string, done time.Time, mediaSeconds float64) *request.Request {
+	req := &request.Request{
+		RequestID:    uuid.New(),
+		CustomerID:   1234567,
+		MediaFileURI: "gs://bucket/audio.mp3",
+		MediaSeconds: mediaSeconds,
+		Status:       request.Pending,
+		Stage:        stage,
+		AcceptedAt:   done.Add(-time.Second).Format(time.RFC3339Nano),
+		Timestamps:   map[string]string{"End" + stage: done.Format(time.RFC3339Nano)},
+	}
+	return req
+}
+
+func TestModel(t *testing.T) {
+	var completed []*request.Request
+	for i := 0; i < 10; i++ {
+		completed = append(completed, completedRequest(base.Add(-time.Hour), 30))
+	}
+	model := eta.NewModel(completed, eta.DefaultConcurrency)
+	now := base
+
+	type test struct {
+		name string
+		req  *request.Request
+		eta  time.Duration // from now
+	}
+
+	tests := []test{
+		{name: "just accepted", req: &request.Request{AcceptedAt: now.Format(time.RFC3339Nano)}, eta: 12*2*time.Second + 15*time.Second},
+		{name: "media unknown", req: pendingRequest("ServiceDispatch", now, 0), eta: 15*time.Second + 8*2*time.Second},
+		{name: "longer media", req: pendingRequest("ServiceDispatch", now, 120), eta: 60*time.Second + 8*2*time.Second},
+		{name: "after transcription", req: pendingRequest("TranscriptionComplete", now, 120), eta: 7 * 2 * time.Second},
+		{name: "in progress", req: pendingRequest("TranscriptionComplete", now.Add(-4*time.Second), 120), eta: 7*2*time.Second - 4*time.Second},
+		{name: "overdue", req: pendingRequest("TaggingQAComplete", now.Add(-time.Minute), 120), eta: 0},
+		{name: "last stage", req: pendingRequest("CompletionProcessing", now, 120), eta: 0},
+	}
+
+	for _, tc := range tests {
+		e := model.Estimate(tc.req, now)
+		if got := e.ETA.Sub(now); got != tc.eta {
+			t.Errorf("%s: expected ETA in %v, got %v", tc.name, tc.eta, got)
+		}
+		if e.Earliest.After(e.ETA) || e.Latest.Before(e.ETA) || e.Earliest.Before(now) {
+			t.Errorf("%s: expected now <= %v <= %v <= %v", tc.name, e.Earliest, e.ETA, e.Latest)
+		}
+		if e.Samples != len(completed) {
+			t.Errorf("%s: expected %d samples, got %d", tc.name, len(completed), e.Samples)
+		}
+	}
+
+	// slower samples widen the band
+	for i := 0; i < 2; i++ {
+		completed = append(completed, completedRequest(base.Add(-time.Hour), 300))
+	}
+	e := eta.NewModel(completed, eta.DefaultConcurrency).Estimate(pendingRequest("ServiceDispatch", now, 0), now)
+	if !e.Latest.After(e.ETA) {
+		t.Errorf("expected latest %v after ETA %v", e.Latest, e.ETA)
+	}
+
+	// with nothing to go on, a guess
+	e = eta.NewModel(nil, eta.DefaultConcurrency).Estimate(pendingRequest("ServiceDispatch", now, 0), now)
+	if e.Samples != 0 || e.ETA.Sub(now) != 45*time.Second {
+		t.Errorf("expected a 45s guess, got %v from %d samples", e.ETA.Sub(now), e.Samples)
+	}
+}
+
+func TestEstimator(t *testing.T) {
+	repo := database.NewMemoryRequestRepository()
+	for i := 0; i < 5; i++ {
+		_ = repo.Create(completedRequest(base.Add(-time.Hour), 30))
+	}
+
+	// 5 waiting for TranscriptionGCP, 2 at a time: the last waits 2 rounds
+	var waiting []*request.Request
+	for i := 0; i < 5; i++ {
+		req := pendingRequest("ServiceDispatch", base, 30)
+		waiting = append(waiting, req)
+		_ = repo.Create(req)
+	}
+
+	estimator := eta.NewEstimator(repo)
+	estimator.Concurrency = 2
+
+	e := estimator.Estimate(waiting[0], base)
+	expected := 15*time.Second + 8*2*time.Second + 2*15*time.Second
+	if got := e.ETA.Sub(base); got != expected {
+		t.Errorf("queued: expected ETA in %v, got %v", expected, got)
+	}
+
+	// the model is reused until Refresh
+	for i := 0; i < 5; i++ {
+		_ = repo.Create(completedRequest(base.Add(-time.Hour), 300))
+	}
+	if e = estimator.Estimate(waiting[0], base.Add(time.Second)); e.Samples != 5 {
+		t.Errorf("expected the cached model's 5 samples, got %d", e.Samples)
+	}
+	if e = estimator.Estimate(waiting[0], base.Add(eta.DefaultRefresh)); e.Samples != 10 {
+		t.Errorf("expected a rebuilt model's 10 samples, got %d", e.Samples)
+	}
+}
+
+// failingRepository fails every List, counting them
+type failingRepository struct {
+	request.RequestRepository
+	lists int
+}
+
+func (r *failingRepository) List(opts request.ListOptions) (*request.ListResult, error) {
+	r.lists++
+	return &request.ListResult{}, errors.New("unavailable")
+}
+
+func TestEstimatorBuildError(t *testing.T) {
+	repo := &failingRepository{RequestRepository: database.NewMemoryRequestRepository()}
+	estimator := eta.NewEstimator(repo)
+	req := pendingRequest("ServiceDispatch", base, 30)
+
+	// a guess, and no more Lists until Refresh
+	for i := 0; i < 3; i++ {
+		if e := estimator.Estimate(req, base.Add(time.Duration(i)*time.Second)); e.Samples != 0 {
+			t.Errorf("expected a guess, got %d samples", e.Samples)
+		}
+	}
+	if repo.lists != 1 {
+		t.Errorf("expected 1 List before Refresh, got %d", repo.lists)
+	}
+	estimator.Estimate(req, base.Add(eta.DefaultRefresh))
+	if repo.lists != 2 {
+		t.Errorf("expected another List after Refresh, got %d in all", repo.lists)
+	}
+}
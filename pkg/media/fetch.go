@@ -0,0 +1,310 @@
+package media
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// ErrTooLarge - the media file is larger than the Fetcher's MaxBytes
+var ErrTooLarge = errors.New("Media file too large")
+
+// ErrNotMedia - the file isn't audio or video
+var ErrNotMedia = errors.New("Not an audio or video file")
+
+// ErrUnavailable - the server refused the file (e.g. 403, 404, or an
+// expired signed URL); retrying won't help
+var ErrUnavailable = errors.New("Media file unavailable")
+
+// ErrForbiddenAddress - the URI names a loopback, private or link-local
+// address, e.g. the metadata server
+var ErrForbiddenAddress = errors.New("Media URI address not allowed")
+
+const (
+	DefaultMaxBytes     = 500 << 20        // about 8 hours of 128 kbps MP3
+	DefaultFetchTimeout = 10 * time.Minute // for the whole download
+	sniffLen            = 512              // bytes http.DetectContentType considers
+)
+
+// Fetcher downloads "http://" and "https://" media files into Store, and
+// copies media from elsewhere in Store, e.g. a customer's own bucket
+type Fetcher struct {
+	Store        Store
+	MaxBytes     int64
+	Timeout      time.Duration
+	AllowPrivate bool         // fetch from private addresses, for tests
+	Client       *http.Client // nil for one refusing private addresses
+}
+
+// NewFetcher returns a Fetcher with the default limits
+func NewFetcher(store Store) *Fetcher {
+	return &Fetcher{
+		Store:    store,
+		MaxBytes: DefaultMaxBytes,
+		Timeout:  DefaultFetchTimeout,
+	}
+}
+
+// Fetched describes a media file copied into the Store
+type Fetched struct {
+	URI         string // where in the Store
+	ContentType string // as sniffed, not as the server said
+	Bytes       int64
+}
+
+// Permanent reports whether err means fetching will never succeed, rather
+// than a network or server error worth retrying
+func Permanent(err error) bool {
+	switch err {
+	case ErrTooLarge, ErrNotMedia, ErrUnavailable, ErrForbiddenAddress, ErrUnsupportedURI:
+		return true
+	}
+	return false
+}
+
+// Fetch streams the media file at src into the Store at dst plus an
+// extension for its type, e.g. ".mp3". Dropbox shared links are fetched
+// as downloads; signed URLs (e.g. presigned S3) are fetched as given.
+func (f *Fetcher) Fetch(ctx context.Context, src, dst string) (*Fetched, error) {
+	sn := serviceInfo.GetServiceName()
+
+	u, err := FetchURL(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, ErrUnsupportedURI
+	}
+	resp, err := f.client().Do(req.WithContext(ctx))
+	if err != nil {
+		var addrErr *forbiddenAddressError
+		if errors.As(err, &addrErr) {
+			return nil, ErrForbiddenAddress
+		}
+		log.Printf("%s.media.Fetch, GET %s error: %v\n", sn, redact(u), err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout:
+		return nil, fmt.Errorf("GET %s: %s", redact(u), resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		log.Printf("%s.media.Fetch, GET %s: %s\n", sn, redact(u), resp.Status)
+		return nil, ErrUnavailable
+	}
+	if f.MaxBytes > 0 && resp.ContentLength > f.MaxBytes {
+		return nil, ErrTooLarge
+	}
+
+	return f.keep(ctx, resp.Body, redact(u), dst)
+}
+
+// Copy copies the media file at src, a URI in Store outside dst's
+// location, e.g. in the customer's own bucket, to dst plus an extension
+// for its type. It's checked as Fetch checks a download.
+func (f *Fetcher) Copy(ctx context.Context, src, dst string) (*Fetched, error) {
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+
+	r, err := f.Store.Get(ctx, src)
+	if err == ErrNotFound {
+		return nil, ErrUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return f.keep(ctx, r, src, dst)
+}
+
+// keep stores the media file read from r, from src, at dst plus an
+// extension for its type, if it's media and within MaxBytes
+func (f *Fetcher) keep(ctx context.Context, r io.Reader, src, dst string) (*Fetched, error) {
+	sn := serviceInfo.GetServiceName()
+
+	// the type is sniffed, as shared links are often served as
+	// application/octet-stream or text/html
+	body := bufio.NewReaderSize(r, sniffLen)
+	head, err := body.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	contentType, ext, ok := SniffMedia(head)
+	if !ok {
+		log.Printf("%s.media.Fetcher, %s is %q, not media\n", sn, src, http.DetectContentType(head))
+		return nil, ErrNotMedia
+	}
+
+	fetched := &Fetched{URI: dst + ext, ContentType: contentType}
+	lr := &limitedReader{r: body, remaining: f.MaxBytes, n: &fetched.Bytes}
+	if err := f.Store.Put(ctx, fetched.URI, lr, contentType); err != nil {
+		if err != ErrTooLarge {
+			log.Printf("%s.media.Fetcher, Put(%q) error: %v\n", sn, fetched.URI, err)
+		}
+		return nil, err
+	}
+
+	return fetched, nil
+}
+
+func (f *Fetcher) client() *http.Client {
+	if f.Client != nil {
+		return f.Client
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if !f.AllowPrivate {
+		dialer.Control = RefusePrivate
+	}
+	transport := &http.Transport{
+		Proxy:                 nil, // a proxy would connect on our behalf, unchecked
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+	}
+	return &http.Client{Transport: transport}
+}
+
+// FetchURL returns the URL to download src from: Dropbox shared links
+// ("?dl=0") are changed to download the file ("?dl=1") rather than the
+// page previewing it
+func FetchURL(src string) (string, error) {
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", ErrUnsupportedURI
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "dropbox.com" || host == "www.dropbox.com" {
+		q := u.Query()
+		q.Set("dl", "1")
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+// SniffMedia returns the content type of an audio or video file from its
+// first bytes, and the extension to store it with
+func SniffMedia(head []byte) (contentType string, ext string, ok bool) {
+	// MPEG audio frames without an ID3 tag, and FLAC, aren't sniffed by
+	// http.DetectContentType
+	switch {
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0 && head[1]&0x06 != 0:
+		return "audio/mpeg", ".mp3", true
+	case strings.HasPrefix(string(head), "fLaC"):
+		return "audio/flac", ".flac", true
+	}
+
+	contentType = http.DetectContentType(head)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	switch contentType {
+	case "audio/mpeg":
+		return contentType, ".mp3", true
+	case "audio/wave":
+		return contentType, ".wav", true
+	case "audio/aiff":
+		return contentType, ".aiff", true
+	case "audio/basic":
+		return contentType, ".au", true
+	case "application/ogg":
+		return contentType, ".ogg", true
+	case "video/mp4":
+		return contentType, ".mp4", true
+	case "video/webm":
+		return contentType, ".webm", true
+	case "audio/amr":
+		return contentType, ".amr", true
+	}
+	return contentType, "", false
+}
+
+// limitedReader returns ErrTooLarge once more than remaining bytes are
+// read, counting them in n
+type limitedReader struct {
+	r         io.Reader
+	remaining int64 // 0 for no limit
+	n         *int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	*l.n += int64(n)
+	if l.remaining > 0 && *l.n > l.remaining {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// forbiddenAddressError is returned by RefusePrivate, through the dialer
+type forbiddenAddressError struct {
+	address string
+}
+
+func (e *forbiddenAddressError) Error() string {
+	return "address not allowed: " + e.address
+}
+
+var privateNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7", "fe80::/10",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// RefusePrivate is a net.Dialer Control refusing to connect to private
+// addresses, e.g. the metadata server. It checks the address resolved, so
+// neither DNS names nor redirects get around it.
+func RefusePrivate(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return &forbiddenAddressError{address: address}
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return &forbiddenAddressError{address: address}
+		}
+	}
+	return nil
+}
+
+// redact drops a URL's query, which for signed URLs holds credentials
+func redact(u string) string {
+	if i := strings.Index(u, "?"); i >= 0 {
+		return u[:i] + "?..."
+	}
+	return u
+}
@@ -0,0 +1,188 @@
+package media_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/media"
+)
+
+// memoryStore holds what's Put, by URI
+type memoryStore map[string][]byte
+
+func (m memoryStore) Delete(ctx context.Context, uri string) error {
+	if _, ok := m[uri]; !ok {
+		return media.ErrNotFound
+	}
+	delete(m, uri)
+	return nil
+}
+
+func (m memoryStore) Exists(ctx context.Context, uri string) (bool, error) {
+	_, ok := m[uri]
+	return ok, nil
+}
+
+func (m memoryStore) Get(ctx context.Context, uri string) (io.ReadCloser, error) {
+	data, ok := m[uri]
+	if !ok {
+		return nil, media.ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m memoryStore) Put(ctx context.Context, uri string, r io.Reader, contentType string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m[uri] = data
+	return nil
+}
+
+func (m memoryStore) SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error) {
+	return "", media.ErrNoSigner
+}
+
+// mp3 is the start of an MP3 file with an ID3 tag
+var mp3 = append([]byte("ID3\x03\x00\x00\x00\x00\x00\x00"), bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x64}, 100)...)
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/audio.mp3":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(mp3)
+		case "/page.html":
+			_, _ = w.Write([]byte("<html><body>not audio</body></html>"))
+		case "/large.mp3":
+			_, _ = w.Write(mp3)
+			_, _ = w.Write(make([]byte, 2048))
+		case "/slow.mp3":
+			_, _ = w.Write(mp3)
+			w.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+		case "/expired.mp3":
+			http.Error(w, "Request has expired", http.StatusForbidden)
+		case "/busy.mp3":
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	type test struct {
+		name      string
+		src       string
+		uri       string // where it's stored
+		err       error
+		temporary bool // any error worth retrying
+	}
+
+	tests := []test{
+		{name: "audio", src: server.URL + "/audio.mp3", uri: "gs://bucket/media/1.mp3"},
+		{name: "signed URL", src: server.URL + "/audio.mp3?X-Amz-Signature=abc&X-Amz-Expires=300", uri: "gs://bucket/media/1.mp3"},
+		{name: "not media", src: server.URL + "/page.html", err: media.ErrNotMedia},
+		{name: "too large", src: server.URL + "/large.mp3", err: media.ErrTooLarge},
+		{name: "too slow", src: server.URL + "/slow.mp3", temporary: true},
+		{name: "busy", src: server.URL + "/busy.mp3", temporary: true},
+		{name: "expired", src: server.URL + "/expired.mp3", err: media.ErrUnavailable},
+		{name: "not found", src: server.URL + "/missing.mp3", err: media.ErrUnavailable},
+		{name: "unsupported", src: "ftp://example.com/audio.mp3", err: media.ErrUnsupportedURI},
+	}
+
+	for _, tc := range tests {
+		store := memoryStore{}
+		f := media.NewFetcher(store)
+		f.MaxBytes = int64(len(mp3)) + 1024
+		f.Timeout = 100 * time.Millisecond
+		f.AllowPrivate = true
+
+		fetched, err := f.Fetch(context.Background(), tc.src, "gs://bucket/media/1")
+		if tc.temporary {
+			if err == nil || media.Permanent(err) {
+				t.Errorf("%s: expected an error worth retrying, got %v", tc.name, err)
+			}
+			continue
+		}
+		if err != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, err)
+			continue
+		}
+		if err != nil {
+			if len(store) != 0 {
+				t.Errorf("%s: expected nothing stored, got %d files", tc.name, len(store))
+			}
+			continue
+		}
+		if fetched.URI != tc.uri || fetched.ContentType != "audio/mpeg" || fetched.Bytes != int64(len(mp3)) {
+			t.Errorf("%s: expected %d bytes of audio/mpeg at %q, got %+v", tc.name, len(mp3), tc.uri, fetched)
+		}
+		if !bytes.Equal(store[tc.uri], mp3) {
+			t.Errorf("%s: expected the file stored at %q, got %v", tc.name, tc.uri, store)
+		}
+	}
+
+	// our own network, e.g. the metadata server, is off limits
+	f := media.NewFetcher(memoryStore{})
+	if _, err := f.Fetch(context.Background(), server.URL+"/audio.mp3", "gs://bucket/media/1"); err != media.ErrForbiddenAddress {
+		t.Errorf("private address: expected %v, got %v", media.ErrForbiddenAddress, err)
+	}
+}
+
+func TestFetchURL(t *testing.T) {
+	type test struct {
+		src      string
+		expected string
+		err      error
+	}
+
+	tests := []test{
+		{src: "https://www.dropbox.com/s/nkyhmhvomfh3ogk/audio-01.mp3?dl=0", expected: "https://www.dropbox.com/s/nkyhmhvomfh3ogk/audio-01.mp3?dl=1"},
+		{src: "https://dropbox.com/s/nkyhmhvomfh3ogk/audio-01.mp3", expected: "https://dropbox.com/s/nkyhmhvomfh3ogk/audio-01.mp3?dl=1"},
+		{src: "https://bucket.s3.amazonaws.com/audio-01.mp3?X-Amz-Expires=300&dl=0", expected: "https://bucket.s3.amazonaws.com/audio-01.mp3?X-Amz-Expires=300&dl=0"},
+		{src: "gs://bucket/audio-01.mp3", err: media.ErrUnsupportedURI},
+		{src: "https:///audio-01.mp3", err: media.ErrUnsupportedURI},
+	}
+
+	for _, tc := range tests {
+		got, err := media.FetchURL(tc.src)
+		if err != tc.err || got != tc.expected {
+			t.Errorf("%s: expected %q, %v, got %q, %v", tc.src, tc.expected, tc.err, got, err)
+		}
+	}
+}
+
+func TestSniffMedia(t *testing.T) {
+	type test struct {
+		name        string
+		head        []byte
+		contentType string
+		ext         string
+		ok          bool
+	}
+
+	tests := []test{
+		{name: "mp3 with ID3", head: mp3, contentType: "audio/mpeg", ext: ".mp3", ok: true},
+		{name: "mp3 frames", head: []byte{0xFF, 0xFB, 0x90, 0x64, 0x00}, contentType: "audio/mpeg", ext: ".mp3", ok: true},
+		{name: "wav", head: []byte("RIFF\x24\x08\x00\x00WAVEfmt "), contentType: "audio/wave", ext: ".wav", ok: true},
+		{name: "flac", head: []byte("fLaC\x00\x00\x00\x22"), contentType: "audio/flac", ext: ".flac", ok: true},
+		{name: "ogg", head: []byte("OggS\x00\x02\x00\x00"), contentType: "application/ogg", ext: ".ogg", ok: true},
+		{name: "html", head: []byte("<!DOCTYPE html><html>"), contentType: "text/html", ok: false},
+		{name: "empty", head: []byte{}, contentType: "text/plain", ok: false},
+	}
+
+	for _, tc := range tests {
+		contentType, ext, ok := media.SniffMedia(tc.head)
+		if contentType != tc.contentType || ext != tc.ext || ok != tc.ok {
+			t.Errorf("%s: expected %q, %q, %t, got %q, %q, %t", tc.name, tc.contentType, tc.ext, tc.ok, contentType, ext, ok)
+		}
+	}
+}
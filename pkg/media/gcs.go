@@ -0,0 +1,213 @@
+package media
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	credentials "cloud.google.com/go/iam/credentials/apiv1"
+	"cloud.google.com/go/storage"
+	credentialspb "google.golang.org/genproto/googleapis/iam/credentials/v1"
+
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// gcsStore implements Store for Google Cloud Storage "gs://bucket/object" URIs
+type gcsStore struct {
+	signer string // service account signing URLs
+}
+
+// NewGCSStore returns the Cloud Storage Store. signer is the email of the
+// service account URLs are signed as, through the IAM Credentials API, as
+// App Engine has no private key to sign with; "" if URLs aren't signed.
+func NewGCSStore(signer string) Store {
+	return gcsStore{signer: signer}
+}
+
+// Delete removes the object, returning ErrNotFound if it doesn't exist
+func (s gcsStore) Delete(ctx context.Context, uri string) error {
+	sn := serviceInfo.GetServiceName()
+
+	bucket, object, err := ParseGCSURI(uri)
+	if err != nil {
+		return err
+	}
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Printf("%s.media.gcsStore.Delete, storage.NewClient error: %v\n", sn, err)
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Bucket(bucket).Object(object).Delete(ctx); err != nil {
+		if err == storage.ErrObjectNotExist {
+			return ErrNotFound
+		}
+		log.Printf("%s.media.gcsStore.Delete, Delete(%q) error: %v\n", sn, uri, err)
+		return err
+	}
+
+	return nil
+}
+
+// Get opens the object for reading, returning ErrNotFound if it doesn't
+// exist
+func (s gcsStore) Get(ctx context.Context, uri string) (io.ReadCloser, error) {
+	sn := serviceInfo.GetServiceName()
+
+	bucket, object, err := ParseGCSURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Printf("%s.media.gcsStore.Get, storage.NewClient error: %v\n", sn, err)
+		return nil, err
+	}
+
+	or, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		client.Close()
+		if err == storage.ErrObjectNotExist {
+			return nil, ErrNotFound
+		}
+		log.Printf("%s.media.gcsStore.Get, NewReader(%q) error: %v\n", sn, uri, err)
+		return nil, err
+	}
+
+	return &gcsReader{Reader: or, client: client}, nil
+}
+
+// gcsReader closes the client along with the object
+type gcsReader struct {
+	*storage.Reader
+	client *storage.Client
+}
+
+func (r *gcsReader) Close() error {
+	err := r.Reader.Close()
+	r.client.Close()
+	return err
+}
+
+// Put streams r into the object, replacing any already there. The object
+// is written only if all of r is.
+func (s gcsStore) Put(ctx context.Context, uri string, r io.Reader, contentType string) error {
+	sn := serviceInfo.GetServiceName()
+
+	bucket, object, err := ParseGCSURI(uri)
+	if err != nil {
+		return err
+	}
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Printf("%s.media.gcsStore.Put, storage.NewClient error: %v\n", sn, err)
+		return err
+	}
+	defer client.Close()
+
+	// canceling the context before Close abandons the upload
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ow := client.Bucket(bucket).Object(object).NewWriter(ctx)
+	ow.ContentType = contentType
+	if _, err := io.Copy(ow, r); err != nil {
+		cancel()
+		_ = ow.Close()
+		return err
+	}
+	if err := ow.Close(); err != nil {
+		log.Printf("%s.media.gcsStore.Put, Close(%q) error: %v\n", sn, uri, err)
+		return err
+	}
+
+	return nil
+}
+
+// Exists reports whether the object exists
+func (s gcsStore) Exists(ctx context.Context, uri string) (bool, error) {
+	bucket, object, err := ParseGCSURI(uri)
+	if err != nil {
+		return false, err
+	}
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	if _, err := client.Bucket(bucket).Object(object).Attrs(ctx); err != nil {
+		if err == storage.ErrObjectNotExist {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SignedURL returns a V4 signed URL to GET the object, signed by the
+// signer service account
+func (s gcsStore) SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error) {
+	sn := serviceInfo.GetServiceName()
+
+	bucket, object, err := ParseGCSURI(uri)
+	if err != nil {
+		return "", err
+	}
+	if s.signer == "" {
+		return "", ErrNoSigner
+	}
+
+	client, err := credentials.NewIamCredentialsClient(ctx)
+	if err != nil {
+		log.Printf("%s.media.gcsStore.SignedURL, NewIamCredentialsClient error: %v\n", sn, err)
+		return "", err
+	}
+	defer client.Close()
+
+	signed, err := storage.SignedURL(bucket, object, &storage.SignedURLOptions{
+		GoogleAccessID: s.signer,
+		SignBytes: func(b []byte) ([]byte, error) {
+			resp, err := client.SignBlob(ctx, &credentialspb.SignBlobRequest{
+				Name:    "projects/-/serviceAccounts/" + s.signer,
+				Payload: b,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return resp.SignedBlob, nil
+		},
+		Method:  "GET",
+		Expires: time.Now().Add(expires),
+		Scheme:  storage.SigningSchemeV4,
+	})
+	if err != nil {
+		log.Printf("%s.media.gcsStore.SignedURL, SignedURL(%q) error: %v\n", sn, uri, err)
+		return "", err
+	}
+
+	return signed, nil
+}
+
+// ParseGCSURI splits "gs://bucket/path/to/object" into bucket and object
+func ParseGCSURI(uri string) (bucket string, object string, err error) {
+	const scheme = "gs://"
+
+	if !strings.HasPrefix(uri, scheme) {
+		return "", "", ErrUnsupportedURI
+	}
+	parts := strings.SplitN(strings.TrimPrefix(uri, scheme), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", ErrUnsupportedURI
+	}
+
+	return parts[0], parts[1], nil
+}
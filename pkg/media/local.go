@@ -0,0 +1,128 @@
+package media
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// localStore implements Store for "file:///path" URIs within a directory
+// on this machine, so the pipeline can run without cloud storage
+type localStore struct {
+	root string
+}
+
+// NewLocalStore returns the Store for files under root; URIs naming files
+// elsewhere are ErrUnsupportedURI
+func NewLocalStore(root string) Store {
+	return localStore{root: filepath.Clean(root)}
+}
+
+// path returns the file's path, if it's under root
+func (s localStore) path(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" || (u.Host != "" && u.Host != "localhost") {
+		return "", ErrUnsupportedURI
+	}
+	path := filepath.Clean(u.Path)
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrUnsupportedURI
+	}
+	return path, nil
+}
+
+// Delete removes the file, returning ErrNotFound if it doesn't exist
+func (s localStore) Delete(ctx context.Context, uri string) error {
+	path, err := s.path(uri)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		log.Printf("%s.media.localStore.Delete, Remove(%q) error: %v\n", serviceInfo.GetServiceName(), path, err)
+		return err
+	}
+	return nil
+}
+
+// Exists reports whether the file exists
+func (s localStore) Exists(ctx context.Context, uri string) (bool, error) {
+	path, err := s.path(uri)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Get opens the file for reading, returning ErrNotFound if it doesn't exist
+func (s localStore) Get(ctx context.Context, uri string) (io.ReadCloser, error) {
+	path, err := s.path(uri)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// Put writes r to a temporary file beside the file, renamed into place
+// once all of r is written
+func (s localStore) Put(ctx context.Context, uri string, r io.Reader, contentType string) error {
+	sn := serviceInfo.GetServiceName()
+
+	path, err := s.path(uri)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Printf("%s.media.localStore.Put, MkdirAll(%q) error: %v\n", sn, filepath.Dir(path), err)
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".put-")
+	if err != nil {
+		log.Printf("%s.media.localStore.Put, TempFile error: %v\n", sn, err)
+		return err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly once renamed
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// SignedURL returns the URI itself: local files are read only on this
+// machine, and need no signature
+func (s localStore) SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error) {
+	if _, err := s.path(uri); err != nil {
+		return "", err
+	}
+	return uri, nil
+}
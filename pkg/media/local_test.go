@@ -0,0 +1,115 @@
+package media_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/media"
+)
+
+// failingReader returns some data, then an error
+type failingReader struct {
+	sent bool
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.sent {
+		return 0, errors.New("connection reset")
+	}
+	f.sent = true
+	return copy(p, "ID3"), nil
+}
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+
+	root, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	store := media.NewLocalStore(root)
+
+	uri := "file://" + filepath.Join(root, "media", "1234567", "audio-01.mp3")
+	if err := store.Put(ctx, uri, strings.NewReader("ID3"), "audio/mpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if exists, err := store.Exists(ctx, uri); !exists || err != nil {
+		t.Errorf("Exists: expected true, got %t, %v", exists, err)
+	}
+	r, err := store.Get(ctx, uri)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(data) != "ID3" {
+		t.Errorf("Get: expected %q, got %q", "ID3", data)
+	}
+	if signed, err := store.SignedURL(ctx, uri, time.Hour); signed != uri || err != nil {
+		t.Errorf("SignedURL: expected %q, got %q, %v", uri, signed, err)
+	}
+
+	if err := store.Delete(ctx, uri); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	if err := store.Delete(ctx, uri); err != media.ErrNotFound {
+		t.Errorf("Delete again: expected %v, got %v", media.ErrNotFound, err)
+	}
+	if _, err := store.Get(ctx, uri); err != media.ErrNotFound {
+		t.Errorf("Get deleted: expected %v, got %v", media.ErrNotFound, err)
+	}
+
+	// an interrupted Put leaves nothing behind
+	partial := "file://" + filepath.Join(root, "partial.mp3")
+	if err := store.Put(ctx, partial, &failingReader{}, "audio/mpeg"); err == nil {
+		t.Errorf("interrupted Put: expected an error, got none")
+	}
+	if entries, _ := ioutil.ReadDir(root); len(entries) != 1 {
+		t.Errorf("interrupted Put: expected only media/ left, got %d entries", len(entries))
+	}
+
+	// nothing outside root
+	for _, outside := range []string{
+		"file:///etc/passwd",
+		"file://" + root + "/../passwd",
+		"file://" + root,
+		"file://otherhost" + root + "/audio-01.mp3",
+		"gs://bucket/audio-01.mp3",
+	} {
+		if _, err := store.Get(ctx, outside); err != media.ErrUnsupportedURI {
+			t.Errorf("%s: expected %v, got %v", outside, media.ErrUnsupportedURI, err)
+		}
+		if err := store.Put(ctx, outside, strings.NewReader("ID3"), "audio/mpeg"); err != media.ErrUnsupportedURI {
+			t.Errorf("%s: expected %v, got %v", outside, media.ErrUnsupportedURI, err)
+		}
+	}
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	store := media.Stores{"gs": memoryStore{"gs://bucket/audio-01.mp3": mp3}}
+
+	r, err := store.Get(ctx, "gs://bucket/audio-01.mp3")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	n, _ := io.Copy(ioutil.Discard, r)
+	r.Close()
+	if n != int64(len(mp3)) {
+		t.Errorf("Get: expected %d bytes, got %d", len(mp3), n)
+	}
+
+	for _, uri := range []string{"s3://bucket/audio-01.mp3", "/tmp/audio-01.mp3", "%zz"} {
+		if _, err := store.Exists(ctx, uri); err != media.ErrUnsupportedURI {
+			t.Errorf("%s: expected %v, got %v", uri, media.ErrUnsupportedURI, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Media package stores the audio files submitted for transcription, in
+// Google Cloud Storage, on local disk, or in S3-compatible storage
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/config"
+)
+
+// ErrNotFound - no media file at the URI
+var ErrNotFound = errors.New("Media file not found")
+
+// ErrUnsupportedURI - URI scheme not handled by this Store
+var ErrUnsupportedURI = errors.New("Unsupported media URI")
+
+// ErrNoSigner - the Store isn't configured to sign URLs
+var ErrNoSigner = errors.New("Media store can't sign URLs")
+
+// Store is implemented by each place media files are kept. Files are named
+// by URI, e.g. "gs://bucket/object", "s3://bucket/key" or "file:///path".
+type Store interface {
+	Delete(ctx context.Context, uri string) error
+	Exists(ctx context.Context, uri string) (bool, error)
+	// Get opens the file for reading; the caller closes it
+	Get(ctx context.Context, uri string) (io.ReadCloser, error)
+	// Put writes all of r to uri; if r returns an error, nothing is kept
+	Put(ctx context.Context, uri string, r io.Reader, contentType string) error
+	// SignedURL returns a URL anyone holding it can GET the file from,
+	// until expires has passed
+	SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error)
+}
+
+// NewStore returns the Store for the media locations cfg configures:
+// Cloud Storage always, S3-compatible storage when an endpoint or
+// credentials are configured, and local disk when MediaStore is a
+// "file://" directory
+func NewStore(cfg *config.Config) Store {
+	stores := Stores{"gs": NewGCSStore(cfg.MediaSigner)}
+	if cfg.S3Endpoint != "" || cfg.S3AccessKeyID != "" {
+		stores["s3"] = NewS3Store(cfg.S3Endpoint, cfg.S3Region, cfg.S3AccessKeyID, cfg.S3SecretAccessKey)
+	}
+	if strings.HasPrefix(cfg.MediaStore, "file://") {
+		stores["file"] = NewLocalStore(strings.TrimPrefix(cfg.MediaStore, "file://"))
+	}
+	return stores
+}
+
+// Stores implements Store by passing each URI to the Store for its scheme,
+// e.g. "gs"; other schemes are ErrUnsupportedURI
+type Stores map[string]Store
+
+func (s Stores) store(uri string) (Store, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, ErrUnsupportedURI
+	}
+	store, ok := s[u.Scheme]
+	if !ok {
+		return nil, ErrUnsupportedURI
+	}
+	return store, nil
+}
+
+func (s Stores) Delete(ctx context.Context, uri string) error {
+	store, err := s.store(uri)
+	if err != nil {
+		return err
+	}
+	return store.Delete(ctx, uri)
+}
+
+func (s Stores) Exists(ctx context.Context, uri string) (bool, error) {
+	store, err := s.store(uri)
+	if err != nil {
+		return false, err
+	}
+	return store.Exists(ctx, uri)
+}
+
+func (s Stores) Get(ctx context.Context, uri string) (io.ReadCloser, error) {
+	store, err := s.store(uri)
+	if err != nil {
+		return nil, err
+	}
+	return store.Get(ctx, uri)
+}
+
+func (s Stores) Put(ctx context.Context, uri string, r io.Reader, contentType string) error {
+	store, err := s.store(uri)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, uri, r, contentType)
+}
+
+func (s Stores) SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error) {
+	store, err := s.store(uri)
+	if err != nil {
+		return "", err
+	}
+	return store.SignedURL(ctx, uri, expires)
+}
@@ -0,0 +1,350 @@
+package media
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3MaxExpires      = 7 * 24 * time.Hour // longest a presigned URL may last
+	s3HeaderTimeout   = time.Minute        // for S3 to start answering
+	s3RequestTimeout  = time.Minute        // for a whole request without a body, e.g. HEAD
+)
+
+// s3Store implements Store for "s3://bucket/key" URIs in Amazon S3 or an
+// S3-compatible service, e.g. MinIO, signing requests with AWS Signature
+// Version 4
+type s3Store struct {
+	endpoint        string // "" for Amazon S3
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	client          *http.Client
+	now             func() time.Time
+}
+
+// NewS3Store returns the Store for S3-compatible storage. endpoint is the
+// service's URL, e.g. "http://localhost:9000" for a local MinIO, whose
+// buckets are addressed by path; "" for Amazon S3, whose buckets are
+// addressed by host. region defaults to "us-east-1".
+func NewS3Store(endpoint, region, accessKeyID, secretAccessKey string) Store {
+	if region == "" {
+		region = "us-east-1"
+	}
+	return s3Store{
+		endpoint:        strings.TrimSuffix(endpoint, "/"),
+		region:          region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		client:          s3Client(),
+		now:             time.Now,
+	}
+}
+
+// s3Client returns the client for S3 requests. It bounds connecting and
+// waiting for a response, not the whole request, as an upload or download
+// may rightly take many minutes; those are bounded by the caller's context.
+func s3Client() *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: s3HeaderTimeout,
+	}
+	return &http.Client{Transport: transport}
+}
+
+// ParseS3URI splits "s3://bucket/path/to/key" into bucket and key
+func ParseS3URI(uri string) (bucket string, key string, err error) {
+	const scheme = "s3://"
+
+	if !strings.HasPrefix(uri, scheme) {
+		return "", "", ErrUnsupportedURI
+	}
+	parts := strings.SplitN(strings.TrimPrefix(uri, scheme), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", ErrUnsupportedURI
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// objectURL returns the HTTP URL of the object at uri
+func (s s3Store) objectURL(uri string) (*url.URL, error) {
+	bucket, key, err := ParseS3URI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.endpoint == "" {
+		host := bucket + ".s3.amazonaws.com"
+		if s.region != "us-east-1" {
+			host = bucket + ".s3." + s.region + ".amazonaws.com"
+		}
+		return &url.URL{Scheme: "https", Host: host, Path: "/" + key}, nil
+	}
+
+	u, err := url.Parse(s.endpoint)
+	if err != nil || u.Host == "" {
+		log.Printf("%s.media.s3Store, bad endpoint %q\n", serviceInfo.GetServiceName(), s.endpoint)
+		return nil, ErrUnsupportedURI
+	}
+	u.Path = u.Path + "/" + bucket + "/" + key
+	return u, nil
+}
+
+// do sends a request for the object at uri, signed in its headers, and
+// returns the response if it's a success
+func (s s3Store) do(ctx context.Context, method, uri string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	u, err := s.objectURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	u.RawPath = s3EncodePath(u.Path)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+	signedHeaders, signature := s.signature(method, u, "", map[string]string{
+		"host":                 u.Host,
+		"x-amz-content-sha256": s3UnsignedPayload,
+		"x-amz-date":           amzDate,
+	}, s3UnsignedPayload, now)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKeyID, s.scope(now), signedHeaders, signature))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	// S3 explains errors in an XML body, e.g. <Code>AccessDenied</Code>
+	var s3Err struct {
+		Code string
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	_ = xml.Unmarshal(data, &s3Err)
+	log.Printf("%s.media.s3Store, %s %s: %s %s\n", serviceInfo.GetServiceName(), method, uri, resp.Status, s3Err.Code)
+	return nil, fmt.Errorf("%s %s: %s %s", method, uri, resp.Status, s3Err.Code)
+}
+
+// Delete removes the object. S3 doesn't say whether it existed, so
+// deleting a missing object succeeds.
+func (s s3Store) Delete(ctx context.Context, uri string) error {
+	ctx, cancel := context.WithTimeout(ctx, s3RequestTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, "DELETE", uri, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Exists reports whether the object exists
+func (s s3Store) Exists(ctx context.Context, uri string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s3RequestTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, "HEAD", uri, nil, 0, "")
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// Get opens the object for reading, returning ErrNotFound if it doesn't
+// exist
+func (s s3Store) Get(ctx context.Context, uri string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, "GET", uri, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put uploads r as the object. S3 needs an upload's length before it
+// starts, so r is first copied to a temporary file; nothing is uploaded
+// unless all of r is copied.
+func (s s3Store) Put(ctx context.Context, uri string, r io.Reader, contentType string) error {
+	if _, _, err := ParseS3URI(uri); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile("", "s3put")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, "PUT", uri, f, size, contentType)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SignedURL returns a presigned URL to GET the object. S3 limits them to
+// seven days.
+func (s s3Store) SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error) {
+	u, err := s.objectURL(uri)
+	if err != nil {
+		return "", err
+	}
+	if expires > s3MaxExpires {
+		expires = s3MaxExpires
+	}
+	u.RawPath = s3EncodePath(u.Path)
+
+	now := s.now().UTC()
+	query := s3CanonicalQuery(url.Values{
+		"X-Amz-Algorithm":     {s3Algorithm},
+		"X-Amz-Credential":    {s.accessKeyID + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	})
+	_, signature := s.signature("GET", u, query, map[string]string{"host": u.Host}, s3UnsignedPayload, now)
+	u.RawQuery = query + "&X-Amz-Signature=" + signature
+
+	return u.String(), nil
+}
+
+// scope is the date, region and service a signature is good for
+func (s s3Store) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature signs the canonical form of a request, as
+// https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+// describes. headers are the signed headers, by lower case name; their
+// names are returned along with the signature.
+func (s s3Store) signature(method string, u *url.URL, query string, headers map[string]string, payloadHash string, now time.Time) (signedHeaders string, signature string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders = strings.Join(names, ";")
+
+	path := s3EncodePath(u.Path)
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		method, path, query, canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3Algorithm, now.Format("20060102T150405Z"), s.scope(now), hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := s3HMAC([]byte("AWS4"+s.secretAccessKey), now.Format("20060102"))
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = s3HMAC(key, part)
+	}
+	return signedHeaders, hex.EncodeToString(s3HMAC(key, stringToSign))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3CanonicalQuery encodes query sorted by name, as signatures require
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, s3Encode(name, true)+"="+s3Encode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3EncodePath encodes each segment of path
+func s3EncodePath(path string) string {
+	return s3Encode(path, false)
+}
+
+// s3Encode percent-encodes all but the unreserved characters, and "/"
+// unless encodeSlash
+func s3Encode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// RequireAdmin wraps an administrative handler, rejecting requests that
+// don't carry "Authorization: Bearer <token>". An empty token rejects all
+// requests, so admin endpoints are closed unless configured.
+func RequireAdmin(token string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := serviceInfo.GetServiceName()
+
+		presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			log.Printf("%s.middleware.RequireAdmin, rejected %s %s from %s\n", sn, r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r, p)
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/peterpla/lead-expert/pkg/customer"
+)
+
+// apiKeyAuthenticator implements Authenticator for customers' own API keys,
+// sent as "Authorization: Bearer lx_<key_id>_<secret>"
+type apiKeyAuthenticator struct {
+	customers customer.KeyRepository
+}
+
+func NewAPIKeyAuthenticator(customers customer.KeyRepository) Authenticator {
+	return apiKeyAuthenticator{customers}
+}
+
+func (a apiKeyAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !strings.HasPrefix(bearer, customer.APIKeyPrefix) {
+		return Principal{}, ErrNoCredentials
+	}
+
+	keyID, secret, err := customer.ParseAPIKey(bearer)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: malformed API key", ErrInvalidCredentials)
+	}
+
+	cust, err := a.customers.FindByAPIKeyID(keyID)
+	if err == customer.ErrAPIKeyNotFound {
+		return Principal{}, fmt.Errorf("%w: unknown key %s", ErrInvalidCredentials, keyID)
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	if err = cust.CheckAPIKey(keyID, secret, time.Now()); err != nil {
+		return Principal{}, fmt.Errorf("%w: invalid or revoked key %s", ErrInvalidCredentials, keyID)
+	}
+
+	return Principal{CustomerID: cust.CustomerID, KeyID: keyID, Scopes: AllScopes}, nil
+}
+
+// RequireAPIKey wraps a public API handler, rejecting requests that don't
+// carry a valid customer API key
+func RequireAPIKey(customers customer.KeyRepository, next httprouter.Handle) httprouter.Handle {
+	return RequireAuth([]Authenticator{NewAPIKeyAuthenticator(customers)}, next)
+}
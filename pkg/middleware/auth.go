@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// scopes granted to public API callers
+const (
+	ScopeRequestsWrite   = "requests:write"   // submit requests
+	ScopeRequestsRead    = "requests:read"    // list requests, read their status
+	ScopeTranscriptsRead = "transcripts:read" // read transcripts and tags
+)
+
+// AllScopes are granted to callers using a customer's own API key
+var AllScopes = []string{ScopeRequestsWrite, ScopeRequestsRead, ScopeTranscriptsRead}
+
+// ErrNoCredentials - the HTTP request carries no credentials the
+// Authenticator recognizes, so the next one should be tried
+var ErrNoCredentials = errors.New("No credentials")
+
+// ErrInvalidCredentials - the HTTP request carries credentials the
+// Authenticator recognizes, but rejects
+var ErrInvalidCredentials = errors.New("Invalid credentials")
+
+// Authenticator is implemented by each way of calling the public API
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// Principal is the authenticated caller of the public API
+type Principal struct {
+	CustomerID int
+	KeyID      string   // API key used, if any
+	Subject    string   // JWT "sub", the partner's user, if any
+	Scopes     []string // what the caller may do
+}
+
+// HasScope returns true if the caller was granted scope
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// String identifies the caller, as audit records name them: the
+// customer, and the API key or partner's user used
+func (p Principal) String() string {
+	switch {
+	case p.KeyID != "":
+		return fmt.Sprintf("customer %d, API key %s", p.CustomerID, p.KeyID)
+	case p.Subject != "":
+		return fmt.Sprintf("customer %d, subject %q", p.CustomerID, p.Subject)
+	}
+	return fmt.Sprintf("customer %d", p.CustomerID)
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the Principal authenticated for the HTTP request,
+// if any
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// RequireAuth wraps a public API handler, rejecting requests that none of
+// auths authenticates. Each is tried in turn until one recognizes the
+// credentials. The handler finds the caller with PrincipalFrom.
+func RequireAuth(auths []Authenticator, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := serviceInfo.GetServiceName()
+
+		for _, auth := range auths {
+			principal, err := auth.Authenticate(r)
+			if err == ErrNoCredentials {
+				continue
+			}
+			if err != nil && !errors.Is(err, ErrInvalidCredentials) {
+				log.Printf("%s.middleware.RequireAuth, %s %s, error: %v\n", sn, r.Method, r.URL.Path, err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if err != nil {
+				unauthorized(w, r, err.Error())
+				return
+			}
+
+			next(w, r.WithContext(WithPrincipal(r.Context(), principal)), p)
+			return
+		}
+
+		unauthorized(w, r, "no credentials")
+	}
+}
+
+// RequireScope wraps a handler already wrapped by RequireAuth, rejecting
+// callers that weren't granted scope
+func RequireScope(scope string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		principal, ok := PrincipalFrom(r.Context())
+		if !ok || !principal.HasScope(scope) {
+			log.Printf("%s.middleware.RequireScope, rejected %s %s from customer %d, %q required\n",
+				serviceInfo.GetServiceName(), r.Method, r.URL.Path, principal.CustomerID, scope)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="insufficient_scope", scope="`+scope+`"`)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next(w, r, p)
+	}
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, reason string) {
+	log.Printf("%s.middleware, rejected %s %s from %s: %s\n", serviceInfo.GetServiceName(), r.Method, r.URL.Path, r.RemoteAddr, reason)
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
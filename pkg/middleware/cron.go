@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// RequireCron wraps a handler invoked by App Engine cron, rejecting requests
+// without the "X-Appengine-Cron: true" header. App Engine strips that header
+// from requests originating outside the app.
+func RequireCron(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if r.Header.Get("X-Appengine-Cron") != "true" {
+			log.Printf("%s.middleware.RequireCron, rejected %s %s from %s\n",
+				serviceInfo.GetServiceName(), r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next(w, r, p)
+	}
+}
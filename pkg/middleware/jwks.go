@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownKey - no key in the key set has the JWT's key ID
+var ErrUnknownKey = errors.New("Unknown signing key")
+
+// KeySource is implemented by each place JWT signing keys are published
+type KeySource interface {
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// NewKeySource returns a KeySource for a JSON Web Key Set at location: an
+// http(s) URL, or "file:<path>"
+func NewKeySource(location string) (KeySource, error) {
+	if strings.HasPrefix(location, "file:") {
+		return NewJWKSFile(strings.TrimPrefix(location, "file:"))
+	}
+	if strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") {
+		return NewJWKSURL(location), nil
+	}
+	return nil, fmt.Errorf("JWKS location %q: must be a URL or file:<path>", location)
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// staticKeys implements KeySource with a key set read once
+type staticKeys map[string]crypto.PublicKey
+
+// NewJWKSFile returns a KeySource holding the key set in the file at path
+func NewJWKSFile(path string) (KeySource, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("JWKS file %q: %v", path, err)
+	}
+	return staticKeys(keys), nil
+}
+
+func (s staticKeys) Key(kid string) (crypto.PublicKey, error) {
+	key, ok := s[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// jwksMaxAge is how long a fetched key set is used before it's refetched
+const jwksMaxAge = time.Hour
+
+// jwksMinRefetch limits refetches prompted by unknown key IDs, so forged
+// tokens can't make us hammer the issuer
+const jwksMinRefetch = time.Minute
+
+// remoteKeys implements KeySource with a key set fetched from a URL, and
+// refetched when it's old or a token names a key it doesn't hold
+type remoteKeys struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewJWKSURL returns a KeySource fetching the key set published at url
+func NewJWKSURL(url string) KeySource {
+	return &remoteKeys{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *remoteKeys) Key(kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[kid]
+	age := time.Since(s.fetchedAt)
+	if (ok && age < jwksMaxAge) || (!ok && s.keys != nil && age < jwksMinRefetch) {
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		return key, nil
+	}
+
+	if err := s.fetch(); err != nil {
+		if ok {
+			// the issuer is unreachable; keep using the key we have
+			return key, nil
+		}
+		return nil, err
+	}
+	if key, ok = s.keys[kid]; !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (s *remoteKeys) fetch() error {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS %q: %s", s.url, resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("JWKS %q: %v", s.url, err)
+	}
+
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	return nil
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// jwk is a JSON Web Key (RFC 7517), RSA or EC fields only
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS returns the RSA and P-256 signing keys in a JSON Web Key Set,
+// by key ID. Other keys are skipped.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: n: %v", k.Kid, err)
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("key %q: invalid e", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: x: %v", k.Kid, err)
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: y: %v", k.Kid, err)
+			}
+			if !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("key %q: point not on P-256", k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
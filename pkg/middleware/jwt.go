@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCustomerClaim is the JWT claim holding the CustomerID the partner
+// calls on behalf of, unless JWTAuthenticator.CustomerClaim is set
+const DefaultCustomerClaim = "customer_id"
+
+// JWTAuthenticator implements Authenticator for partners calling with
+// signed JWTs (RS256 or ES256) as "Authorization: Bearer <jwt>". The
+// caller's scopes come from the "scope" (space-separated) or "scp" claim.
+type JWTAuthenticator struct {
+	Issuer        string        // required "iss"
+	Audience      string        // required in "aud"
+	Keys          KeySource     // signing keys, by "kid"
+	CustomerClaim string        // DefaultCustomerClaim if ""
+	Leeway        time.Duration // allowed clock skew
+	Now           func() time.Time
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, ErrNoCredentials
+	}
+
+	claims, err := a.verify(parts)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	principal, err := a.principal(claims)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	return principal, nil
+}
+
+// verify checks the token's signature and registered claims, returning
+// its claims
+func (a *JWTAuthenticator) verify(parts []string) (map[string]interface{}, error) {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: JWT %s", ErrInvalidCredentials, fmt.Sprintf(format, args...))
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalid("header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid("signature: %v", err)
+	}
+
+	key, err := a.Keys.Key(header.Kid)
+	if err == ErrUnknownKey {
+		return nil, invalid("unknown kid %q", header.Kid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the key, not the token, decides the algorithm
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return nil, invalid("alg %q for an RSA key", header.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return nil, invalid("bad signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" {
+			return nil, invalid("alg %q for an EC key", header.Alg)
+		}
+		if len(sig) != 64 {
+			return nil, invalid("bad signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return nil, invalid("bad signature")
+		}
+	default:
+		return nil, invalid("unsupported key for kid %q", header.Kid)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalid("claims: %v", err)
+	}
+
+	now := time.Now()
+	if a.Now != nil {
+		now = a.Now()
+	}
+	if iss, _ := claims["iss"].(string); iss != a.Issuer {
+		return nil, invalid("issuer %q", iss)
+	}
+	if !audienceContains(claims["aud"], a.Audience) {
+		return nil, invalid("audience %v", claims["aud"])
+	}
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return nil, invalid("without exp")
+	}
+	if !now.Before(exp.Add(a.Leeway)) {
+		return nil, invalid("expired at %s", exp.UTC().Format(time.RFC3339))
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(a.Leeway).Before(nbf) {
+		return nil, invalid("not valid before %s", nbf.UTC().Format(time.RFC3339))
+	}
+
+	return claims, nil
+}
+
+// principal maps verified claims to the caller
+func (a *JWTAuthenticator) principal(claims map[string]interface{}) (Principal, error) {
+	claim := a.CustomerClaim
+	if claim == "" {
+		claim = DefaultCustomerClaim
+	}
+
+	var customerID int
+	switch v := claims[claim].(type) {
+	case json.Number:
+		id, err := v.Int64()
+		if err != nil {
+			return Principal{}, fmt.Errorf("claim %q: %v", claim, err)
+		}
+		customerID = int(id)
+	case string:
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return Principal{}, fmt.Errorf("claim %q: %v", claim, err)
+		}
+		customerID = id
+	default:
+		return Principal{}, fmt.Errorf("claim %q missing", claim)
+	}
+	if customerID < 1 || customerID >= 10000000 {
+		return Principal{}, fmt.Errorf("claim %q: invalid CustomerID %d", claim, customerID)
+	}
+
+	principal := Principal{CustomerID: customerID, Scopes: []string{}}
+	principal.Subject, _ = claims["sub"].(string)
+	if scope, ok := claims["scope"].(string); ok {
+		principal.Scopes = strings.Fields(scope)
+	} else if scp, ok := claims["scp"].([]interface{}); ok {
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				principal.Scopes = append(principal.Scopes, s)
+			}
+		}
+	}
+
+	return principal, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func audienceContains(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, a := range v {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// numericDate converts a JWT NumericDate claim, seconds since the epoch
+func numericDate(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(f*float64(time.Second))), true
+}
@@ -0,0 +1,243 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// testKeys are locally generated signing keys, and their JWKS
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	jwks []byte
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+			{"kty": "oct", "kid": "hmac-1", "k": "c2VjcmV0"},
+		},
+	})
+
+	return testKeys{rsa: rsaKey, ec: ecKey, jwks: jwks}
+}
+
+// sign returns a JWT with the given header and claims, signed by the key
+// matching kid
+func (k testKeys) sign(t *testing.T, alg string, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var sig []byte
+	var err error
+	switch kid {
+	case "rsa-1":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+	case "ec-1":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	keys := newTestKeys(t)
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(path, keys.jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fileKeys, err := NewKeySource("file:" + path)
+	if err != nil {
+		t.Fatalf("NewKeySource(file): %v", err)
+	}
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_, _ = w.Write(keys.jwks)
+	}))
+	defer server.Close()
+	urlKeys, _ := NewKeySource(server.URL)
+
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":         "https://partner.example.com",
+			"aud":         []string{"lead-expert", "other"},
+			"sub":         "partner-user-42",
+			"customer_id": 1234567,
+			"scope":       "requests:read transcripts:read",
+			"exp":         now.Add(time.Hour).Unix(),
+			"nbf":         now.Add(-time.Minute).Unix(),
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	type test struct {
+		name   string
+		keys   KeySource
+		token  string
+		err    error
+		scopes int
+	}
+
+	tests := []test{
+		{name: "RS256 from file", keys: fileKeys, token: keys.sign(t, "RS256", "rsa-1", claims(nil)), scopes: 2},
+		{name: "ES256 from URL", keys: urlKeys, token: keys.sign(t, "ES256", "ec-1", claims(nil)), scopes: 2},
+		{name: "scp array, string customer_id", keys: fileKeys,
+			token:  keys.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"scope": nil, "scp": []string{"requests:write"}, "customer_id": "1234567"})),
+			scopes: 1},
+		{name: "not a JWT", keys: fileKeys, token: "lx_abc_def", err: ErrNoCredentials},
+		{name: "expired", keys: fileKeys, token: keys.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), err: ErrInvalidCredentials},
+		{name: "expired within leeway", keys: fileKeys, token: keys.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), scopes: 2},
+		{name: "no exp", keys: fileKeys, token: keys.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"exp": nil})), err: ErrInvalidCredentials},
+		{name: "not yet valid", keys: fileKeys, token: keys.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), err: ErrInvalidCredentials},
+		{name: "wrong issuer", keys: fileKeys, token: keys.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"iss": "https://evil.example.com"})), err: ErrInvalidCredentials},
+		{name: "wrong audience", keys: fileKeys, token: keys.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"aud": "other"})), err: ErrInvalidCredentials},
+		{name: "alg doesn't match key", keys: fileKeys, token: keys.sign(t, "ES256", "rsa-1", claims(nil)), err: ErrInvalidCredentials},
+		{name: "alg none", keys: fileKeys, token: unsigned(`{"alg":"none","kid":"rsa-1"}`, claims(nil)), err: ErrInvalidCredentials},
+		{name: "unknown kid", keys: fileKeys, token: keys.sign(t, "RS256", "rsa-2", claims(nil)), err: ErrInvalidCredentials},
+		{name: "HMAC key not accepted", keys: fileKeys, token: keys.sign(t, "HS256", "hmac-1", claims(nil)), err: ErrInvalidCredentials},
+		{name: "no customer", keys: fileKeys, token: keys.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"customer_id": nil})), err: ErrInvalidCredentials},
+		{name: "customer out of range", keys: fileKeys, token: keys.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"customer_id": 0})), err: ErrInvalidCredentials},
+	}
+
+	// tampered: another customer's claims under a valid signature
+	valid := strings.Split(keys.sign(t, "RS256", "rsa-1", claims(nil)), ".")
+	forged := strings.Split(unsigned(`{"alg":"RS256","kid":"rsa-1"}`, claims(map[string]interface{}{"customer_id": 7654321})), ".")
+	tests = append(tests, test{name: "tampered", keys: fileKeys, token: valid[0] + "." + forged[1] + "." + valid[2], err: ErrInvalidCredentials})
+
+	for _, tc := range tests {
+		auth := &JWTAuthenticator{
+			Issuer:   "https://partner.example.com",
+			Audience: "lead-expert",
+			Keys:     tc.keys,
+			Leeway:   time.Minute,
+			Now:      func() time.Time { return now },
+		}
+		r := httptest.NewRequest("GET", "/api/v1/requests", nil)
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+
+		principal, err := auth.Authenticate(r)
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if principal.CustomerID != 1234567 || principal.Subject != "partner-user-42" || len(principal.Scopes) != tc.scopes {
+			t.Errorf("%s: unexpected principal %+v", tc.name, principal)
+		}
+	}
+
+	if fetches != 1 {
+		t.Errorf("expected the key set fetched once, got %d", fetches)
+	}
+}
+
+// unsigned returns a JWT with the given header and claims, and no signature
+func unsigned(header string, claims map[string]interface{}) string {
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+// fakeAuth authenticates a fixed bearer token
+type fakeAuth struct {
+	token     string
+	principal Principal
+}
+
+func (f fakeAuth) Authenticate(r *http.Request) (Principal, error) {
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		return Principal{}, ErrNoCredentials
+	}
+	return f.principal, nil
+}
+
+func TestRequireAuthAndScope(t *testing.T) {
+	auths := []Authenticator{
+		fakeAuth{token: "key", principal: Principal{CustomerID: 1234567, Scopes: AllScopes}},
+		fakeAuth{token: "partner", principal: Principal{CustomerID: 1234567, Scopes: []string{ScopeRequestsRead}}},
+	}
+
+	router := httprouter.New()
+	router.GET("/transcripts", RequireAuth(auths, RequireScope(ScopeTranscriptsRead,
+		func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			if p, ok := PrincipalFrom(r.Context()); !ok || p.CustomerID != 1234567 {
+				t.Errorf("expected principal, got %+v", p)
+			}
+		})))
+
+	type test struct {
+		name   string
+		bearer string
+		status int
+	}
+
+	tests := []test{
+		{name: "no credentials", status: http.StatusUnauthorized},
+		{name: "unrecognized", bearer: "nope", status: http.StatusUnauthorized},
+		{name: "all scopes", bearer: "key", status: http.StatusOK},
+		{name: "missing scope", bearer: "partner", status: http.StatusForbidden},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", "/transcripts", nil)
+		if tc.bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.bearer)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		if rr.Code != tc.status {
+			t.Errorf("%s: expected status code %v, got %v", tc.name, tc.status, rr.Code)
+		}
+	}
+}
@@ -30,3 +30,11 @@ func (rec *statusRecorder) WriteHeader(code int) {
 	rec.status = code                    // save the status code
 	rec.ResponseWriter.WriteHeader(code) // pass it on to wrapped method
 }
+
+// Flush lets handlers stream responses, e.g. Server-Sent Events, through
+// the recorder
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/peterpla/lead-expert/pkg/customer"
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/ratelimit"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// RateLimit wraps a handler already wrapped by RequireAuth, enforcing the
+// caller's rate limits and quotas from their customer profile. Rejected
+// requests get 429 and Retry-After; others get the remaining rate and
+// quotas in X-RateLimit-* and X-Quota-Remaining-* headers. Only requests
+// the handler accepts (202) count toward the quotas.
+func RateLimit(customers customer.CustomerRepository, enforcer *ratelimit.Enforcer, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := serviceInfo.GetServiceName()
+		now := time.Now()
+
+		principal, ok := PrincipalFrom(r.Context())
+		if !ok {
+			log.Printf("%s.middleware.RateLimit, no principal for %s %s\n", sn, r.Method, r.URL.Path)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		// unknown customers are limited by the defaults; the handler rejects them
+		var limits customer.Limits
+		cust, err := customers.FindByID(principal.CustomerID)
+		if err != nil && err != database.ErrNotFoundError {
+			log.Printf("%s.middleware.RateLimit, customers.FindByID error: %v\n", sn, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			limits = cust.Limits
+		}
+
+		key := principal.KeyID
+		if key == "" {
+			key = principal.Subject
+		}
+		decision, err := enforcer.Check(principal.CustomerID, key, limits, now)
+		if err != nil {
+			log.Printf("%s.middleware.RateLimit, Check error: %v\n", sn, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		for _, q := range decision.Quotas {
+			remaining := q.Remaining()
+			if decision.Allowed && remaining > 0 && q.CountsRequests() {
+				remaining-- // this request
+			}
+			w.Header().Set("X-Quota-Remaining-"+q.Name, strconv.Itoa(remaining))
+		}
+
+		if !decision.Allowed {
+			log.Printf("%s.middleware.RateLimit, rejected %s %s from customer %d: %s\n",
+				sn, r.Method, r.URL.Path, principal.CustomerID, decision.Reason)
+			w.Header().Set("Retry-After", strconv.Itoa(int((decision.RetryAfter+time.Second-1)/time.Second)))
+			http.Error(w, decision.Reason, http.StatusTooManyRequests)
+			return
+		}
+
+		rec := &statusRecorder{w, http.StatusOK}
+		next(rec, r, p)
+
+		if rec.status == http.StatusAccepted {
+			if err := ratelimit.RecordRequest(enforcer.Usage, principal.CustomerID, now); err != nil {
+				// the request was accepted; don't fail it for bookkeeping
+				log.Printf("%s.middleware.RateLimit, RecordRequest error: %v\n", sn, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/peterpla/lead-expert/pkg/customer"
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	customers := database.NewMemoryCustomerRepository()
+	if err := customers.Create(&customer.Customer{
+		CustomerID: 1234567,
+		Name:       "Limited",
+		Limits:     customer.Limits{RequestsPerMinute: 1, Burst: 3, DailyRequests: 2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	usage := database.NewMemoryUsageStore()
+	enforcer := &ratelimit.Enforcer{Limiter: ratelimit.NewLimiter(), Usage: usage}
+
+	auths := []Authenticator{fakeAuth{token: "key", principal: Principal{CustomerID: 1234567, KeyID: "abc", Scopes: AllScopes}}}
+	router := httprouter.New()
+	router.POST("/requests", RequireAuth(auths, RateLimit(customers, enforcer,
+		func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			if r.URL.Query().Get("reject") != "" {
+				http.Error(w, "invalid", http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		})))
+
+	type test struct {
+		name      string
+		query     string
+		status    int
+		remaining string // X-Quota-Remaining-Daily-Requests
+		retry     bool
+	}
+
+	tests := []test{
+		{name: "rejected by handler, not counted", query: "?reject=1", status: http.StatusBadRequest, remaining: "1"},
+		{name: "first", status: http.StatusAccepted, remaining: "1"},
+		{name: "second", status: http.StatusAccepted, remaining: "0"},
+		{name: "quota exhausted", status: http.StatusTooManyRequests, remaining: "0", retry: true},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest("POST", "/requests"+tc.query, nil)
+		r.Header.Set("Authorization", "Bearer key")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+
+		if rr.Code != tc.status {
+			t.Errorf("%s: expected status code %v, got %v", tc.name, tc.status, rr.Code)
+		}
+		if got := rr.Header().Get("X-Quota-Remaining-" + ratelimit.QuotaDailyRequests); got != tc.remaining {
+			t.Errorf("%s: expected %q remaining, got %q", tc.name, tc.remaining, got)
+		}
+		if got := rr.Header().Get("Retry-After"); (got != "") != tc.retry {
+			t.Errorf("%s: unexpected Retry-After %q", tc.name, got)
+		}
+		if rr.Header().Get("X-RateLimit-Limit") != "1" {
+			t.Errorf("%s: expected X-RateLimit-Limit 1, got %q", tc.name, rr.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+
+	day, _ := usage.Get(1234567, ratelimit.DayPeriod(time.Now()))
+	if day.Requests != 2 {
+		t.Errorf("expected 2 requests counted, got %d", day.Requests)
+	}
+
+	// the rate limit applies once the burst is used
+	customers2 := database.NewMemoryCustomerRepository()
+	_ = customers2.Create(&customer.Customer{CustomerID: 1234567, Name: "Fast", Limits: customer.Limits{RequestsPerMinute: 1, Burst: 1}})
+	enforcer = &ratelimit.Enforcer{Limiter: ratelimit.NewLimiter(), Usage: usage}
+	router = httprouter.New()
+	router.POST("/requests", RequireAuth(auths, RateLimit(customers2, enforcer,
+		func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			w.WriteHeader(http.StatusAccepted)
+		})))
+	codes := []int{}
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("POST", "/requests", nil)
+		r.Header.Set("Authorization", "Bearer key")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		codes = append(codes, rr.Code)
+		if rr.Code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") != "60" {
+			t.Errorf("expected Retry-After 60, got %q", rr.Header().Get("Retry-After"))
+		}
+	}
+	if codes[0] != http.StatusAccepted || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("expected 202 then 429, got %v", codes)
+	}
+}
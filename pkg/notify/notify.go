@@ -0,0 +1,141 @@
+// Notify package tells callers waiting on a Request when it changes, for
+// long-polls of GET /status and Server-Sent Event streams
+package notify
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/request"
+)
+
+// Change says a Request was written. Subscribers re-read the Request for
+// anything more than its Status and Stage.
+type Change struct {
+	RequestID uuid.UUID
+	Status    string // "" if not known
+	Stage     string
+	UpdatedAt string
+}
+
+// Notifier is implemented by each source of Request changes
+type Notifier interface {
+	// Subscribe returns a channel receiving changes to the Request until ctx
+	// is done, when the channel is closed. Changes not yet received are
+	// replaced by later ones.
+	Subscribe(ctx context.Context, reqID uuid.UUID) (<-chan Change, error)
+}
+
+// Latest sends c on ch, a channel with a buffer of one, replacing any
+// change not yet received; subscribers only need the latest
+func Latest(ch chan Change, c Change) {
+	for {
+		select {
+		case ch <- c:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// Broker implements Notifier for changes published in this process
+type Broker struct {
+	mu   sync.Mutex
+	subs map[uuid.UUID]map[chan Change]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[uuid.UUID]map[chan Change]struct{})}
+}
+
+func (b *Broker) Subscribe(ctx context.Context, reqID uuid.UUID) (<-chan Change, error) {
+	ch := make(chan Change, 1)
+
+	b.mu.Lock()
+	if b.subs[reqID] == nil {
+		b.subs[reqID] = make(map[chan Change]struct{})
+	}
+	b.subs[reqID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[reqID], ch)
+		if len(b.subs[reqID]) == 0 {
+			delete(b.subs, reqID)
+		}
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// Publish sends c to every subscriber to its Request
+func (b *Broker) Publish(c Change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[c.RequestID] {
+		Latest(ch, c)
+	}
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// notifyingRequestRepository implements request.RequestRepository,
+// publishing each write to a Broker
+type notifyingRequestRepository struct {
+	repo   request.RequestRepository
+	broker *Broker
+}
+
+// NewNotifyingRequestRepository returns repo, publishing each Create,
+// Update and DeleteFields to broker
+func NewNotifyingRequestRepository(repo request.RequestRepository, broker *Broker) request.RequestRepository {
+	return notifyingRequestRepository{repo: repo, broker: broker}
+}
+
+func (n notifyingRequestRepository) Create(req *request.Request) error {
+	if err := n.repo.Create(req); err != nil {
+		return err
+	}
+	n.broker.Publish(changeOf(req))
+	return nil
+}
+
+func (n notifyingRequestRepository) FindByID(reqID uuid.UUID) (*request.Request, error) {
+	return n.repo.FindByID(reqID)
+}
+
+func (n notifyingRequestRepository) Update(req *request.Request) error {
+	if err := n.repo.Update(req); err != nil {
+		return err
+	}
+	n.broker.Publish(changeOf(req))
+	return nil
+}
+
+func (n notifyingRequestRepository) List(opts request.ListOptions) (*request.ListResult, error) {
+	return n.repo.List(opts)
+}
+
+func (n notifyingRequestRepository) DeleteFields(reqID uuid.UUID, fields ...string) error {
+	if err := n.repo.DeleteFields(reqID, fields...); err != nil {
+		return err
+	}
+	n.broker.Publish(Change{RequestID: reqID})
+	return nil
+}
+
+func changeOf(req *request.Request) Change {
+	return Change{RequestID: req.RequestID, Status: req.Status, Stage: req.Stage, UpdatedAt: req.UpdatedAt}
+}
@@ -0,0 +1,113 @@
+package notify_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/notify"
+	"github.com/peterpla/lead-expert/pkg/request"
+)
+
+func TestBroker(t *testing.T) {
+	broker := notify.NewBroker()
+	reqID := uuid.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := broker.Subscribe(ctx, reqID)
+	if err != nil {
+		t.Fatalf("Subscribe error: %v", err)
+	}
+
+	// another Request's changes aren't received
+	broker.Publish(notify.Change{RequestID: uuid.New(), Status: request.Completed})
+
+	// unreceived changes are replaced by the latest
+	broker.Publish(notify.Change{RequestID: reqID, Stage: "TranscriptionGCP"})
+	broker.Publish(notify.Change{RequestID: reqID, Stage: "TranscriptionComplete"})
+
+	select {
+	case c := <-changes:
+		if c.Stage != "TranscriptionComplete" {
+			t.Errorf("expected latest stage %q, got %q", "TranscriptionComplete", c.Stage)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a change")
+	}
+	select {
+	case c := <-changes:
+		t.Errorf("expected no more changes, got %+v", c)
+	default:
+	}
+
+	// the channel is closed when ctx is done
+	cancel()
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Errorf("expected channel closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected channel closed")
+	}
+	broker.Publish(notify.Change{RequestID: reqID}) // no subscribers, mustn't block or panic
+}
+
+func TestNotifyingRequestRepository(t *testing.T) {
+	broker := notify.NewBroker()
+	repo := notify.NewNotifyingRequestRepository(database.NewMemoryRequestRepository(), broker)
+
+	req := request.Request{
+		RequestID:    uuid.New(),
+		CustomerID:   1234567,
+		MediaFileURI: "gs://elated-practice-224603.appspot.com/audio_uploads/audio-01.mp3",
+		Status:       request.Pending,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, _ := broker.Subscribe(ctx, req.RequestID)
+
+	type test struct {
+		name   string
+		write  func() error
+		status string
+	}
+
+	tests := []test{
+		{name: "create", write: func() error { return repo.Create(&req) }, status: request.Pending},
+		{name: "update", write: func() error {
+			req.Status = request.Completed
+			return repo.Update(&req)
+		}, status: request.Completed},
+		{name: "delete fields", write: func() error { return repo.DeleteFields(req.RequestID, "tags") }, status: ""},
+	}
+
+	for _, tc := range tests {
+		if err := tc.write(); err != nil {
+			t.Fatalf("%s: error: %v", tc.name, err)
+		}
+		select {
+		case c := <-changes:
+			if c.RequestID != req.RequestID || c.Status != tc.status {
+				t.Errorf("%s: expected change to %s with status %q, got %+v", tc.name, req.RequestID, tc.status, c)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: expected a change", tc.name)
+		}
+	}
+
+	// failed writes aren't published
+	nilChanges, _ := broker.Subscribe(ctx, uuid.Nil)
+	if err := repo.Update(&request.Request{}); err == nil {
+		t.Errorf("expected Update with zero RequestID to fail")
+	}
+	select {
+	case c := <-nilChanges:
+		t.Errorf("expected no change, got %+v", c)
+	default:
+	}
+}
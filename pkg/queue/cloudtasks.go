@@ -5,8 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/google/uuid"
+	"google.golang.org/api/iterator"
 	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
 
 	"github.com/peterpla/lead-expert/pkg/config"
@@ -39,10 +43,16 @@ func (gct *gctSystem) Connect(qi *QueueInfo) error {
 }
 
 func (gct *gctSystem) Add(qi *QueueInfo, request *request.Request) error {
+	return gct.AddAfter(qi, request, 0)
+}
+
+// AddAfter adds a task scheduled delay from now; Cloud Tasks runs it no
+// sooner
+func (gct *gctSystem) AddAfter(qi *QueueInfo, request *request.Request, delay time.Duration) error {
 	// add the request to the GCT queue
 
 	// JSON-encode the incoming req as the payload message
-	requestJSON, err := json.Marshal(request)
+	requestJSON, err := TaskPayload(request)
 	if err != nil {
 		return fmt.Errorf("queue.AddToQueue: %v", err)
 	}
@@ -80,6 +90,11 @@ func (gct *gctSystem) Add(qi *QueueInfo, request *request.Request) error {
 		},
 		ResponseView: taskspb.Task_FULL, // includes Body in response
 	}
+	if delay > 0 {
+		if qReq.Task.ScheduleTime, err = ptypes.TimestampProto(time.Now().Add(delay)); err != nil {
+			return fmt.Errorf("queue.AddAfter: %v", err)
+		}
+	}
 
 	createdTask, err := client.CreateTask(ctx, qReq)
 	if err != nil {
@@ -99,6 +114,79 @@ func (gct *gctSystem) Add(qi *QueueInfo, request *request.Request) error {
 	return nil
 }
 
+// FindTasks lists the queue's pending tasks, returning the names of those
+// whose payload is the Request identified by requestID
+func (gct *gctSystem) FindTasks(qi *QueueInfo, requestID uuid.UUID) ([]string, error) {
+	ctx := context.Background()
+	client, err := cloudtasks.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("NewClient: %v", err)
+	}
+	defer client.Close()
+
+	found := []string{}
+	it := client.ListTasks(ctx, &taskspb.ListTasksRequest{
+		Parent:       qi.Name,
+		ResponseView: taskspb.Task_FULL, // includes Body
+	})
+	for {
+		task, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return found, fmt.Errorf("queue.FindTasks: %v", err)
+		}
+
+		var payload struct {
+			RequestID uuid.UUID `json:"request_id"`
+		}
+		if err := json.Unmarshal(task.GetAppEngineHttpRequest().GetBody(), &payload); err != nil {
+			continue // not a Request
+		}
+		if payload.RequestID == requestID {
+			found = append(found, task.Name)
+		}
+	}
+
+	return found, nil
+}
+
+// DeleteTask removes a pending task from the queue
+func (gct *gctSystem) DeleteTask(qi *QueueInfo, taskName string) error {
+	ctx := context.Background()
+	client, err := cloudtasks.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("NewClient: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.DeleteTask(ctx, &taskspb.DeleteTaskRequest{Name: taskName}); err != nil {
+		return fmt.Errorf("queue.DeleteTask: %v", err)
+	}
+	return nil
+}
+
+// PipelineQueueInfos returns a QueueInfo for each queue in the pipeline
+// (config file key PipelineQueues), e.g. to find tasks carrying a Request
+func PipelineQueueInfos(cfg *config.Config) []QueueInfo {
+	infos := []QueueInfo{}
+	for _, name := range cfg.PipelineQueues {
+		infos = append(infos, NamedQueueInfo(cfg, name, ""))
+	}
+	return infos
+}
+
+// NamedQueueInfo returns the QueueInfo for the named Cloud Tasks queue,
+// handled by service
+func NamedQueueInfo(cfg *config.Config, name string, service string) QueueInfo {
+	return QueueInfo{
+		Name:            fmt.Sprintf("projects/%s/locations/%s/queues/%s", cfg.ProjectID, cfg.StorageLocation, name),
+		ServiceToHandle: service,
+		HandlerEndpoint: "/task_handler",
+	}
+}
+
 func (gct *gctSystem) InfoFromConfig(qi *QueueInfo) error {
 	cfg := config.GetConfigPointer()
 
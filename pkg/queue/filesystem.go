@@ -1,6 +1,12 @@
 package queue
 
-import "github.com/peterpla/lead-expert/pkg/request"
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/request"
+)
 
 // ********** ********** ********** ********** ********** **********
 
@@ -33,9 +39,21 @@ func (fs *fileSystem) Add(qi *QueueInfo, request *request.Request) error {
 	return nil
 }
 
+func (fs *fileSystem) AddAfter(qi *QueueInfo, request *request.Request, delay time.Duration) error {
+	return fs.Add(qi, request)
+}
+
 func (fs *fileSystem) InfoFromConfig(qi *QueueInfo) error {
 	qi.Name = "null"
 	qi.ServiceToHandle = "null"
 	qi.HandlerEndpoint = "/task_handler"
 	return nil
 }
+
+func (fs *fileSystem) FindTasks(qi *QueueInfo, requestID uuid.UUID) ([]string, error) {
+	return nil, nil
+}
+
+func (fs *fileSystem) DeleteTask(qi *QueueInfo, taskName string) error {
+	return nil
+}
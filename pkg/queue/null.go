@@ -1,6 +1,12 @@
 package queue
 
-import "github.com/peterpla/lead-expert/pkg/request"
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/request"
+)
 
 // ********** ********** ********** ********** ********** **********
 
@@ -30,9 +36,21 @@ func (gct *nullSystem) Add(qi *QueueInfo, request *request.Request) error {
 	return nil
 }
 
+func (gct *nullSystem) AddAfter(qi *QueueInfo, request *request.Request, delay time.Duration) error {
+	return gct.Add(qi, request)
+}
+
 func (gct *nullSystem) InfoFromConfig(qi *QueueInfo) error {
 	qi.Name = "null"
 	qi.ServiceToHandle = "null"
 	qi.HandlerEndpoint = "/task_handler"
 	return nil
 }
+
+func (gct *nullSystem) FindTasks(qi *QueueInfo, requestID uuid.UUID) ([]string, error) {
+	return nil, nil
+}
+
+func (gct *nullSystem) DeleteTask(qi *QueueInfo, taskName string) error {
+	return nil
+}
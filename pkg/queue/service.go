@@ -1,6 +1,11 @@
 package queue
 
 import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+
 	"github.com/peterpla/lead-expert/pkg/request"
 )
 
@@ -17,7 +22,24 @@ type Queue interface {
 	Create(q *QueueInfo) error
 	Connect(q *QueueInfo) error
 	Add(q *QueueInfo, request *request.Request) error
-	InfoFromConfig(q *QueueInfo) error // populate QueueInfo with config
+	// AddAfter adds a task not run till delay has passed
+	AddAfter(q *QueueInfo, request *request.Request, delay time.Duration) error
+	InfoFromConfig(q *QueueInfo) error                             // populate QueueInfo with config
+	FindTasks(q *QueueInfo, requestID uuid.UUID) ([]string, error) // names of pending tasks carrying the Request
+	DeleteTask(q *QueueInfo, taskName string) error
+}
+
+// MaxTaskSize is the most a Cloud Tasks task, body included, may hold
+const MaxTaskSize = 1 << 20
+
+// TaskPayload returns request as a task's body. The structured transcript
+// is left out, as a long recording's would outgrow a task: later stages
+// read it from the Requests database.
+func TaskPayload(request *request.Request) ([]byte, error) {
+	payload := *request
+	payload.Structured = nil
+	payload.EncryptedStructure = ""
+	return json.Marshal(&payload)
 }
 
 // ********** ********** ********** ********** ********** **********
@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/customer"
+)
+
+// quotas, by the names reported in X-Quota-Remaining-<name> headers
+const (
+	QuotaDailyRequests       = "Daily-Requests"
+	QuotaMonthlyRequests     = "Monthly-Requests"
+	QuotaDailyAudioMinutes   = "Daily-Audio-Minutes"
+	QuotaMonthlyAudioMinutes = "Monthly-Audio-Minutes"
+)
+
+// Quota is one of a customer's quotas, and how much of it is used
+type Quota struct {
+	Name   string // e.g. QuotaDailyRequests
+	Limit  int
+	Used   int
+	Resets time.Time
+}
+
+// Remaining is how much of the quota is left, never negative
+func (q Quota) Remaining() int {
+	if q.Used >= q.Limit {
+		return 0
+	}
+	return q.Limit - q.Used
+}
+
+// CountsRequests returns true if each accepted request uses the quota
+func (q Quota) CountsRequests() bool {
+	return q.Name == QuotaDailyRequests || q.Name == QuotaMonthlyRequests
+}
+
+// Decision is the outcome of checking a request against the caller's rate
+// limits and quotas
+type Decision struct {
+	Allowed    bool
+	Reason     string        // why not
+	RetryAfter time.Duration // when not, how long until it may be
+	Limit      int           // the customer's requests per minute
+	Remaining  int           // requests left in the customer's burst
+	Quotas     []Quota       // the customer's configured quotas
+}
+
+// Enforcer checks requests against customers' rate limits and quotas
+type Enforcer struct {
+	Limiter *Limiter
+	Usage   UsageStore
+}
+
+// Check decides whether the customer may submit a request now. key
+// identifies the credential used, e.g. the API key's KeyID, and gets its
+// own bucket when the customer's profile sets a per-key rate. Exhausted
+// quotas are checked first, and the key's and customer's buckets are
+// checked together, so rejected requests don't use up either rate.
+func (e *Enforcer) Check(customerID int, key string, limits customer.Limits, now time.Time) (Decision, error) {
+	quotas, err := e.quotas(customerID, limits, now)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	perMinute, burst := limits.RequestsPerMinute, limits.Burst
+	if perMinute == 0 {
+		perMinute = DefaultRequestsPerMinute
+	}
+	if burst == 0 {
+		burst = DefaultBurst
+	}
+	d := Decision{Allowed: true, Limit: perMinute, Remaining: burst, Quotas: quotas}
+
+	for _, q := range quotas {
+		if q.Remaining() == 0 {
+			d.Allowed = false
+			d.Reason = fmt.Sprintf("%s quota of %d exhausted", q.Name, q.Limit)
+			if wait := q.Resets.Sub(now); wait > d.RetryAfter {
+				d.RetryAfter = wait
+			}
+		}
+	}
+	if !d.Allowed {
+		return d, nil
+	}
+
+	// the customer's bucket is last, so its tokens are the ones reported
+	rates := []Rate{}
+	if limits.KeyRequestsPerMinute > 0 && key != "" {
+		keyBurst := limits.KeyBurst
+		if keyBurst == 0 {
+			keyBurst = burst
+		}
+		rates = append(rates, Rate{Key: fmt.Sprintf("%d/%s", customerID, key), PerMinute: limits.KeyRequestsPerMinute, Burst: keyBurst})
+	}
+	rates = append(rates, Rate{Key: fmt.Sprintf("%d", customerID), PerMinute: perMinute, Burst: burst})
+
+	denied, remaining, wait := e.Limiter.AllowAll(rates, now)
+	d.Remaining = remaining[len(remaining)-1]
+	switch {
+	case denied < 0:
+	case denied < len(rates)-1:
+		d.Allowed, d.Reason, d.RetryAfter = false, "key rate limit exceeded", wait
+	default:
+		d.Allowed, d.Reason, d.RetryAfter = false, "rate limit exceeded", wait
+	}
+
+	return d, nil
+}
+
+// quotas returns the customer's configured quotas, and their use
+func (e *Enforcer) quotas(customerID int, limits customer.Limits, now time.Time) ([]Quota, error) {
+	quotas := []Quota{}
+	if limits.DailyRequests == 0 && limits.MonthlyRequests == 0 &&
+		limits.DailyAudioMinutes == 0 && limits.MonthlyAudioMinutes == 0 {
+		return quotas, nil
+	}
+
+	day, err := e.Usage.Get(customerID, DayPeriod(now))
+	if err != nil {
+		return nil, err
+	}
+	month, err := e.Usage.Get(customerID, MonthPeriod(now))
+	if err != nil {
+		return nil, err
+	}
+
+	add := func(name string, limit int, used int, resets time.Time) {
+		if limit > 0 {
+			quotas = append(quotas, Quota{Name: name, Limit: limit, Used: used, Resets: resets})
+		}
+	}
+	add(QuotaDailyRequests, limits.DailyRequests, day.Requests, nextDay(now))
+	add(QuotaMonthlyRequests, limits.MonthlyRequests, month.Requests, nextMonth(now))
+	add(QuotaDailyAudioMinutes, limits.DailyAudioMinutes, day.AudioSeconds/60, nextDay(now))
+	add(QuotaMonthlyAudioMinutes, limits.MonthlyAudioMinutes, month.AudioSeconds/60, nextMonth(now))
+
+	return quotas, nil
+}
@@ -0,0 +1,125 @@
+// Ratelimit package limits how fast customers submit requests, with token
+// buckets, and how much, with daily and monthly quotas on requests and
+// audio minutes
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// defaults for customers whose profile doesn't set a rate
+const (
+	DefaultRequestsPerMinute = 60
+	DefaultBurst             = 10
+)
+
+// maxBuckets is how many buckets a Limiter holds before it forgets full
+// ones, which are the same as new ones
+const maxBuckets = 10000
+
+// Limiter is a set of token buckets, by key. Buckets are held in memory, so
+// each service instance limits independently.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens    float64
+	perMinute int
+	burst     int
+	updated   time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+// Rate is the key of a token bucket, and its size and refill rate
+type Rate struct {
+	Key       string
+	PerMinute int
+	Burst     int
+}
+
+// Allow takes a token from key's bucket, which holds up to burst tokens and
+// refills at perMinute. If the bucket is empty it returns false, and how
+// long until a token is available.
+func (l *Limiter) Allow(key string, perMinute int, burst int, now time.Time) (ok bool, remaining int, retryAfter time.Duration) {
+	denied, remainings, wait := l.AllowAll([]Rate{{Key: key, PerMinute: perMinute, Burst: burst}}, now)
+	if denied >= 0 {
+		return false, 0, wait
+	}
+	return true, remainings[0], 0
+}
+
+// AllowAll takes a token from each rate's bucket, but only if none of them
+// is empty, so a request rejected by one bucket doesn't use up the others.
+// It returns the index of the first empty bucket and how long until it has
+// a token, or -1; and the whole tokens left in each bucket.
+func (l *Limiter) AllowAll(rates []Rate, now time.Time) (denied int, remaining []int, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.buckets)+len(rates) > maxBuckets {
+		l.forgetFull(now)
+	}
+
+	buckets := make([]*bucket, len(rates))
+	denied = -1
+	for i, rate := range rates {
+		perMinute, burst := rate.PerMinute, rate.Burst
+		if perMinute < 1 {
+			perMinute = 1
+		}
+		if burst < 1 {
+			burst = 1
+		}
+
+		b, found := l.buckets[rate.Key]
+		if !found {
+			b = &bucket{tokens: float64(burst), updated: now}
+			l.buckets[rate.Key] = b
+		}
+		b.refill(now)
+		// a changed profile takes effect immediately
+		b.perMinute, b.burst = perMinute, burst
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+		buckets[i] = b
+
+		if b.tokens < 1 && denied < 0 {
+			denied = i
+			retryAfter = time.Duration((1 - b.tokens) / float64(perMinute) * float64(time.Minute))
+		}
+	}
+
+	remaining = make([]int, len(rates))
+	for i, b := range buckets {
+		if denied < 0 {
+			b.tokens--
+		}
+		remaining[i] = int(math.Floor(b.tokens))
+	}
+	return denied, remaining, retryAfter
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(b.burst), b.tokens+elapsed.Minutes()*float64(b.perMinute))
+		b.updated = now
+	}
+}
+
+// forgetFull drops buckets that have refilled
+func (l *Limiter) forgetFull(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
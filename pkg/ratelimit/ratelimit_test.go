@@ -0,0 +1,159 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/customer"
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/ratelimit"
+)
+
+func TestLimiter(t *testing.T) {
+	l := ratelimit.NewLimiter()
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	type test struct {
+		name      string
+		after     time.Duration
+		ok        bool
+		remaining int
+		retry     time.Duration
+	}
+
+	// 60 per minute with a burst of 2: one token a second
+	tests := []test{
+		{name: "first", ok: true, remaining: 1},
+		{name: "second", ok: true, remaining: 0},
+		{name: "empty", ok: false, retry: time.Second},
+		{name: "half refilled", after: 500 * time.Millisecond, ok: false, retry: 500 * time.Millisecond},
+		{name: "refilled", after: 500 * time.Millisecond, ok: true, remaining: 0},
+		{name: "full again", after: time.Hour, ok: true, remaining: 1},
+	}
+
+	for _, tc := range tests {
+		now = now.Add(tc.after)
+		ok, remaining, retry := l.Allow("1234567", 60, 2, now)
+		if ok != tc.ok || remaining != tc.remaining || retry != tc.retry {
+			t.Errorf("%s: expected %t, %d, %v, got %t, %d, %v", tc.name, tc.ok, tc.remaining, tc.retry, ok, remaining, retry)
+		}
+	}
+
+	// buckets are independent
+	if ok, _, _ := l.Allow("7654321", 60, 2, now); !ok {
+		t.Errorf("expected another key's bucket to be full")
+	}
+
+	// a token is taken from every bucket or none
+	l.Allow("1234567", 60, 2, now) // the last token
+	rates := []ratelimit.Rate{{Key: "1234567/abc", PerMinute: 60, Burst: 2}, {Key: "1234567", PerMinute: 60, Burst: 2}}
+	denied, remaining, retry := l.AllowAll(rates, now)
+	if denied != 1 || retry != time.Second || remaining[0] != 2 || remaining[1] != 0 {
+		t.Errorf("expected the second bucket empty for 1s and the first untouched, got %d, %v, %v", denied, remaining, retry)
+	}
+	now = now.Add(time.Second)
+	denied, remaining, _ = l.AllowAll(rates, now)
+	if denied != -1 || remaining[0] != 1 || remaining[1] != 0 {
+		t.Errorf("expected a token from each bucket, got %d, %v", denied, remaining)
+	}
+}
+
+func TestEnforcer(t *testing.T) {
+	now := time.Date(2020, 3, 31, 23, 0, 0, 0, time.UTC)
+
+	type test struct {
+		name      string
+		limits    customer.Limits
+		key       string
+		requests  int // already accepted today
+		audio     time.Duration
+		allowed   int // of 3 attempts
+		retry     time.Duration
+		quotas    int
+		remaining int // of the first quota, before the attempts
+	}
+
+	tests := []test{
+		{name: "defaults", allowed: 3},
+		{name: "customer rate", limits: customer.Limits{RequestsPerMinute: 1, Burst: 2}, allowed: 2, retry: time.Minute},
+		{name: "key rate", limits: customer.Limits{KeyRequestsPerMinute: 1, KeyBurst: 1}, key: "abc", allowed: 1, retry: time.Minute},
+		{name: "key rate, customer empty", limits: customer.Limits{RequestsPerMinute: 1, Burst: 1, KeyRequestsPerMinute: 60, KeyBurst: 3}, key: "abc", allowed: 1, retry: time.Minute},
+		{name: "key rate, no key", limits: customer.Limits{KeyRequestsPerMinute: 1, KeyBurst: 1}, allowed: 3},
+		{name: "daily requests", limits: customer.Limits{DailyRequests: 5}, requests: 5, allowed: 0, retry: time.Hour, quotas: 1},
+		{name: "monthly requests", limits: customer.Limits{MonthlyRequests: 10}, requests: 4, allowed: 3, quotas: 1, remaining: 6},
+		{name: "daily audio", limits: customer.Limits{DailyAudioMinutes: 10, DailyRequests: 100}, audio: 10 * time.Minute, allowed: 0, retry: time.Hour, quotas: 2},
+		{name: "monthly audio left", limits: customer.Limits{MonthlyAudioMinutes: 10}, audio: 9*time.Minute + 59*time.Second, allowed: 3, quotas: 1, remaining: 1},
+	}
+
+	for _, tc := range tests {
+		usage := database.NewMemoryUsageStore()
+		for i := 0; i < tc.requests; i++ {
+			if err := ratelimit.RecordRequest(usage, 1234567, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ratelimit.RecordAudio(usage, 1234567, tc.audio, now); err != nil {
+			t.Fatal(err)
+		}
+		e := &ratelimit.Enforcer{Limiter: ratelimit.NewLimiter(), Usage: usage}
+
+		allowed := 0
+		var last ratelimit.Decision
+		for i := 0; i < 3; i++ {
+			d, err := e.Check(1234567, tc.key, tc.limits, now)
+			if err != nil {
+				t.Fatalf("%s: unexpected error %v", tc.name, err)
+			}
+			if i == 0 && len(d.Quotas) > 0 && tc.remaining > 0 && d.Quotas[0].Remaining() != tc.remaining {
+				t.Errorf("%s: expected %d remaining, got %+v", tc.name, tc.remaining, d.Quotas[0])
+			}
+			if d.Allowed {
+				allowed++
+			}
+			last = d
+		}
+		if allowed != tc.allowed {
+			t.Errorf("%s: expected %d allowed, got %d", tc.name, tc.allowed, allowed)
+		}
+		if !last.Allowed && last.RetryAfter != tc.retry {
+			t.Errorf("%s: expected Retry-After %v, got %v", tc.name, tc.retry, last.RetryAfter)
+		}
+		if len(last.Quotas) != tc.quotas {
+			t.Errorf("%s: expected %d quotas, got %+v", tc.name, tc.quotas, last.Quotas)
+		}
+	}
+}
+
+func TestRecordUsage(t *testing.T) {
+	usage := database.NewMemoryUsageStore()
+	march := time.Date(2020, 3, 31, 23, 59, 0, 0, time.UTC)
+	april := march.Add(2 * time.Minute)
+
+	_ = ratelimit.RecordRequest(usage, 1234567, march)
+	_ = ratelimit.RecordAudio(usage, 1234567, 90*time.Second+time.Millisecond, march)
+	_ = ratelimit.RecordRequest(usage, 1234567, april)
+
+	type test struct {
+		period   string
+		requests int
+		seconds  int
+	}
+
+	tests := []test{
+		{period: "day:2020-03-31", requests: 1, seconds: 91},
+		{period: "month:2020-03", requests: 1, seconds: 91},
+		{period: "day:2020-04-01", requests: 1},
+		{period: "month:2020-04", requests: 1},
+		{period: "month:2020-05"},
+	}
+
+	for _, tc := range tests {
+		u, err := usage.Get(1234567, tc.period)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Requests != tc.requests || u.AudioSeconds != tc.seconds {
+			t.Errorf("%s: expected %d requests, %d seconds, got %+v", tc.period, tc.requests, tc.seconds, u)
+		}
+	}
+}
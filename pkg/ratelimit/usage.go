@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// Usage is how much a customer submitted in a period
+type Usage struct {
+	CustomerID   int    `json:"customer_id" firestore:"customer_id"`
+	Period       string `json:"period" firestore:"period"` // DayPeriod or MonthPeriod
+	Requests     int    `json:"requests" firestore:"requests"`
+	AudioSeconds int    `json:"audio_seconds" firestore:"audio_seconds"`
+}
+
+// UsageStore is implemented by each supported usage database. Get returns
+// zero Usage for a period with none recorded.
+type UsageStore interface {
+	Add(customerID int, period string, requests int, audioSeconds int) error
+	Get(customerID int, period string) (*Usage, error)
+}
+
+// DayPeriod names the UTC day holding t, e.g. "day:2020-03-01"
+func DayPeriod(t time.Time) string {
+	return "day:" + t.UTC().Format("2006-01-02")
+}
+
+// MonthPeriod names the UTC month holding t, e.g. "month:2020-03"
+func MonthPeriod(t time.Time) string {
+	return "month:" + t.UTC().Format("2006-01")
+}
+
+// nextDay and nextMonth are when the periods holding t end
+func nextDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+func nextMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// RecordRequest counts an accepted request toward the customer's quotas
+func RecordRequest(store UsageStore, customerID int, now time.Time) error {
+	if err := store.Add(customerID, DayPeriod(now), 1, 0); err != nil {
+		return err
+	}
+	return store.Add(customerID, MonthPeriod(now), 1, 0)
+}
+
+// RecordAudio counts transcribed audio toward the customer's quotas
+func RecordAudio(store UsageStore, customerID int, audio time.Duration, now time.Time) error {
+	seconds := int((audio + time.Second - 1) / time.Second)
+	if seconds <= 0 {
+		return nil
+	}
+	if err := store.Add(customerID, DayPeriod(now), 0, seconds); err != nil {
+		return err
+	}
+	return store.Add(customerID, MonthPeriod(now), 0, seconds)
+}
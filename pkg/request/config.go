@@ -0,0 +1,92 @@
+package request
+
+// DefaultLanguageCode is the speech-to-text language used when neither the
+// customer's profile nor the Request specifies one
+const DefaultLanguageCode = "en-US"
+
+// DefaultModel is the speech-to-text model used when neither the customer's
+// profile nor the Request specifies one
+const DefaultModel = "phone_call"
+
+// DefaultMaxAlternatives is the number of speech-to-text alternatives requested
+// when neither the customer's profile nor the Request specifies one
+const DefaultMaxAlternatives = 2
+
+// Channels of ProcessingConfig: how multi-channel audio, e.g. a call
+// recorded with caller and agent on separate channels, is transcribed
+const (
+	ChannelsSeparate = "separate" // each channel alone, as one speaker; the default
+	ChannelsMixed    = "mixed"    // mixed down, speakers told apart by diarization
+)
+
+// DefaultInfoTypes are the DLP InfoTypes tagged when neither the customer's
+// profile nor the Request specifies any
+var DefaultInfoTypes = []string{"PHONE_NUMBER", "PERSON_NAME", "STREET_ADDRESS", "US_STATE"}
+
+// ProcessingConfig controls how a Request is transcribed and tagged.
+// Zero-valued fields mean "use the default".
+type ProcessingConfig struct {
+	Provider                string   `json:"provider,omitempty" firestore:"provider,omitempty" validate:"omitempty,oneof=google local"` // speech-to-text provider, see pkg/transcription
+	LanguageCode            string   `json:"language_code,omitempty" firestore:"language_code,omitempty" validate:"omitempty,max=35"`   // BCP-47, e.g. "en-US"
+	Model                   string   `json:"model,omitempty" firestore:"model,omitempty" validate:"omitempty,oneof=default phone_call video command_and_search"`
+	MaxAlternatives         int      `json:"max_alternatives,omitempty" firestore:"max_alternatives,omitempty" validate:"gte=0,lte=30"`
+	DiarizationSpeakerCount int      `json:"diarization_speaker_count,omitempty" firestore:"diarization_speaker_count,omitempty" validate:"gte=0,lte=10"` // 0 = detect automatically
+	Channels                string   `json:"channels,omitempty" firestore:"channels,omitempty" validate:"omitempty,oneof=separate mixed"`                 // ChannelsSeparate or ChannelsMixed; "" = separate
+	InfoTypes               []string `json:"info_types,omitempty" firestore:"info_types,omitempty" validate:"omitempty,dive,required"`
+}
+
+// DefaultProcessingConfig returns the system-wide defaults
+func DefaultProcessingConfig() ProcessingConfig {
+	return ProcessingConfig{
+		LanguageCode:    DefaultLanguageCode,
+		Model:           DefaultModel,
+		MaxAlternatives: DefaultMaxAlternatives,
+		InfoTypes:       append([]string(nil), DefaultInfoTypes...),
+	}
+}
+
+// Merge returns a copy of c with each non-zero field of over replacing the
+// corresponding field of c
+func (c ProcessingConfig) Merge(over ProcessingConfig) ProcessingConfig {
+	merged := c
+
+	if over.Provider != "" {
+		merged.Provider = over.Provider
+	}
+	if over.LanguageCode != "" {
+		merged.LanguageCode = over.LanguageCode
+	}
+	if over.Model != "" {
+		merged.Model = over.Model
+	}
+	if over.MaxAlternatives != 0 {
+		merged.MaxAlternatives = over.MaxAlternatives
+	}
+	if over.DiarizationSpeakerCount != 0 {
+		merged.DiarizationSpeakerCount = over.DiarizationSpeakerCount
+	}
+	if over.Channels != "" {
+		merged.Channels = over.Channels
+	}
+	if len(over.InfoTypes) != 0 {
+		merged.InfoTypes = append([]string(nil), over.InfoTypes...)
+	}
+
+	return merged
+}
+
+// ResolveConfig sets the Request's Config: the system defaults, overridden
+// by the customer's profile, overridden by the Request's CustomConfig
+func (req *Request) ResolveConfig(customerDefaults ProcessingConfig) {
+	resolved := DefaultProcessingConfig().Merge(customerDefaults)
+	if req.CustomConfig != nil {
+		resolved = resolved.Merge(*req.CustomConfig)
+	}
+	req.Config = resolved
+}
+
+// EffectiveConfig returns the Request's Config with defaults filled in for
+// any zero-valued fields, e.g. for Requests accepted before Config existed
+func (req *Request) EffectiveConfig() ProcessingConfig {
+	return DefaultProcessingConfig().Merge(req.Config)
+}
@@ -0,0 +1,98 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/go-playground/validator"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolveConfig(t *testing.T) {
+	customerDefaults := ProcessingConfig{
+		Model:     "video",
+		InfoTypes: []string{"EMAIL_ADDRESS"},
+	}
+
+	type test struct {
+		name     string
+		custom   *ProcessingConfig
+		defaults ProcessingConfig
+		expected ProcessingConfig
+	}
+
+	tests := []test{
+		{name: "system defaults",
+			custom:   nil,
+			defaults: ProcessingConfig{},
+			expected: DefaultProcessingConfig(),
+		},
+		{name: "customer defaults",
+			custom:   nil,
+			defaults: customerDefaults,
+			expected: ProcessingConfig{
+				LanguageCode:    DefaultLanguageCode,
+				Model:           "video",
+				MaxAlternatives: DefaultMaxAlternatives,
+				InfoTypes:       []string{"EMAIL_ADDRESS"},
+			},
+		},
+		{name: "custom_config overrides",
+			custom: &ProcessingConfig{
+				LanguageCode:            "es-US",
+				DiarizationSpeakerCount: 2,
+				Channels:                ChannelsMixed,
+				InfoTypes:               []string{"PHONE_NUMBER", "CREDIT_CARD_NUMBER"},
+			},
+			defaults: customerDefaults,
+			expected: ProcessingConfig{
+				LanguageCode:            "es-US",
+				Model:                   "video",
+				MaxAlternatives:         DefaultMaxAlternatives,
+				DiarizationSpeakerCount: 2,
+				Channels:                ChannelsMixed,
+				InfoTypes:               []string{"PHONE_NUMBER", "CREDIT_CARD_NUMBER"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		req := Request{CustomConfig: tc.custom}
+		req.ResolveConfig(tc.defaults)
+		if diff := cmp.Diff(tc.expected, req.Config); diff != "" {
+			t.Errorf("%s: mismatch (-expected +got):\n%s", tc.name, diff)
+		}
+	}
+
+	// Requests accepted before Config existed get the system defaults
+	var old Request
+	if diff := cmp.Diff(DefaultProcessingConfig(), old.EffectiveConfig()); diff != "" {
+		t.Errorf("EffectiveConfig: mismatch (-expected +got):\n%s", diff)
+	}
+}
+
+func TestProcessingConfigValidation(t *testing.T) {
+	validate := validator.New()
+
+	type test struct {
+		name    string
+		custom  *ProcessingConfig
+		wantErr bool
+	}
+
+	tests := []test{
+		{name: "no custom_config", custom: nil, wantErr: false},
+		{name: "valid", custom: &ProcessingConfig{Model: "phone_call", MaxAlternatives: 3}, wantErr: false},
+		{name: "unknown model", custom: &ProcessingConfig{Model: "bogus"}, wantErr: true},
+		{name: "too many alternatives", custom: &ProcessingConfig{MaxAlternatives: 31}, wantErr: true},
+		{name: "negative speaker count", custom: &ProcessingConfig{DiarizationSpeakerCount: -1}, wantErr: true},
+		{name: "unknown channels", custom: &ProcessingConfig{Channels: "left"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		req := Request{CustomerID: 1234567, MediaFileURI: "gs://bucket/file.mp3", CustomConfig: tc.custom}
+		err := validate.Struct(req)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
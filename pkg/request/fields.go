@@ -0,0 +1,38 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrUnknownField - no Request field has the given firestore name
+var ErrUnknownField = errors.New("Unknown Request field")
+
+// TranscriptFields are the firestore names of the fields holding transcript content
+var TranscriptFields = []string{"working_transcript", "final_transcript", "structured_transcript", "encrypted_structured_transcript", "tags", "encrypted_tags"}
+
+// ClearFields sets each named field, identified by its firestore name, to its
+// zero value. It's how in-memory copies mirror RequestRepository.DeleteFields.
+func (req *Request) ClearFields(fields ...string) error {
+	v := reflect.ValueOf(req).Elem()
+	t := v.Type()
+
+	for _, name := range fields {
+		found := false
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.SplitN(t.Field(i).Tag.Get("firestore"), ",", 2)[0]
+			if tag == name && tag != "-" {
+				v.Field(i).Set(reflect.Zero(t.Field(i).Type))
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: %q", ErrUnknownField, name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package request
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultListLimit is the page size used when ListOptions.Limit is zero
+const DefaultListLimit = 50
+
+// MaxListLimit is the largest page size List will return
+const MaxListLimit = 100
+
+// ErrInvalidListOptions - ListOptions failed validation
+var ErrInvalidListOptions = errors.New("Invalid list options")
+
+// ErrInvalidCursor - cursor provided does not decode
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// sortable fields, by Firestore/JSON field name
+var listOrderFields = map[string]bool{
+	"accepted_at":  true,
+	"created_at":   true,
+	"updated_at":   true,
+	"completed_at": true,
+}
+
+// ListOptions selects, sorts and pages the Requests returned by
+// RequestRepository.List. Zero-valued filters are ignored.
+type ListOptions struct {
+	CustomerID     int
+	Status         string    // one of "PENDING", "ERROR", "COMPLETED"
+	Stage          string    // most recent pipeline stage completed, e.g. "TranscriptionGCP"
+	AcceptedAfter  time.Time // inclusive
+	AcceptedBefore time.Time // exclusive
+	OrderBy        string    // one of listOrderFields, default "accepted_at"
+	Descending     bool
+	Limit          int
+	Cursor         string // opaque, from a previous ListResult.NextCursor
+}
+
+// ListResult holds one page of Requests, and the cursor for the next page
+// ("" when there are no more pages)
+type ListResult struct {
+	Requests   []*Request
+	NextCursor string
+}
+
+// Normalize applies defaults and validates the options
+func (o *ListOptions) Normalize() error {
+	if o.OrderBy == "" {
+		o.OrderBy = "accepted_at"
+	}
+	if !listOrderFields[o.OrderBy] {
+		return fmt.Errorf("%w: cannot sort by %q", ErrInvalidListOptions, o.OrderBy)
+	}
+
+	// Firestore requires the first sort field to match any range filter
+	if (!o.AcceptedAfter.IsZero() || !o.AcceptedBefore.IsZero()) && o.OrderBy != "accepted_at" {
+		return fmt.Errorf("%w: accepted_at range requires sort by accepted_at", ErrInvalidListOptions)
+	}
+	if !o.AcceptedAfter.IsZero() && !o.AcceptedBefore.IsZero() && !o.AcceptedAfter.Before(o.AcceptedBefore) {
+		return fmt.Errorf("%w: accepted_after must be before accepted_before", ErrInvalidListOptions)
+	}
+
+	switch o.Status {
+	case "", Pending, Error, Completed:
+	default:
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidListOptions, o.Status)
+	}
+
+	if o.Limit < 0 {
+		return fmt.Errorf("%w: negative limit", ErrInvalidListOptions)
+	}
+	if o.Limit == 0 {
+		o.Limit = DefaultListLimit
+	}
+	if o.Limit > MaxListLimit {
+		o.Limit = MaxListLimit
+	}
+
+	if o.Cursor != "" {
+		if _, _, err := DecodeCursor(o.Cursor); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether req satisfies the options' filters
+func (o *ListOptions) Matches(req *Request) bool {
+	if o.CustomerID != 0 && req.CustomerID != o.CustomerID {
+		return false
+	}
+	if o.Status != "" && req.Status != o.Status {
+		return false
+	}
+	if o.Stage != "" && req.Stage != o.Stage {
+		return false
+	}
+	if !o.AcceptedAfter.IsZero() && req.AcceptedAt < FormatListTime(o.AcceptedAfter) {
+		return false
+	}
+	if !o.AcceptedBefore.IsZero() && req.AcceptedAt >= FormatListTime(o.AcceptedBefore) {
+		return false
+	}
+	return true
+}
+
+// OrderValue returns the value of req's OrderBy field
+func (o *ListOptions) OrderValue(req *Request) string {
+	switch o.OrderBy {
+	case "created_at":
+		return req.CreatedAt
+	case "updated_at":
+		return req.UpdatedAt
+	case "completed_at":
+		return req.CompletedAt
+	default:
+		return req.AcceptedAt
+	}
+}
+
+// TimeLayout is how the Request timestamps Requests are listed by are
+// stored: RFC 3339 in UTC, always to the nanosecond, so comparing them as
+// strings orders them in time. (time.RFC3339Nano drops trailing zeros, so
+// "...:05Z" would sort after "...:05.1Z".)
+const TimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// FormatListTime formats t the way Request timestamps are stored, so the
+// result can be compared with them
+func FormatListTime(t time.Time) string {
+	return t.UTC().Format(TimeLayout)
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// listCursor identifies the last Request on a page: its sort value, and
+// its RequestID to break ties
+type listCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// EncodeCursor returns an opaque cursor positioned after the given sort value and RequestID
+func EncodeCursor(value string, id string) string {
+	b, _ := json.Marshal(listCursor{Value: value, ID: id})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor returns the sort value and RequestID encoded by EncodeCursor
+func DecodeCursor(cursor string) (value string, id string, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", ErrInvalidCursor
+	}
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return "", "", ErrInvalidCursor
+	}
+	return c.Value, c.ID, nil
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// ListOptionsFromQuery builds ListOptions from GET /requests query parameters
+func ListOptionsFromQuery(v url.Values) (ListOptions, error) {
+	var opts ListOptions
+	var err error
+
+	if s := v.Get("customer_id"); s != "" {
+		if opts.CustomerID, err = strconv.Atoi(s); err != nil {
+			return opts, fmt.Errorf("%w: customer_id %q", ErrInvalidListOptions, s)
+		}
+	}
+	opts.Status = strings.ToUpper(v.Get("status"))
+	opts.Stage = v.Get("stage")
+
+	if s := v.Get("accepted_after"); s != "" {
+		if opts.AcceptedAfter, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return opts, fmt.Errorf("%w: accepted_after %q", ErrInvalidListOptions, s)
+		}
+	}
+	if s := v.Get("accepted_before"); s != "" {
+		if opts.AcceptedBefore, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return opts, fmt.Errorf("%w: accepted_before %q", ErrInvalidListOptions, s)
+		}
+	}
+
+	opts.OrderBy = v.Get("sort")
+	switch strings.ToLower(v.Get("order")) {
+	case "", "desc":
+		opts.Descending = true // newest first unless asked otherwise
+	case "asc":
+		opts.Descending = false
+	default:
+		return opts, fmt.Errorf("%w: order %q", ErrInvalidListOptions, v.Get("order"))
+	}
+
+	if s := v.Get("limit"); s != "" {
+		if opts.Limit, err = strconv.Atoi(s); err != nil {
+			return opts, fmt.Errorf("%w: limit %q", ErrInvalidListOptions, s)
+		}
+	}
+	opts.Cursor = v.Get("cursor")
+
+	if err = opts.Normalize(); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// RequestSummary holds selected fields of a Request to include in
+// HTTP response to GET /requests
+type RequestSummary struct {
+	RequestID    string `json:"request_id"`
+	CustomerID   int    `json:"customer_id"`
+	MediaFileURI string `json:"media_uri"`
+	Status       string `json:"status"`
+	Stage        string `json:"stage,omitempty"`
+	AcceptedAt   string `json:"accepted_at"`
+	CompletedAt  string `json:"completed_at,omitempty"`
+	Endpoint     string `json:"endpoint,omitempty"` // uri
+}
+
+// ListResponse is the HTTP response to GET /requests
+type ListResponse struct {
+	Requests   []RequestSummary `json:"requests"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
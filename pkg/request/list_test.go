@@ -0,0 +1,106 @@
+package request
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestListOptionsFromQuery(t *testing.T) {
+
+	type test struct {
+		name     string
+		query    string
+		err      error
+		expected ListOptions
+	}
+
+	after := time.Date(2020, 1, 6, 0, 0, 0, 0, time.UTC)
+	before := after.Add(time.Hour * 24 * 7)
+
+	tests := []test{
+		{name: "defaults",
+			query:    "customer_id=1234567",
+			expected: ListOptions{CustomerID: 1234567, OrderBy: "accepted_at", Descending: true, Limit: DefaultListLimit}},
+		{name: "all filters",
+			query: "customer_id=1234567&status=error&stage=TranscriptionGCP&accepted_after=2020-01-06T00:00:00Z&accepted_before=2020-01-13T00:00:00Z&order=asc&limit=10",
+			expected: ListOptions{CustomerID: 1234567, Status: Error, Stage: "TranscriptionGCP",
+				AcceptedAfter: after, AcceptedBefore: before, OrderBy: "accepted_at", Limit: 10}},
+		{name: "limit capped",
+			query:    "customer_id=1234567&limit=1000",
+			expected: ListOptions{CustomerID: 1234567, OrderBy: "accepted_at", Descending: true, Limit: MaxListLimit}},
+		{name: "bad status",
+			query: "customer_id=1234567&status=LOST",
+			err:   ErrInvalidListOptions},
+		{name: "bad sort field",
+			query: "customer_id=1234567&sort=media_uri",
+			err:   ErrInvalidListOptions},
+		{name: "range requires accepted_at sort",
+			query: "customer_id=1234567&sort=updated_at&accepted_after=2020-01-06T00:00:00Z",
+			err:   ErrInvalidListOptions},
+		{name: "inverted range",
+			query: "customer_id=1234567&accepted_after=2020-01-13T00:00:00Z&accepted_before=2020-01-06T00:00:00Z",
+			err:   ErrInvalidListOptions},
+		{name: "bad time",
+			query: "customer_id=1234567&accepted_after=yesterday",
+			err:   ErrInvalidListOptions},
+		{name: "bad cursor",
+			query: "customer_id=1234567&cursor=nope",
+			err:   ErrInvalidCursor},
+	}
+
+	for _, tc := range tests {
+		values, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("%s: ParseQuery error: %v", tc.name, err)
+		}
+
+		got, err := ListOptionsFromQuery(values)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: err expected %v, got %v", tc.name, tc.err, err)
+			continue
+		}
+		if tc.err != nil {
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%s: expected %+v, got %+v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	value := "2020-01-06T16:36:47.60642Z"
+	id := CompletedUUIDStr
+
+	gotValue, gotID, err := DecodeCursor(EncodeCursor(value, id))
+	if err != nil {
+		t.Fatalf("DecodeCursor error: %v", err)
+	}
+	if gotValue != value || gotID != id {
+		t.Errorf("cursor round trip expected (%q, %q), got (%q, %q)", value, id, gotValue, gotID)
+	}
+}
+
+func TestFormatListTime(t *testing.T) {
+	whole := time.Date(2020, 1, 6, 16, 36, 5, 0, time.UTC)
+	tenth := whole.Add(100 * time.Millisecond)
+
+	// as strings, in time order
+	if a, b := FormatListTime(whole), FormatListTime(tenth); a >= b {
+		t.Errorf("expected %q before %q", a, b)
+	}
+	if got, err := time.Parse(time.RFC3339Nano, FormatListTime(tenth)); err != nil || !got.Equal(tenth) {
+		t.Errorf("round trip expected %v, got %v, err %v", tenth, got, err)
+	}
+
+	// and stored version 2 timestamps are rewritten so
+	doc := map[string]interface{}{VersionKey: int64(2), "accepted_at": "2020-01-06T16:36:05Z", "completed_at": "2020-01-06T16:36:05.1Z"}
+	if _, err := MigrateDocument(doc); err != nil {
+		t.Fatalf("MigrateDocument: unexpected error %v", err)
+	}
+	if doc["accepted_at"] != "2020-01-06T16:36:05.000000000Z" || doc["completed_at"] != "2020-01-06T16:36:05.100000000Z" {
+		t.Errorf("migrated expected fixed-width timestamps, got %v", doc)
+	}
+}
@@ -0,0 +1,145 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// VersionKey is the stored document field holding the Request's schema version
+const VersionKey = "request_version"
+
+// ErrNoMigration - no migration registered to upgrade from a stored version
+var ErrNoMigration = errors.New("No migration registered for Request version")
+
+// ErrFutureVersion - stored version is newer than this code understands
+var ErrFutureVersion = errors.New("Request version newer than supported")
+
+// MigrationFunc upgrades a stored Request document, keyed by its firestore
+// field names, from one version to the next. It modifies doc in place;
+// Migrate updates the version field.
+type MigrationFunc func(doc map[string]interface{}) error
+
+// Migrator upgrades stored Request documents to the latest version, one
+// version at a time
+type Migrator struct {
+	latest     int
+	migrations map[int]MigrationFunc // keyed by the version upgraded from
+}
+
+// NewMigrator returns a Migrator that upgrades documents to version latest
+func NewMigrator(latest int) *Migrator {
+	return &Migrator{
+		latest:     latest,
+		migrations: make(map[int]MigrationFunc),
+	}
+}
+
+// Register adds the migration from version from to version from+1
+func (m *Migrator) Register(from int, fn MigrationFunc) {
+	if _, ok := m.migrations[from]; ok {
+		panic(fmt.Sprintf("request.Migrator, migration from version %d registered twice", from))
+	}
+	m.migrations[from] = fn
+}
+
+// Migrate upgrades doc in place to the latest version, returning true if any
+// migration was applied
+func (m *Migrator) Migrate(doc map[string]interface{}) (bool, error) {
+	version := DocumentVersion(doc)
+	if version > m.latest {
+		return false, fmt.Errorf("%w: %d > %d", ErrFutureVersion, version, m.latest)
+	}
+
+	migrated := false
+	for ; version < m.latest; version++ {
+		fn, ok := m.migrations[version]
+		if !ok {
+			return migrated, fmt.Errorf("%w: %d", ErrNoMigration, version)
+		}
+		if err := fn(doc); err != nil {
+			return migrated, fmt.Errorf("migrating Request from version %d: %w", version, err)
+		}
+		doc[VersionKey] = int64(version + 1)
+		migrated = true
+	}
+
+	return migrated, nil
+}
+
+// DocumentVersion returns the schema version of a stored Request document.
+// Documents written before the version field existed are version 1.
+func DocumentVersion(doc map[string]interface{}) int {
+	switch v := doc[VersionKey].(type) {
+	case int64:
+		if v > 0 {
+			return int(v)
+		}
+	case int:
+		if v > 0 {
+			return v
+		}
+	}
+	return 1
+}
+
+// FromDocument decodes a stored Request document, keyed by its firestore
+// field names, into a Request. Migrate the document first.
+func FromDocument(doc map[string]interface{}) (*Request, error) {
+	var req Request
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName: "firestore",
+		Result:  &req,
+	})
+	if err != nil {
+		return &Request{}, err
+	}
+	if err := decoder.Decode(doc); err != nil {
+		return &Request{}, err
+	}
+
+	return &req, nil
+}
+
+// migrations is the Migrator applied to Requests read from the database
+var migrations = NewMigrator(RequestVersion)
+
+// RegisterMigration adds the migration from version from to version from+1
+// to the Migrator used by MigrateDocument
+func RegisterMigration(from int, fn MigrationFunc) {
+	migrations.Register(from, fn)
+}
+
+// MigrateDocument upgrades a stored Request document in place to
+// RequestVersion, returning true if any migration was applied
+func MigrateDocument(doc map[string]interface{}) (bool, error) {
+	return migrations.Migrate(doc)
+}
+
+func init() {
+	// version 1 Requests predate Timestamps
+	RegisterMigration(1, func(doc map[string]interface{}) error {
+		if _, ok := doc["timestamps"]; !ok {
+			doc["timestamps"] = make(map[string]interface{})
+		}
+		return nil
+	})
+
+	// version 2 Requests' timestamps may drop trailing zeros, misordering
+	// them as strings; see TimeLayout
+	RegisterMigration(2, func(doc map[string]interface{}) error {
+		for _, key := range []string{"accepted_at", "created_at", "updated_at", "completed_at"} {
+			s, ok := doc[key].(string)
+			if !ok {
+				continue
+			}
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				doc[key] = FormatListTime(t)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,120 @@
+package request
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMigrate(t *testing.T) {
+	// a hypothetical version 3 renames media_uri and adds a field
+	m := NewMigrator(3)
+	m.Register(1, func(doc map[string]interface{}) error {
+		if _, ok := doc["timestamps"]; !ok {
+			doc["timestamps"] = make(map[string]interface{})
+		}
+		return nil
+	})
+	m.Register(2, func(doc map[string]interface{}) error {
+		doc["media_file"] = doc["media_uri"]
+		delete(doc, "media_uri")
+		doc["priority"] = "normal"
+		return nil
+	})
+
+	type test struct {
+		name     string
+		doc      map[string]interface{}
+		migrated bool
+		expected map[string]interface{}
+		err      error
+	}
+
+	tests := []test{
+		{name: "v1 to v3",
+			doc:      map[string]interface{}{"customer_id": int64(1234567), "media_uri": "gs://bucket/file.mp3"},
+			migrated: true,
+			expected: map[string]interface{}{"request_version": int64(3), "customer_id": int64(1234567),
+				"media_file": "gs://bucket/file.mp3", "priority": "normal", "timestamps": map[string]interface{}{}},
+		},
+		{name: "v2 to v3",
+			doc: map[string]interface{}{"request_version": int64(2), "media_uri": "gs://bucket/file.mp3",
+				"timestamps": map[string]interface{}{"BeginDefault": "x"}},
+			migrated: true,
+			expected: map[string]interface{}{"request_version": int64(3), "media_file": "gs://bucket/file.mp3",
+				"priority": "normal", "timestamps": map[string]interface{}{"BeginDefault": "x"}},
+		},
+		{name: "already v3",
+			doc:      map[string]interface{}{"request_version": int64(3), "media_file": "gs://bucket/file.mp3"},
+			migrated: false,
+			expected: map[string]interface{}{"request_version": int64(3), "media_file": "gs://bucket/file.mp3"},
+		},
+		{name: "v4 is from the future",
+			doc:      map[string]interface{}{"request_version": int64(4)},
+			migrated: false,
+			expected: map[string]interface{}{"request_version": int64(4)},
+			err:      ErrFutureVersion,
+		},
+	}
+
+	for _, tc := range tests {
+		migrated, err := m.Migrate(tc.doc)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, err)
+		}
+		if migrated != tc.migrated {
+			t.Errorf("%s: expected migrated %t, got %t", tc.name, tc.migrated, migrated)
+		}
+		if diff := cmp.Diff(tc.expected, tc.doc); diff != "" {
+			t.Errorf("%s: mismatch (-expected +got):\n%s", tc.name, diff)
+		}
+	}
+
+	// a gap in the registered migrations is an error
+	gap := NewMigrator(3)
+	gap.Register(2, func(doc map[string]interface{}) error { return nil })
+	if _, err := gap.Migrate(map[string]interface{}{}); !errors.Is(err, ErrNoMigration) {
+		t.Errorf("gap: expected error %v, got %v", ErrNoMigration, err)
+	}
+}
+
+func TestMigrateDocument(t *testing.T) {
+	// a version 1 document as read from Firestore
+	doc := map[string]interface{}{
+		"customer_id": int64(1234567),
+		"media_uri":   "gs://bucket/file.mp3",
+		"status":      Completed,
+		"accepted_at": "2020-03-01T12:00:00Z",
+		"tags": map[string]interface{}{
+			"PHONE_NUMBER": map[string]interface{}{"Quote": "555-1212", "InfoType": "PHONE_NUMBER", "Likelihood": int64(4)},
+		},
+		"config": map[string]interface{}{"language_code": "en-US", "info_types": []interface{}{"PHONE_NUMBER"}},
+	}
+
+	migrated, err := MigrateDocument(doc)
+	if err != nil || !migrated {
+		t.Fatalf("expected migration, got migrated %t, err %v", migrated, err)
+	}
+
+	req, err := FromDocument(doc)
+	if err != nil {
+		t.Fatalf("FromDocument: unexpected error %v", err)
+	}
+
+	expected := Request{
+		Version:      RequestVersion,
+		CustomerID:   1234567,
+		MediaFileURI: "gs://bucket/file.mp3",
+		Status:       Completed,
+		AcceptedAt:   "2020-03-01T12:00:00.000000000Z",
+		MatchedTags: map[string]Tags{
+			"PHONE_NUMBER": {Quote: "555-1212", InfoType: "PHONE_NUMBER", Likelihood: 4},
+		},
+		Timestamps: map[string]string{},
+		Config:     ProcessingConfig{LanguageCode: "en-US", InfoTypes: []string{"PHONE_NUMBER"}},
+	}
+	if diff := cmp.Diff(expected, *req); diff != "" {
+		t.Errorf("mismatch (-expected +got):\n%s", diff)
+	}
+}
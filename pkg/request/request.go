@@ -39,23 +39,74 @@ var ErrInvalidTime = errors.New("Invalid time value cannot be parsed")
 // Request defines properties of an incoming transcription request
 // to be added
 type Request struct {
-	Version           int               `json:"version" firestore:"request_version"`
-	RequestID         uuid.UUID         `json:"request_id" firestore:"-"` // redundant when Firestore docID = RequestID
-	CustomerID        int               `json:"customer_id" firestore:"customer_id" validate:"required,gte=1,lt=10000000"`
-	MediaFileURI      string            `json:"media_uri" firestore:"media_uri" validate:"required,uri"`
-	Status            string            `json:"status" firestore:"status"`                                       // one of "PENDING", "ERROR", "COMPLETED"
-	OriginalStatus    int               `json:"original_status,omitempty" firestore:"original_status,omitempty"` // as reported throughout the pipeline
-	AcceptedAt        string            `json:"accepted_at" firestore:"accepted_at"`
-	CreatedAt         string            `json:"created_at,omitempty" firestore:"created_at,omitempty"`
-	UpdatedAt         string            `json:"updated_at,omitempty" firestore:"updated_at,omitempty"`
-	CompletedAt       string            `json:"completed_at,omitempty" firestore:"completed_at,omitempty"`
-	WorkingTranscript string            `json:"working_transcript,omitempty" firestore:"working_transcript,omitempty"`
-	FinalTranscript   string            `json:"final_transcript,omitempty" firestore:"final_transcript,omitempty"`
-	MatchedTags       map[string]Tags   `json:"tags,omitempty" firestore:"tags,omitempty"`
-	Timestamps        map[string]string `json:"timestamps" firestore:"timestamps"`
+	Version            int               `json:"version" firestore:"request_version"`
+	RequestID          uuid.UUID         `json:"request_id" firestore:"-"` // redundant when Firestore docID = RequestID
+	CustomerID         int               `json:"customer_id" firestore:"customer_id" validate:"required,gte=1,lt=10000000"`
+	MediaFileURI       string            `json:"media_uri" firestore:"media_uri" validate:"required,uri"`
+	WorkingMediaURI    string            `json:"working_media_uri,omitempty" firestore:"working_media_uri,omitempty"`                                    // copy of the media in our store, see cmd/mediaFetch and cmd/mediaConvert
+	MediaSeconds       float64           `json:"media_seconds,omitempty" firestore:"media_seconds,omitempty"`                                            // duration of the media, once known
+	Media              *Media            `json:"media,omitempty" firestore:"media,omitempty"`                                                            // the working media, as the provider receives it, see cmd/mediaConvert
+	SourceMedia        *Media            `json:"source_media,omitempty" firestore:"source_media,omitempty"`                                              // the media as submitted, if mediaConvert converted it
+	Chunks             []Chunk           `json:"chunks,omitempty" firestore:"chunks,omitempty"`                                                          // long media, split to transcribe in parallel, see cmd/mediaConvert
+	Operation          *Operation        `json:"operation,omitempty" firestore:"operation,omitempty"`                                                    // the provider transcribing the working media, see cmd/transcriptionGCP
+	CallbackURL        string            `json:"callback_url,omitempty" firestore:"callback_url,omitempty" validate:"omitempty,url,startswith=https://"` // told when the Request completes or fails; the customer's webhook if unset
+	Status             string            `json:"status" firestore:"status"`                                                                              // one of "PENDING", "ERROR", "COMPLETED"
+	OriginalStatus     int               `json:"original_status,omitempty" firestore:"original_status,omitempty"`                                        // as reported throughout the pipeline
+	Error              string            `json:"error,omitempty" firestore:"error,omitempty"`                                                            // why the Request failed, as the customer is told
+	Stage              string            `json:"stage,omitempty" firestore:"stage,omitempty"`                                                            // most recent pipeline stage completed
+	AcceptedAt         string            `json:"accepted_at" firestore:"accepted_at"`
+	CreatedAt          string            `json:"created_at,omitempty" firestore:"created_at,omitempty"`
+	UpdatedAt          string            `json:"updated_at,omitempty" firestore:"updated_at,omitempty"`
+	CompletedAt        string            `json:"completed_at,omitempty" firestore:"completed_at,omitempty"`
+	WorkingTranscript  string            `json:"working_transcript,omitempty" firestore:"working_transcript,omitempty"`
+	FinalTranscript    string            `json:"final_transcript,omitempty" firestore:"final_transcript,omitempty"`
+	Structured         *Transcript       `json:"structured_transcript,omitempty" firestore:"structured_transcript,omitempty"`                     // WorkingTranscript with timing, confidence and alternatives
+	EncryptedStructure string            `json:"encrypted_structured_transcript,omitempty" firestore:"encrypted_structured_transcript,omitempty"` // Structured as stored, see pkg/encryption
+	MatchedTags        map[string]Tags   `json:"tags,omitempty" firestore:"tags,omitempty"`
+	EncryptedTags      string            `json:"encrypted_tags,omitempty" firestore:"encrypted_tags,omitempty"` // MatchedTags as stored, see pkg/encryption
+	Timestamps         map[string]string `json:"timestamps" firestore:"timestamps"`
+	CustomConfig       *ProcessingConfig `json:"custom_config,omitempty" firestore:"custom_config,omitempty"`               // as submitted, overrides customer's profile
+	Config             ProcessingConfig  `json:"config" firestore:"config"`                                                 // as resolved when accepted, used by all stages
+	MediaPurgedAt      string            `json:"media_purged_at,omitempty" firestore:"media_purged_at,omitempty"`           // media file deleted per retention policy
+	TranscriptPurgedAt string            `json:"transcript_purged_at,omitempty" firestore:"transcript_purged_at,omitempty"` // transcripts and tags deleted per retention policy
+	ErasedAt           string            `json:"erased_at,omitempty" firestore:"erased_at,omitempty"`                       // personal data deleted on request, see pkg/erasure
 }
 
-const RequestVersion = 2 // distinguish older from newer requests
+const RequestVersion = 3 // distinguish older from newer requests
+
+// Media describes a media file's audio, as mediaConvert probed it
+type Media struct {
+	Encoding        string  `json:"encoding,omitempty" firestore:"encoding,omitempty"` // as Speech-to-Text names it, e.g. "FLAC", "LINEAR16", "MP3"; "" if it must be converted
+	Codec           string  `json:"codec" firestore:"codec"`                           // as ffprobe names it, e.g. "mp3", "aac", "amr_nb"
+	SampleRateHertz int     `json:"sample_rate_hertz" firestore:"sample_rate_hertz"`
+	Channels        int     `json:"channels" firestore:"channels"`
+	Seconds         float64 `json:"seconds,omitempty" firestore:"seconds,omitempty"` // 0 if the file doesn't say
+	Bytes           int64   `json:"bytes" firestore:"bytes"`
+}
+
+// Chunk is a part of long media, transcribed on its own; transcriptionGCP
+// stitches the chunks' transcripts together. Each chunk but the first
+// overlaps the end of the previous one, to match up their speakers.
+type Chunk struct {
+	MediaURI      string  `json:"media_uri" firestore:"media_uri"`
+	Start         float64 `json:"start" firestore:"start"` // seconds from the start of the working media
+	End           float64 `json:"end" firestore:"end"`
+	Status        string  `json:"status" firestore:"status"`                                     // Pending, Completed or Error, to retry
+	Attempts      int     `json:"attempts,omitempty" firestore:"attempts,omitempty"`             // to transcribe it
+	Error         string  `json:"error,omitempty" firestore:"error,omitempty"`                   // of the last attempt
+	TranscriptURI string  `json:"transcript_uri,omitempty" firestore:"transcript_uri,omitempty"` // the chunk's transcript, as JSON, once Completed
+}
+
+// Operation is a speech-to-text provider's long-running operation
+// transcribing the working media. transcriptionGCP submits it, then checks
+// on it until it's done.
+type Operation struct {
+	Name        string `json:"name" firestore:"name"` // as the provider names it
+	Provider    string `json:"provider" firestore:"provider"`
+	SubmittedAt string `json:"submitted_at" firestore:"submitted_at"`
+	Checks      int    `json:"checks,omitempty" firestore:"checks,omitempty"`     // so far; each waits longer than the last
+	Progress    int    `json:"progress,omitempty" firestore:"progress,omitempty"` // percent done, at the last check
+}
 
 type Tags struct {
 	Quote           string // Quote initially used as the key of the map
@@ -69,22 +120,59 @@ type RequestRepository interface {
 	Create(request *Request) error
 	FindByID(reqID uuid.UUID) (*Request, error)
 	Update(request *Request) error
+	List(opts ListOptions) (*ListResult, error)
+	DeleteFields(reqID uuid.UUID, fields ...string) error // by firestore field name; Update can't remove fields
+}
+
+// WorkingMedia returns the URI stages read the media from: the copy
+// mediaFetch or mediaConvert made, else the media_uri submitted
+func (req *Request) WorkingMedia() string {
+	if req.WorkingMediaURI != "" {
+		return req.WorkingMediaURI
+	}
+	return req.MediaFileURI
+}
+
+// StoredMedia returns the URIs of the Request's media that may be in our
+// store: the copy mediaFetch or mediaConvert made, and the media_uri
+// submitted, then any chunks' media and transcripts
+func (req *Request) StoredMedia() []string {
+	var uris []string
+	for _, uri := range []string{req.WorkingMediaURI, req.MediaFileURI} {
+		if uri != "" && (len(uris) == 0 || uris[0] != uri) {
+			uris = append(uris, uri)
+		}
+	}
+	for _, chunk := range req.Chunks {
+		for _, uri := range []string{chunk.MediaURI, chunk.TranscriptURI} {
+			if uri != "" {
+				uris = append(uris, uri)
+			}
+		}
+	}
+	return uris
 }
 
 func (req *Request) ReadRequest(w http.ResponseWriter, r *http.Request, p httprouter.Params, validate *validator.Validate) error {
+	return ReadJSON(w, r, req, validate)
+}
+
+// ReadJSON decodes the JSON body of r into dst and validates it. On error,
+// ReadJSON calls http.Error so the caller need only return.
+func ReadJSON(w http.ResponseWriter, r *http.Request, dst interface{}, validate *validator.Validate) error {
 	sn := serviceInfo.GetServiceName()
 
 	var err error
 
-	err = decodeJSONBody(w, r, req)
+	err = decodeJSONBody(w, r, dst)
 
 	if err != nil {
 		var mr *malformedRequest
 		if errors.As(err, &mr) {
-			log.Printf("%s.request.ReadRequest, err: %v\n", sn, err.Error())
+			log.Printf("%s.request.ReadJSON, err: %v\n", sn, err.Error())
 			http.Error(w, mr.msg, mr.status)
 		} else {
-			log.Printf("%s.request.ReadRequest, err: %v\n", sn, err.Error())
+			log.Printf("%s.request.ReadJSON, err: %v\n", sn, err.Error())
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		}
 		return err
@@ -94,13 +182,13 @@ func (req *Request) ReadRequest(w http.ResponseWriter, r *http.Request, p httpro
 
 	// validate incoming request
 	// See https://github.com/go-playground/validator/blob/master/doc.go
-	err = validate.Struct(req)
+	err = validate.Struct(dst)
 	if err != nil {
-		log.Printf("%s.request.ReadRequest, validation error: %v\n", sn, err)
+		log.Printf("%s.request.ReadJSON, validation error: %v\n", sn, err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return err
 	}
-	// log.Printf("%s.request.ReadRequest - validated request: %+v\n", sn, newRequest)
+	// log.Printf("%s.request.ReadJSON - validated request: %+v\n", sn, newRequest)
 
 	return nil
 }
@@ -179,6 +267,26 @@ func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) err
 
 // ********** ********** ********** ********** ********** **********
 
+// Submission holds the fields of a Request the customer sets in the
+// initial POST request; the rest are the pipeline's, and a body naming
+// any of them is rejected
+type Submission struct {
+	CustomerID   int               `json:"customer_id" validate:"required,gte=1,lt=10000000"`
+	MediaFileURI string            `json:"media_uri" validate:"required,uri"`
+	CallbackURL  string            `json:"callback_url,omitempty" validate:"omitempty,url,startswith=https://"`
+	CustomConfig *ProcessingConfig `json:"custom_config,omitempty"`
+}
+
+// Request returns a new Request holding the Submission's fields
+func (s *Submission) Request() Request {
+	return Request{
+		CustomerID:   s.CustomerID,
+		MediaFileURI: s.MediaFileURI,
+		CallbackURL:  s.CallbackURL,
+		CustomConfig: s.CustomConfig,
+	}
+}
+
 // PostResponse holds seelcted fields of Result struct to include in
 // HTTP response to initial POST request
 type PostResponse struct {
@@ -200,9 +308,14 @@ type GetStatusResponse struct {
 	OriginalRequestID   uuid.UUID `json:"original_request_id"`
 	OriginalAcceptedAt  string    `json:"original_accepted_at,omitempty"`
 	OriginalCompletedAt string    `json:"original_completed_at,omitempty"`
-	ETA                 string    `json:"eta,omitempty"`             // time.Time.String()
+	ETA                 string    `json:"eta,omitempty"`          // time.Time.String()
+	ETAEarliest         string    `json:"eta_earliest,omitempty"` // ETA's band, see pkg/eta
+	ETALatest           string    `json:"eta_latest,omitempty"`
 	Endpoint            string    `json:"endpoint,omitempty"`        // uri
 	OriginalStatus      int       `json:"original_status,omitempty"` // http.Status*
+	Error               string    `json:"error,omitempty"`           // why the original Request failed
+	Status              string    `json:"status,omitempty"`          // of the original Request
+	Stage               string    `json:"stage,omitempty"`           // of the original Request
 }
 
 // GetTranscriptResponse holds selected fields of Result struct to include in
@@ -259,6 +372,9 @@ func (req *Request) AddTimestamps(startKey, startTimestamp, endKey string) (time
 	req.Timestamps[endKey] = now.Format(time.RFC3339Nano)
 	duration := now.Sub(startTime)
 
+	// endKey names the stage just completed, e.g. "EndTranscriptionGCP"
+	req.Stage = strings.TrimPrefix(endKey, "End")
+
 	return duration, nil
 }
 
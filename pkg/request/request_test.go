@@ -35,12 +35,14 @@ func TestToMap(t *testing.T) {
 	expected["media_uri"] = request.MediaFileURI
 	expected["status"] = request.Status
 	expected["original_status"] = float64(request.OriginalStatus)
+	expected["stage"] = request.Stage
 	expected["accepted_at"] = request.AcceptedAt
 	expected["created_at"] = request.CreatedAt
 	expected["updated_at"] = request.UpdatedAt
 	expected["completed_at"] = request.CompletedAt
 	expected["working_transcript"] = request.WorkingTranscript
 	expected["final_transcript"] = request.FinalTranscript
+	expected["config"] = make(map[string]interface{})
 
 	timestamps := make(map[string]interface{})
 	timestamps["BeginTest"] = request.Timestamps["BeginTest"]
@@ -0,0 +1,46 @@
+package request
+
+import "strings"
+
+// Transcript is the working transcript, structured for later stages: each
+// speaker's turns as segments, word by word with timing and confidence,
+// then the provider's alternatives. Times are seconds from the start of
+// the media.
+type Transcript struct {
+	Provider     string        `json:"provider" firestore:"provider"`
+	Confidence   float32       `json:"confidence" firestore:"confidence"` // 0.0 to 1.0, 0 if not known
+	Segments     []Segment     `json:"segments" firestore:"segments"`
+	Alternatives []Alternative `json:"alternatives,omitempty" firestore:"alternatives,omitempty"`
+}
+
+// Segment is a speaker's turn
+type Segment struct {
+	Speaker int     `json:"speaker" firestore:"speaker"` // from 1; 0 if not known
+	Start   float64 `json:"start" firestore:"start"`
+	End     float64 `json:"end" firestore:"end"`
+	Text    string  `json:"text" firestore:"text"`
+	Words   []Word  `json:"words" firestore:"words"`
+}
+
+// Word is one word of a Segment
+type Word struct {
+	Text       string  `json:"text" firestore:"text"`
+	Start      float64 `json:"start" firestore:"start"`
+	End        float64 `json:"end" firestore:"end"`
+	Confidence float32 `json:"confidence,omitempty" firestore:"confidence,omitempty"`
+}
+
+// Alternative is a less likely transcript, text only
+type Alternative struct {
+	Text       string  `json:"text" firestore:"text"`
+	Confidence float32 `json:"confidence" firestore:"confidence"`
+}
+
+// Text returns the segments' text, one line per segment
+func (t *Transcript) Text() string {
+	lines := make([]string, 0, len(t.Segments))
+	for _, s := range t.Segments {
+		lines = append(lines, s.Text)
+	}
+	return strings.Join(lines, "\n")
+}
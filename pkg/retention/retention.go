@@ -0,0 +1,204 @@
+// Retention package enforces each customer's data retention settings by
+// purging media files and transcripts older than the customer allows
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/audit"
+	"github.com/peterpla/lead-expert/pkg/customer"
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// what is purged
+const (
+	Media      = "media"
+	Transcript = "transcript"
+)
+
+// Purger deletes media and transcripts that have outlived their customer's
+// retention settings
+type Purger struct {
+	Requests  request.RequestRepository
+	Customers customer.CustomerRepository
+	Media     media.Store
+	MediaRoot string // our media store, e.g. "gs://bucket"; media elsewhere is the customer's
+	Audit     audit.Sink
+	Now       func() time.Time // for tests; time.Now if nil
+}
+
+// Item is one purge, done or (in a dry run) to be done
+type Item struct {
+	CustomerID int    `json:"customer_id"`
+	RequestID  string `json:"request_id"`
+	What       string `json:"what"` // Media or Transcript
+	AcceptedAt string `json:"accepted_at"`
+	MediaURI   string `json:"media_uri,omitempty"`
+}
+
+// Report summarizes a purge run
+type Report struct {
+	DryRun bool     `json:"dry_run"`
+	Purged []Item   `json:"purged"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Run purges every customer's expired data. With dryRun, nothing is deleted
+// and the Report lists what would be.
+func (p *Purger) Run(ctx context.Context, dryRun bool) (*Report, error) {
+	sn := serviceInfo.GetServiceName()
+
+	report := &Report{DryRun: dryRun, Purged: []Item{}}
+
+	custs, err := p.Customers.List()
+	if err != nil {
+		log.Printf("%s.retention.Run, Customers.List error: %v\n", sn, err)
+		return report, err
+	}
+
+	for _, cust := range custs {
+		if err := p.PurgeCustomer(ctx, cust, dryRun, report); err != nil {
+			// keep going, one customer's failure shouldn't block the others
+			report.Errors = append(report.Errors, fmt.Sprintf("customer %d: %v", cust.CustomerID, err))
+		}
+	}
+
+	log.Printf("%s.retention.Run, dry run: %t, purged %d, errors %d\n", sn, dryRun, len(report.Purged), len(report.Errors))
+	return report, nil
+}
+
+// PurgeCustomer purges one customer's expired data, adding to report
+func (p *Purger) PurgeCustomer(ctx context.Context, cust *customer.Customer, dryRun bool, report *Report) error {
+	now := time.Now
+	if p.Now != nil {
+		now = p.Now
+	}
+
+	if days := cust.Retention.MediaDays; days > 0 {
+		cutoff := now().AddDate(0, 0, -days)
+		if err := p.purgeBefore(ctx, cust.CustomerID, cutoff, Media, dryRun, report); err != nil {
+			return err
+		}
+	}
+	if days := cust.Retention.TranscriptDays; days > 0 {
+		cutoff := now().AddDate(0, 0, -days)
+		if err := p.purgeBefore(ctx, cust.CustomerID, cutoff, Transcript, dryRun, report); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// purgeBefore purges what from the customer's Requests accepted before cutoff
+func (p *Purger) purgeBefore(ctx context.Context, customerID int, cutoff time.Time, what string, dryRun bool, report *Report) error {
+	opts := request.ListOptions{
+		CustomerID:     customerID,
+		AcceptedBefore: cutoff,
+		Limit:          request.MaxListLimit,
+	}
+
+	for {
+		page, err := p.Requests.List(opts)
+		if err != nil {
+			return err
+		}
+
+		for _, req := range page.Requests {
+			if !purgeable(req, what) {
+				continue
+			}
+
+			item := Item{
+				CustomerID: customerID,
+				RequestID:  req.RequestID.String(),
+				What:       what,
+				AcceptedAt: req.AcceptedAt,
+			}
+			if what == Media {
+				item.MediaURI = req.MediaFileURI
+			}
+
+			if !dryRun {
+				if err := p.purge(ctx, req, what); err != nil {
+					report.Errors = append(report.Errors, fmt.Sprintf("request %s %s: %v", item.RequestID, what, err))
+					continue
+				}
+			}
+			report.Purged = append(report.Purged, item)
+		}
+
+		if page.NextCursor == "" {
+			return nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// purgeable reports whether what remains to be purged from req. Requests
+// still in the pipeline are left alone until they finish.
+func purgeable(req *request.Request, what string) bool {
+	if req.Status == request.Pending {
+		return false
+	}
+	if what == Media {
+		return req.MediaPurgedAt == ""
+	}
+	return req.TranscriptPurgedAt == ""
+}
+
+// purge deletes what from req, marks req purged, and writes an audit record
+func (p *Purger) purge(ctx context.Context, req *request.Request, what string) error {
+	purgedAt := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var rec audit.Record
+	switch what {
+	case Media:
+		// media outside our store (e.g. the customer's own "gs://" media_uri)
+		// isn't ours to delete
+		deleted := []string{}
+		for _, uri := range req.StoredMedia() {
+			if !p.ours(uri) {
+				continue
+			}
+			err := p.Media.Delete(ctx, uri)
+			if err != nil && err != media.ErrNotFound && err != media.ErrUnsupportedURI {
+				return err
+			}
+			deleted = append(deleted, uri)
+		}
+		req.MediaPurgedAt = purgedAt
+		if err := p.Requests.Update(req); err != nil {
+			return err
+		}
+		rec = audit.NewRecord(req.CustomerID, req.RequestID.String(), audit.ActionPurgeMedia, deleted...)
+
+	case Transcript:
+		// delete before marking, so a partial failure is retried rather than forgotten
+		if err := p.Requests.DeleteFields(req.RequestID, request.TranscriptFields...); err != nil {
+			return err
+		}
+		if err := req.ClearFields(request.TranscriptFields...); err != nil {
+			return err
+		}
+		req.TranscriptPurgedAt = purgedAt
+		if err := p.Requests.Update(req); err != nil {
+			return err
+		}
+		rec = audit.NewRecord(req.CustomerID, req.RequestID.String(), audit.ActionPurgeTranscript, request.TranscriptFields...)
+	}
+
+	return p.Audit.Write(rec)
+}
+
+// ours reports whether uri is in our media store
+func (p *Purger) ours(uri string) bool {
+	root := strings.TrimSuffix(p.MediaRoot, "/")
+	return root != "" && strings.HasPrefix(uri, root+"/")
+}
@@ -0,0 +1,168 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/audit"
+	"github.com/peterpla/lead-expert/pkg/customer"
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/request"
+)
+
+type fakeMedia struct {
+	deleted []string
+}
+
+func (f *fakeMedia) Delete(ctx context.Context, uri string) error {
+	f.deleted = append(f.deleted, uri)
+	if uri == "gs://store/media/1234567/gone.mp3" {
+		return media.ErrNotFound
+	}
+	return nil
+}
+
+func (f *fakeMedia) Exists(ctx context.Context, uri string) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeMedia) Get(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return nil, media.ErrNotFound
+}
+
+func (f *fakeMedia) Put(ctx context.Context, uri string, r io.Reader, contentType string) error {
+	return nil
+}
+
+func (f *fakeMedia) SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error) {
+	return "", media.ErrNoSigner
+}
+
+type fakeAudit struct {
+	records []audit.Record
+}
+
+func (f *fakeAudit) Write(rec audit.Record) error {
+	f.records = append(f.records, rec)
+	return nil
+}
+
+func TestPurge(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	requests := database.NewMemoryRequestRepository()
+	customers := database.NewMemoryCustomerRepository()
+	_ = customers.Create(&customer.Customer{CustomerID: 1234567, Name: "Acme",
+		Retention: customer.Retention{MediaDays: 7, TranscriptDays: 90}})
+	_ = customers.Create(&customer.Customer{CustomerID: 7654321, Name: "Keep Forever"})
+
+	type seed struct {
+		customerID int
+		mediaURI   string
+		status     string
+		age        time.Duration
+	}
+	seeds := []seed{
+		{1234567, "gs://bucket/new.mp3", request.Completed, 24 * time.Hour},         // keep all
+		{1234567, "gs://bucket/week.mp3", request.Completed, 8 * 24 * time.Hour},    // purge media
+		{1234567, "gs://bucket/gone.mp3", request.Error, 8 * 24 * time.Hour},        // purge media, already deleted
+		{1234567, "gs://bucket/old.mp3", request.Completed, 91 * 24 * time.Hour},    // purge both
+		{1234567, "gs://bucket/stuck.mp3", request.Pending, 91 * 24 * time.Hour},    // still in pipeline
+		{7654321, "gs://bucket/other.mp3", request.Completed, 365 * 24 * time.Hour}, // no retention set
+	}
+	ids := make(map[string]uuid.UUID)
+	for _, s := range seeds {
+		req := request.Request{
+			RequestID:         uuid.New(),
+			CustomerID:        s.customerID,
+			MediaFileURI:      s.mediaURI,
+			WorkingMediaURI:   strings.Replace(s.mediaURI, "gs://bucket/", fmt.Sprintf("gs://store/media/%d/", s.customerID), 1),
+			Status:            s.status,
+			AcceptedAt:        now.Add(-s.age).Format(time.RFC3339Nano),
+			WorkingTranscript: "[Speaker 1] hello",
+			FinalTranscript:   "[Speaker 1] Hello",
+			Structured:        &request.Transcript{Segments: []request.Segment{{Speaker: 1, Text: "hello"}}},
+			MatchedTags:       map[string]request.Tags{"PERSON_NAME": {Quote: "Bob"}},
+		}
+		if err := requests.Create(&req); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		ids[s.mediaURI] = req.RequestID
+	}
+
+	fm := &fakeMedia{}
+	fa := &fakeAudit{}
+	p := Purger{Requests: requests, Customers: customers, Media: fm, MediaRoot: "gs://store/", Audit: fa, Now: func() time.Time { return now }}
+
+	// dry run changes nothing
+	report, err := p.Run(context.Background(), true)
+	if err != nil {
+		t.Fatalf("dry run: unexpected error %v", err)
+	}
+	if len(report.Purged) != 4 {
+		t.Errorf("dry run: expected 4 items, got %d: %+v", len(report.Purged), report.Purged)
+	}
+	if len(fm.deleted) != 0 || len(fa.records) != 0 {
+		t.Errorf("dry run: expected no deletions or audit records, got %v, %v", fm.deleted, fa.records)
+	}
+
+	report, err = p.Run(context.Background(), false)
+	if err != nil {
+		t.Fatalf("run: unexpected error %v", err)
+	}
+	if len(report.Purged) != 4 || len(report.Errors) != 0 {
+		t.Errorf("run: expected 4 items and no errors, got %+v", report)
+	}
+	if len(fa.records) != 4 {
+		t.Errorf("run: expected 4 audit records, got %d", len(fa.records))
+	}
+	// only our copies; the customer's own media_uri is left alone
+	expected := []string{"gs://store/media/1234567/week.mp3", "gs://store/media/1234567/gone.mp3", "gs://store/media/1234567/old.mp3"}
+	sort.Strings(expected)
+	sort.Strings(fm.deleted)
+	if !reflect.DeepEqual(fm.deleted, expected) {
+		t.Errorf("run: expected %v deleted, got %v", expected, fm.deleted)
+	}
+
+	type test struct {
+		mediaURI       string
+		mediaPurged    bool
+		transcriptGone bool
+	}
+	tests := []test{
+		{"gs://bucket/new.mp3", false, false},
+		{"gs://bucket/week.mp3", true, false},
+		{"gs://bucket/gone.mp3", true, false},
+		{"gs://bucket/old.mp3", true, true},
+		{"gs://bucket/stuck.mp3", false, false},
+		{"gs://bucket/other.mp3", false, false},
+	}
+	for _, tc := range tests {
+		got, err := requests.FindByID(ids[tc.mediaURI])
+		if err != nil {
+			t.Fatalf("%s: FindByID: %v", tc.mediaURI, err)
+		}
+		if (got.MediaPurgedAt != "") != tc.mediaPurged {
+			t.Errorf("%s: expected media purged %t, got MediaPurgedAt %q", tc.mediaURI, tc.mediaPurged, got.MediaPurgedAt)
+		}
+		gone := got.TranscriptPurgedAt != "" && got.WorkingTranscript == "" && got.FinalTranscript == "" && got.Structured == nil && got.MatchedTags == nil
+		if gone != tc.transcriptGone {
+			t.Errorf("%s: expected transcript gone %t, got %+v", tc.mediaURI, tc.transcriptGone, got)
+		}
+	}
+
+	// a second run has nothing left to do
+	report, _ = p.Run(context.Background(), false)
+	if len(report.Purged) != 0 {
+		t.Errorf("second run: expected nothing purged, got %+v", report.Purged)
+	}
+}
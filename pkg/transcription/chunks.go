@@ -0,0 +1,190 @@
+package transcription
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// matchWindow is how far apart in time a word in two chunks' overlap may
+// be transcribed, and still be taken for the same word
+const matchWindow = 300 * time.Millisecond
+
+// ChunkTranscript is the Transcript of a chunk of the media, its word
+// times from the start of the chunk, and where in the media the chunk is
+type ChunkTranscript struct {
+	Transcript *Transcript
+	Start      time.Duration
+	End        time.Duration
+}
+
+// Stitch joins the chunks' transcripts, in order, into the media's. Word
+// times are made relative to the start of the media. A chunk's words in
+// its overlap with the previous chunk are dropped, after telling which of
+// the previous chunk's speakers said them: unless keepSpeakers (e.g. each
+// speaker is a channel), the chunk's speakers are renumbered to match.
+// Alternatives, text only, are joined as they are, overlaps and all.
+func Stitch(chunks []ChunkTranscript, keepSpeakers bool) *Transcript {
+	t := &Transcript{Words: []Word{}}
+
+	var confidence float32
+	var confident int // words with a confidence
+	var alternatives [][]string
+	var altConfidence []float32
+	var previousEnd time.Duration
+	for i, c := range chunks {
+		if t.Provider == "" {
+			t.Provider = c.Transcript.Provider
+		}
+
+		var overlap, kept []Word
+		for _, w := range c.Transcript.Words {
+			w.Start += c.Start
+			w.End += c.Start
+			if i > 0 && w.Start < previousEnd {
+				overlap = append(overlap, w)
+			} else {
+				kept = append(kept, w)
+			}
+		}
+		if !keepSpeakers && i > 0 {
+			speakers := matchSpeakers(t.Words, overlap, kept)
+			for j := range kept {
+				if s, ok := speakers[kept[j].Speaker]; ok {
+					kept[j].Speaker = s
+				}
+			}
+		}
+		t.Words = append(t.Words, kept...)
+
+		if c.Transcript.Confidence > 0 {
+			confidence += c.Transcript.Confidence * float32(len(kept))
+			confident += len(kept)
+		}
+		for a, alt := range c.Transcript.Alternatives {
+			for len(alternatives) <= a {
+				alternatives = append(alternatives, nil)
+				altConfidence = append(altConfidence, 0)
+			}
+			alternatives[a] = append(alternatives[a], alt.Text)
+			altConfidence[a] += alt.Confidence
+		}
+		previousEnd = c.End
+	}
+
+	texts := make([]string, 0, len(t.Words))
+	for _, w := range t.Words {
+		texts = append(texts, w.Text)
+	}
+	t.Text = strings.Join(texts, " ")
+	if confident > 0 {
+		t.Confidence = confidence / float32(confident)
+	}
+	for a, alt := range alternatives {
+		t.Alternatives = append(t.Alternatives, Alternative{Text: strings.Join(alt, " "), Confidence: altConfidence[a] / float32(len(alt))})
+	}
+
+	return t
+}
+
+// matchSpeakers returns the speakers of a chunk's words renumbered as the
+// previous chunks' speakers. Who said each word of the overlap was heard
+// in both chunks: each speaker takes the previous speaker they share the
+// most words with. Speakers not heard in the overlap take any previous
+// speaker not yet matched, then new numbers.
+func matchSpeakers(previous []Word, overlap []Word, kept []Word) map[int]int {
+	type pair struct{ from, to, words int }
+	votes := map[[2]int]int{}
+	for _, w := range overlap {
+		if w.Speaker == 0 {
+			continue
+		}
+		if p := matchWord(previous, w); p != nil && p.Speaker != 0 {
+			votes[[2]int{w.Speaker, p.Speaker}]++
+		}
+	}
+	pairs := make([]pair, 0, len(votes))
+	for k, n := range votes {
+		pairs = append(pairs, pair{from: k[0], to: k[1], words: n})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].words != pairs[j].words {
+			return pairs[i].words > pairs[j].words
+		}
+		if pairs[i].from != pairs[j].from {
+			return pairs[i].from < pairs[j].from
+		}
+		return pairs[i].to < pairs[j].to
+	})
+
+	speakers := map[int]int{}
+	used := map[int]bool{}
+	for _, p := range pairs {
+		if _, ok := speakers[p.from]; !ok && !used[p.to] {
+			speakers[p.from] = p.to
+			used[p.to] = true
+		}
+	}
+
+	var earlier []int
+	last := 0
+	for _, w := range previous {
+		if w.Speaker != 0 && !containsInt(earlier, w.Speaker) {
+			earlier = append(earlier, w.Speaker)
+		}
+		if w.Speaker > last {
+			last = w.Speaker
+		}
+	}
+	sort.Ints(earlier)
+	for _, w := range kept {
+		if _, ok := speakers[w.Speaker]; ok || w.Speaker == 0 {
+			continue
+		}
+		to := 0
+		for _, s := range earlier {
+			if !used[s] {
+				to = s
+				break
+			}
+		}
+		if to == 0 {
+			last++
+			to = last
+		}
+		speakers[w.Speaker] = to
+		used[to] = true
+	}
+	return speakers
+}
+
+// matchWord returns the previous chunks' word w is, if any
+func matchWord(previous []Word, w Word) *Word {
+	text := normalizeWord(w.Text)
+	for i := len(previous) - 1; i >= 0; i-- {
+		p := &previous[i]
+		if p.Start < w.Start-matchWindow {
+			break // words are in time order
+		}
+		if p.Start <= w.Start+matchWindow && normalizeWord(p.Text) == text {
+			return p
+		}
+	}
+	return nil
+}
+
+// normalizeWord drops case and punctuation, which chunks may transcribe
+// differently at their edges
+func normalizeWord(text string) string {
+	return strings.ToLower(strings.TrimFunc(text, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) }))
+}
+
+func containsInt(list []int, n int) bool {
+	for _, i := range list {
+		if i == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package transcription
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStitch(t *testing.T) {
+	s := time.Second
+	word := func(text string, speaker int, start time.Duration) Word {
+		return Word{Text: text, Speaker: speaker, Start: start, End: start + s/2}
+	}
+
+	// the second chunk starts 4.5s before the first ends, where its
+	// diarization numbers the speakers the other way round
+	first := &Transcript{Provider: Google, Confidence: 0.9, Words: []Word{
+		word("Thank", 1, 0), word("you", 1, s), word("for", 1, 2*s),
+		word("calling.", 1, 3*s), word("Hi,", 2, 7*s), word("I", 2, 8*s),
+	}, Alternatives: []Alternative{{Text: "Thank you for calling hi I", Confidence: 0.5}}}
+	second := &Transcript{Provider: Google, Confidence: 0.6, Words: []Word{
+		word("hi", 1, 3*s), word("I", 1, 4*s), word("need", 1, 5*s),
+		word("a", 1, 6*s), word("quote.", 1, 7*s), word("Sure.", 2, 9*s),
+		word("Hello?", 3, 11*s),
+	}, Alternatives: []Alternative{{Text: "hi I need a coat", Confidence: 0.3}}}
+
+	chunks := []ChunkTranscript{
+		{Transcript: first, Start: 0, End: 8*s + s/2},
+		{Transcript: second, Start: 4 * s, End: 20 * s},
+	}
+
+	got := Stitch(chunks, false)
+	expected := []Word{
+		word("Thank", 1, 0), word("you", 1, s), word("for", 1, 2*s),
+		word("calling.", 1, 3*s), word("Hi,", 2, 7*s), word("I", 2, 8*s),
+		word("need", 2, 9*s), word("a", 2, 10*s), word("quote.", 2, 11*s),
+		word("Sure.", 1, 13*s), word("Hello?", 3, 15*s),
+	}
+	if !cmp.Equal(got.Words, expected) {
+		t.Errorf("speakers matched: %s", cmp.Diff(expected, got.Words))
+	}
+	if got.Provider != Google || got.Text != "Thank you for calling. Hi, I need a quote. Sure. Hello?" {
+		t.Errorf("expected the words' text, got %q, %q", got.Provider, got.Text)
+	}
+	if got.Confidence < 0.76 || got.Confidence > 0.77 {
+		t.Errorf("expected confidence weighted by words, got %v", got.Confidence)
+	}
+	if expected := "Thank you for calling hi I hi I need a coat"; len(got.Alternatives) != 1 || got.Alternatives[0].Text != expected {
+		t.Errorf("expected alternatives joined, got %+v", got.Alternatives)
+	}
+
+	// speakers who are channels keep their numbers
+	got = Stitch(chunks, true)
+	if got.Words[6].Speaker != 1 || got.Words[9].Speaker != 2 {
+		t.Errorf("speakers kept: expected 1 and 2, got %+v", got.Words)
+	}
+
+	if empty := Stitch(nil, false); empty.Text != "" || len(empty.Words) != 0 {
+		t.Errorf("no chunks: expected an empty transcript, got %+v", empty)
+	}
+}
+
+func TestMatchSpeakers(t *testing.T) {
+	previous := []Word{{Text: "Yes.", Speaker: 1, Start: 10 * time.Second}, {Text: "Okay", Speaker: 2, Start: 11 * time.Second}}
+
+	type test struct {
+		name     string
+		overlap  []Word
+		kept     []Word
+		expected map[int]int
+	}
+
+	tests := []test{
+		{name: "matched",
+			overlap:  []Word{{Text: "yes", Speaker: 2, Start: 10*time.Second + 200*time.Millisecond}, {Text: "okay.", Speaker: 1, Start: 11 * time.Second}},
+			kept:     []Word{{Speaker: 1}, {Speaker: 2}},
+			expected: map[int]int{2: 1, 1: 2}},
+		{name: "too far apart",
+			overlap:  []Word{{Text: "Yes.", Speaker: 2, Start: 9 * time.Second}},
+			kept:     []Word{{Speaker: 2}, {Speaker: 1}},
+			expected: map[int]int{2: 1, 1: 2}},
+		{name: "new speaker",
+			overlap:  []Word{{Text: "Okay", Speaker: 1, Start: 11 * time.Second}},
+			kept:     []Word{{Speaker: 1}, {Speaker: 3}, {Speaker: 2}},
+			expected: map[int]int{1: 2, 3: 1, 2: 3}},
+	}
+
+	for _, tc := range tests {
+		if got := matchSpeakers(previous, tc.overlap, tc.kept); !cmp.Equal(got, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
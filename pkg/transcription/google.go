@@ -0,0 +1,375 @@
+package transcription
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	speech "cloud.google.com/go/speech/apiv1"
+	"github.com/golang/protobuf/ptypes/duration"
+	speechpb "google.golang.org/genproto/googleapis/cloud/speech/v1"
+
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// googleInlineMax is the largest media file sent within a Speech-to-Text
+// request; larger files are read only from Cloud Storage
+const googleInlineMax = 10 << 20
+
+// googleProvider implements Provider with Google Cloud Speech-to-Text
+type googleProvider struct {
+	store media.Store
+}
+
+// NewGoogleProvider returns the Google Cloud Speech-to-Text Provider.
+// Media files not in Cloud Storage are read from store, and sent within
+// the request.
+func NewGoogleProvider(store media.Store) Provider {
+	return googleProvider{store: store}
+}
+
+func (g googleProvider) Name() string {
+	return Google
+}
+
+// Transcribe submits the media file to Speech-to-Text, and waits for the
+// transcript
+func (g googleProvider) Transcribe(ctx context.Context, mediaURI string, opts Options) (*Transcript, error) {
+	sn := serviceInfo.GetServiceName()
+
+	client, err := speech.NewClient(ctx)
+	if err != nil {
+		log.Printf("%s.transcription.google.Transcribe, speech.NewClient() error: %v", sn, err)
+		return nil, err
+	}
+	defer client.Close()
+
+	op, err := g.submit(ctx, client, mediaURI, opts)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := op.Wait(ctx)
+	if err != nil {
+		log.Printf("%s.transcription.google.Transcribe, Wait() error: %v", sn, err)
+		return nil, err
+	}
+
+	return googleTranscript(resp, googleSeparate(opts)), nil
+}
+
+// Submit submits the media file to Speech-to-Text, returning the name of
+// the operation transcribing it
+func (g googleProvider) Submit(ctx context.Context, mediaURI string, opts Options) (string, error) {
+	sn := serviceInfo.GetServiceName()
+
+	client, err := speech.NewClient(ctx)
+	if err != nil {
+		log.Printf("%s.transcription.google.Submit, speech.NewClient() error: %v", sn, err)
+		return "", err
+	}
+	defer client.Close()
+
+	op, err := g.submit(ctx, client, mediaURI, opts)
+	if err != nil {
+		return "", err
+	}
+	return op.Name(), nil
+}
+
+// Check asks Speech-to-Text how the operation Submit started is going,
+// returning the transcript once it's done
+func (g googleProvider) Check(ctx context.Context, operation string, opts Options) (*Operation, error) {
+	sn := serviceInfo.GetServiceName()
+
+	client, err := speech.NewClient(ctx)
+	if err != nil {
+		log.Printf("%s.transcription.google.Check, speech.NewClient() error: %v", sn, err)
+		return nil, err
+	}
+	defer client.Close()
+
+	op := client.LongRunningRecognizeOperation(operation)
+	resp, err := op.Poll(ctx)
+	if err != nil {
+		log.Printf("%s.transcription.google.Check, Poll(%q) error: %v", sn, operation, err)
+		if op.Done() {
+			// the operation itself failed, e.g. the audio isn't as configured
+			return nil, ErrOperationFailed
+		}
+		return nil, err
+	}
+
+	status := &Operation{Name: operation, Done: op.Done()}
+	if meta, err := op.Metadata(); err == nil && meta != nil {
+		status.Progress = int(meta.ProgressPercent)
+	}
+	if status.Done {
+		status.Progress = 100
+		status.Transcript = googleTranscript(resp, googleSeparate(opts))
+	}
+	return status, nil
+}
+
+// submit starts a Speech-to-Text operation transcribing the media file
+func (g googleProvider) submit(ctx context.Context, client *speech.Client, mediaURI string, opts Options) (*speech.LongRunningRecognizeOperation, error) {
+	sn := serviceInfo.GetServiceName()
+
+	// Overall flow:
+	//   1. confirm the media file is in a supported format (mediaConvert converts others)
+	//   2. point Speech-to-Text at the file in Cloud Storage, or read it from the Store
+	// 	 3. submit file to Speech-to-Text service
+	//   4. the caller waits for, or checks on, the operation, then normalizes the
+	//      transcription for use by later pipeline stages
+
+	if err := checkGoogleMedia(mediaURI, opts); err != nil {
+		return nil, err
+	}
+	audio, err := g.audio(ctx, mediaURI)
+	if err != nil {
+		return nil, err
+	}
+
+	// "Transcribing long audio files", https://cloud.google.com/speech-to-text/docs/async-recognize
+	req := googleRecognizeRequest(audio, opts)
+	op, err := client.LongRunningRecognize(ctx, req)
+	if err != nil {
+		log.Printf("%s.transcription.google.submit, error from LongRunningRecognize(config: %+v), error: %v", sn, req.Config, err)
+		return nil, err
+	}
+	return op, nil
+}
+
+// googleSeparate reports whether googleRecognizeRequest has each channel
+// recognized separately
+func googleSeparate(opts Options) bool {
+	return opts.SeparateChannels && opts.Channels > 1
+}
+
+// checkGoogleMedia ensures the media file is one Speech-to-Text can read:
+// one mediaConvert found in an encoding it accepts, else an MP3 file
+func checkGoogleMedia(uri string, opts Options) error {
+	sn := serviceInfo.GetServiceName()
+
+	if opts.Encoding != "" {
+		if _, ok := speechpb.RecognitionConfig_AudioEncoding_value[opts.Encoding]; !ok && opts.Encoding != "MP3" {
+			log.Printf("%s.transcription.checkGoogleMedia, %s not supported: %q\n", sn, opts.Encoding, uri)
+			return ErrUnsupportedMedia
+		}
+		return nil
+	}
+
+	// media not probed by mediaConvert must be an MP3 file
+	if strings.ToLower(filepath.Ext(uri)) != ".mp3" {
+		log.Printf("%s.transcription.checkGoogleMedia, only \".MP3\" files supported unconverted: %q", sn, uri)
+		return ErrUnsupportedMedia
+	}
+
+	return nil
+}
+
+// audio returns the media file for Speech-to-Text, which reads files in
+// Cloud Storage itself; others are read from the Store into the request
+func (g googleProvider) audio(ctx context.Context, uri string) (*speechpb.RecognitionAudio, error) {
+	sn := serviceInfo.GetServiceName()
+
+	if strings.HasPrefix(uri, "gs://") {
+		return &speechpb.RecognitionAudio{AudioSource: &speechpb.RecognitionAudio_Uri{Uri: uri}}, nil
+	}
+	if g.store == nil {
+		log.Printf("%s.transcription.google.audio, no Store to read %q from\n", sn, uri)
+		return nil, ErrUnsupportedMedia
+	}
+
+	r, err := g.store.Get(ctx, uri)
+	if err == media.ErrUnsupportedURI {
+		log.Printf("%s.transcription.google.audio, no Store for %q\n", sn, uri)
+		return nil, ErrUnsupportedMedia
+	}
+	if err != nil {
+		log.Printf("%s.transcription.google.audio, Get(%q) error: %v\n", sn, uri, err)
+		return nil, err
+	}
+	defer r.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(r, googleInlineMax+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > googleInlineMax {
+		log.Printf("%s.transcription.google.audio, %q larger than %d bytes, must be in Cloud Storage\n", sn, uri, googleInlineMax)
+		return nil, ErrUnsupportedMedia
+	}
+	return &speechpb.RecognitionAudio{AudioSource: &speechpb.RecognitionAudio_Content{Content: content}}, nil
+}
+
+// googleRecognizeRequest builds the Speech-to-Text request for the media
+// file audio
+func googleRecognizeRequest(audio *speechpb.RecognitionAudio, opts Options) *speechpb.LongRunningRecognizeRequest {
+	// "By using the [classes] in your recognition config, Cloud
+	// Speech-to-Text is more likely to correctly transcribe audio
+	// that includes [those classes]""
+	speechContext := speechpb.SpeechContext{Phrases: opts.Phrases}
+
+	// recognize different speakers and what they say; a fixed speaker count improves attribution
+	diarization := &speechpb.SpeakerDiarizationConfig{
+		EnableSpeakerDiarization: true,
+	}
+	if opts.SpeakerCount > 0 {
+		diarization.MinSpeakerCount = int32(opts.SpeakerCount)
+		diarization.MaxSpeakerCount = int32(opts.SpeakerCount)
+	}
+
+	// each channel of a call recorded on separate channels is one
+	// speaker, more reliably than diarization tells them apart
+	var channels int32
+	if googleSeparate(opts) {
+		channels = int32(opts.Channels)
+		diarization = nil
+	}
+
+	// for MP3, DO NOT include Encoding or SampleRateHertz
+	var encoding speechpb.RecognitionConfig_AudioEncoding
+	var sampleRate int32
+	if e, ok := speechpb.RecognitionConfig_AudioEncoding_value[opts.Encoding]; ok {
+		encoding = speechpb.RecognitionConfig_AudioEncoding(e)
+		sampleRate = int32(opts.SampleRateHertz)
+	}
+
+	return &speechpb.LongRunningRecognizeRequest{
+		Config: &speechpb.RecognitionConfig{
+			Encoding:        encoding,
+			SampleRateHertz: sampleRate,
+			LanguageCode:    opts.LanguageCode,
+			UseEnhanced:     true, // phone model requires enhanced service
+			Model:           opts.Model,
+			MaxAlternatives: int32(opts.MaxAlternatives),
+			// adds punctuation to recognition result
+			EnableAutomaticPunctuation:          true,
+			DiarizationConfig:                   diarization,
+			AudioChannelCount:                   channels,
+			EnableSeparateRecognitionPerChannel: channels > 1,
+			SpeechContexts: []*speechpb.SpeechContext{
+				&speechContext,
+			},
+		},
+		Audio: audio,
+	}
+}
+
+// googleTranscript normalizes a Speech-to-Text response. With diarization,
+// the last result carrying words repeats every word with its speaker, so
+// each alternative is taken from the last result that has it. With each
+// channel recognized separately, see googleChannelsTranscript.
+func googleTranscript(resp *speechpb.LongRunningRecognizeResponse, separate bool) *Transcript {
+	if separate {
+		return googleChannelsTranscript(resp)
+	}
+	t := &Transcript{Provider: Google, Words: []Word{}}
+
+	var alternatives []*speechpb.SpeechRecognitionAlternative
+	for _, result := range resp.GetResults() {
+		for a, alt := range result.GetAlternatives() {
+			if len(alt.GetWords()) == 0 {
+				// alternatives with no WordInfo records are ignored
+				continue
+			}
+			for len(alternatives) <= a {
+				alternatives = append(alternatives, nil)
+			}
+			alternatives[a] = alt
+		}
+	}
+
+	for a, alt := range alternatives {
+		if alt == nil {
+			continue
+		}
+		if a > 0 {
+			t.Alternatives = append(t.Alternatives, Alternative{Text: alt.GetTranscript(), Confidence: alt.GetConfidence()})
+			continue
+		}
+		t.Text = alt.GetTranscript()
+		t.Confidence = alt.GetConfidence()
+		for _, w := range alt.GetWords() {
+			t.Words = append(t.Words, Word{
+				Text:    w.GetWord(),
+				Speaker: int(w.GetSpeakerTag()),
+				Start:   fromProto(w.GetStartTime()),
+				End:     fromProto(w.GetEndTime()),
+			})
+		}
+	}
+
+	return t
+}
+
+// googleChannelsTranscript normalizes a Speech-to-Text response recognizing
+// each channel separately: every result is one channel's utterance, its
+// words spoken by that channel's speaker. Utterances are merged in the
+// order they start, and words in the order they're spoken.
+func googleChannelsTranscript(resp *speechpb.LongRunningRecognizeResponse) *Transcript {
+	t := &Transcript{Provider: Google, Words: []Word{}}
+
+	type utterance struct {
+		start        time.Duration
+		best         *speechpb.SpeechRecognitionAlternative
+		alternatives []*speechpb.SpeechRecognitionAlternative
+	}
+	var utterances []utterance
+	for _, result := range resp.GetResults() {
+		alts := result.GetAlternatives()
+		if len(alts) == 0 || len(alts[0].GetWords()) == 0 {
+			continue
+		}
+		u := utterance{start: fromProto(alts[0].GetWords()[0].GetStartTime()), best: alts[0], alternatives: alts[1:]}
+		utterances = append(utterances, u)
+		for _, w := range alts[0].GetWords() {
+			t.Words = append(t.Words, Word{
+				Text:    w.GetWord(),
+				Speaker: int(result.GetChannelTag()),
+				Start:   fromProto(w.GetStartTime()),
+				End:     fromProto(w.GetEndTime()),
+			})
+		}
+	}
+	sort.SliceStable(utterances, func(i, j int) bool { return utterances[i].start < utterances[j].start })
+	sort.SliceStable(t.Words, func(i, j int) bool { return t.Words[i].Start < t.Words[j].Start })
+
+	// the text, and each alternative's, joins the utterances'; confidence
+	// is their mean
+	var texts []string
+	var confidence float32
+	var alternatives [][]string
+	var altConfidence []float32
+	for _, u := range utterances {
+		texts = append(texts, u.best.GetTranscript())
+		confidence += u.best.GetConfidence()
+		for a, alt := range u.alternatives {
+			for len(alternatives) <= a {
+				alternatives = append(alternatives, nil)
+				altConfidence = append(altConfidence, 0)
+			}
+			alternatives[a] = append(alternatives[a], alt.GetTranscript())
+			altConfidence[a] += alt.GetConfidence()
+		}
+	}
+	if len(utterances) > 0 {
+		t.Text = strings.Join(texts, " ")
+		t.Confidence = confidence / float32(len(utterances))
+	}
+	for a, alt := range alternatives {
+		t.Alternatives = append(t.Alternatives, Alternative{Text: strings.Join(alt, " "), Confidence: altConfidence[a] / float32(len(alt))})
+	}
+
+	return t
+}
+
+func fromProto(d *duration.Duration) time.Duration {
+	return time.Duration(d.GetSeconds())*time.Second + time.Duration(d.GetNanos())
+}
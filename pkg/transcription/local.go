@@ -0,0 +1,301 @@
+package transcription
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// ErrEngineOutput - the local engine's output isn't a transcript in a
+// format localTranscript recognizes
+var ErrEngineOutput = errors.New("Unrecognized local engine output")
+
+// localProvider implements Provider with an offline speech-to-text engine,
+// e.g. whisper.cpp or Vosk, run as a subprocess: audio never leaves the
+// machine
+type localProvider struct {
+	command []string
+	store   media.Store
+}
+
+// NewLocalProvider returns the Provider running command, the engine and its
+// arguments. In each argument, {media} is replaced by the media file's
+// path, {language} by the BCP-47 language code, {lang} by its language
+// alone (e.g. "en", as whisper.cpp's -l expects), {model} by the model, and
+// {output} by a temporary file name. The engine writes its JSON transcript
+// to stdout or, when {output} is used, to that file (or that file plus
+// ".json", as whisper.cpp's -oj -of does). Media files not on this
+// machine, e.g. "s3://" ones, are read from store into a temporary file.
+//
+//	whisper-cli -m ggml-base.en.bin -l {lang} -oj -of {output} -f {media}
+func NewLocalProvider(command []string, store media.Store) Provider {
+	return localProvider{command: command, store: store}
+}
+
+func (l localProvider) Name() string {
+	return Local
+}
+
+// Transcribe runs the engine on the media file, and normalizes its output
+func (l localProvider) Transcribe(ctx context.Context, mediaURI string, opts Options) (*Transcript, error) {
+	sn := serviceInfo.GetServiceName()
+
+	if len(l.command) == 0 {
+		log.Printf("%s.transcription.local.Transcribe, no engine command configured\n", sn)
+		return nil, ErrUnknownProvider
+	}
+
+	dir, err := ioutil.TempDir("", "transcription")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "transcript")
+
+	path, err := l.mediaPath(ctx, mediaURI, dir)
+	if err != nil {
+		log.Printf("%s.transcription.local.Transcribe, %q: %v\n", sn, mediaURI, err)
+		return nil, err
+	}
+
+	args, usesOutput := localArgs(l.command[1:], path, output, opts)
+	cmd := exec.CommandContext(ctx, l.command[0], args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("%s.transcription.local.Transcribe, %s error: %v, stderr: %s\n", sn, l.command[0], err, strings.TrimSpace(stderr.String()))
+		return nil, fmt.Errorf("%s: %v", l.command[0], err)
+	}
+
+	out := stdout.Bytes()
+	if usesOutput {
+		if out, err = ioutil.ReadFile(output + ".json"); os.IsNotExist(err) {
+			out, err = ioutil.ReadFile(output)
+		}
+		if err != nil {
+			log.Printf("%s.transcription.local.Transcribe, reading %s output error: %v\n", sn, l.command[0], err)
+			return nil, err
+		}
+	}
+
+	t, err := localTranscript(out)
+	if err != nil {
+		log.Printf("%s.transcription.local.Transcribe, %s output error: %v\n", sn, l.command[0], err)
+		return nil, err
+	}
+	return t, nil
+}
+
+// mediaPath returns the path of the media file on this machine, reading
+// it from the Store into dir if it's elsewhere
+func (l localProvider) mediaPath(ctx context.Context, uri string, dir string) (string, error) {
+	if l.store == nil || !strings.Contains(uri, "://") || strings.HasPrefix(uri, "file://") {
+		return localMediaPath(uri)
+	}
+
+	r, err := l.store.Get(ctx, uri)
+	if err == media.ErrUnsupportedURI {
+		return "", ErrUnsupportedMedia
+	}
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	// engines may go by the extension
+	path := filepath.Join(dir, "media"+filepath.Ext(uri))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// localMediaPath returns the path of a media file on this machine, a path
+// or "file://" URI; others (e.g. "gs://") are ErrUnsupportedMedia
+func localMediaPath(uri string) (string, error) {
+	path := uri
+	if strings.Contains(uri, "://") {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme != "file" {
+			return "", ErrUnsupportedMedia
+		}
+		path = u.Path
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", ErrUnsupportedMedia
+	}
+	return path, nil
+}
+
+// localArgs replaces the placeholders in args, and reports whether
+// {output} was among them
+func localArgs(args []string, media, output string, opts Options) ([]string, bool) {
+	lang := opts.LanguageCode
+	if i := strings.IndexAny(lang, "-_"); i > 0 {
+		lang = lang[:i]
+	}
+	replacer := strings.NewReplacer(
+		"{media}", media,
+		"{language}", opts.LanguageCode,
+		"{lang}", lang,
+		"{model}", opts.Model,
+		"{output}", output,
+	)
+
+	usesOutput := false
+	expanded := make([]string, 0, len(args))
+	for _, arg := range args {
+		if strings.Contains(arg, "{output}") {
+			usesOutput = true
+		}
+		expanded = append(expanded, replacer.Replace(arg))
+	}
+	return expanded, usesOutput
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// whisperOutput is whisper.cpp's JSON output (-oj): segments of text, with
+// speaker turns when run with -tdrz
+type whisperOutput struct {
+	Transcription []struct {
+		Offsets struct {
+			From int64 `json:"from"` // milliseconds
+			To   int64 `json:"to"`
+		} `json:"offsets"`
+		Text            string `json:"text"`
+		SpeakerTurnNext bool   `json:"speaker_turn_next"`
+	} `json:"transcription"`
+}
+
+// voskOutput is Vosk's final result, with words (SetWords(true)); with
+// SetMaxAlternatives, the result is in Alternatives instead
+type voskOutput struct {
+	voskResult
+	Alternatives []voskResult `json:"alternatives"`
+}
+
+type voskResult struct {
+	Text       string     `json:"text"`
+	Confidence float32    `json:"confidence"`
+	Result     []voskWord `json:"result"`
+}
+
+type voskWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"` // seconds
+	End   float64 `json:"end"`
+	Conf  float32 `json:"conf"`
+}
+
+// localTranscript normalizes the output of whisper.cpp or Vosk
+func localTranscript(out []byte) (*Transcript, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, ErrEngineOutput
+	}
+
+	if _, ok := probe["transcription"]; ok {
+		var w whisperOutput
+		if err := json.Unmarshal(out, &w); err != nil {
+			return nil, ErrEngineOutput
+		}
+		return whisperTranscript(w), nil
+	}
+
+	_, hasResult := probe["result"]
+	_, hasAlternatives := probe["alternatives"]
+	if hasResult || hasAlternatives {
+		var v voskOutput
+		if err := json.Unmarshal(out, &v); err != nil {
+			return nil, ErrEngineOutput
+		}
+		return voskTranscript(v), nil
+	}
+
+	return nil, ErrEngineOutput
+}
+
+// whisperTranscript spreads each segment's time evenly over its words, as
+// whisper.cpp times segments, not words
+func whisperTranscript(w whisperOutput) *Transcript {
+	t := &Transcript{Provider: Local, Words: []Word{}}
+
+	speaker := 1
+	var texts []string
+	for _, segment := range w.Transcription {
+		words := strings.Fields(segment.Text)
+		if len(words) > 0 {
+			texts = append(texts, strings.Join(words, " "))
+		}
+		from := time.Duration(segment.Offsets.From) * time.Millisecond
+		var each time.Duration
+		if len(words) > 0 {
+			each = (time.Duration(segment.Offsets.To)*time.Millisecond - from) / time.Duration(len(words))
+		}
+		for i, word := range words {
+			t.Words = append(t.Words, Word{
+				Text:    word,
+				Speaker: speaker,
+				Start:   from + time.Duration(i)*each,
+				End:     from + time.Duration(i+1)*each,
+			})
+		}
+		if segment.SpeakerTurnNext {
+			speaker = speaker%2 + 1 // -tdrz marks turns, not who speaks
+		}
+	}
+	t.Text = strings.Join(texts, " ")
+
+	return t
+}
+
+// voskTranscript takes the best alternative's words; Vosk doesn't
+// attribute speakers
+func voskTranscript(v voskOutput) *Transcript {
+	t := &Transcript{Provider: Local, Words: []Word{}}
+
+	results := v.Alternatives
+	if len(results) == 0 {
+		results = []voskResult{v.voskResult}
+	}
+
+	for a, result := range results {
+		if a > 0 {
+			t.Alternatives = append(t.Alternatives, Alternative{Text: result.Text, Confidence: result.Confidence})
+			continue
+		}
+		t.Text = result.Text
+		t.Confidence = result.Confidence
+		for _, w := range result.Result {
+			t.Words = append(t.Words, Word{
+				Text:       w.Word,
+				Start:      time.Duration(w.Start * float64(time.Second)),
+				End:        time.Duration(w.End * float64(time.Second)),
+				Confidence: w.Conf,
+			})
+		}
+	}
+
+	return t
+}
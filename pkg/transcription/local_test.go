@@ -0,0 +1,177 @@
+package transcription
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/peterpla/lead-expert/pkg/media"
+)
+
+func TestLocalTranscript(t *testing.T) {
+
+	type test struct {
+		name       string
+		out        string
+		err        error
+		attributed string
+		duration   time.Duration
+		confidence float32
+	}
+
+	tests := []test{
+		{name: "whisper.cpp",
+			out: `{"transcription": [
+				{"offsets": {"from": 0, "to": 1000}, "text": " Thank you", "speaker_turn_next": true},
+				{"offsets": {"from": 1000, "to": 2500}, "text": " Hi there Michael"}]}`,
+			attributed: "[Speaker 1] Thank you|[Speaker 2] Hi there Michael\n",
+			duration:   2500 * time.Millisecond},
+		{name: "whisper.cpp, no speech",
+			out:        `{"transcription": []}`,
+			attributed: ""},
+		{name: "vosk",
+			out: `{"text": "thank you", "result": [
+				{"word": "thank", "start": 0.0, "end": 0.5, "conf": 0.9},
+				{"word": "you", "start": 0.5, "end": 1.25, "conf": 1.0}]}`,
+			attributed: "[Speaker 1] thank you\n",
+			duration:   1250 * time.Millisecond},
+		{name: "vosk alternatives",
+			out: `{"alternatives": [
+				{"text": "thank you", "confidence": 0.8, "result": [{"word": "thank", "start": 0.0, "end": 0.5}, {"word": "you", "start": 0.5, "end": 1.0}]},
+				{"text": "thank ewe", "confidence": 0.2, "result": []}]}`,
+			attributed: "[Speaker 1] thank you\n",
+			duration:   time.Second,
+			confidence: 0.8},
+		{name: "not JSON", out: `Thank you`, err: ErrEngineOutput},
+		{name: "unknown engine", out: `{"segments": []}`, err: ErrEngineOutput},
+	}
+
+	for _, tc := range tests {
+		got, err := localTranscript([]byte(tc.out))
+		if err != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got.Provider != Local {
+			t.Errorf("%s: expected provider %q, got %q", tc.name, Local, got.Provider)
+		}
+		if attributed := got.Attributed(); attributed != tc.attributed {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.attributed, attributed)
+		}
+		if got.Duration() != tc.duration {
+			t.Errorf("%s: expected duration %v, got %v", tc.name, tc.duration, got.Duration())
+		}
+		if got.Confidence != tc.confidence {
+			t.Errorf("%s: expected confidence %v, got %v", tc.name, tc.confidence, got.Confidence)
+		}
+	}
+}
+
+func TestLocalArgs(t *testing.T) {
+	opts := Options{LanguageCode: "en-US", Model: "phone_call"}
+
+	args, usesOutput := localArgs([]string{"-l", "{lang}", "-oj", "-of", "{output}", "-f", "{media}"}, "/tmp/a.mp3", "/tmp/out", opts)
+	if expected := []string{"-l", "en", "-oj", "-of", "/tmp/out", "-f", "/tmp/a.mp3"}; !cmp.Equal(args, expected) || !usesOutput {
+		t.Errorf("expected %q using output, got %q, %t", expected, args, usesOutput)
+	}
+
+	args, usesOutput = localArgs([]string{"--lang={language}", "--model={model}", "{media}"}, "/tmp/a.mp3", "/tmp/out", opts)
+	if expected := []string{"--lang=en-US", "--model=phone_call", "/tmp/a.mp3"}; !cmp.Equal(args, expected) || usesOutput {
+		t.Errorf("expected %q on stdout, got %q, %t", expected, args, usesOutput)
+	}
+}
+
+// TestLocalProvider runs cmd/fakeSTT as the engine
+func TestLocalProvider(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds cmd/fakeSTT")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found, can't build cmd/fakeSTT")
+	}
+
+	dir, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	engine := filepath.Join(dir, "fakeSTT")
+	if out, err := exec.Command(goTool, "build", "-o", engine, "../../cmd/fakeSTT").CombinedOutput(); err != nil {
+		t.Fatalf("building fakeSTT: %v, %s", err, out)
+	}
+
+	mediaFile := filepath.Join(dir, "audio-01.mp3")
+	if err := ioutil.WriteFile(mediaFile, []byte("ID3"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(mediaFile+".txt", []byte("Thank you for calling.\nHi, I'd like a quote.\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		name       string
+		command    []string
+		uri        string
+		err        error
+		attributed string
+	}
+
+	tests := []test{
+		{name: "whisper.cpp, to a file",
+			command:    []string{engine, "-l", "{lang}", "-oj", "-of", "{output}", "-f", "{media}"},
+			uri:        "file://" + mediaFile,
+			attributed: "[Speaker 1] Thank you for calling.|[Speaker 2] Hi, I'd like a quote.\n"},
+		{name: "vosk, to stdout",
+			command:    []string{engine, "-format", "vosk", "-f", "{media}"},
+			uri:        mediaFile,
+			attributed: "[Speaker 1] Thank you for calling. Hi, I'd like a quote.\n"},
+		{name: "media in a Store",
+			command:    []string{engine, "-format", "vosk", "-f", "{media}"},
+			uri:        "s3://bucket/audio-01.mp3",
+			attributed: "[Speaker 1] Thank you for calling Park flooring. This is Michael. How may I help you? Hey Michael. How are you today? Good. What's up? My name is Yuri.\n"},
+		{name: "no Store for the media",
+			command: []string{engine, "-f", "{media}"},
+			uri:     "gs://bucket/audio-01.mp3",
+			err:     ErrUnsupportedMedia},
+		{name: "no engine",
+			uri: mediaFile,
+			err: ErrUnknownProvider},
+	}
+
+	// the engine finds no transcript beside a copy, and uses its canned call
+	store := media.Stores{"s3": memoryStore{"s3://bucket/audio-01.mp3": []byte("ID3")}}
+
+	for _, tc := range tests {
+		provider := NewLocalProvider(tc.command, store)
+		got, err := provider.Transcribe(context.Background(), tc.uri, Options{LanguageCode: "en-US"})
+		if err != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if attributed := got.Attributed(); attributed != tc.attributed {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.attributed, attributed)
+		}
+	}
+
+	// not a media file, and the engine failing
+	if _, err := NewLocalProvider([]string{engine, "-f", "{media}"}, nil).Transcribe(context.Background(), dir, Options{}); err != ErrUnsupportedMedia {
+		t.Errorf("directory: expected %v, got %v", ErrUnsupportedMedia, err)
+	}
+	if _, err := NewLocalProvider([]string{engine, "-format", "other", "-f", "{media}"}, nil).Transcribe(context.Background(), mediaFile, Options{}); err == nil {
+		t.Errorf("engine error: expected an error, got none")
+	}
+}
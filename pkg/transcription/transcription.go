@@ -0,0 +1,263 @@
+// Transcription package defines speech-to-text providers, and the
+// normalized transcript each returns, so serviceDispatch can choose among
+// them per customer or Request
+package transcription
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/peterpla/lead-expert/pkg/audio"
+	"github.com/peterpla/lead-expert/pkg/request"
+)
+
+// the providers, by the name ProcessingConfig.Provider chooses them with
+const (
+	Google = "google" // Google Cloud Speech-to-Text, the default
+	Local  = "local"  // an offline engine on the transcription service's machine
+)
+
+// Names are the providers' names
+var Names = []string{Google, Local}
+
+// Accepted lists the audio formats each provider transcribes; mediaConvert
+// converts media in any other to the first, keeping channels it separates
+var Accepted = map[string][]audio.Format{
+	// Speech-to-Text reads mono audio in these encodings, or each channel
+	// of multi-channel audio separately, and MP3 as it always has
+	Google: {
+		{Encoding: "FLAC", SampleRateHertz: 16000, Channels: 1},
+		{Encoding: "FLAC", Channels: 1},
+		{Encoding: "LINEAR16", Channels: 1},
+		{Encoding: "MULAW", Channels: 1},
+		{Encoding: "AMR", SampleRateHertz: 8000, Channels: 1},
+		{Encoding: "AMR_WB", SampleRateHertz: 16000, Channels: 1},
+		{Encoding: "OGG_OPUS", Channels: 1},
+		{Encoding: "MP3", Channels: 1},
+	},
+	// whisper.cpp reads only 16 kHz WAV, and Vosk's models expect it
+	Local: {
+		{Encoding: "LINEAR16", SampleRateHertz: 16000, Channels: 1},
+	},
+}
+
+// separates lists the providers transcribing each channel of multi-channel
+// audio separately
+var separates = map[string]bool{
+	Google: true,
+}
+
+// Separate reports whether the provider is to transcribe each of the
+// channels separately, as one speaker, rather than diarize them mixed
+func Separate(provider string, cfg request.ProcessingConfig, channels int) bool {
+	return separates[provider] && channels > 1 && cfg.Channels != request.ChannelsMixed
+}
+
+// Accepts reports whether the provider transcribes audio in format f; if
+// separate, each channel is transcribed as mono audio
+func Accepts(provider string, f audio.Format, separate bool) bool {
+	if separate {
+		f.Channels = 1
+	}
+	for _, accepted := range Accepted[provider] {
+		if accepted.Matches(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrUnsupportedMedia - the provider can't transcribe the media file;
+// retrying won't help
+var ErrUnsupportedMedia = errors.New("Unsupported media")
+
+// ErrUnknownProvider - no provider has the name given
+var ErrUnknownProvider = errors.New("Unknown transcription provider")
+
+// ErrOperationFailed - the provider's operation ended without a
+// transcript; checking it again won't help
+var ErrOperationFailed = errors.New("Transcription failed")
+
+// Provider is implemented by each speech-to-text service
+type Provider interface {
+	// Name identifies the provider in ProcessingConfig.Provider
+	Name() string
+	// Transcribe returns the transcript of the media at mediaURI
+	Transcribe(ctx context.Context, mediaURI string, opts Options) (*Transcript, error)
+}
+
+// AsyncProvider is implemented by providers transcribing as a long-running
+// operation, which the transcription service submits then checks on later,
+// rather than waiting for
+type AsyncProvider interface {
+	Provider
+	// Submit starts transcribing the media at mediaURI, returning the
+	// operation's name
+	Submit(ctx context.Context, mediaURI string, opts Options) (string, error)
+	// Check returns the status of the operation, given the Options it was
+	// submitted with
+	Check(ctx context.Context, operation string, opts Options) (*Operation, error)
+}
+
+// Operation is the status of an AsyncProvider's operation
+type Operation struct {
+	Name       string
+	Done       bool
+	Progress   int         // percent, 0 if not known
+	Transcript *Transcript // once Done
+}
+
+// Options are the speech-to-text settings of a Request
+type Options struct {
+	LanguageCode     string   // BCP-47, e.g. "en-US"
+	Model            string   // e.g. "phone_call"
+	MaxAlternatives  int      // alternatives to the best transcript
+	SpeakerCount     int      // 0 = detect automatically
+	Phrases          []string // hints, e.g. "$MONEY"
+	Encoding         string   // of the media, as mediaConvert found it; "" if not known
+	SampleRateHertz  int      // likewise
+	Channels         int      // likewise
+	SeparateChannels bool     // transcribe each channel as one speaker, see Separate
+}
+
+// OptionsFrom returns the Options of a Request's ProcessingConfig
+func OptionsFrom(cfg request.ProcessingConfig) Options {
+	return Options{
+		LanguageCode:    cfg.LanguageCode,
+		Model:           cfg.Model,
+		MaxAlternatives: cfg.MaxAlternatives,
+		SpeakerCount:    cfg.DiarizationSpeakerCount,
+		Phrases:         []string{"$MONEY", "$MONTH", "$POSTALCODE", "$FULLPHONENUM"},
+	}
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// Transcript is a provider's transcript in a common form: the best
+// alternative word by word, and any others as text
+type Transcript struct {
+	Provider     string        `json:"provider"`
+	Text         string        `json:"text"`
+	Confidence   float32       `json:"confidence"` // 0.0 to 1.0, 0 if not known
+	Words        []Word        `json:"words"`
+	Alternatives []Alternative `json:"alternatives,omitempty"`
+}
+
+// Word is one word of a Transcript, who said it, and when
+type Word struct {
+	Text       string        `json:"text"`
+	Speaker    int           `json:"speaker"` // from 1; 0 if not known
+	Start      time.Duration `json:"start"`   // from the start of the media
+	End        time.Duration `json:"end"`
+	Confidence float32       `json:"confidence,omitempty"`
+}
+
+// Alternative is a less likely transcript
+type Alternative struct {
+	Text       string  `json:"text"`
+	Confidence float32 `json:"confidence"`
+}
+
+// Duration is how much of the media was transcribed, to the end of the
+// last word
+func (t *Transcript) Duration() time.Duration {
+	var end time.Duration
+	for _, word := range t.Words {
+		if word.End > end {
+			end = word.End
+		}
+	}
+	return end
+}
+
+// Attributed returns the transcript as Request.WorkingTranscript holds it:
+// each turn "[Speaker n] words...", separated by "|" (completionProcessing
+// replaces | with \n), ending "\n"; "" if there are no words
+func (t *Transcript) Attributed() string {
+	return strings.Join(attributedStrings(t.Words), "")
+}
+
+// Structured returns the transcript as Request.Structured holds
+// it: a segment for each speaker's turn, as Attributed has them, but with a
+// word of no known speaker joining the current turn, not speaker 1's
+func (t *Transcript) Structured() *request.Transcript {
+	s := &request.Transcript{Provider: t.Provider, Confidence: t.Confidence, Segments: []request.Segment{}}
+
+	var segment *request.Segment
+	var texts []string
+	finish := func() {
+		if segment != nil {
+			segment.Text = strings.Join(texts, " ")
+			s.Segments = append(s.Segments, *segment)
+		}
+	}
+	for _, word := range t.Words {
+		if segment == nil || (word.Speaker != 0 && word.Speaker != segment.Speaker) {
+			// changed speakers - end the current turn
+			finish()
+			segment = &request.Segment{Speaker: word.Speaker, Start: word.Start.Seconds()}
+			texts = nil
+		}
+		segment.Words = append(segment.Words, request.Word{
+			Text:       word.Text,
+			Start:      word.Start.Seconds(),
+			End:        word.End.Seconds(),
+			Confidence: word.Confidence,
+		})
+		if end := word.End.Seconds(); end > segment.End {
+			segment.End = end
+		}
+		texts = append(texts, word.Text)
+	}
+	finish()
+
+	for _, alt := range t.Alternatives {
+		s.Alternatives = append(s.Alternatives, request.Alternative{Text: alt.Text, Confidence: alt.Confidence})
+	}
+	return s
+}
+
+// attributedStrings returns each speaker's turn in words
+func attributedStrings(words []Word) []string {
+	// use | instead of \n to keep log entries cleaner
+	const separator = "|"
+
+	turns := []string{}
+	if len(words) == 0 {
+		return turns
+	}
+
+	speaker := 1
+	turn := "[Speaker 1]"
+	for _, word := range words {
+		if word.Speaker != speaker && word.Speaker != 0 {
+			// changed speakers - end the current turn
+			turns = append(turns, turn+separator)
+			turn = fmt.Sprintf("[Speaker %d]", word.Speaker)
+			speaker = word.Speaker
+		}
+
+		// POLICY: add space in front of the word we're adding (avoids
+		// trailing spaces)
+		turn += " " + word.Text
+	}
+	return append(turns, turn+"\n")
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// Select returns the name of the provider to transcribe the Request: its
+// resolved Config's (from the Request's custom_config, or the customer's
+// profile), else defaultProvider
+func Select(req *request.Request, defaultProvider string) string {
+	if p := req.EffectiveConfig().Provider; p != "" {
+		return p
+	}
+	if defaultProvider != "" {
+		return defaultProvider
+	}
+	return Google
+}
@@ -0,0 +1,444 @@
+package transcription
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/google/go-cmp/cmp"
+	speechpb "google.golang.org/genproto/googleapis/cloud/speech/v1"
+
+	"github.com/peterpla/lead-expert/pkg/audio"
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/request"
+)
+
+// memoryStore holds media files for "s3://" URIs
+type memoryStore map[string][]byte
+
+func (m memoryStore) Delete(ctx context.Context, uri string) error {
+	delete(m, uri)
+	return nil
+}
+
+func (m memoryStore) Exists(ctx context.Context, uri string) (bool, error) {
+	_, ok := m[uri]
+	return ok, nil
+}
+
+func (m memoryStore) Get(ctx context.Context, uri string) (io.ReadCloser, error) {
+	data, ok := m[uri]
+	if !ok {
+		return nil, media.ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m memoryStore) Put(ctx context.Context, uri string, r io.Reader, contentType string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m[uri] = data
+	return nil
+}
+
+func (m memoryStore) SignedURL(ctx context.Context, uri string, expires time.Duration) (string, error) {
+	return "", media.ErrNoSigner
+}
+
+func TestAttributed(t *testing.T) {
+
+	type test struct {
+		name   string
+		words  []Word
+		result []string
+	}
+
+	tests := []test{
+		{"zero words",
+			[]Word{},
+			[]string{},
+		},
+
+		{"one word",
+			[]Word{
+				{Text: "Thank", Speaker: 1},
+			},
+			[]string{
+				"[Speaker 1] Thank\n",
+			},
+		},
+
+		{"two words",
+			[]Word{
+				{Text: "Thank", Speaker: 1},
+				{Text: "you", Speaker: 1},
+			},
+			[]string{
+				"[Speaker 1] Thank you\n",
+			},
+		},
+
+		{"speaker not known",
+			[]Word{
+				{Text: "Thank"},
+				{Text: "you"},
+			},
+			[]string{
+				"[Speaker 1] Thank you\n",
+			},
+		},
+
+		{"two speakers",
+			[]Word{
+				{Text: "Thank", Speaker: 1},
+				{Text: "you", Speaker: 1},
+				{Text: "for", Speaker: 1},
+				{Text: "calling", Speaker: 1},
+				{Text: "Park", Speaker: 1},
+				{Text: "flooring.", Speaker: 1},
+				{Text: "This", Speaker: 1},
+				{Text: "is", Speaker: 1},
+				{Text: "Michael.", Speaker: 1},
+				{Text: "How", Speaker: 1},
+				{Text: "may", Speaker: 1},
+				{Text: "I", Speaker: 1},
+				{Text: "help", Speaker: 1},
+				{Text: "you?", Speaker: 1},
+				{Text: "Hey", Speaker: 2},
+				{Text: "Michael.", Speaker: 2},
+				{Text: "How", Speaker: 2},
+				{Text: "are", Speaker: 2},
+				{Text: "you", Speaker: 2},
+				{Text: "today?", Speaker: 2},
+				{Text: "Good.", Speaker: 1},
+				{Text: "What's", Speaker: 1},
+				{Text: "up?", Speaker: 1},
+				{Text: "My", Speaker: 2},
+				{Text: "name", Speaker: 2},
+				{Text: "is", Speaker: 2},
+				{Text: "Yuri.", Speaker: 2},
+			},
+			[]string{
+				"[Speaker 1] Thank you for calling Park flooring. This is Michael. How may I help you?|",
+				"[Speaker 2] Hey Michael. How are you today?|",
+				"[Speaker 1] Good. What's up?|",
+				"[Speaker 2] My name is Yuri.\n",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		if got := attributedStrings(tc.words); !cmp.Equal(got, tc.result) {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.result, got)
+		}
+	}
+}
+
+func TestStructured(t *testing.T) {
+	s := time.Second
+	transcript := &Transcript{Provider: Google, Confidence: 0.8, Words: []Word{
+		{Text: "Thank", Speaker: 1, Start: 0, End: s / 2, Confidence: 0.9},
+		{Text: "you.", Speaker: 1, Start: s / 2, End: s, Confidence: 0.8},
+		{Text: "Hi,", Speaker: 2, Start: 2 * s, End: 2*s + s/2, Confidence: 0.7},
+		{Text: "um", Start: 3 * s, End: 3*s + s/4},
+		{Text: "Yuri.", Speaker: 2, Start: 4 * s, End: 5 * s, Confidence: 0.6},
+	}, Alternatives: []Alternative{{Text: "Thank you high um Yuri", Confidence: 0.4}}}
+
+	expected := &request.Transcript{Provider: Google, Confidence: 0.8, Segments: []request.Segment{
+		{Speaker: 1, Start: 0, End: 1, Text: "Thank you.", Words: []request.Word{
+			{Text: "Thank", Start: 0, End: 0.5, Confidence: 0.9},
+			{Text: "you.", Start: 0.5, End: 1, Confidence: 0.8},
+		}},
+		{Speaker: 2, Start: 2, End: 5, Text: "Hi, um Yuri.", Words: []request.Word{
+			{Text: "Hi,", Start: 2, End: 2.5, Confidence: 0.7},
+			{Text: "um", Start: 3, End: 3.25},
+			{Text: "Yuri.", Start: 4, End: 5, Confidence: 0.6},
+		}},
+	}, Alternatives: []request.Alternative{{Text: "Thank you high um Yuri", Confidence: 0.4}}}
+
+	got := transcript.Structured()
+	if !cmp.Equal(got, expected) {
+		t.Errorf("segments by speaker: %s", cmp.Diff(expected, got))
+	}
+	if text := got.Text(); text != "Thank you.\nHi, um Yuri." {
+		t.Errorf("expected each segment's text on a line, got %q", text)
+	}
+
+	if empty := (&Transcript{}).Structured(); len(empty.Segments) != 0 || empty.Text() != "" {
+		t.Errorf("no words: expected no segments, got %+v", empty)
+	}
+}
+
+func TestGoogleTranscript(t *testing.T) {
+	word := func(text string, speaker int32, start, end int64) *speechpb.WordInfo {
+		return &speechpb.WordInfo{
+			Word:       text,
+			SpeakerTag: speaker,
+			StartTime:  &duration.Duration{Seconds: start},
+			EndTime:    &duration.Duration{Seconds: end, Nanos: 500000000},
+		}
+	}
+
+	// with diarization, the final result repeats every word with its speaker
+	resp := &speechpb.LongRunningRecognizeResponse{
+		Results: []*speechpb.SpeechRecognitionResult{
+			{Alternatives: []*speechpb.SpeechRecognitionAlternative{
+				{Transcript: "Thank you", Confidence: 0.9, Words: []*speechpb.WordInfo{word("Thank", 0, 0, 0), word("you", 0, 1, 1)}},
+			}},
+			{Alternatives: []*speechpb.SpeechRecognitionAlternative{
+				{Transcript: "Thank you hi", Confidence: 0.8, Words: []*speechpb.WordInfo{word("Thank", 1, 0, 0), word("you", 1, 1, 1), word("hi", 2, 2, 3)}},
+				{Transcript: "Thank ewe hi", Confidence: 0.4, Words: []*speechpb.WordInfo{word("Thank", 1, 0, 0)}},
+				{Transcript: "no words", Confidence: 0.1},
+			}},
+		},
+	}
+
+	got := googleTranscript(resp, false)
+	expected := &Transcript{
+		Provider:   Google,
+		Text:       "Thank you hi",
+		Confidence: 0.8,
+		Words: []Word{
+			{Text: "Thank", Speaker: 1, Start: 0, End: 500 * time.Millisecond},
+			{Text: "you", Speaker: 1, Start: time.Second, End: 1500 * time.Millisecond},
+			{Text: "hi", Speaker: 2, Start: 2 * time.Second, End: 3500 * time.Millisecond},
+		},
+		Alternatives: []Alternative{{Text: "Thank ewe hi", Confidence: 0.4}},
+	}
+	if !cmp.Equal(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	if got.Duration() != 3500*time.Millisecond {
+		t.Errorf("expected duration 3.5s, got %v", got.Duration())
+	}
+	if attributed := got.Attributed(); attributed != "[Speaker 1] Thank you|[Speaker 2] hi\n" {
+		t.Errorf("expected attributed transcript, got %q", attributed)
+	}
+
+	if empty := googleTranscript(&speechpb.LongRunningRecognizeResponse{}, false); empty.Attributed() != "" || empty.Duration() != 0 {
+		t.Errorf("expected empty transcript, got %+v", empty)
+	}
+}
+
+func TestGoogleChannelsTranscript(t *testing.T) {
+	word := func(text string, start, end int64) *speechpb.WordInfo {
+		return &speechpb.WordInfo{
+			Word:      text,
+			StartTime: &duration.Duration{Seconds: start},
+			EndTime:   &duration.Duration{Seconds: end, Nanos: 500000000},
+		}
+	}
+
+	// each channel's results, in the order Speech-to-Text returns them
+	resp := &speechpb.LongRunningRecognizeResponse{
+		Results: []*speechpb.SpeechRecognitionResult{
+			{ChannelTag: 1, Alternatives: []*speechpb.SpeechRecognitionAlternative{
+				{Transcript: "Thank you for calling.", Confidence: 0.9, Words: []*speechpb.WordInfo{word("Thank", 0, 0), word("you", 1, 1), word("for", 2, 2), word("calling.", 3, 3)}},
+				{Transcript: "Thank ewe for calling.", Confidence: 0.3},
+			}},
+			{ChannelTag: 1, Alternatives: []*speechpb.SpeechRecognitionAlternative{
+				{Transcript: "Sure.", Confidence: 0.7, Words: []*speechpb.WordInfo{word("Sure.", 8, 8)}},
+			}},
+			{ChannelTag: 2, Alternatives: []*speechpb.SpeechRecognitionAlternative{
+				{Transcript: "Hi, a quote please.", Confidence: 0.8, Words: []*speechpb.WordInfo{word("Hi,", 2, 2), word("a", 5, 5), word("quote", 6, 6), word("please.", 7, 7)}},
+				{Transcript: "Hi, a coat please.", Confidence: 0.5},
+			}},
+			{ChannelTag: 2, Alternatives: []*speechpb.SpeechRecognitionAlternative{{Transcript: ""}}},
+		},
+	}
+
+	got := googleTranscript(resp, true)
+	if expected := "Thank you for calling. Hi, a quote please. Sure."; got.Text != expected {
+		t.Errorf("expected text %q, got %q", expected, got.Text)
+	}
+	if got.Confidence < 0.799 || got.Confidence > 0.801 {
+		t.Errorf("expected mean confidence 0.8, got %v", got.Confidence)
+	}
+	if expected := []Alternative{{Text: "Thank ewe for calling. Hi, a coat please.", Confidence: 0.4}}; !cmp.Equal(got.Alternatives, expected, cmp.Comparer(func(a, b float32) bool { return a-b < 0.001 && b-a < 0.001 })) {
+		t.Errorf("expected alternatives %+v, got %+v", expected, got.Alternatives)
+	}
+	// the caller starts talking as the agent finishes
+	if attributed := got.Attributed(); attributed != "[Speaker 1] Thank you for|[Speaker 2] Hi,|[Speaker 1] calling.|[Speaker 2] a quote please.|[Speaker 1] Sure.\n" {
+		t.Errorf("expected words merged by time, got %q", attributed)
+	}
+	if got.Duration() != 8500*time.Millisecond {
+		t.Errorf("expected duration 8.5s, got %v", got.Duration())
+	}
+}
+
+func TestCheckGoogleMedia(t *testing.T) {
+	type test struct {
+		uri      string
+		encoding string
+		err      error
+	}
+
+	tests := []test{
+		{uri: "gs://bucket/audio-01.mp3", err: nil},
+		{uri: "gs://bucket/audio-01.MP3", err: nil},
+		{uri: "gs://bucket/audio-01.wav", err: ErrUnsupportedMedia},
+		{uri: "s3://bucket/audio-01.mp3", err: nil},
+		{uri: "gs://bucket/audio-01.wav", encoding: "LINEAR16", err: nil},
+		{uri: "gs://bucket/audio-01.mp3", encoding: "MP3", err: nil},
+		{uri: "gs://bucket/audio-01.m4a", encoding: "AAC", err: ErrUnsupportedMedia},
+	}
+
+	for _, tc := range tests {
+		if err := checkGoogleMedia(tc.uri, Options{Encoding: tc.encoding}); err != tc.err {
+			t.Errorf("%s, %q: expected %v, got %v", tc.uri, tc.encoding, tc.err, err)
+		}
+	}
+}
+
+func TestGoogleRecognizeRequest(t *testing.T) {
+	audio := &speechpb.RecognitionAudio{AudioSource: &speechpb.RecognitionAudio_Uri{Uri: "gs://bucket/audio-01.flac"}}
+
+	req := googleRecognizeRequest(audio, Options{LanguageCode: "en-US", Encoding: "FLAC", SampleRateHertz: 16000})
+	if req.Config.Encoding != speechpb.RecognitionConfig_FLAC || req.Config.SampleRateHertz != 16000 {
+		t.Errorf("FLAC: expected encoding and sample rate passed through, got %v, %d", req.Config.Encoding, req.Config.SampleRateHertz)
+	}
+
+	if req.Config.DiarizationConfig == nil || req.Config.EnableSeparateRecognitionPerChannel {
+		t.Errorf("mono: expected diarization, got %+v", req.Config)
+	}
+
+	req = googleRecognizeRequest(audio, Options{LanguageCode: "en-US", Encoding: "FLAC", SampleRateHertz: 16000, Channels: 2, SeparateChannels: true})
+	if req.Config.AudioChannelCount != 2 || !req.Config.EnableSeparateRecognitionPerChannel || req.Config.DiarizationConfig != nil {
+		t.Errorf("separate channels: expected each channel recognized, not diarized, got %+v", req.Config)
+	}
+
+	// Speech-to-Text v1 detects MP3 itself
+	req = googleRecognizeRequest(audio, Options{LanguageCode: "en-US", Encoding: "MP3", SampleRateHertz: 44100})
+	if req.Config.Encoding != speechpb.RecognitionConfig_ENCODING_UNSPECIFIED || req.Config.SampleRateHertz != 0 {
+		t.Errorf("MP3: expected encoding and sample rate unspecified, got %v, %d", req.Config.Encoding, req.Config.SampleRateHertz)
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	type test struct {
+		provider string
+		format   audio.Format
+		separate bool
+		expected bool
+	}
+
+	tests := []test{
+		{provider: Google, format: audio.Format{Encoding: "MP3", SampleRateHertz: 44100, Channels: 1}, expected: true},
+		{provider: Google, format: audio.Format{Encoding: "MP3", SampleRateHertz: 44100, Channels: 2}, expected: false},
+		{provider: Google, format: audio.Format{Encoding: "MP3", SampleRateHertz: 44100, Channels: 2}, separate: true, expected: true},
+		{provider: Google, format: audio.Format{Encoding: "LINEAR16", SampleRateHertz: 8000, Channels: 1}, expected: true},
+		{provider: Google, format: audio.Format{Encoding: "LINEAR16", SampleRateHertz: 8000, Channels: 2}, expected: false},
+		{provider: Google, format: audio.Format{Encoding: "LINEAR16", SampleRateHertz: 8000, Channels: 2}, separate: true, expected: true},
+		{provider: Google, format: audio.Format{Encoding: "AMR", SampleRateHertz: 8000, Channels: 1}, expected: true},
+		{provider: Google, format: audio.Format{SampleRateHertz: 44100, Channels: 2}, expected: false},
+		{provider: Local, format: audio.Format{Encoding: "LINEAR16", SampleRateHertz: 16000, Channels: 1}, expected: true},
+		{provider: Local, format: audio.Format{Encoding: "MP3", SampleRateHertz: 16000, Channels: 1}, expected: false},
+		{provider: "other", format: audio.Format{Encoding: "MP3"}, expected: false},
+	}
+
+	for _, tc := range tests {
+		if got := Accepts(tc.provider, tc.format, tc.separate); got != tc.expected {
+			t.Errorf("%s, %+v, %t: expected %t, got %t", tc.provider, tc.format, tc.separate, tc.expected, got)
+		}
+	}
+}
+
+func TestSeparate(t *testing.T) {
+	type test struct {
+		name     string
+		provider string
+		channels int
+		config   string
+		expected bool
+	}
+
+	tests := []test{
+		{name: "stereo call", provider: Google, channels: 2, expected: true},
+		{name: "asked for separate", provider: Google, channels: 2, config: request.ChannelsSeparate, expected: true},
+		{name: "asked for mixed", provider: Google, channels: 2, config: request.ChannelsMixed, expected: false},
+		{name: "mono", provider: Google, channels: 1, expected: false},
+		{name: "offline engine", provider: Local, channels: 2, expected: false},
+	}
+
+	for _, tc := range tests {
+		if got := Separate(tc.provider, request.ProcessingConfig{Channels: tc.config}, tc.channels); got != tc.expected {
+			t.Errorf("%s: expected %t, got %t", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestAsyncProviders(t *testing.T) {
+	// Speech-to-Text transcribes as an operation, checked on later
+	if _, ok := NewGoogleProvider(nil).(AsyncProvider); !ok {
+		t.Errorf("expected the Google provider to submit operations")
+	}
+	// the offline engine runs while the task waits
+	if _, ok := NewLocalProvider([]string{"whisper"}, nil).(AsyncProvider); ok {
+		t.Errorf("expected the local provider to transcribe synchronously")
+	}
+}
+
+func TestGoogleAudio(t *testing.T) {
+	store := media.Stores{"s3": memoryStore{
+		"s3://bucket/audio-01.mp3": []byte("ID3"),
+		"s3://bucket/long.mp3":     make([]byte, googleInlineMax+1),
+	}}
+
+	type test struct {
+		uri     string
+		err     error
+		gcsURI  string
+		content string
+	}
+
+	tests := []test{
+		{uri: "gs://bucket/audio-01.mp3", gcsURI: "gs://bucket/audio-01.mp3"},
+		{uri: "s3://bucket/audio-01.mp3", content: "ID3"},
+		{uri: "s3://bucket/long.mp3", err: ErrUnsupportedMedia},
+		{uri: "s3://bucket/missing.mp3", err: media.ErrNotFound},
+		{uri: "file:///tmp/audio-01.mp3", err: ErrUnsupportedMedia},
+	}
+
+	g := googleProvider{store: store}
+	for _, tc := range tests {
+		audio, err := g.audio(context.Background(), tc.uri)
+		if err != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.uri, tc.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if audio.GetUri() != tc.gcsURI || string(audio.GetContent()) != tc.content {
+			t.Errorf("%s: expected URI %q, content %q, got %+v", tc.uri, tc.gcsURI, tc.content, audio)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	type test struct {
+		name     string
+		config   request.ProcessingConfig
+		fallback string
+		expected string
+	}
+
+	tests := []test{
+		{name: "system default", expected: Google},
+		{name: "configured default", fallback: "local", expected: "local"},
+		{name: "customer or request", config: request.ProcessingConfig{Provider: "google"}, fallback: "local", expected: Google},
+	}
+
+	for _, tc := range tests {
+		req := request.Request{Config: tc.config}
+		if got := Select(&req, tc.fallback); got != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, got)
+		}
+	}
+}
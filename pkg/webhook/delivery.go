@@ -0,0 +1,341 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/customer"
+	"github.com/peterpla/lead-expert/pkg/media"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/serviceInfo"
+)
+
+// Delivery statuses
+const (
+	Pending   = "pending"   // awaiting its first attempt, or a retry
+	Delivered = "delivered" // the receiver answered 2xx
+	Failed    = "failed"    // out of attempts; see Dispatcher.Replay
+)
+
+// DefaultMaxAttempts is how many times an event is sent before its
+// Delivery fails, unless Dispatcher.MaxAttempts is set
+const DefaultMaxAttempts = 6
+
+// retryBase is the wait before the first retry; each later wait is four
+// times the one before: 30s, 2m, 8m, 32m, ~2h
+const retryBase = 30 * time.Second
+
+// ErrNoCallback - neither the Request nor its customer has a callback URL
+var ErrNoCallback = errors.New("No callback URL")
+
+// ErrErased - the Delivery's payload was erased, see pkg/erasure
+var ErrErased = errors.New("Delivery erased")
+
+// Delivery is one event sent, or to be sent, to one callback URL, with
+// every attempt to send it
+type Delivery struct {
+	DeliveryID    string    `json:"delivery_id" firestore:"-"`
+	CustomerID    int       `json:"customer_id" firestore:"customer_id"`
+	RequestID     string    `json:"request_id" firestore:"request_id"`
+	EventType     string    `json:"event_type" firestore:"event_type"`
+	URL           string    `json:"url" firestore:"url"`
+	Payload       string    `json:"payload" firestore:"payload"` // the Event, as sent
+	Status        string    `json:"status" firestore:"status"`
+	Attempts      []Attempt `json:"attempts" firestore:"attempts"`
+	NextAttemptAt string    `json:"next_attempt_at,omitempty" firestore:"next_attempt_at,omitempty"` // when Pending
+	CreatedAt     string    `json:"created_at" firestore:"created_at"`
+	DeliveredAt   string    `json:"delivered_at,omitempty" firestore:"delivered_at,omitempty"`
+	ReplayedAt    string    `json:"replayed_at,omitempty" firestore:"replayed_at,omitempty"` // retries count from here
+	ErasedAt      string    `json:"erased_at,omitempty" firestore:"erased_at,omitempty"`     // Payload deleted on request; never sent again
+}
+
+// Attempt is one POST of a Delivery's event
+type Attempt struct {
+	At         string `json:"at" firestore:"at"`
+	StatusCode int    `json:"status_code,omitempty" firestore:"status_code,omitempty"`
+	Error      string `json:"error,omitempty" firestore:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms" firestore:"duration_ms"`
+}
+
+// ListOptions selects Deliveries; zero-valued fields don't filter
+type ListOptions struct {
+	CustomerID int
+	RequestID  string
+	Status     string
+	DueBy      string // Pending deliveries with NextAttemptAt at or before this time, in request.TimeLayout
+	Limit      int
+}
+
+// DeliveryRepository is implemented by each supported delivery log database
+type DeliveryRepository interface {
+	Create(d *Delivery) error
+	Update(d *Delivery) error
+	FindByID(deliveryID string) (*Delivery, error)
+	List(opts ListOptions) ([]*Delivery, error) // newest first; with DueBy, longest overdue first
+}
+
+// Dispatcher sends events to customers' callback URLs, and retries
+// deliveries that fail
+type Dispatcher struct {
+	Deliveries   DeliveryRepository
+	Customers    customer.CustomerRepository
+	Client       *http.Client     // nil for a 10-second timeout, refusing private addresses and redirects
+	AllowPrivate bool             // send to private addresses, for tests
+	MaxAttempts  int              // DefaultMaxAttempts if 0
+	Now          func() time.Time // for tests; time.Now if nil
+}
+
+// Notify sends ev to url, logging the Delivery, and returns it. A failed
+// first attempt isn't an error: the Delivery is left Pending for RetryDue.
+func (d *Dispatcher) Notify(ctx context.Context, url string, ev Event) (*Delivery, error) {
+	if url == "" {
+		return nil, ErrNoCallback
+	}
+
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+	delivery := &Delivery{
+		DeliveryID: uuid.New().String(),
+		CustomerID: ev.Data.CustomerID,
+		RequestID:  ev.Data.RequestID,
+		EventType:  ev.Type,
+		URL:        url,
+		Payload:    string(payload),
+		Status:     Pending,
+		Attempts:   []Attempt{},
+		CreatedAt:  d.now().UTC().Format(request.TimeLayout),
+	}
+	if err := d.Deliveries.Create(delivery); err != nil {
+		return nil, err
+	}
+
+	return delivery, d.attempt(ctx, delivery)
+}
+
+// NotifyRequest sends the event of type eventType about req to the
+// Request's callback_url, or else its customer's webhook. It returns
+// ErrNoCallback if there's neither.
+func (d *Dispatcher) NotifyRequest(ctx context.Context, eventType string, req *request.Request, reason string) (*Delivery, error) {
+	url := req.CallbackURL
+	if url == "" {
+		cust, err := d.Customers.FindByID(req.CustomerID)
+		if err != nil {
+			return nil, fmt.Errorf("customer %d: %v", req.CustomerID, err)
+		}
+		url = cust.Webhook.URL
+	}
+	if url == "" {
+		return nil, ErrNoCallback
+	}
+
+	return d.Notify(ctx, url, NewEvent(eventType, req, reason, d.now()))
+}
+
+// FailRequest marks req failed for reason, records it in repo, and tells
+// the customer. Pipeline stages call it for errors retrying won't fix; the
+// error returned is from recording the failure, so the task is retried.
+func FailRequest(ctx context.Context, repo request.RequestRepository, d *Dispatcher, req *request.Request, reason error) error {
+	sn := serviceInfo.GetServiceName()
+
+	req.Status = request.Error
+	req.OriginalStatus = http.StatusBadRequest
+	req.Error = reason.Error()
+	req.CompletedAt = time.Now().UTC().Format(request.TimeLayout)
+	if err := repo.Update(req); err != nil {
+		log.Printf("%s.webhook.FailRequest, repo.Update error: %+v\n", sn, err)
+		return err
+	}
+
+	if _, err := d.NotifyRequest(ctx, EventFailed, req, reason.Error()); err != nil && err != ErrNoCallback {
+		log.Printf("%s.webhook.FailRequest, NotifyRequest error: %+v\n", sn, err)
+	}
+	return nil
+}
+
+// RetryDue attempts every Pending delivery whose retry is due, returning
+// how many it attempted. One whose outcome can't be recorded doesn't stop
+// the rest; the errors are returned together.
+func (d *Dispatcher) RetryDue(ctx context.Context) (int, error) {
+	sn := serviceInfo.GetServiceName()
+
+	due, err := d.Deliveries.List(ListOptions{Status: Pending, DueBy: d.now().UTC().Format(request.TimeLayout)})
+	if err != nil {
+		return 0, err
+	}
+
+	var failed []string
+	for _, delivery := range due {
+		if err := d.attempt(ctx, delivery); err != nil {
+			log.Printf("%s.webhook.RetryDue, delivery %s: %v\n", sn, delivery.DeliveryID, err)
+			failed = append(failed, fmt.Sprintf("delivery %s: %v", delivery.DeliveryID, err))
+		}
+	}
+	if len(failed) > 0 {
+		return len(due), fmt.Errorf("%d of %d attempts not recorded: %s", len(failed), len(due), strings.Join(failed, "; "))
+	}
+	return len(due), nil
+}
+
+// Replay sends a Delivery's event again now, whatever its status. If it
+// fails, it gets a full set of retries.
+func (d *Dispatcher) Replay(ctx context.Context, deliveryID string) (*Delivery, error) {
+	delivery, err := d.Deliveries.FindByID(deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if delivery.ErasedAt != "" {
+		return nil, ErrErased
+	}
+	delivery.Status = Pending
+	delivery.ReplayedAt = d.now().UTC().Format(request.TimeLayout)
+
+	return delivery, d.attempt(ctx, delivery)
+}
+
+// attempt POSTs the Delivery's event once, and records the outcome. The
+// returned error is from the delivery log, not the receiver.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *Delivery) error {
+	sn := serviceInfo.GetServiceName()
+	start := d.now()
+
+	statusCode, sendErr := d.send(ctx, delivery, start)
+	att := Attempt{
+		At:         start.UTC().Format(request.TimeLayout),
+		StatusCode: statusCode,
+		DurationMS: d.now().Sub(start).Milliseconds(),
+	}
+	if sendErr != nil {
+		att.Error = sendErr.Error()
+	}
+	delivery.Attempts = append(delivery.Attempts, att)
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = Delivered
+		delivery.DeliveredAt = att.At
+		delivery.NextAttemptAt = ""
+	case d.sentSinceReplay(delivery) >= d.maxAttempts():
+		log.Printf("%s.webhook.attempt, delivery %s to %s failed after %d attempts: %v\n",
+			sn, delivery.DeliveryID, delivery.URL, d.maxAttempts(), sendErr)
+		delivery.Status = Failed
+		delivery.NextAttemptAt = ""
+	default:
+		wait := retryBase << (2 * uint(d.sentSinceReplay(delivery)-1))
+		delivery.NextAttemptAt = start.Add(wait).UTC().Format(request.TimeLayout)
+	}
+
+	return d.Deliveries.Update(delivery)
+}
+
+// send POSTs the Delivery's payload, signed with the customer's secret
+func (d *Dispatcher) send(ctx context.Context, delivery *Delivery, now time.Time) (int, error) {
+	secret, err := d.secret(delivery.CustomerID)
+	if err != nil {
+		return 0, err
+	}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "LeadExpert-Webhook/1")
+	req.Header.Set(SignatureHeader, Sign(secret, body, now))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.DeliveryID)
+
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// secret returns the customer's signing secret, generating one for
+// customers created before webhooks were
+func (d *Dispatcher) secret(customerID int) (string, error) {
+	cust, err := d.Customers.FindByID(customerID)
+	if err != nil {
+		return "", fmt.Errorf("customer %d: %v", customerID, err)
+	}
+	if cust.Webhook.Secret == "" {
+		if err := cust.EnsureWebhookSecret(); err != nil {
+			return "", err
+		}
+		if err := d.Customers.Update(cust); err != nil {
+			return "", fmt.Errorf("customer %d: %v", customerID, err)
+		}
+	}
+	return cust.Webhook.Secret, nil
+}
+
+// sentSinceReplay counts the attempts since the Delivery was last replayed
+func (d *Dispatcher) sentSinceReplay(delivery *Delivery) int {
+	replayed, _ := time.Parse(time.RFC3339Nano, delivery.ReplayedAt)
+	n := 0
+	for _, att := range delivery.Attempts {
+		if at, err := time.Parse(time.RFC3339Nano, att.At); err == nil && !at.Before(replayed) {
+			n++
+		}
+	}
+	return n
+}
+
+func (d *Dispatcher) maxAttempts() int {
+	if d.MaxAttempts > 0 {
+		return d.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+func (d *Dispatcher) client() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	// callback URLs are the customer's: they mustn't reach our network
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !d.AllowPrivate {
+		dialer.Control = media.RefusePrivate
+	}
+	transport := &http.Transport{
+		Proxy:               nil, // a proxy would connect on our behalf, unchecked
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+		// a redirect's 3xx is the receiver's answer, and fails the attempt
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func (d *Dispatcher) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
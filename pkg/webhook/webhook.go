@@ -0,0 +1,135 @@
+// Webhook package tells customers their requests completed or failed, by
+// POSTing signed JSON events to their callback URL, retrying failed
+// deliveries with backoff and keeping a log of every attempt
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/request"
+)
+
+// event types
+const (
+	EventCompleted = "request.completed"
+	EventFailed    = "request.failed"
+)
+
+// headers sent with each delivery
+const (
+	SignatureHeader = "X-LeadExpert-Signature" // "t=<unix seconds>,v1=<hex HMAC-SHA256>"
+	EventHeader     = "X-LeadExpert-Event"     // the event's Type
+	DeliveryHeader  = "X-LeadExpert-Delivery"  // the Delivery's ID, the same for retries and replays
+)
+
+// ErrInvalidSignature - the signature header is missing, malformed, stale
+// or doesn't match the body
+var ErrInvalidSignature = errors.New("Invalid webhook signature")
+
+// Event is the JSON body POSTed to the callback URL. It carries no
+// transcript; the receiver fetches that from TranscriptsEndpoint.
+type Event struct {
+	EventID   string    `json:"event_id"`
+	Type      string    `json:"type"`
+	CreatedAt string    `json:"created_at"`
+	Data      EventData `json:"data"`
+}
+
+// EventData describes the Request the event is about
+type EventData struct {
+	RequestID           string `json:"request_id"`
+	CustomerID          int    `json:"customer_id"`
+	MediaFileURI        string `json:"media_uri"`
+	Status              string `json:"status"`
+	Stage               string `json:"stage,omitempty"`
+	AcceptedAt          string `json:"accepted_at"`
+	CompletedAt         string `json:"completed_at,omitempty"`
+	Error               string `json:"error,omitempty"` // why it failed
+	StatusEndpoint      string `json:"status_endpoint"`
+	TranscriptsEndpoint string `json:"transcripts_endpoint,omitempty"`
+}
+
+// NewEvent returns the event of type eventType about req; reason says why a
+// failed Request failed
+func NewEvent(eventType string, req *request.Request, reason string, now time.Time) Event {
+	ev := Event{
+		EventID:   uuid.New().String(),
+		Type:      eventType,
+		CreatedAt: now.UTC().Format(request.TimeLayout),
+		Data: EventData{
+			RequestID:      req.RequestID.String(),
+			CustomerID:     req.CustomerID,
+			MediaFileURI:   req.MediaFileURI,
+			Status:         req.Status,
+			Stage:          req.Stage,
+			AcceptedAt:     req.AcceptedAt,
+			CompletedAt:    req.CompletedAt,
+			Error:          reason,
+			StatusEndpoint: "/api/v1/status/" + req.RequestID.String(),
+		},
+	}
+	if eventType == EventCompleted {
+		ev.Data.TranscriptsEndpoint = "/api/v1/transcripts/" + req.RequestID.String()
+	}
+	return ev
+}
+
+// Sign returns the SignatureHeader value for body sent at t: the
+// HMAC-SHA256, keyed by secret, of "<unix seconds>.<body>"
+func Sign(secret string, body []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + mac(secret, ts, body)
+}
+
+// Verify checks a SignatureHeader value against body, rejecting signatures
+// more than tolerance from now, so captured deliveries can't be replayed
+// later. Receivers do the same.
+func Verify(secret string, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			sigs = append(sigs, kv[1])
+		}
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return fmt.Errorf("%w: malformed", ErrInvalidSignature)
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+
+	expected := mac(secret, ts, body)
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no matching signature", ErrInvalidSignature)
+}
+
+func mac(secret string, ts string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
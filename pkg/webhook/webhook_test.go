@@ -0,0 +1,267 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/peterpla/lead-expert/pkg/customer"
+	"github.com/peterpla/lead-expert/pkg/database"
+	"github.com/peterpla/lead-expert/pkg/request"
+	"github.com/peterpla/lead-expert/pkg/webhook"
+)
+
+func TestSignVerify(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"type":"request.completed"}`)
+	header := webhook.Sign("whsec_abc", body, now)
+
+	type test struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		at     time.Time
+		valid  bool
+	}
+
+	tests := []test{
+		{name: "valid", secret: "whsec_abc", header: header, body: body, at: now, valid: true},
+		{name: "within tolerance", secret: "whsec_abc", header: header, body: body, at: now.Add(4 * time.Minute), valid: true},
+		{name: "stale", secret: "whsec_abc", header: header, body: body, at: now.Add(6 * time.Minute)},
+		{name: "wrong secret", secret: "whsec_xyz", header: header, body: body, at: now},
+		{name: "edited body", secret: "whsec_abc", header: header, body: []byte(`{"type":"request.failed"}`), at: now},
+		{name: "rotating secrets", secret: "whsec_abc", header: header + ",v1=deadbeef", body: body, at: now, valid: true},
+		{name: "malformed", secret: "whsec_abc", header: "v1=abc", body: body, at: now},
+	}
+
+	for _, tc := range tests {
+		err := webhook.Verify(tc.secret, tc.header, tc.body, 5*time.Minute, tc.at)
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if !tc.valid && !errors.Is(err, webhook.ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	customers := database.NewMemoryCustomerRepository()
+	cust := &customer.Customer{CustomerID: 1234567, Name: "Hooked"}
+	if err := customers.Create(cust); err != nil {
+		t.Fatal(err)
+	}
+
+	// the receiver fails until told otherwise, and checks every signature
+	failing := true
+	received := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		found, _ := customers.FindByID(1234567)
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := webhook.Verify(found.Webhook.Secret, r.Header.Get(webhook.SignatureHeader), body, 5*time.Minute, now); err != nil {
+			t.Errorf("receiver: %v", err)
+		}
+		received++
+		if failing {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	d := &webhook.Dispatcher{
+		Deliveries:   database.NewMemoryDeliveryRepository(),
+		Customers:    customers,
+		AllowPrivate: true,
+		MaxAttempts:  3,
+		Now:          func() time.Time { return now },
+	}
+
+	req := &request.Request{
+		RequestID:    uuid.New(),
+		CustomerID:   1234567,
+		MediaFileURI: "gs://bucket/call.mp3",
+		Status:       request.Completed,
+		CallbackURL:  receiver.URL,
+	}
+
+	// no callback URL anywhere
+	noCallback := *req
+	noCallback.CallbackURL = ""
+	if _, err := d.NotifyRequest(context.Background(), webhook.EventCompleted, &noCallback, ""); err != webhook.ErrNoCallback {
+		t.Errorf("expected ErrNoCallback, got %v", err)
+	}
+
+	delivery, err := d.NotifyRequest(context.Background(), webhook.EventCompleted, req, "")
+	if err != nil {
+		t.Fatalf("NotifyRequest: %v", err)
+	}
+	if delivery.Status != webhook.Pending || len(delivery.Attempts) != 1 || delivery.Attempts[0].StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a pending delivery after one failed attempt, got %+v", delivery)
+	}
+	if delivery.NextAttemptAt != now.Add(30*time.Second).Format(request.TimeLayout) {
+		t.Errorf("expected retry in 30s, got %s", delivery.NextAttemptAt)
+	}
+
+	// not due yet
+	if n, _ := d.RetryDue(context.Background()); n != 0 {
+		t.Errorf("expected no retries due, got %d", n)
+	}
+
+	// retries back off, then give up; due within the second counts, as
+	// times are compared as strings
+	for _, wait := range []time.Duration{30*time.Second + 500*time.Millisecond, 2 * time.Minute} {
+		now = now.Add(wait)
+		if n, err := d.RetryDue(context.Background()); n != 1 || err != nil {
+			t.Fatalf("expected 1 retry, got %d, %v", n, err)
+		}
+	}
+	found, _ := d.Deliveries.FindByID(delivery.DeliveryID)
+	if found.Status != webhook.Failed || len(found.Attempts) != 3 {
+		t.Fatalf("expected a failed delivery after 3 attempts, got %+v", found)
+	}
+	if failed, _ := d.Deliveries.List(webhook.ListOptions{CustomerID: 1234567, Status: webhook.Failed}); len(failed) != 1 {
+		t.Errorf("expected 1 failed delivery, got %d", len(failed))
+	}
+
+	// replay once the receiver recovers
+	failing = false
+	now = now.Add(time.Hour)
+	replayed, err := d.Replay(context.Background(), delivery.DeliveryID)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if replayed.Status != webhook.Delivered || len(replayed.Attempts) != 4 || replayed.DeliveredAt == "" {
+		t.Errorf("expected a delivered delivery, got %+v", replayed)
+	}
+	if received != 4 {
+		t.Errorf("expected 4 POSTs received, got %d", received)
+	}
+
+	// the customer got a signing secret on first use
+	if found, _ := customers.FindByID(1234567); found.Webhook.Secret == "" {
+		t.Errorf("expected the customer's webhook secret generated")
+	}
+}
+
+func TestDispatcherRefuses(t *testing.T) {
+	customers := database.NewMemoryCustomerRepository()
+	if err := customers.Create(&customer.Customer{CustomerID: 1234567, Name: "Hooked"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the receiver redirects to the metadata server, say
+	redirected := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metadata" {
+			redirected++
+			return
+		}
+		http.Redirect(w, r, "/metadata", http.StatusTemporaryRedirect)
+	}))
+	defer receiver.Close()
+
+	req := &request.Request{
+		RequestID:    uuid.New(),
+		CustomerID:   1234567,
+		MediaFileURI: "gs://bucket/call.mp3",
+		Status:       request.Completed,
+		CallbackURL:  receiver.URL + "/hook",
+	}
+
+	type test struct {
+		name         string
+		allowPrivate bool
+		statusCode   int
+	}
+
+	tests := []test{
+		{name: "private address", allowPrivate: false, statusCode: 0},
+		{name: "redirect", allowPrivate: true, statusCode: http.StatusTemporaryRedirect},
+	}
+
+	for _, tc := range tests {
+		d := &webhook.Dispatcher{
+			Deliveries:   database.NewMemoryDeliveryRepository(),
+			Customers:    customers,
+			AllowPrivate: tc.allowPrivate,
+		}
+		delivery, err := d.NotifyRequest(context.Background(), webhook.EventCompleted, req, "")
+		if err != nil {
+			t.Fatalf("%s: NotifyRequest: %v", tc.name, err)
+		}
+		if delivery.Status != webhook.Pending || len(delivery.Attempts) != 1 || delivery.Attempts[0].StatusCode != tc.statusCode || delivery.Attempts[0].Error == "" {
+			t.Errorf("%s: expected a failed attempt answered %d, got %+v", tc.name, tc.statusCode, delivery.Attempts)
+		}
+	}
+	if redirected != 0 {
+		t.Errorf("expected no redirect followed, got %d", redirected)
+	}
+}
+
+// unrecordedRepository fails to Update the delivery named
+type unrecordedRepository struct {
+	webhook.DeliveryRepository
+	deliveryID string
+}
+
+func (r *unrecordedRepository) Update(d *webhook.Delivery) error {
+	if d.DeliveryID == r.deliveryID {
+		return errors.New("unavailable")
+	}
+	return r.DeliveryRepository.Update(d)
+}
+
+func TestRetryDueContinues(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	customers := database.NewMemoryCustomerRepository()
+	if err := customers.Create(&customer.Customer{CustomerID: 1234567, Name: "Hooked"}); err != nil {
+		t.Fatal(err)
+	}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	repo := &unrecordedRepository{DeliveryRepository: database.NewMemoryDeliveryRepository()}
+	d := &webhook.Dispatcher{
+		Deliveries:   repo,
+		Customers:    customers,
+		AllowPrivate: true,
+		Now:          func() time.Time { return now },
+	}
+
+	// three deliveries due, the first overdue unrecordable
+	var ids []string
+	for i := 0; i < 3; i++ {
+		req := &request.Request{RequestID: uuid.New(), CustomerID: 1234567, Status: request.Completed, CallbackURL: receiver.URL}
+		delivery, err := d.NotifyRequest(context.Background(), webhook.EventCompleted, req, "")
+		if err != nil {
+			t.Fatalf("NotifyRequest: %v", err)
+		}
+		ids = append(ids, delivery.DeliveryID)
+		now = now.Add(time.Second)
+	}
+	repo.deliveryID = ids[0]
+	now = now.Add(time.Minute)
+
+	n, err := d.RetryDue(context.Background())
+	if n != 3 || err == nil || !strings.Contains(err.Error(), ids[0]) {
+		t.Errorf("expected 3 attempted, and an error for %s, got %d, %v", ids[0], n, err)
+	}
+	for _, id := range ids[1:] {
+		if found, _ := repo.FindByID(id); len(found.Attempts) != 2 {
+			t.Errorf("expected %s retried after the unrecorded one, got %d attempts", id, len(found.Attempts))
+		}
+	}
+}